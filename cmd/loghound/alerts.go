@@ -0,0 +1,129 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/juacker/loghound/internal/alerts"
+	"github.com/juacker/loghound/internal/api"
+)
+
+var historyCommand = &command{
+	name:    "history",
+	summary: "print the alerts history stored in the alerts state file",
+	setup: func(fs *flag.FlagSet) func(args []string) error {
+		fs.String("alerts-state", "loghound-alerts.json", "file persisting alerts state and history")
+
+		return func(args []string) error {
+			if len(args) > 0 {
+				return errUsage
+			}
+
+			cfg, err := loadConfig(fs)
+			if err != nil {
+				return fmt.Errorf("invalid configuration:\n%v", err)
+			}
+
+			state, err := alerts.LoadState(cfg.Alerts.State)
+			if err != nil {
+				return fmt.Errorf("error loading alerts state: %v", err)
+			}
+
+			for _, t := range state.Transitions() {
+				fmt.Printf("[%v] %s\n", t.Time.Truncate(time.Second), t.Text)
+			}
+			return nil
+		}
+	},
+}
+
+var silenceCommand = &command{
+	name:    "silence",
+	args:    "<pattern>",
+	summary: "silence the alerts matching a metric pattern in the running loghound (see -http)",
+	setup: func(fs *flag.FlagSet) func(args []string) error {
+		duration := fs.Duration("for", time.Hour, "duration of the silence")
+		comment := fs.String("comment", "", "comment of the silence")
+
+		return func(args []string) error {
+			if len(args) != 1 {
+				return errUsage
+			}
+
+			addr, err := apiAddr(fs)
+			if err != nil {
+				return err
+			}
+
+			s, err := api.CreateSilence(addr, args[0], *duration, *comment)
+			if err != nil {
+				return fmt.Errorf("error creating silence: %v", err)
+			}
+			fmt.Printf("silence %s created for %s until %v\n", s.ID, s.Matcher, s.End.Truncate(time.Second))
+			return nil
+		}
+	},
+}
+
+var ackCommand = &command{
+	name:    "ack",
+	args:    "<rule>",
+	summary: "acknowledge the active alert of a rule in the running loghound (see -http)",
+	setup: func(fs *flag.FlagSet) func(args []string) error {
+		return func(args []string) error {
+			if len(args) != 1 {
+				return errUsage
+			}
+
+			addr, err := apiAddr(fs)
+			if err != nil {
+				return err
+			}
+
+			err = api.Ack(addr, args[0])
+			if err != nil {
+				return fmt.Errorf("error acknowledging alert: %v", err)
+			}
+			fmt.Printf("alert %s acknowledged\n", args[0])
+			return nil
+		}
+	},
+}
+
+var reloadCommand = &command{
+	name:    "reload",
+	summary: "reload the configuration of the running loghound (see -http)",
+	setup: func(fs *flag.FlagSet) func(args []string) error {
+		return func(args []string) error {
+			if len(args) > 0 {
+				return errUsage
+			}
+
+			addr, err := apiAddr(fs)
+			if err != nil {
+				return err
+			}
+
+			changes, err := api.Reload(addr)
+			if err != nil {
+				return fmt.Errorf("error reloading configuration: %v", err)
+			}
+			fmt.Printf("configuration reloaded: %v\n", changes)
+			return nil
+		}
+	},
+}
+
+// apiAddr returns the address of the api of the running loghound
+func apiAddr(fs *flag.FlagSet) (string, error) {
+	cfg, err := loadConfig(fs)
+	if err != nil {
+		return "", fmt.Errorf("invalid configuration:\n%v", err)
+	}
+
+	if cfg.Exporters.HTTP.Addr == "" {
+		return "", fmt.Errorf("-http address of the running loghound is required")
+	}
+	return cfg.Exporters.HTTP.Addr, nil
+}
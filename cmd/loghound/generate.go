@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/juacker/loghound/internal/traffic"
+)
+
+var generateCommand = &command{
+	name:    "generate",
+	summary: "write realistic traffic following a profile to a file, in real time until interrupted or -duration",
+	setup: func(fs *flag.FlagSet) func(args []string) error {
+		logfile := fs.String("o", "/tmp/access.log", "file to store logs, - for stdout")
+		profile := fs.String("profile", "steady", "traffic profile yaml file, or one of "+strings.Join(traffic.ProfileNames(), ", "))
+		rate := fs.Float64("rate", 0, "mean requests per second, the profile one if 0")
+		seed := fs.Int64("seed", 0, "random seed, the same seed generates the same traffic. Random if 0")
+		format := fs.String("format", "clf", "log format: "+strings.Join(traffic.Formats, ", ")+" or nginx:<log_format>")
+		duration := fs.Duration("duration", 0, "period of traffic generated, forever if 0")
+		fast := fs.Bool("fast", false, "write the traffic of the -duration until now at once, without waiting")
+		appendLogs := fs.Bool("append", false, "append to the file instead of truncating it")
+
+		return func(args []string) error {
+			if len(args) > 0 {
+				return errUsage
+			}
+			if *fast && *duration <= 0 {
+				return fmt.Errorf("-fast requires -duration")
+			}
+
+			p, err := traffic.LoadProfile(*profile)
+			if err != nil {
+				return err
+			}
+			if *rate > 0 {
+				p.Rate = *rate
+			}
+
+			formatter, err := traffic.NewFormatter(*format)
+			if err != nil {
+				return err
+			}
+
+			if *seed == 0 {
+				*seed = time.Now().UnixNano()
+				fmt.Fprintf(os.Stderr, "generating traffic with seed %d\n", *seed)
+			}
+
+			start := time.Now()
+			if *fast {
+				start = start.Add(-*duration)
+			}
+
+			g, err := traffic.NewGenerator(*p, *seed, start)
+			if err != nil {
+				return err
+			}
+
+			f := os.Stdout
+			if *logfile != "-" {
+				mode := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+				if *appendLogs {
+					mode = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+				}
+
+				f, err = os.OpenFile(*logfile, mode, 0644)
+				if err != nil {
+					return err
+				}
+				defer f.Close()
+			}
+
+			w := bufio.NewWriter(f)
+			defer w.Flush()
+
+			end := start.Add(*duration)
+			for {
+				entry := g.Next()
+				if *duration > 0 && !entry.Date.Before(end) {
+					return nil
+				}
+
+				// in real time, lines are written when they are dated
+				if !*fast {
+					time.Sleep(time.Until(entry.Date))
+				}
+
+				_, err = w.WriteString(formatter(entry) + "\n")
+				if err == nil && !*fast {
+					err = w.Flush()
+				}
+				if err != nil {
+					return fmt.Errorf("fail writing file: %v", err)
+				}
+			}
+		}
+	},
+}
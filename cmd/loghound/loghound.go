@@ -1,62 +1,221 @@
 package main
 
 import (
+	"errors"
 	"flag"
-	"log"
+	"fmt"
 	"os"
-	"sync"
+	"strings"
 
-	"github.com/juacker/loghound/internal/alerts"
-	"github.com/juacker/loghound/internal/broker"
-	"github.com/juacker/loghound/internal/console"
-	"github.com/juacker/loghound/internal/filemon"
-	"github.com/juacker/loghound/internal/stats"
+	"github.com/juacker/loghound/internal/config"
 )
 
-func main() {
+// command is a loghound subcommand
+type command struct {
+	name    string
+	args    string // positional arguments, shown in the usage
+	summary string
+	// setup registers the command flags in fs, and returns the function running
+	// the command with the positional arguments
+	setup func(fs *flag.FlagSet) func(args []string) error
+}
 
-	// parse command line arguments
-	logfile := flag.String("l", "/tmp/access.log", "common log format file to monitor")
-	threshold := flag.Int("t", 10, "alarm threshold (req/seq)")
-	alarmInterval := flag.Int64("a", 120, "interval to consider for alarm threshold (s)")
-	statsInterval := flag.Int64("s", 2, "stats interval generation (s)")
+// commands in the order shown in the help
+var commands = []*command{
+	tailCommand,
+	serveCommand,
+	replayCommand,
+	analyzeCommand,
+	queryCommand,
+	generateCommand,
+	testRulesCommand,
+	configCommand,
+	historyCommand,
+	silenceCommand,
+	ackCommand,
+	reloadCommand,
+}
 
-	flag.Parse()
+// flags shared by all the commands, accepted before and after the command name
+var global struct {
+	config string
+	http   string
+}
+
+func globalFlags(fs *flag.FlagSet) {
+	// registering the flags resets the values parsed before the command name
+	defer func(config, http string) {
+		global.config, global.http = config, http
+	}(global.config, global.http)
+
+	fs.StringVar(&global.config, "config", "", "yaml configuration file, LOGHOUND_* environment variables and flags override it")
+	fs.StringVar(&global.http, "http", "", "address of the http api, like localhost:8080. Served by tail, serve and replay, used by the rest of commands")
+}
+
+// errUsage makes the command print its usage and exit with code 2
+var errUsage = errors.New("invalid arguments")
+
+// exitCode makes the command exit with its value, after printing its output
+type exitCode int
+
+func (e exitCode) Error() string {
+	return fmt.Sprintf("exit code %d", int(e))
+}
+
+// configuration keys overridden by flags, flags in seconds get the unit appended
+var flagKeys = map[string]string{
+	"l":                     "inputs",
+	"t":                     "alerts.threshold",
+	"a":                     "alerts.window",
+	"s":                     "stats.interval",
+	"journal":               "journal.dir",
+	"journal-max-size":      "journal.max_size",
+	"journal-max-age":       "journal.max_age",
+	"journal-replay":        "journal.replay",
+	"data":                  "storage.dir",
+	"syslog":                "syslog.listen",
+	"syslog-format":         "syslog.format",
+	"dead-letter":           "rejects.dead_letter",
+	"alerts-state":          "alerts.state",
+	"rules":                 "alerts.rules_file",
+	"alerts-repeat":         "alerts.repeat",
+	"http":                  "exporters.http.addr",
+	"http-public":           "exporters.http.public",
+	"notify-webhook":        "notifiers.webhook",
+	"notify-file":           "notifiers.file",
+	"notify-group-by":       "notifiers.group_by",
+	"notify-group-wait":     "notifiers.group_wait",
+	"notify-group-interval": "notifiers.group_interval",
+	"notify-repeat":         "notifiers.repeat",
+}
+
+var secondsFlags = map[string]bool{"a": true, "s": true}
+
+// loadConfig loads the -config file, if any, with the LOGHOUND_* environment
+// variables and the global and command flags set in the command line overriding it
+func loadConfig(fs *flag.FlagSet) (*config.Config, error) {
+	c := config.Default()
+	if global.config != "" {
+		var err error
+		c, err = config.Load(global.config)
+		if err != nil {
+			return nil, err
+		}
+	}
 
-	// print logs to file
-	f, err := os.OpenFile("loghound.log", os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+	err := c.ApplyEnv(os.LookupEnv)
 	if err != nil {
-		log.Fatalf("error opening log file: %v", err)
+		return nil, err
 	}
-	defer f.Close()
 
-	log.Println("writing logs to ./loghound.log file")
+	for _, fs := range []*flag.FlagSet{flag.CommandLine, fs} {
+		fs.Visit(func(f *flag.Flag) {
+			key, ok := flagKeys[f.Name]
+			if !ok || err != nil {
+				return
+			}
+
+			value := f.Value.String()
+			if secondsFlags[f.Name] {
+				value += "s"
+			}
+			err = c.Set(key, value)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
 
-	log.SetOutput(f)
+	return c, c.Validate()
+}
 
-	// goroutines control channel
-	ctl := make(chan bool)
+// newFlagSet returns the flag set of cmd, with the global flags and its usage
+func newFlagSet(cmd *command) *flag.FlagSet {
+	fs := flag.NewFlagSet("loghound "+cmd.name, flag.ExitOnError)
+	globalFlags(fs)
+
+	fs.Usage = func() {
+		out := fs.Output()
+		fmt.Fprintln(out, strings.TrimSpace("usage: loghound "+cmd.name+" [flags] "+cmd.args))
+		fmt.Fprintln(out)
+		fmt.Fprintf(out, "%s.\n\nflags:\n", strings.ToUpper(cmd.summary[:1])+cmd.summary[1:])
+		fs.PrintDefaults()
+	}
 
-	var wg sync.WaitGroup
+	return fs
+}
 
-	wg.Add(4)
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintln(out, "usage: loghound [global flags] <command> [flags] [arguments]")
+	fmt.Fprintln(out, "\ncommands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(out, "  %-12s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintln(out, "\nglobal flags:")
+	flag.PrintDefaults()
+	fmt.Fprintln(out, "\nWithout command, tail is run. Run 'loghound help <command>' for the flags of a command.")
+}
 
-	go broker.Run(&wg, ctl)
-	go filemon.Run(&wg, ctl, *logfile)
-	go stats.Run(&wg, ctl, *statsInterval)
+func lookup(name string) *command {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd
+		}
+	}
+	return nil
+}
 
-	go alerts.Run(&wg, ctl, "requests.total", "mean", *alarmInterval, *threshold)
+func main() {
 
-	console.Run()
+	globalFlags(flag.CommandLine)
+	flag.Usage = usage
+	flag.Parse()
 
-	log.Println("main: stopping goroutines")
+	name := flag.Arg(0)
+	args := flag.Args()
+	switch name {
+	case "":
+		name = tailCommand.name
+	case "help":
+		if flag.NArg() < 2 {
+			usage()
+			return
+		}
+		cmd := lookup(flag.Arg(1))
+		if cmd == nil {
+			fmt.Fprintf(os.Stderr, "loghound: unknown command %s\n", flag.Arg(1))
+			os.Exit(2)
+		}
+		fs := newFlagSet(cmd)
+		cmd.setup(fs)
+		fs.Usage()
+		return
+	default:
+		args = args[1:]
+	}
 
-	// stopping goroutines
-	for i := 0; i < 4; i++ {
-		ctl <- true
+	cmd := lookup(name)
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "loghound: unknown command %s\n\n", name)
+		usage()
+		os.Exit(2)
 	}
 
-	// waiting until they finish
-	wg.Wait()
-	log.Println("main: All goroutines stopped, exiting")
+	fs := newFlagSet(cmd)
+	run := cmd.setup(fs)
+	fs.Parse(args) //errcheck: nolint
+
+	err := run(fs.Args())
+	if err == errUsage {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if code, ok := err.(exitCode); ok {
+		os.Exit(int(code))
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "loghound %s: %v\n", cmd.name, err)
+		os.Exit(1)
+	}
 }
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/juacker/loghound/internal/alerts"
+	"github.com/juacker/loghound/internal/api"
+	"github.com/juacker/loghound/internal/broker"
+	"github.com/juacker/loghound/internal/clock"
+	"github.com/juacker/loghound/internal/console"
+	"github.com/juacker/loghound/internal/filemon"
+	"github.com/juacker/loghound/internal/health"
+	"github.com/juacker/loghound/internal/journal"
+	"github.com/juacker/loghound/internal/notify"
+	"github.com/juacker/loghound/internal/rejects"
+	"github.com/juacker/loghound/internal/reload"
+	"github.com/juacker/loghound/internal/replay"
+	"github.com/juacker/loghound/internal/stats"
+	"github.com/juacker/loghound/internal/syslog"
+	"github.com/juacker/loghound/internal/tsdb"
+	"github.com/juacker/loghound/pkg/clf"
+)
+
+var tailCommand = &command{
+	name:    "tail",
+	summary: "monitor the log files in the terminal dashboard, logs are written to ./loghound.log",
+	setup: func(fs *flag.FlagSet) func(args []string) error {
+		pipelineFlags(fs)
+
+		return func(args []string) error {
+			if len(args) > 0 {
+				return errUsage
+			}
+			return runPipeline(fs, filemon.Run, clock.New(), true)
+		}
+	},
+}
+
+var serveCommand = &command{
+	name:    "serve",
+	summary: "monitor the log files without dashboard until SIGINT or SIGTERM, logs are written to stderr",
+	setup: func(fs *flag.FlagSet) func(args []string) error {
+		pipelineFlags(fs)
+
+		return func(args []string) error {
+			if len(args) > 0 {
+				return errUsage
+			}
+			return runPipeline(fs, filemon.Run, clock.New(), false)
+		}
+	},
+}
+
+var replayCommand = &command{
+	name:    "replay",
+	args:    "<file>",
+	summary: "send the entries of a log file to the pipeline with their original spacing scaled by -speed, instead of monitoring the inputs",
+	setup: func(fs *flag.FlagSet) func(args []string) error {
+		pipelineFlags(fs)
+		headless := fs.Bool("headless", false, "replay without dashboard, logs are written to stderr")
+		speed := fs.Float64("speed", 1, "speed factor of the replay, like 10 or 100")
+		format := fs.String("format", clf.FormatCLF, "log format of the file, one of "+strings.Join(clf.Formats, ", ")+" or regexp:<expression>")
+
+		return func(args []string) error {
+			if len(args) != 1 || *speed <= 0 {
+				return errUsage
+			}
+
+			start, err := replay.Start(args[0], *format)
+			if err != nil {
+				return err
+			}
+
+			// stats, alerts and dashboard run on the time of the log
+			clk := clock.NewVirtual(start, *speed)
+
+			input := func(wg *sync.WaitGroup, ctl chan bool, files []string, formats map[string]string, sink *rejects.Sink) {
+				replay.Run(wg, ctl, args[0], *format, clk, sink)
+			}
+			return runPipeline(fs, input, clk, !*headless)
+		}
+	},
+}
+
+// pipelineFlags registers the flags overriding the pipeline configuration
+func pipelineFlags(fs *flag.FlagSet) {
+	fs.String("l", "/tmp/access.log", "comma separated common log format files to monitor")
+	fs.Int("t", 10, "alarm threshold (req/seq)")
+	fs.Int64("a", 120, "interval to consider for alarm threshold (s)")
+	fs.Int64("s", 2, "stats interval generation (s)")
+	fs.String("journal", "", "directory to store the data journal, disabled if empty")
+	fs.Int64("journal-max-size", 512, "journal retention size (MB)")
+	fs.Duration("journal-max-age", 24*time.Hour, "journal retention age")
+	fs.Duration("journal-replay", 0, "period before startup to rebuild stats from the journal")
+	fs.String("syslog", "", "address to receive access logs by syslog over udp and tcp, like localhost:5514, disabled if empty")
+	fs.String("syslog-format", clf.FormatCLF, "log format of the syslog messages, one of "+strings.Join(clf.Formats, ", ")+" or regexp:<expression>")
+	fs.Bool("http-public", false, "serve the http api on non loopback addresses, its endpoints changing alerts and configuration have no authentication")
+	fs.String("dead-letter", "", "file to append the lines failing to parse, disabled if empty")
+	fs.String("data", "", "directory to store stats history, disabled if empty")
+	fs.String("alerts-state", "loghound-alerts.json", "file to persist alerts state and history, disabled if empty")
+	fs.String("rules", "", "alert rules and slos file, if empty a high traffic alert is created with -t and -a")
+	fs.Duration("alerts-repeat", 0, "interval to notify again active alerts not acknowledged, disabled if 0")
+	fs.String("notify-webhook", "", "url to post grouped alert notifications, disabled if empty")
+	fs.String("notify-file", "", "file to append grouped alert notifications as json lines, disabled if empty")
+	fs.String("notify-group-by", "alertname", "comma separated labels to group alert notifications")
+	fs.Duration("notify-group-wait", 30*time.Second, "time to wait for more alerts before notifying a new group")
+	fs.Duration("notify-group-interval", 5*time.Minute, "time to wait before notifying changes of a group")
+	fs.Duration("notify-repeat", 4*time.Hour, "interval to notify again a group with firing alerts, disabled if 0")
+}
+
+// runPipeline runs the modules with the configuration of the command line, reading
+// log entries with input and telling the time with clk, until the dashboard is closed
+// or, without dashboard, until SIGINT or SIGTERM are received
+func runPipeline(fs *flag.FlagSet, input func(wg *sync.WaitGroup, ctl chan bool, files []string, formats map[string]string, sink *rejects.Sink), clk clock.Clock, dashboard bool) error {
+	cfg, err := loadConfig(fs)
+	if err != nil {
+		return fmt.Errorf("invalid configuration:\n%v", err)
+	}
+
+	if httpAddr := cfg.Exporters.HTTP.Addr; httpAddr != "" {
+		err = api.CheckAddr(httpAddr, cfg.Exporters.HTTP.Public)
+		if err != nil {
+			return err
+		}
+	}
+
+	// the configuration is loaded again on reload, rules and slos files included
+	load := func() (*reload.Config, error) {
+		c, err := loadConfig(fs)
+		if err != nil {
+			return nil, err
+		}
+
+		rules, slos, err := c.Rules()
+		if err != nil {
+			return nil, err
+		}
+
+		return &reload.Config{
+			Files:    c.Files(),
+			Formats:  c.FileFormats(),
+			Rules:    rules,
+			SLOs:     slos,
+			Settings: c.Settings(),
+		}, nil
+	}
+
+	running, err := load()
+	if err != nil {
+		return fmt.Errorf("error loading alert rules: %v", err)
+	}
+
+	state, err := alerts.LoadState(cfg.Alerts.State)
+	if err != nil {
+		return fmt.Errorf("error loading alerts state: %v", err)
+	}
+	state.SetClock(clk)
+
+	if dashboard {
+		// print logs to file
+		f, err := os.OpenFile("loghound.log", os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+		if err != nil {
+			return fmt.Errorf("error opening log file: %v", err)
+		}
+		defer f.Close()
+
+		log.Println("writing logs to ./loghound.log file")
+
+		log.SetOutput(f)
+	}
+
+	sink, err := rejects.Open(cfg.Rejects.DeadLetter)
+	if err != nil {
+		return err
+	}
+	defer sink.Close()
+
+	// goroutines control channel
+	ctl := make(chan bool)
+
+	var wg sync.WaitGroup
+
+	modules := 5
+
+	// journal, storage and reloader are opened before any module is started,
+	// so the pipeline is not left half started when they fail
+	var j *journal.Journal
+	if cfg.Journal.Dir != "" {
+		opts := journal.DefaultOptions()
+		opts.MaxSize = cfg.Journal.MaxSize << 20
+		opts.MaxAge = cfg.Journal.MaxAge
+
+		j, err = journal.Open(cfg.Journal.Dir, opts)
+		if err != nil {
+			return fmt.Errorf("error opening journal: %v", err)
+		}
+	}
+
+	var db *tsdb.DB
+
+	// closeStorage closes journal and storage when the pipeline is not started
+	closeStorage := func() {
+		if j != nil {
+			j.Close()
+		}
+		if db != nil {
+			db.Close()
+		}
+	}
+
+	if cfg.Storage.Dir != "" {
+		opts := tsdb.DefaultOptions()
+		opts.Clock = clk
+
+		db, err = tsdb.Open(cfg.Storage.Dir, opts)
+		if err != nil {
+			closeStorage()
+			return fmt.Errorf("error opening stats storage: %v", err)
+		}
+	}
+
+	reloader, err := reload.NewReloader(load, running)
+	if err != nil {
+		closeStorage()
+		return fmt.Errorf("error creating configuration reloader: %v", err)
+	}
+
+	if j != nil {
+		modules++
+		wg.Add(1)
+		go journal.Run(&wg, ctl, j)
+	}
+
+	if db != nil {
+		modules++
+		wg.Add(1)
+		go tsdb.Run(&wg, ctl, db)
+	}
+
+	if cfg.Syslog.Listen != "" {
+		modules++
+		wg.Add(1)
+		go syslog.Run(&wg, ctl, cfg.Syslog.Listen, cfg.Syslog.Format, sink)
+	}
+
+	modules++
+	wg.Add(1)
+	go reload.Run(&wg, ctl, reloader)
+
+	if httpAddr := cfg.Exporters.HTTP.Addr; httpAddr != "" {
+		modules++
+		wg.Add(1)
+		go api.Run(&wg, ctl, httpAddr, state, db, reloader, clk)
+	}
+
+	notifiers := make([]notify.Notifier, 0)
+	if cfg.Notifiers.Webhook != "" {
+		notifiers = append(notifiers, notify.NewWebhook(cfg.Notifiers.Webhook))
+	}
+	if cfg.Notifiers.File != "" {
+		notifiers = append(notifiers, notify.NewFile(cfg.Notifiers.File))
+	}
+
+	if len(notifiers) > 0 {
+		modules++
+		wg.Add(1)
+		opts := cfg.NotifyOptions()
+		opts.Clock = clk
+
+		go notify.Run(&wg, ctl, opts, notifiers)
+	}
+
+	// history rebuilt from the journal is already sent to the pipeline,
+	// don't load it twice from the storage
+	history := db
+	if j != nil && cfg.Journal.Replay > 0 {
+		history = nil
+	}
+
+	wg.Add(5)
+
+	go broker.Run(&wg, ctl)
+	go health.Run(&wg, ctl)
+	go input(&wg, ctl, running.Files, running.Formats, sink)
+	go stats.Run(&wg, ctl, int64(cfg.Stats.Interval.Seconds()), j, cfg.Journal.Replay, cfg.Counting(running.SLOs), clk)
+
+	go alerts.Run(&wg, ctl, running.Rules, alerts.Options{
+		Repeat: cfg.Alerts.Repeat,
+		State:  state,
+		DB:     history,
+		SLOs:   running.SLOs,
+		Clock:  clk,
+	})
+
+	if dashboard {
+		opts := cfg.DashboardOptions()
+		opts.Clock = clk
+		console.Run(state, history, opts)
+	} else {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+		sig := <-signals
+		log.Println("main: signal received: ", sig)
+	}
+
+	log.Println("main: stopping goroutines")
+
+	// stopping goroutines
+	for i := 0; i < modules; i++ {
+		ctl <- true
+	}
+
+	// waiting until they finish
+	wg.Wait()
+	log.Println("main: All goroutines stopped, exiting")
+
+	return nil
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/juacker/loghound/internal/api"
+	"github.com/juacker/loghound/internal/config"
+	"github.com/juacker/loghound/internal/message"
+	"github.com/juacker/loghound/internal/ruletest"
+	"github.com/juacker/loghound/internal/stats"
+	"github.com/juacker/loghound/internal/tsdb"
+	"github.com/juacker/loghound/pkg/clf"
+)
+
+var analyzeCommand = &command{
+	name:    "analyze",
+	args:    "[file...]",
+	summary: "print a traffic report of log files, the configured inputs if none is given",
+	setup: func(fs *flag.FlagSet) func(args []string) error {
+		top := fs.Int("top", 10, "paths shown, by requests, all if 0")
+		format := fs.String("format", clf.FormatCLF, "log format of the files given, one of "+strings.Join(clf.Formats, ", ")+" or regexp:<expression>")
+
+		return func(args []string) error {
+			cfg, err := loadConfig(fs)
+			if err != nil {
+				return fmt.Errorf("invalid configuration:\n%v", err)
+			}
+
+			files, formats := args, make(map[string]string, len(args))
+			for _, filename := range files {
+				formats[filename] = *format
+			}
+			if len(files) == 0 {
+				files, formats = cfg.Files(), cfg.FileFormats()
+			}
+
+			return analyze(files, formats, cfg.Counting(nil), *top)
+		}
+	},
+}
+
+// analyze prints the totals of the entries in files, parsed with their format
+// in formats, and the paths with more requests
+func analyze(files []string, formats map[string]string, c stats.Counting, top int) error {
+	counter, err := stats.NewCounter(c)
+	if err != nil {
+		return err
+	}
+
+	var first, last time.Time
+	var entries, skipped, bytes int
+	classes := make(map[int]int)
+
+	for _, filename := range files {
+		parser, err := clf.NewParser(formats[filename])
+		if err != nil {
+			return err
+		}
+
+		file, err := os.Open(filename)
+		if err != nil {
+			return err
+		}
+
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			if len(scanner.Text()) == 0 {
+				continue
+			}
+
+			logEntry, err := parser.Parse(scanner.Text())
+			if err == clf.ErrDirective {
+				continue
+			}
+			if err != nil {
+				skipped++
+				continue
+			}
+
+			if first.IsZero() || logEntry.Date.Before(first) {
+				first = logEntry.Date
+			}
+			if logEntry.Date.After(last) {
+				last = logEntry.Date
+			}
+			entries++
+			bytes += logEntry.Bytes
+			classes[logEntry.Status/100]++
+
+			err = counter.Add(message.NewCLFMessage(logEntry))
+			if err != nil {
+				skipped++
+			}
+		}
+
+		err = scanner.Err()
+		file.Close()
+		if err != nil {
+			return fmt.Errorf("failed reading file %s: %v", filename, err)
+		}
+	}
+
+	if entries == 0 {
+		fmt.Printf("no entries found in %s\n", strings.Join(files, ", "))
+		return nil
+	}
+
+	period := last.Sub(first)
+	rate := float64(entries)
+	if period >= time.Second {
+		rate /= period.Seconds()
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "entries:\t%d from %v to %v (%v)\n", entries, first, last, period)
+	fmt.Fprintf(w, "requests:\t%.2f req/s\n", rate)
+	fmt.Fprintf(w, "bytes:\t%d\n", bytes)
+	fmt.Fprint(w, "status:\t")
+	for class := 1; class <= 5; class++ {
+		fmt.Fprintf(w, "%dxx %d (%.1f%%)  ", class, classes[class], 100*float64(classes[class])/float64(entries))
+	}
+	fmt.Fprintln(w)
+	if skipped > 0 {
+		fmt.Fprintf(w, "skipped:\t%d lines not parsed\n", skipped)
+	}
+	w.Flush()
+
+	table := clf.NewPathTable()
+	for metric, value := range counter.Stats() {
+		table.Add(metric, float64(value))
+	}
+
+	rows := table.Requests()
+	if len(rows) == 0 {
+		return nil
+	}
+
+	pathBytes := make(map[string]float64, len(rows))
+	for _, row := range table.Bytes() {
+		pathBytes[row.Path] = row.Bytes
+	}
+
+	sort.SliceStable(rows, func(a, b int) bool {
+		return rows[a].Requests > rows[b].Requests
+	})
+	if top > 0 && len(rows) > top {
+		rows = rows[:top]
+	}
+
+	fmt.Println()
+	w = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "PATH\tREQUESTS\t2XX\t3XX\t4XX\t5XX\tBYTES\t")
+	for _, row := range rows {
+		fmt.Fprintf(w, "%s\t%.0f\t%.0f\t%.0f\t%.0f\t%.0f\t%.0f\t\n", row.Path, row.Requests, row.Status2xx, row.Status3xx, row.Status4xx, row.Status5xx, pathBytes[row.Path])
+	}
+
+	return w.Flush()
+}
+
+var queryCommand = &command{
+	name:    "query",
+	args:    "[metric]",
+	summary: "print the stored points of a metric, or the metric names without metric, from the running loghound (see -http)",
+	setup: func(fs *flag.FlagSet) func(args []string) error {
+		from := fs.String("from", "-1h", "start of the range, unix seconds or a duration relative to now")
+		to := fs.String("to", "0s", "end of the range, unix seconds or a duration relative to now")
+		step := fs.Duration("step", 0, "aggregate the points in steps of this duration, not aggregated if 0")
+		agg := fs.String("agg", "avg", "aggregation of the points within a step: avg, sum, min, max, count or last")
+
+		return func(args []string) error {
+			if len(args) > 1 {
+				return errUsage
+			}
+
+			addr, err := apiAddr(fs)
+			if err != nil {
+				return err
+			}
+
+			if len(args) == 0 {
+				metrics, err := api.Metrics(addr)
+				if err != nil {
+					return err
+				}
+				for _, metric := range metrics {
+					fmt.Println(metric)
+				}
+				return nil
+			}
+
+			aggregation, err := tsdb.ParseAggregation(*agg)
+			if err != nil {
+				return err
+			}
+
+			points, err := api.Series(addr, args[0], *from, *to, *step, aggregation)
+			if err != nil {
+				return err
+			}
+
+			for _, p := range points {
+				fmt.Printf("%v\t%g\n", time.Unix(p.Timestamp, 0), p.Value)
+			}
+			return nil
+		}
+	},
+}
+
+var testRulesCommand = &command{
+	name:    "test-rules",
+	args:    "<spec>",
+	summary: "run an alert rules test spec on a simulated clock, with the rules of -rules or the spec ones",
+	setup: func(fs *flag.FlagSet) func(args []string) error {
+		fs.String("rules", "", "alert rules and slos file, overrides the one of the spec")
+
+		return func(args []string) error {
+			if len(args) != 1 {
+				return errUsage
+			}
+
+			cfg, err := loadConfig(fs)
+			if err != nil {
+				return fmt.Errorf("invalid configuration:\n%v", err)
+			}
+
+			// monitors log every evaluation
+			log.SetOutput(ioutil.Discard)
+
+			result, err := ruletest.Run(args[0], cfg.Alerts.RulesFile)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return exitCode(2)
+			}
+
+			for _, t := range result.Transitions {
+				fmt.Printf("[%v] %s\n", t.Time.Sub(result.Start), t.Text)
+			}
+			for _, f := range result.Failures {
+				fmt.Println("FAIL:", f)
+			}
+			if len(result.Failures) > 0 {
+				return exitCode(1)
+			}
+
+			fmt.Println("ok:", args[0])
+			return nil
+		}
+	},
+}
+
+var configCommand = &command{
+	name:    "config",
+	args:    "validate [file]",
+	summary: "validate a configuration file, the -config one if none is given, printing the line of every error",
+	setup: func(fs *flag.FlagSet) func(args []string) error {
+		return func(args []string) error {
+			if len(args) == 0 || len(args) > 2 || args[0] != "validate" {
+				return errUsage
+			}
+
+			path := global.config
+			if len(args) == 2 {
+				path = args[1]
+			}
+			if path == "" {
+				return errUsage
+			}
+
+			_, err := config.Load(path)
+			if errs, ok := err.(config.Errors); ok {
+				for _, e := range errs {
+					// reported as file:line: field: message
+					location := path
+					if e.Line > 0 {
+						location = fmt.Sprintf("%s:%d", path, e.Line)
+						e.Line = 0
+					}
+					fmt.Printf("%s: %v\n", location, e)
+				}
+				return exitCode(1)
+			}
+			if err != nil {
+				return err
+			}
+
+			fmt.Println("ok:", path)
+			return nil
+		}
+	},
+}
@@ -0,0 +1,148 @@
+package alerts
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/juacker/loghound/internal/message"
+)
+
+// fileState is the last status reported by filemon for a file
+type fileState struct {
+	status message.FileStatus
+	err    string
+	size   int64
+	grown  time.Time
+}
+
+// sourceWatch tracks when the log files matching a pattern were last seen alive,
+// by the lines read from them and the status reported by filemon. Until a line
+// or status arrives, the source is unknown since started
+type sourceWatch struct {
+	sync.Mutex
+	pattern  string
+	window   time.Duration
+	started  time.Time
+	lastLine time.Time
+	files    map[string]*fileState
+	reason   string
+	// resumed is the last sign of life, the cause of cancelling the alert
+	resumed string
+}
+
+func newSourceWatch(pattern string, window time.Duration, now time.Time) *sourceWatch {
+	return &sourceWatch{
+		pattern: pattern,
+		window:  window,
+		started: now,
+		files:   make(map[string]*fileState),
+	}
+}
+
+// match returns if file is watched, every file is when there is no pattern
+func (s *sourceWatch) match(file string) bool {
+	if s.pattern == "" {
+		return true
+	}
+
+	ok, _ := filepath.Match(s.pattern, file)
+	return ok
+}
+
+// line registers a line read from file at t
+func (s *sourceWatch) line(file string, t time.Time) {
+	if !s.match(file) {
+		return
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	s.lastLine = t
+	s.resumed = "lines resumed"
+	if f, ok := s.files[file]; ok {
+		f.grown = t
+	}
+}
+
+// status registers the status of a file reported at t
+func (s *sourceWatch) status(msg *message.FileMessage, t time.Time) {
+	if !s.match(msg.File) {
+		return
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	f, ok := s.files[msg.File]
+	if !ok {
+		f = &fileState{status: msg.Status, size: msg.Size, grown: t}
+		s.files[msg.File] = f
+	}
+
+	if msg.Status == message.FileOK && f.status != message.FileOK {
+		s.resumed = fmt.Sprintf("file %s reappeared", msg.File)
+	}
+
+	// any change, truncation included, means the file is alive
+	if msg.Size != f.size {
+		f.grown = t
+		s.resumed = fmt.Sprintf("file %s growing", msg.File)
+	}
+
+	f.status = msg.Status
+	f.err = msg.Error
+	f.size = msg.Size
+}
+
+// evaluate returns the seconds since the last line and if the source is absent at now:
+// a file is missing or unreadable, it is not growing, or no lines were read within the window.
+// errNoData is returned while nothing was heard from the source within the window since
+// started, so an alert restored active is kept until there is a sign of life
+func (s *sourceWatch) evaluate(now time.Time) (float64, bool, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	last := s.lastLine
+	if last.IsZero() {
+		last = s.started
+	}
+	idle := now.Sub(last).Truncate(time.Second)
+	s.reason = ""
+
+	for name, f := range s.files {
+		if f.status != message.FileOK {
+			s.reason = fmt.Sprintf("file %s is %s", name, f.status)
+			return idle.Seconds(), true, nil
+		}
+	}
+
+	for name, f := range s.files {
+		if now.Sub(f.grown) >= s.window {
+			s.reason = fmt.Sprintf("file %s not growing for %v", name, now.Sub(f.grown).Truncate(time.Second))
+			return idle.Seconds(), true, nil
+		}
+	}
+
+	if idle >= s.window {
+		s.reason = fmt.Sprintf("no lines for %v", idle)
+		return idle.Seconds(), true, nil
+	}
+
+	if s.resumed == "" {
+		return idle.Seconds(), false, errNoData
+	}
+
+	s.reason = s.resumed
+	return idle.Seconds(), false, nil
+}
+
+// why returns the reason of the last evaluation, why the alert is raised or cancelled
+func (s *sourceWatch) why() string {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.reason
+}
@@ -4,70 +4,221 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"sort"
 	"sync"
 	"time"
 
 	"github.com/juacker/loghound/internal/broker"
+	"github.com/juacker/loghound/internal/clock"
+	"github.com/juacker/loghound/internal/health"
 	"github.com/juacker/loghound/internal/message"
+	"github.com/juacker/loghound/internal/slo"
+	"github.com/juacker/loghound/internal/tsdb"
 )
 
-type metricMonitor struct {
-	ctl             chan bool
-	wg              *sync.WaitGroup
-	broker          broker.Link
-	store           *metricStore
-	metric          string
-	operation       string
-	threshold       float64
-	currentSeverity message.Severity
+// kind of the monitors of SLO burn rate alerts
+const kindSLO = "slo"
+
+// Options sets the behaviour shared by all the alert monitors
+type Options struct {
+	// Repeat is the interval to notify again active alerts not acknowledged, 0 disables it
+	Repeat time.Duration
+	// State restores alert severities and records transitions, silences and acknowledgements
+	State *State
+	// DB, if set, is used to load the metric history at startup
+	DB *tsdb.DB
+	// SLOs get a monitor for their burn rate alerts, and their error budget is reported
+	SLOs []slo.SLO
+	// Clock tells the time to the monitors, the system clock if nil
+	Clock clock.Clock
 }
 
-func (a *metricMonitor) loop() {
+type alertsModule struct {
+	ctl       chan bool
+	wg        *sync.WaitGroup
+	broker    broker.Link
+	monitors  []*metricMonitor
+	templates []*ruleTemplate
+	opts      Options
+	// absent tells if there are absent rules, lines and files status are
+	// ignored without them
+	absent bool
+}
+
+func (m *alertsModule) loop() {
 	log.Println("alerts: initializing alerts monitoring")
 
-	ticker := time.NewTicker(5 * time.Second)
+	ticker := m.opts.Clock.NewTicker(5 * time.Second)
+	defer ticker.Stop()
 
 LOOP:
 	for {
 		select {
-		case payload := <-a.broker.Receive():
+		case payload := <-m.broker.Receive():
 			log.Println("alerts: new message received")
-			err := a.processMessage(payload)
+			err := m.processMessage(payload)
 			if err != nil {
 				log.Println("alerts: failed processing message: ", err)
 			}
 		case <-ticker.C:
 			log.Println("alerts: checking alerts")
-			err := a.checkAlert()
-			if err != nil {
-				log.Println("alerts: failed cheking alerts: ", err)
+			for _, monitor := range m.monitors {
+				start := time.Now()
+				err := monitor.checkAlert()
+				health.Observe(health.AlertEvaluation, time.Since(start), "rule", monitor.name)
+				if err != nil {
+					log.Println("alerts: failed cheking alerts: ", monitor.name, err)
+				}
 			}
-		case <-a.ctl:
+		case <-m.ctl:
 			log.Println("alerts: ctl signal received, exiting")
 			break LOOP
 		}
 	}
 
-	a.wg.Done()
+	m.wg.Done()
 }
 
-func (a *metricMonitor) processMessage(payload []byte) error {
-	var msg message.StatMessage
+func (m *alertsModule) processMessage(payload []byte) error {
+	var msg message.Message
 	err := json.Unmarshal(payload, &msg)
 	if err != nil {
 		return err
 	}
 
-	// check message is the expected
-	if !msg.IsValid() {
+	switch msg.Type {
+	case message.TypeStat:
+		var statMsg message.StatMessage
+		err = json.Unmarshal(payload, &statMsg)
+		if err != nil {
+			return err
+		}
+
+		if len(m.templates) > 0 {
+			metrics := make([]string, 0, len(statMsg.Stats))
+			for metric := range statMsg.Stats {
+				metrics = append(metrics, metric)
+			}
+			m.discover(metrics)
+		}
+
+		for _, monitor := range m.monitors {
+			err = monitor.processStatMessage(&statMsg)
+			if err != nil {
+				log.Println("alerts: failed processing message: ", monitor.name, err)
+			}
+		}
+	case message.TypeCLF:
+		if !m.absent {
+			return nil
+		}
+
+		var clfMsg message.CLFMessage
+		err = json.Unmarshal(payload, &clfMsg)
+		if err != nil {
+			return err
+		}
+
+		for _, monitor := range m.monitors {
+			monitor.processCLFMessage(&clfMsg)
+		}
+	case message.TypeFile:
+		if !m.absent {
+			return nil
+		}
+
+		var fileMsg message.FileMessage
+		err = json.Unmarshal(payload, &fileMsg)
+		if err != nil {
+			return err
+		}
+
+		for _, monitor := range m.monitors {
+			monitor.processFileMessage(&fileMsg)
+		}
+	case message.TypeConfig:
+		var configMsg message.ConfigMessage
+		err = json.Unmarshal(payload, &configMsg)
+		if err != nil {
+			return err
+		}
+
+		return m.processConfigMessage(&configMsg)
+	default:
 		return fmt.Errorf("invalid message")
 	}
 
-	return a.processStatMessage(&msg)
+	return nil
+}
+
+// discover creates the instances of the rule templates for new label values in metrics
+func (m *alertsModule) discover(metrics []string) {
+	sort.Strings(metrics)
+
+	for _, t := range m.templates {
+		rules, labels := t.discover(metrics)
+
+		for i, r := range rules {
+			log.Println("alerts: new instance of rule template ", r.Name)
+			monitor := newMetricMonitor(m.broker, r, m.opts)
+			monitor.labels = labels[i]
+			monitor.template = t
+			m.monitors = append(m.monitors, monitor)
+		}
+
+		// logged once, when the instances created reach the limit
+		if len(rules) > 0 && len(t.instances) >= maxInstances {
+			log.Printf("alerts: rule template %s reached %d instances", t.rule.Name, maxInstances)
+		}
+	}
+}
+
+type metricMonitor struct {
+	broker          broker.Link
+	store           *metricStore
+	rule            Rule
+	template        *ruleTemplate
+	name            string
+	labels          map[string]string
+	kind            string
+	metric          string
+	operation       string
+	threshold       float64
+	baseline        *baseline
+	window          *windowStore
+	expr            expression
+	volume          expression
+	minVolume       float64
+	source          *sourceWatch
+	burn            *burnWatch
+	currentSeverity message.Severity
+	state           *State
+	repeat          time.Duration
+	lastNotified    time.Time
+	clock           clock.Clock
 }
 
 func (a *metricMonitor) processStatMessage(msg *message.StatMessage) error {
 
+	if a.burn != nil {
+		if total, ok := msg.Stats[a.burn.slo.TotalMetric()]; ok {
+			a.burn.store.push(requests{
+				Timestamp: msg.End,
+				Good:      msg.Stats[a.burn.slo.GoodMetric()],
+				Total:     total,
+			})
+		}
+		return nil
+	}
+
+	if a.window != nil {
+		a.window.push(snapshot{
+			Timestamp: msg.End,
+			Stats:     msg.Stats,
+		})
+		return nil
+	}
+
 	if val, ok := msg.Stats[a.metric]; ok {
 		a.store.push(
 			datapoint{
@@ -80,50 +231,538 @@ func (a *metricMonitor) processStatMessage(msg *message.StatMessage) error {
 	return nil
 }
 
+// processCLFMessage registers the lines read for absent rules
+func (a *metricMonitor) processCLFMessage(msg *message.CLFMessage) {
+	if a.source != nil {
+		a.source.line(msg.File, a.clock.Now())
+	}
+}
+
+// processFileMessage registers the files status for absent rules
+func (a *metricMonitor) processFileMessage(msg *message.FileMessage) {
+	if a.source != nil {
+		a.source.status(msg, a.clock.Now())
+	}
+}
+
+// evaluate returns the current metric value and if it raises the alert,
+// errNoData if the alert can't be evaluated
+func (a *metricMonitor) evaluate() (float64, bool, error) {
+	switch a.kind {
+	case RuleExpression:
+		return a.evaluateExpression()
+	case RuleAbsent:
+		return a.source.evaluate(a.clock.Now())
+	case kindSLO:
+		burn, raised := a.burn.evaluate(a.clock.Now().Unix())
+		return burn, raised, nil
+	}
+
+	mean := a.store.mean(a.clock.Now())
+
+	if a.kind == RuleAnomaly {
+		now := a.clock.Now().Unix()
+		raised := a.baseline.anomalous(now, mean)
+		a.baseline.observe(now, mean)
+		return mean, raised, nil
+	}
+
+	return mean, mean > a.threshold, nil
+}
+
+// evaluateExpression computes the rule expression over the window, provided the
+// volume reaches the minimum and there are no divisions by zero
+func (a *metricMonitor) evaluateExpression() (float64, bool, error) {
+	now := a.clock.Now()
+
+	if a.volume != nil {
+		volume, err := a.volume.eval(a.window, now)
+		if err == errDivisionByZero || err == nil && volume < a.minVolume {
+			return 0, false, errNoData
+		}
+		if err != nil {
+			return 0, false, err
+		}
+	}
+
+	value, err := a.expr.eval(a.window, now)
+	if err == errDivisionByZero {
+		return 0, false, errNoData
+	}
+	if err != nil {
+		return 0, false, err
+	}
+
+	return value, value > a.threshold, nil
+}
+
+// text returns the alert text for a new, canceled or repeated alert
+func (a *metricMonitor) text(severity message.Severity, repeated bool, value float64) string {
+	now := a.clock.Now().Truncate(time.Second)
+
+	if a.kind == RuleAnomaly {
+		expected, lower, upper := a.baseline.band(now.Unix())
+
+		switch {
+		case repeated:
+			return fmt.Sprintf("Anomaly on %s still active - value = {%.2f}, expected = {%.2f} [%.2f, %.2f], at {%v}", a.metric, value, expected, lower, upper, now)
+		case severity == message.SeverityCanceled:
+			return fmt.Sprintf("Anomaly on %s CANCELED - value = {%.2f}, expected = {%.2f} [%.2f, %.2f], at {%v}", a.metric, value, expected, lower, upper, now)
+		default:
+			return fmt.Sprintf("Anomaly on %s generated an alert - value = {%.2f}, expected = {%.2f} [%.2f, %.2f], triggered at {%v}", a.metric, value, expected, lower, upper, now)
+		}
+	}
+
+	if a.kind == kindSLO {
+		_, _, remaining := a.burn.remaining(now.Unix())
+
+		switch {
+		case repeated:
+			return fmt.Sprintf("SLO %s burn rate alert still active - %s, budget remaining = {%.1f%%}, at {%v}", a.name, a.burn.status, remaining*100, now)
+		case severity == message.SeverityCanceled:
+			return fmt.Sprintf("SLO %s burn rate alert CANCELED - burn rate = {%.2fx}, budget remaining = {%.1f%%}, at {%v}", a.name, value, remaining*100, now)
+		default:
+			return fmt.Sprintf("SLO %s burn rate generated an alert - %s, budget remaining = {%.1f%%}, triggered at {%v}", a.name, a.burn.status, remaining*100, now)
+		}
+	}
+
+	if a.kind == RuleAbsent {
+		switch {
+		case repeated:
+			return fmt.Sprintf("No data from %s still active - %s, at {%v}", a.metric, a.source.why(), now)
+		case severity == message.SeverityCanceled:
+			return fmt.Sprintf("No data from %s CANCELED - %s, at {%v}", a.metric, a.source.why(), now)
+		default:
+			return fmt.Sprintf("No data from %s generated an alert - %s, triggered at {%v}", a.metric, a.source.why(), now)
+		}
+	}
+
+	if a.kind == RuleExpression {
+		switch {
+		case repeated:
+			return fmt.Sprintf("Expression %s alert still active - value = {%.4f}, threshold = {%.4f}, at {%v}", a.metric, value, a.threshold, now)
+		case severity == message.SeverityCanceled:
+			return fmt.Sprintf("Expression %s alert CANCELED - value = {%.4f}, threshold = {%.4f}, at {%v}", a.metric, value, a.threshold, now)
+		default:
+			return fmt.Sprintf("Expression %s generated an alert - value = {%.4f}, threshold = {%.4f}, triggered at {%v}", a.metric, value, a.threshold, now)
+		}
+	}
+
+	switch {
+	case repeated:
+		return fmt.Sprintf("High traffic alert still active - hits = {%.2f}, at {%v}", value, now)
+	case severity == message.SeverityCanceled:
+		return fmt.Sprintf("High traffic alert CANCELED - hits = {%.2f}, at {%v}", value, now)
+	default:
+		return fmt.Sprintf("High traffic generated an alert - hits = {%.2f}, triggered at {%v}", value, now)
+	}
+}
+
 func (a *metricMonitor) checkAlert() error {
-	mean := a.store.mean()
-	thresholdRaised := mean > a.threshold
+	value, raised, err := a.evaluate()
+	if err == errNoData {
+		// keep the current severity until there is enough data again
+		log.Println("alerts: not enough data to evaluate rule ", a.name)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if a.baseline != nil && a.baseline.ready() {
+		err := a.sendBaseline()
+		if err != nil {
+			log.Println("alerts: failed sending baseline: ", err)
+		}
+	}
+
+	if a.burn != nil {
+		err := a.sendSLO()
+		if err != nil {
+			log.Println("alerts: failed sending slo status: ", err)
+		}
+	}
 
 	var text string
 	var msg *message.AlertMessage
 
-	if thresholdRaised && a.currentSeverity == message.SeverityCanceled {
-		log.Println("alerts: new alert detected for metric ", a.metric)
-		text = fmt.Sprintf("High traffic generated an alert - hits = {%.2f}, triggered at {%v}", mean, time.Now().Truncate(time.Second))
+	if raised && a.currentSeverity == message.SeverityCanceled {
+		log.Println("alerts: new alert detected for rule ", a.name)
 		a.currentSeverity = message.SeverityMax
+		text = a.text(a.currentSeverity, false, value)
 		msg = message.NewAlertMessage(a.metric, text, a.currentSeverity)
-	} else if !thresholdRaised && a.currentSeverity == message.SeverityMax {
-		log.Println("alerts: cancelling alert for metric ", a.metric)
-		text = fmt.Sprintf("High traffic alert CANCELED - hits = {%.2f}, at {%v}", mean, time.Now().Truncate(time.Second))
+	} else if !raised && a.currentSeverity == message.SeverityMax {
+		log.Println("alerts: cancelling alert for rule ", a.name)
 		a.currentSeverity = message.SeverityCanceled
+		text = a.text(a.currentSeverity, false, value)
+		msg = message.NewAlertMessage(a.metric, text, a.currentSeverity)
+	} else if a.shouldRepeat() {
+		log.Println("alerts: notifying again active alert for rule ", a.name)
+		text = a.text(a.currentSeverity, true, value)
 		msg = message.NewAlertMessage(a.metric, text, a.currentSeverity)
+		return a.notify(msg, value)
 	} else {
-		log.Println("alerts: nothing to do for alert ", a.metric, mean)
+		log.Println("alerts: nothing to do for alert ", a.name, value)
 		return nil
 	}
 
+	a.record(value, text)
+	return a.notify(msg, value)
+}
+
+// key identifies the alerts of the monitor in the state, the rule name or,
+// not to collide with rule names, the SLO name prefixed by slo:
+func (a *metricMonitor) key() string {
+	if a.kind == kindSLO {
+		return kindSLO + ":" + a.name
+	}
+	return a.name
+}
+
+// record persists a transition to the current severity in the state
+func (a *metricMonitor) record(value float64, text string) {
+	if a.state == nil {
+		return
+	}
+
+	err := a.state.Record(Transition{
+		Time:     a.clock.Now(),
+		Rule:     a.key(),
+		Labels:   a.labels,
+		Metric:   a.metric,
+		Value:    value,
+		Severity: a.currentSeverity,
+		Text:     text,
+	})
+	if err != nil {
+		log.Println("alerts: failed persisting alert state: ", err)
+	}
+}
+
+// retire cancels the active alert of a monitor whose rule was removed
+func (a *metricMonitor) retire() error {
+	if a.currentSeverity == message.SeverityCanceled {
+		return nil
+	}
+
+	log.Println("alerts: cancelling alert for removed rule ", a.name)
+	a.currentSeverity = message.SeverityCanceled
+	text := fmt.Sprintf("Alert %s CANCELED - rule removed, at {%v}", a.name, a.clock.Now().Truncate(time.Second))
+
+	a.record(0, text)
+	return a.notify(message.NewAlertMessage(a.metric, text, a.currentSeverity), 0)
+}
+
+// sendBaseline sends the current baseline band of anomaly rules
+func (a *metricMonitor) sendBaseline() error {
+	now := a.clock.Now().Unix()
+	expected, lower, upper := a.baseline.band(now)
+
+	return a.broker.Send(broker.TopicBaseline, message.NewBaselineMessage(a.name, a.metric, now, expected, lower, upper))
+}
+
+// sendSLO sends the error budget and burn rate of SLO monitors
+func (a *metricMonitor) sendSLO() error {
+	now := a.clock.Now().Unix()
+	good, total, remaining := a.burn.remaining(now)
+
+	var burn float64
+	if len(a.burn.slo.Windows) > 0 {
+		burn = a.burn.burn(a.burn.slo.Windows[0].Long, now)
+	}
+
+	return a.broker.Send(broker.TopicSLO, message.NewSLOMessage(a.name, a.burn.slo.Objective, good, total, remaining, burn))
+}
+
+// shouldRepeat returns if an active alert not acknowledged has to be notified again
+func (a *metricMonitor) shouldRepeat() bool {
+	if a.repeat <= 0 || a.currentSeverity == message.SeverityCanceled {
+		return false
+	}
+
+	if a.state != nil && a.state.Acked(a.key()) {
+		return false
+	}
+
+	return a.clock.Now().Sub(a.lastNotified) >= a.repeat
+}
+
+// notify sends the alert message to the broker, flagged if it is silenced or acknowledged
+func (a *metricMonitor) notify(msg *message.AlertMessage, value float64) error {
+	msg.Rule = a.key()
+	msg.Labels = a.labels
+	msg.Value = value
+
+	if a.state != nil {
+		msg.Silenced = a.state.Silenced(a.metric, a.clock.Now())
+		msg.Acked = a.state.Acked(a.key())
+	}
+
+	a.lastNotified = a.clock.Now()
 	return a.broker.Send(broker.TopicAlert, msg)
 }
 
-// Run starts alerts
-func Run(wg *sync.WaitGroup, ctl chan bool, metric, operation string, interval int64, threshold int) {
-	conn, err := broker.NewConnection(broker.TopicStat)
+// backfill loads the metric points within the store interval from the storage.
+// anomaly baselines are trained with the history of the last two seasons
+func (a *metricMonitor) backfill(db *tsdb.DB) error {
+	now := a.clock.Now().Unix()
+
+	if a.window != nil {
+		return a.backfillWindow(db, now)
+	}
+
+	if a.burn != nil {
+		return a.backfillSLO(db, now)
+	}
+
+	points, err := db.Query(a.metric, now-a.store.interval, now, 0, tsdb.AggSum)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range points {
+		a.store.push(datapoint{
+			Timestamp: p.Timestamp,
+			Value:     int(p.Value),
+		})
+	}
+
+	log.Printf("alerts: loaded %d points from storage for rule %s", len(points), a.name)
+
+	if a.baseline == nil {
+		return nil
+	}
+
+	history := int64(len(a.baseline.seasonal)) * a.baseline.step * 2
+	if history == 0 {
+		history = minSamples * a.baseline.step * 10
+	}
+
+	points, err = db.Query(a.metric, now-history, now, a.baseline.step, tsdb.AggSum)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range points {
+		// observations are rates per second, like the store mean
+		a.baseline.observe(p.Timestamp, p.Value/float64(a.baseline.step))
+	}
+
+	log.Printf("alerts: trained baseline with %d points from storage for rule %s", len(points), a.name)
+	return nil
+}
+
+// backfillWindow loads the snapshots within the window of expression rules from the storage
+func (a *metricMonitor) backfillWindow(db *tsdb.DB, now int64) error {
+	snapshots := make(map[int64]map[string]int)
+
+	for _, metric := range db.Metrics() {
+		points, err := db.Query(metric, now-a.window.interval, now, 0, tsdb.AggSum)
+		if err != nil {
+			return err
+		}
+
+		for _, p := range points {
+			if snapshots[p.Timestamp] == nil {
+				snapshots[p.Timestamp] = make(map[string]int)
+			}
+			snapshots[p.Timestamp][metric] = int(p.Value)
+		}
+	}
+
+	timestamps := make([]int64, 0, len(snapshots))
+	for ts := range snapshots {
+		timestamps = append(timestamps, ts)
+	}
+	sort.Slice(timestamps, func(i, j int) bool { return timestamps[i] < timestamps[j] })
+
+	for _, ts := range timestamps {
+		a.window.push(snapshot{
+			Timestamp: ts,
+			Stats:     snapshots[ts],
+		})
+	}
+
+	log.Printf("alerts: loaded %d snapshots from storage for rule %s", len(timestamps), a.name)
+	return nil
+}
+
+// backfillSLO loads the requests of the SLO period from the storage, aggregated
+// out of the burn windows
+func (a *metricMonitor) backfillSLO(db *tsdb.DB, now int64) error {
+	store := a.burn.store
+	good, total := a.burn.slo.GoodMetric(), a.burn.slo.TotalMetric()
+
+	query := func(from, to, step int64) (map[int64]*requests, []int64, error) {
+		points := make(map[int64]*requests)
+		timestamps := make([]int64, 0)
+
+		for i, metric := range []string{good, total} {
+			values, err := db.Query(metric, from, to, step, tsdb.AggSum)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			for _, p := range values {
+				r, ok := points[p.Timestamp]
+				if !ok {
+					r = &requests{Timestamp: p.Timestamp}
+					points[p.Timestamp] = r
+					timestamps = append(timestamps, p.Timestamp)
+				}
+				if i == 0 {
+					r.Good = int(p.Value)
+				} else {
+					r.Total = int(p.Value)
+				}
+			}
+		}
+
+		sort.Slice(timestamps, func(i, j int) bool { return timestamps[i] < timestamps[j] })
+		return points, timestamps, nil
+	}
+
+	// raw points within the burn windows, from the start of a budget step
+	rawFrom := now - store.window
+	rawFrom -= rawFrom % budgetStep
+
+	buckets, timestamps, err := query(now-store.period, rawFrom-1, budgetStep)
+	if err != nil {
+		return err
+	}
+
+	store.Lock()
+	for _, ts := range timestamps {
+		store.add(*buckets[ts])
+	}
+	store.Unlock()
+
+	points, timestamps, err := query(rawFrom, now, 0)
+	if err != nil {
+		return err
+	}
+
+	for _, ts := range timestamps {
+		store.push(*points[ts])
+	}
+
+	log.Printf("alerts: loaded %d buckets and %d points from storage for slo %s", len(buckets), len(points), a.name)
+	return nil
+}
+
+func newMetricMonitor(link broker.Link, r Rule, opts Options) *metricMonitor {
+	if opts.Clock == nil {
+		opts.Clock = clock.New()
+	}
+
+	monitor := &metricMonitor{
+		broker:    link,
+		rule:      r,
+		name:      r.Name,
+		kind:      r.Type,
+		metric:    r.Metric,
+		operation: "mean",
+		threshold: r.Threshold,
+		store: &metricStore{
+			points:   make([]datapoint, 0),
+			interval: int64(r.Window.Seconds()),
+		},
+		state:        opts.State,
+		repeat:       opts.Repeat,
+		clock:        opts.Clock,
+		lastNotified: opts.Clock.Now(),
+	}
+
+	if opts.State != nil {
+		monitor.currentSeverity = opts.State.Severity(monitor.key())
+	}
+
+	switch r.Type {
+	case RuleAnomaly:
+		monitor.baseline = newBaseline(r)
+	case RuleExpression:
+		// rules are validated when loaded
+		monitor.expr, _ = parseExpression(r.Expr)
+		if r.Volume != "" {
+			monitor.volume, _ = parseExpression(r.Volume)
+		} else {
+			monitor.volume = denominator(monitor.expr)
+		}
+		monitor.minVolume = r.MinVolume
+		monitor.window = &windowStore{
+			snapshots: make([]snapshot, 0),
+			interval:  int64(r.Window.Seconds()),
+		}
+		if monitor.metric == "" {
+			monitor.metric = r.Expr
+		}
+	case RuleAbsent:
+		monitor.source = newSourceWatch(r.File, r.Window, opts.Clock.Now())
+		if monitor.metric == "" {
+			monitor.metric = r.File
+		}
+		if monitor.metric == "" {
+			monitor.metric = "files"
+		}
+	}
+
+	if opts.DB != nil && r.Type != RuleAbsent {
+		err := monitor.backfill(opts.DB)
+		if err != nil {
+			log.Println("alerts: failed loading points from storage: ", err)
+		}
+	}
+
+	return monitor
+}
+
+// newSLOMonitor returns a monitor for the burn rate alerts of an SLO
+func newSLOMonitor(link broker.Link, s slo.SLO, opts Options) *metricMonitor {
+	rule := Rule{
+		Name:   s.Name,
+		Type:   kindSLO,
+		Metric: "slo." + s.Name,
+	}
+
+	monitor := newMetricMonitor(link, rule, Options{Repeat: opts.Repeat, State: opts.State, Clock: opts.Clock})
+	monitor.burn = newBurnWatch(s)
+
+	if opts.DB != nil {
+		err := monitor.backfill(opts.DB)
+		if err != nil {
+			log.Println("alerts: failed loading points from storage: ", err)
+		}
+	}
+
+	return monitor
+}
+
+// Run starts alerts, with a monitor for each rule, rule template instance and SLO.
+// The alert severities are restored from the state in opts, and transitions are recorded there.
+// Reloaded rules and SLOs only replace the monitors of the rules and SLOs that changed
+func Run(wg *sync.WaitGroup, ctl chan bool, rules []Rule, opts Options) {
+	// lines and files status are needed by absent rules, which may be added on reload
+	topics := []int{broker.TopicStat, broker.TopicConfig, broker.TopicData, broker.TopicFile}
+
+	conn, err := broker.NewConnection("alerts", topics...)
 	if err != nil {
 		log.Fatal("alerts: failed opening broker connection ", err)
 	}
 
-	p := &metricMonitor{
+	if opts.Clock == nil {
+		opts.Clock = clock.New()
+	}
+
+	m := &alertsModule{
 		ctl:       ctl,
 		wg:        wg,
 		broker:    conn,
-		metric:    metric,
-		operation: operation,
-		threshold: float64(threshold),
-		store: &metricStore{
-			points:   make([]datapoint, 0),
-			interval: interval,
-		},
+		monitors:  make([]*metricMonitor, 0, len(rules)+len(opts.SLOs)),
+		templates: make([]*ruleTemplate, 0),
+		opts:      opts,
 	}
 
-	p.loop()
+	m.reload(rules, opts.SLOs)
+
+	m.loop()
 }
@@ -3,11 +3,17 @@ package alerts
 import (
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/juacker/loghound/internal/broker"
+	"github.com/juacker/loghound/internal/clock"
 	"github.com/juacker/loghound/internal/message"
+	"github.com/juacker/loghound/internal/slo"
 	"github.com/juacker/loghound/internal/testutils"
 	tassert "github.com/stretchr/testify/assert"
 )
@@ -21,25 +27,33 @@ func TestInternalAlerts(t *testing.T) {
 		T: t,
 	}
 
+	c := clock.NewFake(time.Date(2021, 1, 1, 10, 0, 0, 0, time.UTC))
+
 	monitor := &metricMonitor{
 		broker: &link,
+		clock:  c,
+	}
+
+	module := &alertsModule{
+		broker:   &link,
+		monitors: []*metricMonitor{monitor},
 	}
 
 	// processMessage invalid message
 	t.Run("processMessage - fail unmarshaling", func(t *testing.T) {
-		err := monitor.processMessage([]byte("msg"))
+		err := module.processMessage([]byte("msg"))
 		assert.Equal("invalid character 'm' looking for beginning of value", err.Error())
 	})
 
 	// processMessage invalid message
 	t.Run("processMessage - invalid message", func(t *testing.T) {
 		msg := message.Message{
-			Type: message.TypeCLF,
+			Type: message.TypeAlert,
 		}
 
 		payload, err := json.Marshal(msg)
 		assert.Nil(err, "err nil")
-		assert.Equal(fmt.Errorf("invalid message"), monitor.processMessage(payload))
+		assert.Equal(fmt.Errorf("invalid message"), module.processMessage(payload))
 	})
 
 	// processMessage success
@@ -48,11 +62,11 @@ func TestInternalAlerts(t *testing.T) {
 
 		payload, err := json.Marshal(msg)
 		assert.Nil(err, "err nil")
-		assert.Equal(nil, monitor.processMessage(payload))
+		assert.Equal(nil, module.processMessage(payload))
 	})
 
 	// processMessage success not my metric
-	t.Run("processMessage - success - my metric", func(t *testing.T) {
+	t.Run("processMessage - success - not my metric", func(t *testing.T) {
 		monitor.metric = "my.metric"
 		monitor.store = &metricStore{
 			points:   make([]datapoint, 0),
@@ -62,11 +76,11 @@ func TestInternalAlerts(t *testing.T) {
 		metrics := make(map[string]int)
 		metrics["another.metric"] = 42
 
-		msg := message.NewStatMessage(metrics, 0, time.Now().Unix())
+		msg := message.NewStatMessage(metrics, 0, c.Now().Unix())
 
 		payload, err := json.Marshal(msg)
 		assert.Nil(err, "err nil")
-		assert.Equal(nil, monitor.processMessage(payload), "err nil")
+		assert.Equal(nil, module.processMessage(payload), "err nil")
 		assert.Equal(0, monitor.store.sum, "expected store sum")
 	})
 
@@ -81,11 +95,11 @@ func TestInternalAlerts(t *testing.T) {
 		metrics := make(map[string]int)
 		metrics["my.metric"] = 42
 
-		msg := message.NewStatMessage(metrics, 0, time.Now().Unix())
+		msg := message.NewStatMessage(metrics, 0, c.Now().Unix())
 
 		payload, err := json.Marshal(msg)
 		assert.Nil(err, "err nil")
-		assert.Equal(nil, monitor.processMessage(payload), "err nil")
+		assert.Equal(nil, module.processMessage(payload), "err nil")
 		assert.Equal(42, monitor.store.sum, "expected store sum")
 	})
 
@@ -114,10 +128,12 @@ func TestInternalAlerts(t *testing.T) {
 		}
 
 		topic := broker.TopicAlert
-		text := fmt.Sprintf("High traffic generated an alert - hits = {%.2f}, triggered at {%v}", float64(10), time.Now().Truncate(time.Second))
+		text := fmt.Sprintf("High traffic generated an alert - hits = {%.2f}, triggered at {%v}", float64(10), c.Now())
 
 		link.ExpectedSentTopic = &topic
-		link.ExpectedSentMsg = message.NewAlertMessage(monitor.metric, text, message.SeverityMax)
+		expected := message.NewAlertMessage(monitor.metric, text, message.SeverityMax)
+		expected.Value = 10
+		link.ExpectedSentMsg = expected
 
 		assert.Nil(nil, monitor.checkAlert(), "err nil")
 		assert.Equal(1, link.SendCount, "message sent to broker")
@@ -136,7 +152,7 @@ func TestInternalAlerts(t *testing.T) {
 		}
 
 		topic := broker.TopicAlert
-		text := fmt.Sprintf("High traffic alert CANCELED - hits = {%.2f}, at {%v}", 0.0, time.Now().Truncate(time.Second))
+		text := fmt.Sprintf("High traffic alert CANCELED - hits = {%.2f}, at {%v}", 0.0, c.Now())
 
 		link.ExpectedSentTopic = &topic
 		link.ExpectedSentMsg = message.NewAlertMessage(monitor.metric, text, message.SeverityCanceled)
@@ -144,4 +160,675 @@ func TestInternalAlerts(t *testing.T) {
 		assert.Nil(nil, monitor.checkAlert(), "err nil")
 		assert.Equal(1, link.SendCount, "message sent to broker")
 	})
+
+	// checkAlert success state persisted
+	t.Run("checkAlert - success - state persisted", func(t *testing.T) {
+		link.Reset()
+
+		dir, err := ioutil.TempDir("", "alerts")
+		assert.Nil(err, "err nil")
+		defer os.RemoveAll(dir)
+
+		path := filepath.Join(dir, "state.json")
+		state, err := LoadState(path)
+		assert.Nil(err, "err nil")
+
+		monitor.threshold = 1
+		monitor.name = "my.rule"
+		monitor.metric = "my.metric"
+		monitor.state = state
+		monitor.currentSeverity = state.Severity(monitor.name)
+		monitor.store = &metricStore{
+			sum:      10,
+			interval: 1,
+		}
+
+		topic := broker.TopicAlert
+		text := fmt.Sprintf("High traffic generated an alert - hits = {%.2f}, triggered at {%v}", float64(10), c.Now())
+
+		expected := message.NewAlertMessage(monitor.metric, text, message.SeverityMax)
+		expected.Rule = monitor.name
+		expected.Value = 10
+		link.ExpectedSentTopic = &topic
+		link.ExpectedSentMsg = expected
+
+		assert.Nil(monitor.checkAlert(), "err nil")
+		assert.Equal(1, link.SendCount, "message sent to broker")
+
+		// state reloaded after a restart keeps the alert active
+		state, err = LoadState(path)
+		assert.Nil(err, "err nil")
+		assert.Equal(message.SeverityMax, state.Severity(monitor.name), "alert still active")
+		assert.Equal(1, len(state.Transitions()), "transition recorded")
+		assert.Equal(1, len(state.Active()), "one active alert")
+
+		monitor.name = ""
+		monitor.state = nil
+	})
+}
+
+func TestInternalAnomaly(t *testing.T) {
+
+	assert := tassert.New(t)
+
+	rule := Rule{Name: "anomaly", Type: RuleAnomaly, Metric: "requests.total"}
+	rule.setDefaults()
+	assert.Nil(rule.Validate(), "valid rule")
+
+	t.Run("baseline - ewma detects deviations", func(t *testing.T) {
+		b := newBaseline(rule)
+
+		for i := int64(0); i < 20; i++ {
+			value := 10.0
+			if i%2 == 0 {
+				value = 12
+			}
+			b.observe(i*60, value)
+		}
+
+		assert.True(b.ready(), "baseline ready")
+		assert.False(b.anomalous(20*60, 11), "normal value")
+		assert.True(b.anomalous(20*60, 30), "anomalous value")
+		assert.True(b.anomalous(20*60, 0), "anomalous value")
+
+		// more than one observation per step is ignored
+		samples := b.samples
+		b.observe(19*60+30, 100)
+		assert.Equal(samples, b.samples, "observation ignored")
+	})
+
+	t.Run("baseline - seasonal pattern", func(t *testing.T) {
+		seasonal := rule
+		seasonal.Season = 10 * time.Minute
+		b := newBaseline(seasonal)
+
+		// a season of ten steps, high traffic in the first half
+		for i := int64(0); i < 100; i++ {
+			value := 5.0
+			if i%10 < 5 {
+				value = 50
+			}
+			b.observe(i*60, value)
+		}
+
+		assert.False(b.anomalous(100*60, 50), "high traffic expected in the first half")
+		assert.True(b.anomalous(105*60, 50), "high traffic not expected in the second half")
+	})
+
+	t.Run("ParseRules - defaults and validation", func(t *testing.T) {
+		rules, err := ParseRules([]byte(`
+rules:
+  - name: high-traffic
+    metric: requests.total
+    threshold: 10
+  - name: traffic-anomaly
+    type: anomaly
+    metric: requests.total
+    window: 1m
+    season: 24h
+`))
+		assert.Nil(err, "err nil")
+		assert.Equal(2, len(rules), "two rules")
+		assert.Equal(RuleThreshold, rules[0].Type, "default type")
+		assert.Equal(2*time.Minute, rules[0].Window, "default window")
+		assert.Equal(time.Minute, rules[1].Window, "window")
+		assert.Equal(24*time.Hour, rules[1].Season, "season")
+		assert.Equal(float64(3), rules[1].Deviations, "default deviations")
+
+		_, err = ParseRules([]byte(`
+rules:
+  - name: unknown
+    type: magic
+    metric: requests.total
+`))
+		assert.Equal("alerts: invalid rule: rule unknown: unknown type magic", err.Error())
+	})
+}
+
+func TestInternalExpression(t *testing.T) {
+
+	assert := tassert.New(t)
+
+	t.Run("matchMetric - wildcards within segments", func(t *testing.T) {
+		assert.True(matchMetric("path.*.status.5*.requests", "path./api.status.503.requests"))
+		assert.True(matchMetric("path./api.status.50?.requests", "path./api.status.500.requests"))
+		assert.False(matchMetric("path.*.status.5*.requests", "path./api.status.404.requests"))
+		assert.False(matchMetric("path.*.requests", "path./api.status.500.requests"))
+		assert.True(matchMetric("requests.total", "requests.total"))
+	})
+
+	t.Run("parseExpression - precedence and errors", func(t *testing.T) {
+		expr, err := parseExpression(`"path./api.status.5*.requests" / "path./api.requests" * 100`)
+		assert.Nil(err, "err nil")
+		assert.Equal(`((sum("path./api.status.5*.requests") / sum("path./api.requests")) * 100)`, expr.String())
+
+		expr, err = parseExpression(`max(path.*.bytes) - -2 + requests.total`)
+		assert.Nil(err, "err nil")
+		assert.Equal(`((max("path.*.bytes") - -2) + sum("requests.total"))`, expr.String())
+
+		_, err = parseExpression(`(requests.total`)
+		assert.Equal("missing ) at position 15", err.Error())
+
+		_, err = parseExpression(`avg(requests.total)`)
+		assert.Equal("unknown function avg", err.Error())
+
+		_, err = parseExpression(`requests.total /`)
+		assert.Equal("unexpected end of expression", err.Error())
+	})
+
+	t.Run("evaluate - ratio with volume guard and division by zero", func(t *testing.T) {
+		rule := Rule{
+			Name:      "api-errors",
+			Type:      RuleExpression,
+			Expr:      `"path./api.status.5*.requests" / "path./api.requests"`,
+			Threshold: 0.02,
+			MinVolume: 100,
+		}
+		rule.setDefaults()
+		assert.Nil(rule.Validate(), "valid rule")
+
+		c := clock.NewFake(time.Date(2021, 1, 1, 10, 0, 0, 0, time.UTC))
+		monitor := newMetricMonitor(&testutils.Link{T: t}, rule, Options{Clock: c})
+		assert.Equal(rule.Expr, monitor.metric, "expression used as metric")
+
+		// no requests at all
+		_, _, err := monitor.evaluate()
+		assert.Equal(errNoData, err, "division by zero")
+
+		now := c.Now().Unix()
+		monitor.processStatMessage(&message.StatMessage{End: now, Stats: map[string]int{
+			"path./api.requests":            50,
+			"path./api.status.500.requests": 5,
+		}})
+
+		// 10% errors, but not enough requests
+		_, _, err = monitor.evaluate()
+		assert.Equal(errNoData, err, "volume below minimum")
+
+		monitor.processStatMessage(&message.StatMessage{End: now, Stats: map[string]int{
+			"path./api.requests":            150,
+			"path./api.status.200.requests": 147,
+			"path./api.status.503.requests": 1,
+		}})
+
+		value, raised, err := monitor.evaluate()
+		assert.Nil(err, "err nil")
+		assert.Equal(0.03, value, "wildcard errors summed over the window")
+		assert.True(raised, "alert raised")
+
+		// snapshots out of the window are expired
+		monitor.window.snapshots[0].Timestamp = now - 3600
+		value, raised, err = monitor.evaluate()
+		assert.Nil(err, "err nil")
+		assert.InDelta(0.0067, value, 0.0001, "expired snapshot")
+		assert.False(raised, "alert not raised")
+	})
+
+	t.Run("ParseRules - invalid expression", func(t *testing.T) {
+		_, err := ParseRules([]byte(`
+rules:
+  - name: broken
+    type: expression
+    expr: requests.total / (path.*.requests
+`))
+		assert.Equal("alerts: invalid rule: rule broken: invalid expr: missing ) at position 33", err.Error())
+	})
+}
+
+func TestInternalAbsent(t *testing.T) {
+
+	assert := tassert.New(t)
+
+	start := time.Date(2021, 1, 1, 10, 0, 0, 0, time.UTC)
+	file := "/var/log/nginx/access.log"
+
+	t.Run("sourceWatch - no lines within the window", func(t *testing.T) {
+		s := newSourceWatch("/var/log/nginx/*.log", time.Minute, start)
+
+		_, raised, err := s.evaluate(start.Add(30 * time.Second))
+		assert.Equal(errNoData, err, "nothing heard within the window")
+		assert.False(raised, "within the window")
+
+		idle, raised, err := s.evaluate(start.Add(time.Minute))
+		assert.Nil(err, "err nil")
+		assert.True(raised, "no lines")
+		assert.Equal(float64(60), idle, "idle seconds")
+		assert.Equal("no lines for 1m0s", s.why())
+
+		// lines from other files are ignored
+		s.line("/tmp/other.log", start.Add(time.Minute))
+		_, raised, _ = s.evaluate(start.Add(time.Minute))
+		assert.True(raised, "other file ignored")
+
+		// recovery when lines resume
+		s.line(file, start.Add(time.Minute))
+		_, raised, err = s.evaluate(start.Add(time.Minute + time.Second))
+		assert.Nil(err, "err nil")
+		assert.False(raised, "lines resumed")
+		assert.Equal("lines resumed", s.why())
+	})
+
+	t.Run("sourceWatch - files status", func(t *testing.T) {
+		s := newSourceWatch("", time.Minute, start)
+
+		s.status(message.NewFileMessage(file, message.FileOK, 100, start), start)
+		s.line(file, start.Add(50*time.Second))
+		_, raised, _ := s.evaluate(start.Add(50 * time.Second))
+		assert.False(raised, "file ok")
+
+		s.status(message.NewFileMessage(file, message.FileMissing, 0, time.Time{}), start.Add(55*time.Second))
+		_, raised, _ = s.evaluate(start.Add(55 * time.Second))
+		assert.True(raised, "file missing")
+		assert.Equal("file "+file+" is missing", s.why())
+
+		// back, but not growing
+		s.status(message.NewFileMessage(file, message.FileOK, 0, start), start.Add(60*time.Second))
+		_, raised, _ = s.evaluate(start.Add(60 * time.Second))
+		assert.False(raised, "file back")
+		assert.Equal("file "+file+" reappeared", s.why())
+
+		s.status(message.NewFileMessage(file, message.FileOK, 0, start), start.Add(2*time.Minute))
+		_, raised, _ = s.evaluate(start.Add(2 * time.Minute))
+		assert.True(raised, "file not growing")
+		assert.Equal("file "+file+" not growing for 1m5s", s.why())
+	})
+
+	t.Run("sourceWatch - restored alert kept until a sign of life", func(t *testing.T) {
+		rule := Rule{Name: "nginx-dead", Type: RuleAbsent, File: file, Window: time.Minute}
+		rule.setDefaults()
+
+		c := clock.NewFake(start)
+		monitor := newMetricMonitor(&testutils.Link{T: t}, rule, Options{Clock: c})
+		monitor.currentSeverity = message.SeverityMax
+
+		c.Advance(time.Second)
+		assert.Nil(monitor.checkAlert(), "err nil")
+		assert.Equal(message.SeverityMax, monitor.currentSeverity, "not cancelled without data")
+
+		monitor.processCLFMessage(&message.CLFMessage{File: file})
+		c.Advance(time.Second)
+		value, raised, err := monitor.evaluate()
+		assert.Nil(err, "err nil")
+		assert.False(raised, "line read")
+		assert.Equal(fmt.Sprintf("No data from %s CANCELED - lines resumed, at {%v}", file, c.Now()), monitor.text(message.SeverityCanceled, false, value))
+	})
+
+	t.Run("checkAlert - absent rule raised", func(t *testing.T) {
+		link := testutils.Link{T: t}
+
+		rule := Rule{Name: "nginx-dead", Type: RuleAbsent, File: file, Window: time.Second}
+		rule.setDefaults()
+		assert.Nil(rule.Validate(), "valid rule")
+
+		c := clock.NewFake(start)
+		monitor := newMetricMonitor(&link, rule, Options{Clock: c})
+		assert.Equal(file, monitor.metric, "file used as metric")
+
+		c.Advance(time.Minute)
+
+		topic := broker.TopicAlert
+		text := fmt.Sprintf("No data from %s generated an alert - no lines for 1m0s, triggered at {%v}", file, c.Now())
+
+		link.Reset()
+		link.ExpectedSentTopic = &topic
+		expected := message.NewAlertMessage(file, text, message.SeverityMax)
+		expected.Rule = "nginx-dead"
+		expected.Value = 60
+		link.ExpectedSentMsg = expected
+
+		err := monitor.checkAlert()
+		assert.Nil(err, "err nil")
+		assert.Equal(1, link.SendCount, "message sent to broker")
+		assert.Equal(message.SeverityMax, monitor.currentSeverity, "alert raised")
+	})
+}
+
+func TestInternalBurnRate(t *testing.T) {
+
+	assert := tassert.New(t)
+
+	objective := slo.SLO{
+		Name:      "api",
+		Objective: 0.99,
+		Period:    24 * time.Hour,
+		Windows: []slo.BurnWindow{
+			{Long: time.Hour, Short: 5 * time.Minute, BurnRate: 10},
+		},
+	}
+
+	now := time.Now().Unix()
+
+	t.Run("burnWatch - fires when both windows burn", func(t *testing.T) {
+		w := newBurnWatch(objective)
+
+		// 5% errors during the last hour, a 5x burn
+		for ts := now - 3600; ts <= now; ts += 60 {
+			w.store.push(requests{Timestamp: ts, Good: 95, Total: 100})
+		}
+
+		burn, raised := w.evaluate(now)
+		assert.InDelta(5, burn, 0.01, "burn rate")
+		assert.False(raised, "below burn rate")
+
+		// an error spike in the last 5 minutes burns the short window only
+		for ts := now - 120; ts <= now; ts += 60 {
+			w.store.push(requests{Timestamp: ts, Good: 0, Total: 100})
+		}
+
+		_, raised = w.evaluate(now)
+		assert.False(raised, "long window below burn rate")
+
+		// a sustained spike burns both
+		for ts := now - 3600; ts <= now; ts += 60 {
+			w.store.push(requests{Timestamp: ts, Good: 0, Total: 100})
+		}
+
+		burn, raised = w.evaluate(now)
+		assert.True(raised, "both windows above burn rate")
+		assert.True(burn > 10, "burn rate above threshold")
+		assert.Contains(w.status, "over 1h0m0s")
+	})
+
+	t.Run("burnWatch - error budget remaining", func(t *testing.T) {
+		w := newBurnWatch(objective)
+
+		_, _, remaining := w.remaining(now)
+		assert.Equal(float64(1), remaining, "no requests, full budget")
+
+		// half the budget spent, out of the burn windows
+		w.store.add(requests{Timestamp: now - 12*3600, Good: 995, Total: 1000})
+
+		good, total, remaining := w.remaining(now)
+		assert.Equal(995, good, "good requests")
+		assert.Equal(1000, total, "total requests")
+		assert.InDelta(0.5, remaining, 0.0001, "half budget")
+
+		// out of the period
+		_, total, _ = w.remaining(now + 24*3600)
+		assert.Equal(0, total, "requests expired")
+	})
+
+	t.Run("newSLOMonitor - state apart from a rule with the same name", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "alerts")
+		assert.Nil(err, "err nil")
+		defer os.RemoveAll(dir)
+
+		state, err := LoadState(filepath.Join(dir, "state.json"))
+		assert.Nil(err, "err nil")
+
+		rule := newMetricMonitor(&testutils.Recorder{}, Rule{Name: "api", Metric: "requests.total"}, Options{State: state})
+		rule.currentSeverity = message.SeverityMax
+		rule.record(100, "High traffic")
+		assert.Nil(state.Ack("api"), "err nil")
+
+		monitor := newSLOMonitor(&testutils.Recorder{}, objective, Options{State: state})
+		assert.Equal("slo:api", monitor.key(), "SLO state key")
+		assert.Equal(message.SeverityCanceled, monitor.currentSeverity, "rule severity not restored")
+		assert.False(state.Acked(monitor.key()), "rule ack not shared")
+	})
+}
+
+func TestInternalTemplates(t *testing.T) {
+
+	assert := tassert.New(t)
+
+	rule := Rule{
+		Name:      "path-traffic",
+		Metric:    "path.{path}.requests",
+		Threshold: 50,
+	}
+	rule.setDefaults()
+	assert.Nil(rule.Validate(), "valid rule")
+	assert.True(isTemplate(rule), "rule template")
+
+	t.Run("ruleTemplate - discover instances", func(t *testing.T) {
+		template, err := newRuleTemplate(rule)
+		assert.Nil(err, "err nil")
+
+		rules, labels := template.discover([]string{
+			"requests.total",
+			"path./admin.requests",
+			"path./admin.bytes",
+			"path./users.requests",
+			"path./users.status.200.requests",
+		})
+		assert.Equal(2, len(rules), "two instances")
+		assert.Equal("path-traffic{path=/admin}", rules[0].Name)
+		assert.Equal("path./admin.requests", rules[0].Metric)
+		assert.Equal(map[string]string{"path": "/users"}, labels[1])
+
+		// instances are created once
+		rules, _ = template.discover([]string{"path./admin.requests", "path./api.requests"})
+		assert.Equal(1, len(rules), "new path only")
+		assert.Equal("path./api.requests", rules[0].Metric)
+	})
+
+	t.Run("ruleTemplate - expressions", func(t *testing.T) {
+		ratio := Rule{
+			Name:      "path-errors",
+			Type:      RuleExpression,
+			Metric:    "path.{path}.requests",
+			Expr:      `"path.{path}.status.5*.requests" / "path.{path}.requests"`,
+			Threshold: 0.02,
+		}
+		ratio.setDefaults()
+		assert.Nil(ratio.Validate(), "valid rule")
+
+		template, _ := newRuleTemplate(ratio)
+		rules, _ := template.discover([]string{"path./api.requests"})
+		assert.Equal(`"path./api.status.5*.requests" / "path./api.requests"`, rules[0].Expr)
+
+		ratio.Metric = "requests.total"
+		assert.Equal("rule path-errors: label {path} is not in the metric template", ratio.Validate().Error())
+	})
+
+	t.Run("processMessage - independent instances with labels", func(t *testing.T) {
+		link := testutils.Link{T: t}
+		template, _ := newRuleTemplate(rule)
+
+		c := clock.NewFake(time.Date(2021, 1, 1, 10, 0, 0, 0, time.UTC))
+
+		m := &alertsModule{
+			broker:    &link,
+			monitors:  make([]*metricMonitor, 0),
+			templates: []*ruleTemplate{template},
+			opts:      Options{Clock: c},
+		}
+
+		msg := message.NewStatMessage(map[string]int{
+			"path./admin.requests": 12000,
+			"path./users.requests": 10,
+		}, c.Now().Unix()-2, c.Now().Unix())
+		payload, _ := json.Marshal(msg)
+
+		assert.Nil(m.processMessage(payload), "err nil")
+		assert.Equal(2, len(m.monitors), "two instances")
+
+		admin := m.monitors[0]
+		assert.Equal(map[string]string{"path": "/admin"}, admin.labels)
+		assert.Equal(1, len(admin.store.points), "point pushed to the new instance")
+
+		topic := broker.TopicAlert
+		text := fmt.Sprintf("High traffic generated an alert - hits = {%.2f}, triggered at {%v}", float64(100), c.Now())
+
+		link.Reset()
+		link.ExpectedSentTopic = &topic
+		expected := message.NewAlertMessage("path./admin.requests", text, message.SeverityMax)
+		expected.Rule = "path-traffic{path=/admin}"
+		expected.Labels = map[string]string{"path": "/admin"}
+		expected.Value = 100
+		link.ExpectedSentMsg = expected
+
+		assert.Nil(admin.checkAlert(), "err nil")
+		assert.Equal(1, link.SendCount, "alert sent for /admin")
+		assert.Equal(message.SeverityCanceled, m.monitors[1].currentSeverity, "/users not raised")
+	})
+}
+
+func TestInternalReload(t *testing.T) {
+
+	assert := tassert.New(t)
+
+	state, err := LoadState("")
+	assert.Nil(err, "err nil")
+
+	link := &testutils.Recorder{}
+	start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := clock.NewFake(start)
+
+	m := &alertsModule{
+		broker:    link,
+		monitors:  make([]*metricMonitor, 0),
+		templates: make([]*ruleTemplate, 0),
+		opts:      Options{State: state, Clock: c},
+	}
+
+	traffic := Rule{Name: "high-traffic", Metric: "requests.total", Threshold: 1}
+	bytes := Rule{Name: "big-responses", Metric: "bytes.total", Threshold: 1000}
+	paths := Rule{Name: "path-traffic", Metric: "path.{path}.requests", Threshold: 50}
+	for _, r := range []*Rule{&traffic, &bytes, &paths} {
+		r.setDefaults()
+	}
+	api := slo.SLO{Name: "api", Objective: 0.99, Period: time.Hour, Windows: slo.DefaultWindows()}
+
+	m.reload([]Rule{traffic, bytes, paths}, []slo.SLO{api})
+	assert.Equal(3, len(m.monitors), "rules and slo monitors")
+	assert.Equal(1, len(m.templates), "rule template")
+
+	c.Advance(10 * time.Second)
+	payload, _ := json.Marshal(message.NewStatMessage(map[string]int{
+		"requests.total":       600,
+		"path./admin.requests": 10,
+	}, c.Now().Unix()-10, c.Now().Unix()))
+	assert.Nil(m.processMessage(payload), "err nil")
+	assert.Equal(4, len(m.monitors), "template instance discovered")
+
+	running := m.monitors[0]
+	assert.Nil(running.checkAlert(), "err nil")
+	assert.Equal(message.SeverityMax, running.currentSeverity, "high traffic raised")
+	link.Reset()
+
+	t.Run("reload - unchanged monitors keep their windows", func(t *testing.T) {
+		changed := bytes
+		changed.Threshold = 2000
+		errors := Rule{Name: "errors", Metric: "path./.status.500.requests", Threshold: 1}
+		errors.setDefaults()
+
+		monitors := m.monitors
+		m.reload([]Rule{traffic, changed, errors, paths}, []slo.SLO{api})
+
+		assert.Equal(5, len(m.monitors), "monitors after reload")
+		assert.True(running == m.monitors[0], "unchanged rule monitor kept")
+		assert.Equal(1, len(m.monitors[0].store.points), "window kept")
+		assert.False(monitors[1] == m.monitors[1], "changed rule monitor replaced")
+		assert.Equal(2000.0, m.monitors[1].threshold, "new threshold")
+		assert.Equal("errors", m.monitors[2].name, "new rule monitor")
+		assert.True(monitors[3] == m.monitors[3], "template instance kept")
+		assert.True(monitors[2] == m.monitors[4], "slo monitor kept")
+		assert.Equal(0, len(link.Sent(broker.TopicAlert)), "no alerts canceled")
+	})
+
+	t.Run("reload - removed rules cancel their alerts", func(t *testing.T) {
+		m.reload([]Rule{bytes}, nil)
+
+		assert.Equal(1, len(m.monitors), "one monitor left")
+		assert.Equal(0, len(m.templates), "template removed")
+		sent := link.Sent(broker.TopicAlert)
+		assert.Equal(1, len(sent), "active alert canceled")
+		alert := sent[0].(*message.AlertMessage)
+		assert.Equal("high-traffic", alert.Rule, "removed rule")
+		assert.Equal(message.SeverityCanceled, alert.Severity, "canceled")
+		assert.Equal(message.SeverityCanceled, state.Severity("high-traffic"), "state canceled")
+	})
+
+	t.Run("reload - added absent rules receive lines", func(t *testing.T) {
+		file := "/var/log/nginx/access.log"
+		payload, _ := json.Marshal(message.CLFMessage{Message: message.Message{Type: message.TypeCLF}, File: file})
+		assert.Nil(m.processMessage(payload), "lines ignored without absent rules")
+
+		absent := Rule{Name: "nginx-dead", Type: RuleAbsent, File: file, Window: time.Minute}
+		absent.setDefaults()
+		m.reload([]Rule{bytes, absent}, nil)
+		assert.True(m.absent, "absent rule added")
+
+		assert.Nil(m.processMessage(payload), "err nil")
+		assert.Equal(c.Now(), m.monitors[1].source.lastLine, "line received by the new absent rule")
+	})
+}
+
+func TestInternalClock(t *testing.T) {
+
+	assert := tassert.New(t)
+
+	start := time.Date(2021, 1, 1, 10, 0, 0, 0, time.UTC)
+
+	t.Run("loop - alerts checked on the clock ticks", func(t *testing.T) {
+		c := clock.NewFake(start)
+		link := &testutils.Recorder{}
+
+		rule := Rule{Name: "high-traffic", Metric: "requests.total", Threshold: 1}
+		rule.setDefaults()
+
+		monitor := newMetricMonitor(link, rule, Options{Clock: c})
+		monitor.processStatMessage(message.NewStatMessage(map[string]int{"requests.total": 600}, start.Unix()-10, start.Unix()))
+
+		var wg sync.WaitGroup
+		ctl := make(chan bool)
+		m := &alertsModule{
+			ctl:      ctl,
+			wg:       &wg,
+			broker:   link,
+			monitors: []*metricMonitor{monitor},
+			opts:     Options{Clock: c},
+		}
+
+		wg.Add(1)
+		go m.loop()
+		c.BlockUntil(1)
+
+		c.Advance(4 * time.Second)
+		assert.Equal(0, len(link.Sent(broker.TopicAlert)), "alert sent before the tick")
+
+		c.Advance(time.Second)
+		sent := link.Wait(broker.TopicAlert, 1, time.Second)
+		if assert.Equal(1, len(sent), "alert sent on the tick") {
+			alert := sent[0].(*message.AlertMessage)
+			assert.Equal(message.SeverityMax, alert.Severity, "alert raised on the tick")
+			assert.Equal(fmt.Sprintf("High traffic generated an alert - hits = {%.2f}, triggered at {%v}", 5.0, c.Now()), alert.Text)
+		}
+
+		ctl <- true
+		wg.Wait()
+		c.BlockUntil(0)
+	})
+
+	t.Run("State - silences and acks on the clock", func(t *testing.T) {
+		c := clock.NewFake(start)
+
+		state, err := LoadState("")
+		assert.Nil(err, "err nil")
+		state.SetClock(c)
+
+		silence, err := NewSilence("requests.*", start, start.Add(time.Minute), "")
+		assert.Nil(err, "err nil")
+		assert.Nil(state.AddSilence(silence), "err nil")
+		assert.Equal(1, len(state.ActiveSilences()), "silence active")
+
+		c.Advance(time.Minute)
+		assert.Equal(0, len(state.ActiveSilences()), "silence expired")
+
+		assert.Nil(state.Record(Transition{Time: c.Now(), Rule: "high-traffic", Severity: message.SeverityMax}), "err nil")
+		assert.Nil(state.Ack("high-traffic"), "err nil")
+		assert.Equal(c.Now(), state.Acks["high-traffic"], "acked at the clock time")
+	})
+
+	t.Run("Silence - matches per path metrics", func(t *testing.T) {
+		silence, err := NewSilence("path.*.requests", start, start.Add(time.Minute), "")
+		assert.Nil(err, "err nil")
+
+		assert.True(silence.Matches("path./users.requests", start), "path with slash")
+		assert.True(silence.Matches("path./api/v1/users.requests", start), "nested path")
+		assert.False(silence.Matches("path./users.status.500.requests", start), "other metric")
+		assert.False(silence.Matches("path./users.requests", start.Add(time.Minute)), "silence ended")
+	})
 }
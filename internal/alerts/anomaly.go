@@ -0,0 +1,113 @@
+package alerts
+
+import (
+	"math"
+)
+
+// observations needed before the baseline is used to detect anomalies
+const minSamples = 10
+
+// baseline forecasts the expected value of a metric. It is an additive Holt-Winters
+// model (level, trend and seasonal components) updated once per step, or an EWMA of
+// the level when there is no season. The variance of the forecast errors is tracked
+// as an EWMA too, to decide when an observation is anomalous.
+type baseline struct {
+	alpha      float64
+	beta       float64
+	gamma      float64
+	deviations float64
+	step       int64
+
+	level    float64
+	trend    float64
+	seasonal []float64
+	variance float64
+	samples  int
+	last     int64
+}
+
+func newBaseline(r Rule) *baseline {
+	b := &baseline{
+		alpha:      r.Alpha,
+		beta:       r.Beta,
+		gamma:      r.Gamma,
+		deviations: r.Deviations,
+		step:       int64(r.Step.Seconds()),
+	}
+
+	if r.Season > 0 {
+		b.seasonal = make([]float64, int64(r.Season.Seconds())/b.step)
+	} else {
+		// no trend for a plain EWMA
+		b.beta = 0
+	}
+
+	return b
+}
+
+// season returns the seasonal component for the step of timestamp,
+// slots are aligned to the clock so a daily season follows the time of the day
+func (b *baseline) season(timestamp int64) (float64, int) {
+	if b.seasonal == nil {
+		return 0, 0
+	}
+
+	slot := int((timestamp / b.step) % int64(len(b.seasonal)))
+	return b.seasonal[slot], slot
+}
+
+// observe updates the model with the value observed at timestamp, once per step
+func (b *baseline) observe(timestamp int64, value float64) {
+	s := timestamp / b.step
+	if b.samples > 0 && s <= b.last {
+		return
+	}
+
+	season, slot := b.season(timestamp)
+
+	if b.samples == 0 {
+		b.level = value - season
+	} else {
+		residual := value - (b.level + b.trend + season)
+		if b.samples == 1 {
+			b.variance = residual * residual
+		} else {
+			b.variance = b.alpha*residual*residual + (1-b.alpha)*b.variance
+		}
+
+		previous := b.level
+		b.level = b.alpha*(value-season) + (1-b.alpha)*(b.level+b.trend)
+		b.trend = b.beta*(b.level-previous) + (1-b.beta)*b.trend
+
+		if b.seasonal != nil {
+			b.seasonal[slot] = b.gamma*(value-b.level) + (1-b.gamma)*season
+		}
+	}
+
+	b.samples++
+	b.last = s
+}
+
+// ready returns if the model has enough observations to detect anomalies
+func (b *baseline) ready() bool {
+	return b.samples >= minSamples
+}
+
+// band returns the expected value at timestamp and the limits of the normal values
+func (b *baseline) band(timestamp int64) (float64, float64, float64) {
+	season, _ := b.season(timestamp)
+	expected := b.level + b.trend + season
+	width := b.deviations * math.Sqrt(b.variance)
+
+	return expected, expected - width, expected + width
+}
+
+// anomalous returns if value is out of the band at timestamp
+func (b *baseline) anomalous(timestamp int64, value float64) bool {
+	if !b.ready() {
+		return false
+	}
+
+	_, lower, upper := b.band(timestamp)
+	return value < lower || value > upper
+}
@@ -0,0 +1,159 @@
+package alerts
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/juacker/loghound/internal/slo"
+)
+
+// resolution of the requests kept for the error budget of an SLO period
+const budgetStep = 60
+
+// requests counts the good and total requests of an SLO at a given time
+type requests struct {
+	Timestamp int64
+	Good      int
+	Total     int
+}
+
+// burnStore keeps the requests of an SLO: as received within the longest burn
+// window, and in steps of budgetStep seconds within the SLO period
+type burnStore struct {
+	sync.Mutex
+	points  []requests
+	window  int64
+	buckets []requests
+	period  int64
+}
+
+// push adds the requests counted by stats at a given time
+func (b *burnStore) push(r requests) {
+	b.Lock()
+	defer b.Unlock()
+
+	b.points = append(b.points, r)
+	b.add(r)
+}
+
+// add adds requests only to the period buckets
+func (b *burnStore) add(r requests) {
+	ts := r.Timestamp - r.Timestamp%budgetStep
+
+	last := len(b.buckets) - 1
+	if last >= 0 && b.buckets[last].Timestamp == ts {
+		b.buckets[last].Good += r.Good
+		b.buckets[last].Total += r.Total
+		return
+	}
+
+	b.buckets = append(b.buckets, requests{ts, r.Good, r.Total})
+}
+
+// expire drops the requests out of the window and the period at now
+func (b *burnStore) expire(now int64) {
+	for len(b.points) > 0 && b.points[0].Timestamp <= now-b.window {
+		b.points = b.points[1:]
+	}
+	for len(b.buckets) > 0 && b.buckets[0].Timestamp < now-b.period {
+		b.buckets = b.buckets[1:]
+	}
+}
+
+// errorRate returns the fraction of bad requests within window at now
+func (b *burnStore) errorRate(window time.Duration, now int64) float64 {
+	b.Lock()
+	defer b.Unlock()
+
+	b.expire(now)
+
+	var good, total int
+	limit := now - int64(window.Seconds())
+	for _, p := range b.points {
+		if p.Timestamp > limit {
+			good += p.Good
+			total += p.Total
+		}
+	}
+
+	if total == 0 {
+		return 0
+	}
+
+	return float64(total-good) / float64(total)
+}
+
+// totals returns the good and total requests within the period at now
+func (b *burnStore) totals(now int64) (int, int) {
+	b.Lock()
+	defer b.Unlock()
+
+	b.expire(now)
+
+	var good, total int
+	for _, r := range b.buckets {
+		good += r.Good
+		total += r.Total
+	}
+
+	return good, total
+}
+
+// burnWatch evaluates the multi window burn rate alerts of an SLO
+type burnWatch struct {
+	slo    slo.SLO
+	store  *burnStore
+	status string
+}
+
+func newBurnWatch(s slo.SLO) *burnWatch {
+	return &burnWatch{
+		slo: s,
+		store: &burnStore{
+			points:  make([]requests, 0),
+			window:  int64(s.LongestWindow().Seconds()),
+			buckets: make([]requests, 0),
+			period:  int64(s.Period.Seconds()),
+		},
+	}
+}
+
+// burn returns how many times faster than allowed the error budget is spent within window
+func (w *burnWatch) burn(window time.Duration, now int64) float64 {
+	return w.store.errorRate(window, now) / w.slo.Budget()
+}
+
+// evaluate returns the highest long window burn rate and if any burn window alert fires at now
+func (w *burnWatch) evaluate(now int64) (float64, bool) {
+	var highest float64
+	w.status = ""
+
+	for _, bw := range w.slo.Windows {
+		long := w.burn(bw.Long, now)
+		short := w.burn(bw.Short, now)
+
+		if long > highest {
+			highest = long
+		}
+
+		if long > bw.BurnRate && short > bw.BurnRate {
+			w.status = fmt.Sprintf("burn rate = {%.2fx} over %v and {%.2fx} over %v, threshold = {%.1fx}", long, bw.Long, short, bw.Short, bw.BurnRate)
+			return long, true
+		}
+	}
+
+	return highest, false
+}
+
+// remaining returns the good and total requests within the period, and the fraction of
+// the error budget not spent yet, negative when it is exhausted
+func (w *burnWatch) remaining(now int64) (int, int, float64) {
+	good, total := w.store.totals(now)
+	if total == 0 {
+		return good, total, 1
+	}
+
+	allowed := w.slo.Budget() * float64(total)
+	return good, total, 1 - float64(total-good)/allowed
+}
@@ -0,0 +1,315 @@
+package alerts
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// errNoData is returned when an expression can't be evaluated with the window data
+var errNoData = errors.New("no data")
+
+// errDivisionByZero is returned when an expression divides by zero
+var errDivisionByZero = errors.New("division by zero")
+
+// expression is an arithmetic expression over the metrics of a window
+type expression interface {
+	eval(w *windowStore, now time.Time) (float64, error)
+	String() string
+}
+
+type number float64
+
+func (n number) eval(w *windowStore, now time.Time) (float64, error) {
+	return float64(n), nil
+}
+
+func (n number) String() string {
+	return strconv.FormatFloat(float64(n), 'g', -1, 64)
+}
+
+// selector aggregates the window totals of the metrics matching a pattern
+type selector struct {
+	pattern     string
+	aggregation string
+}
+
+func (s selector) eval(w *windowStore, now time.Time) (float64, error) {
+	totals := w.totals(s.pattern, now)
+
+	if s.aggregation == "count" {
+		return float64(len(totals)), nil
+	}
+
+	var result float64
+	first := true
+	for _, v := range totals {
+		switch {
+		case s.aggregation == "max" && (first || v > result):
+			result = v
+		case s.aggregation == "min" && (first || v < result):
+			result = v
+		case s.aggregation == "sum" || s.aggregation == "rate":
+			result += v
+		}
+		first = false
+	}
+
+	if s.aggregation == "rate" {
+		result = result / float64(w.interval)
+	}
+
+	return result, nil
+}
+
+func (s selector) String() string {
+	return fmt.Sprintf("%s(%q)", s.aggregation, s.pattern)
+}
+
+type binary struct {
+	op          byte
+	left, right expression
+}
+
+func (b binary) eval(w *windowStore, now time.Time) (float64, error) {
+	left, err := b.left.eval(w, now)
+	if err != nil {
+		return 0, err
+	}
+	right, err := b.right.eval(w, now)
+	if err != nil {
+		return 0, err
+	}
+
+	switch b.op {
+	case '+':
+		return left + right, nil
+	case '-':
+		return left - right, nil
+	case '*':
+		return left * right, nil
+	default:
+		if right == 0 {
+			return 0, errDivisionByZero
+		}
+		return left / right, nil
+	}
+}
+
+func (b binary) String() string {
+	return fmt.Sprintf("(%s %c %s)", b.left, b.op, b.right)
+}
+
+type negative struct {
+	expr expression
+}
+
+func (n negative) eval(w *windowStore, now time.Time) (float64, error) {
+	v, err := n.expr.eval(w, now)
+	return -v, err
+}
+
+func (n negative) String() string {
+	return fmt.Sprintf("-%s", n.expr)
+}
+
+// parser is a recursive descent parser of alert expressions:
+//
+//	expr    = term { ("+" | "-") term }
+//	term    = unary { ("*" | "/") unary }
+//	unary   = "-" unary | primary
+//	primary = number | metric | func "(" metric ")" | "(" expr ")"
+//
+// metrics are bare names like requests.total, or quoted names when they contain
+// a path, like "path./api.requests". They may have wildcards, and they are summed
+// over the rule window. func aggregates the metrics matching a pattern: sum, max,
+// min, count (number of metrics) and rate (sum per second)
+type parser struct {
+	input string
+	pos   int
+}
+
+// parseExpression parses an alert expression
+func parseExpression(input string) (expression, error) {
+	p := &parser{input: input}
+
+	expr, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+
+	p.skipSpaces()
+	if p.pos < len(p.input) {
+		return nil, fmt.Errorf("unexpected %q at position %d", p.input[p.pos], p.pos)
+	}
+
+	return expr, nil
+}
+
+func (p *parser) skipSpaces() {
+	for p.pos < len(p.input) && p.input[p.pos] == ' ' {
+		p.pos++
+	}
+}
+
+// peek returns the next non space character, 0 at the end of the input
+func (p *parser) peek() byte {
+	p.skipSpaces()
+	if p.pos >= len(p.input) {
+		return 0
+	}
+	return p.input[p.pos]
+}
+
+func (p *parser) parseExpr() (expression, error) {
+	left, err := p.parseTerm()
+	if err != nil {
+		return nil, err
+	}
+
+	for c := p.peek(); c == '+' || c == '-'; c = p.peek() {
+		p.pos++
+		right, err := p.parseTerm()
+		if err != nil {
+			return nil, err
+		}
+		left = binary{c, left, right}
+	}
+
+	return left, nil
+}
+
+func (p *parser) parseTerm() (expression, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	for c := p.peek(); c == '*' || c == '/'; c = p.peek() {
+		p.pos++
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = binary{c, left, right}
+	}
+
+	return left, nil
+}
+
+func (p *parser) parseUnary() (expression, error) {
+	if p.peek() == '-' {
+		p.pos++
+		expr, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return negative{expr}, nil
+	}
+
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (expression, error) {
+	c := p.peek()
+
+	switch {
+	case c == 0:
+		return nil, fmt.Errorf("unexpected end of expression")
+	case c == '(':
+		p.pos++
+		expr, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ')' {
+			return nil, fmt.Errorf("missing ) at position %d", p.pos)
+		}
+		p.pos++
+		return expr, nil
+	case c == '"':
+		pattern, err := p.parseQuoted()
+		if err != nil {
+			return nil, err
+		}
+		return selector{pattern, "sum"}, nil
+	case c >= '0' && c <= '9' || c == '.':
+		start := p.pos
+		for p.pos < len(p.input) && strings.IndexByte("0123456789.eE", p.input[p.pos]) >= 0 {
+			p.pos++
+		}
+		n, err := strconv.ParseFloat(p.input[start:p.pos], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %s", p.input[start:p.pos])
+		}
+		return number(n), nil
+	case isNameChar(c):
+		start := p.pos
+		for p.pos < len(p.input) && isNameChar(p.input[p.pos]) {
+			p.pos++
+		}
+		name := p.input[start:p.pos]
+
+		if p.peek() != '(' {
+			return selector{name, "sum"}, nil
+		}
+
+		switch name {
+		case "sum", "max", "min", "count", "rate":
+		default:
+			return nil, fmt.Errorf("unknown function %s", name)
+		}
+
+		p.pos++
+		var pattern string
+		if p.peek() == '"' {
+			var err error
+			pattern, err = p.parseQuoted()
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			start := p.pos
+			for p.pos < len(p.input) && isNameChar(p.input[p.pos]) {
+				p.pos++
+			}
+			pattern = p.input[start:p.pos]
+		}
+		if pattern == "" {
+			return nil, fmt.Errorf("missing metric in %s at position %d", name, p.pos)
+		}
+		if p.peek() != ')' {
+			return nil, fmt.Errorf("missing ) at position %d", p.pos)
+		}
+		p.pos++
+		return selector{pattern, name}, nil
+	default:
+		return nil, fmt.Errorf("unexpected %q at position %d", c, p.pos)
+	}
+}
+
+func (p *parser) parseQuoted() (string, error) {
+	end := strings.IndexByte(p.input[p.pos+1:], '"')
+	if end < 0 {
+		return "", fmt.Errorf("unterminated metric name at position %d", p.pos)
+	}
+
+	name := p.input[p.pos+1 : p.pos+1+end]
+	p.pos += end + 2
+	return name, nil
+}
+
+func isNameChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' ||
+		c == '_' || c == '.' || c == '*' || c == '?'
+}
+
+// denominator returns the right side of the expression if it is a division
+func denominator(expr expression) expression {
+	if b, ok := expr.(binary); ok && b.op == '/' {
+		return b.right
+	}
+	return nil
+}
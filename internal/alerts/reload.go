@@ -0,0 +1,145 @@
+package alerts
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"reflect"
+
+	"github.com/juacker/loghound/internal/message"
+	"github.com/juacker/loghound/internal/slo"
+)
+
+// processConfigMessage reloads the rules and slos of a new configuration
+func (m *alertsModule) processConfigMessage(msg *message.ConfigMessage) error {
+	if !msg.IsValid() {
+		return fmt.Errorf("invalid message")
+	}
+
+	var rules []Rule
+	err := json.Unmarshal(msg.Rules, &rules)
+	if err != nil {
+		return err
+	}
+
+	var slos []slo.SLO
+	err = json.Unmarshal(msg.SLOs, &slos)
+	if err != nil {
+		return err
+	}
+
+	m.reload(rules, slos)
+	return nil
+}
+
+// reload reconciles the monitors with rules and slos. Monitors of the rules, rule templates
+// and slos without changes are kept with their windows and baselines, the changed ones are
+// created again, restoring their severity from the state, and the active alerts of the
+// removed ones are canceled
+func (m *alertsModule) reload(rules []Rule, slos []slo.SLO) {
+	running := make(map[string]*metricMonitor)
+	runningSLOs := make(map[string]*metricMonitor)
+	for _, monitor := range m.monitors {
+		switch {
+		case monitor.burn != nil:
+			runningSLOs[monitor.name] = monitor
+		case monitor.template == nil:
+			running[monitor.name] = monitor
+		}
+	}
+
+	runningTemplates := make(map[string]*ruleTemplate)
+	for _, t := range m.templates {
+		runningTemplates[t.rule.Name] = t
+	}
+
+	monitors := make([]*metricMonitor, 0, len(rules)+len(slos))
+	templates := make([]*ruleTemplate, 0)
+	kept := make(map[*ruleTemplate]bool)
+	names := make(map[string]bool)
+	sloNames := make(map[string]bool)
+	var created, removed int
+	absent := false
+
+	for _, r := range rules {
+		names[r.Name] = true
+		if r.Type == RuleAbsent {
+			absent = true
+		}
+
+		if isTemplate(r) {
+			if t, ok := runningTemplates[r.Name]; ok && reflect.DeepEqual(t.rule, r) {
+				templates = append(templates, t)
+				kept[t] = true
+				continue
+			}
+
+			t, err := newRuleTemplate(r)
+			if err != nil {
+				log.Println("alerts: invalid rule template: ", err)
+				continue
+			}
+			templates = append(templates, t)
+			created++
+			continue
+		}
+
+		if monitor, ok := running[r.Name]; ok && reflect.DeepEqual(monitor.rule, r) {
+			monitors = append(monitors, monitor)
+			continue
+		}
+
+		monitors = append(monitors, newMetricMonitor(m.broker, r, m.opts))
+		created++
+	}
+
+	// instances of unchanged templates are kept, the rest are discovered again
+	for _, monitor := range m.monitors {
+		if monitor.template != nil && kept[monitor.template] {
+			monitors = append(monitors, monitor)
+		}
+	}
+
+	m.opts.SLOs = slos
+	for _, s := range slos {
+		sloNames[s.Name] = true
+
+		if monitor, ok := runningSLOs[s.Name]; ok && reflect.DeepEqual(monitor.burn.slo, s) {
+			monitors = append(monitors, monitor)
+			continue
+		}
+
+		monitors = append(monitors, newSLOMonitor(m.broker, s, m.opts))
+		created++
+	}
+
+	for _, monitor := range m.monitors {
+		name := monitor.name
+		if monitor.template != nil {
+			name = monitor.template.rule.Name
+		}
+
+		if monitor.burn != nil && sloNames[name] || monitor.burn == nil && names[name] {
+			continue
+		}
+
+		removed++
+		err := monitor.retire()
+		if err != nil {
+			log.Println("alerts: failed cancelling alert: ", monitor.name, err)
+		}
+	}
+
+	if len(m.monitors) > 0 || len(m.templates) > 0 {
+		log.Printf("alerts: rules reloaded, %d monitors created and %d removed", created, removed)
+	}
+
+	m.monitors = monitors
+	m.templates = templates
+	m.absent = absent
+
+	// instances for the labels already stored are created at once
+	if m.opts.DB != nil && len(m.templates) > len(kept) {
+		m.discover(m.opts.DB.Metrics())
+	}
+}
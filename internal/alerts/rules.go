@@ -0,0 +1,206 @@
+package alerts
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Rule types
+const (
+	RuleThreshold  = "threshold"
+	RuleAnomaly    = "anomaly"
+	RuleExpression = "expression"
+	RuleAbsent     = "absent"
+)
+
+// Rule defines an alert on a metric generated by stats. A metric with label
+// placeholders, like path.{path}.requests, makes the rule a template: an
+// independent instance of the rule is created for every label value found in
+// the stats, with the placeholders of metric, expr and volume replaced
+type Rule struct {
+	Name   string        `yaml:"name" json:"name"`
+	Type   string        `yaml:"type" json:"type"`
+	Metric string        `yaml:"metric" json:"metric"`
+	Window time.Duration `yaml:"window" json:"window"`
+
+	// threshold rules: fires when the metric mean over the window (per second) is above threshold
+	Threshold float64 `yaml:"threshold" json:"threshold"`
+
+	// anomaly rules: fires when the metric mean over the window deviates from its
+	// baseline more than Deviations standard deviations. The baseline is an EWMA,
+	// or a Holt-Winters baseline if Season is set, updated every Step
+	Deviations float64       `yaml:"deviations" json:"deviations"`
+	Step       time.Duration `yaml:"step" json:"step"`
+	Season     time.Duration `yaml:"season" json:"season"`
+	Alpha      float64       `yaml:"alpha" json:"alpha"`
+	Beta       float64       `yaml:"beta" json:"beta"`
+	Gamma      float64       `yaml:"gamma" json:"gamma"`
+
+	// expression rules: fires when Expr, computed with the metric totals over the window,
+	// is above threshold. It is not evaluated while Volume (by default the denominator of
+	// Expr if it is a ratio) is below MinVolume, or when dividing by zero. Metric, if set,
+	// is the name used for silences and notifications, Expr otherwise
+	Expr      string  `yaml:"expr" json:"expr,omitempty"`
+	Volume    string  `yaml:"volume" json:"volume,omitempty"`
+	MinVolume float64 `yaml:"min_volume" json:"min_volume,omitempty"`
+
+	// absent rules: fires when no lines were read from the files matching File (all the
+	// monitored files if empty) within the window, or a file is missing, unreadable or
+	// not growing. Metric, if set, is the name used for silences and notifications, File otherwise
+	File string `yaml:"file" json:"file,omitempty"`
+}
+
+// setDefaults fills the optional fields of the rule
+func (r *Rule) setDefaults() {
+	if r.Type == "" {
+		r.Type = RuleThreshold
+	}
+	if r.Window == 0 {
+		r.Window = 2 * time.Minute
+	}
+
+	if r.Type == RuleAnomaly {
+		if r.Deviations == 0 {
+			r.Deviations = 3
+		}
+		if r.Step == 0 {
+			r.Step = time.Minute
+		}
+		if r.Alpha == 0 {
+			r.Alpha = 0.3
+		}
+		if r.Beta == 0 {
+			r.Beta = 0.05
+		}
+		if r.Gamma == 0 {
+			r.Gamma = 0.1
+		}
+	}
+}
+
+// Validate checks the rule is complete and consistent
+func (r *Rule) Validate() error {
+	if r.Name == "" {
+		return fmt.Errorf("rule name is required")
+	}
+	if r.Metric == "" && r.Type != RuleExpression && r.Type != RuleAbsent {
+		return fmt.Errorf("rule %s: metric is required", r.Name)
+	}
+	if r.Window < time.Second {
+		return fmt.Errorf("rule %s: window must be at least 1s", r.Name)
+	}
+
+	// label placeholders are only known if the metric has them
+	for _, e := range []string{r.Expr, r.Volume} {
+		for _, p := range placeholder.FindAllString(e, -1) {
+			if !strings.Contains(r.Metric, p) {
+				return fmt.Errorf("rule %s: label %s is not in the metric template", r.Name, p)
+			}
+		}
+	}
+
+	if isTemplate(*r) {
+		if r.Type == RuleAbsent {
+			return fmt.Errorf("rule %s: absent rules can't be templates", r.Name)
+		}
+		_, err := newRuleTemplate(*r)
+		if err != nil {
+			return err
+		}
+	}
+
+	switch r.Type {
+	case RuleThreshold:
+	case RuleAnomaly:
+		if r.Deviations <= 0 {
+			return fmt.Errorf("rule %s: deviations must be positive", r.Name)
+		}
+		if r.Step < time.Second {
+			return fmt.Errorf("rule %s: step must be at least 1s", r.Name)
+		}
+		if r.Season != 0 && r.Season < 2*r.Step {
+			return fmt.Errorf("rule %s: season must be at least two steps", r.Name)
+		}
+		for _, f := range []float64{r.Alpha, r.Beta, r.Gamma} {
+			if f <= 0 || f >= 1 {
+				return fmt.Errorf("rule %s: alpha, beta and gamma must be between 0 and 1", r.Name)
+			}
+		}
+	case RuleExpression:
+		if r.Expr == "" {
+			return fmt.Errorf("rule %s: expr is required", r.Name)
+		}
+		_, err := parseExpression(placeholder.ReplaceAllString(r.Expr, "x"))
+		if err != nil {
+			return fmt.Errorf("rule %s: invalid expr: %v", r.Name, err)
+		}
+		if r.Volume != "" {
+			_, err = parseExpression(placeholder.ReplaceAllString(r.Volume, "x"))
+			if err != nil {
+				return fmt.Errorf("rule %s: invalid volume: %v", r.Name, err)
+			}
+		}
+		if r.MinVolume < 0 {
+			return fmt.Errorf("rule %s: min_volume can't be negative", r.Name)
+		}
+	case RuleAbsent:
+		_, err := filepath.Match(r.File, "")
+		if err != nil {
+			return fmt.Errorf("rule %s: invalid file pattern: %v", r.Name, err)
+		}
+	default:
+		return fmt.Errorf("rule %s: unknown type %s", r.Name, r.Type)
+	}
+
+	return nil
+}
+
+// Check fills the optional fields of the rule with their defaults, and validates it
+func (r *Rule) Check() error {
+	r.setDefaults()
+	return r.Validate()
+}
+
+// ParseRules parses and validates a list of rules in yaml
+func ParseRules(data []byte) ([]Rule, error) {
+	var file struct {
+		Rules []Rule `yaml:"rules"`
+	}
+
+	err := yaml.Unmarshal(data, &file)
+	if err != nil {
+		return nil, fmt.Errorf("alerts: invalid rules: %v", err)
+	}
+
+	names := make(map[string]bool)
+	for i := range file.Rules {
+		r := &file.Rules[i]
+
+		err = r.Check()
+		if err != nil {
+			return nil, fmt.Errorf("alerts: invalid rule: %v", err)
+		}
+
+		if names[r.Name] {
+			return nil, fmt.Errorf("alerts: duplicated rule name %s", r.Name)
+		}
+		names[r.Name] = true
+	}
+
+	return file.Rules, nil
+}
+
+// LoadRules reads the rules file at path
+func LoadRules(path string) ([]Rule, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("alerts: failed reading rules file %s: %v", path, err)
+	}
+
+	return ParseRules(data)
+}
@@ -0,0 +1,175 @@
+package alerts
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+// Silence mutes the notifications of the alerts whose metric matches
+// Matcher, a pattern like path.*.requests, between Start and End
+type Silence struct {
+	ID      string    `json:"id"`
+	Matcher string    `json:"matcher"`
+	Start   time.Time `json:"start"`
+	End     time.Time `json:"end"`
+	Comment string    `json:"comment"`
+}
+
+// Matches returns if the silence mutes metric at t
+func (s *Silence) Matches(metric string, t time.Time) bool {
+	if t.Before(s.Start) || !t.Before(s.End) {
+		return false
+	}
+
+	return matchMetric(s.Matcher, metric)
+}
+
+// matchMetric returns if the metric name matches pattern. In patterns, '*' matches
+// any sequence of characters within a metric segment, segments are separated by '.',
+// and '?' matches a single character. Paths like /api are a single segment.
+func matchMetric(pattern, name string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			// try every split of the current segment
+			for i := 0; i <= len(name); i++ {
+				if matchMetric(pattern[1:], name[i:]) {
+					return true
+				}
+				if i < len(name) && name[i] == '.' {
+					break
+				}
+			}
+			return false
+		case '?':
+			if len(name) == 0 || name[0] == '.' {
+				return false
+			}
+		default:
+			if len(name) == 0 || name[0] != pattern[0] {
+				return false
+			}
+		}
+
+		pattern = pattern[1:]
+		name = name[1:]
+	}
+
+	return len(name) == 0
+}
+
+// NewSilence returns a silence for matcher from start to end
+func NewSilence(matcher string, start, end time.Time, comment string) (*Silence, error) {
+	if matcher == "" {
+		return nil, fmt.Errorf("alerts: silence matcher is required")
+	}
+
+	if !end.After(start) {
+		return nil, fmt.Errorf("alerts: silence must end after it starts")
+	}
+
+	id := make([]byte, 8)
+	_, err := rand.Read(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Silence{
+		ID:      hex.EncodeToString(id),
+		Matcher: matcher,
+		Start:   start,
+		End:     end,
+		Comment: comment,
+	}, nil
+}
+
+// AddSilence adds a silence, expired silences are removed
+func (s *State) AddSilence(silence *Silence) error {
+	s.Lock()
+	defer s.Unlock()
+
+	now := s.clock.Now()
+	silences := make([]Silence, 0, len(s.Silences)+1)
+	for _, existing := range s.Silences {
+		if existing.End.After(now) {
+			silences = append(silences, existing)
+		}
+	}
+
+	s.Silences = append(silences, *silence)
+	return s.save()
+}
+
+// RemoveSilence expires the silence with id
+func (s *State) RemoveSilence(id string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	for i, silence := range s.Silences {
+		if silence.ID == id {
+			s.Silences = append(s.Silences[:i], s.Silences[i+1:]...)
+			return s.save()
+		}
+	}
+
+	return fmt.Errorf("alerts: silence %s not found", id)
+}
+
+// ActiveSilences returns the silences not expired yet
+func (s *State) ActiveSilences() []Silence {
+	s.Lock()
+	defer s.Unlock()
+
+	now := s.clock.Now()
+	silences := make([]Silence, 0, len(s.Silences))
+	for _, silence := range s.Silences {
+		if silence.End.After(now) {
+			silences = append(silences, silence)
+		}
+	}
+
+	return silences
+}
+
+// Silenced returns if an alert for metric is silenced at t
+func (s *State) Silenced(metric string, t time.Time) bool {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.silenced(metric, t)
+}
+
+func (s *State) silenced(metric string, t time.Time) bool {
+	for _, silence := range s.Silences {
+		if silence.Matches(metric, t) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Ack acknowledges the active alert for rule, it won't be notified again
+// until it is canceled
+func (s *State) Ack(rule string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if _, ok := s.Severities[rule]; !ok {
+		return fmt.Errorf("alerts: no active alert for rule %s", rule)
+	}
+
+	s.Acks[rule] = s.clock.Now()
+	return s.save()
+}
+
+// Acked returns if the active alert for rule is acknowledged
+func (s *State) Acked(rule string) bool {
+	s.Lock()
+	defer s.Unlock()
+
+	_, ok := s.Acks[rule]
+	return ok
+}
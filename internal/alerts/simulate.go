@@ -0,0 +1,88 @@
+package alerts
+
+import (
+	"encoding/json"
+
+	"github.com/juacker/loghound/internal/broker"
+	"github.com/juacker/loghound/internal/clock"
+	"github.com/juacker/loghound/internal/message"
+	"github.com/juacker/loghound/internal/slo"
+)
+
+// recorder is a broker link keeping the alert messages sent by the monitors
+type recorder struct {
+	alerts []*message.AlertMessage
+}
+
+func (r *recorder) Send(topic int, msg interface{}) error {
+	if alert, ok := msg.(*message.AlertMessage); ok && topic == broker.TopicAlert {
+		r.alerts = append(r.alerts, alert)
+	}
+	return nil
+}
+
+func (r *recorder) Receive() <-chan []byte {
+	return nil
+}
+
+// Simulator runs the alert monitors of some rules and SLOs out of the pipeline, on the
+// time of a fake clock, so their alerts are deterministic
+type Simulator struct {
+	module *alertsModule
+	link   *recorder
+	clock  *clock.Fake
+}
+
+// NewSimulator returns a simulator of rules and slos, running on c
+func NewSimulator(rules []Rule, slos []slo.SLO, c *clock.Fake) *Simulator {
+	link := &recorder{}
+	opts := Options{SLOs: slos, Clock: c}
+
+	m := &alertsModule{
+		broker:    link,
+		monitors:  make([]*metricMonitor, 0),
+		templates: make([]*ruleTemplate, 0),
+		opts:      opts,
+	}
+	m.reload(rules, slos)
+
+	return &Simulator{
+		module: m,
+		link:   link,
+		clock:  c,
+	}
+}
+
+// Process feeds the monitors with a message of the pipeline: stats, log lines or file status
+func (s *Simulator) Process(msg interface{}) error {
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	return s.module.processMessage(payload)
+}
+
+// Evaluate checks the alerts at the clock time, and returns their transitions
+func (s *Simulator) Evaluate() []Transition {
+	s.link.alerts = s.link.alerts[:0]
+
+	for _, monitor := range s.module.monitors {
+		monitor.checkAlert() //errcheck: nolint
+	}
+
+	transitions := make([]Transition, 0, len(s.link.alerts))
+	for _, a := range s.link.alerts {
+		transitions = append(transitions, Transition{
+			Time:     s.clock.Now(),
+			Rule:     a.Rule,
+			Labels:   a.Labels,
+			Metric:   a.Metric,
+			Value:    a.Value,
+			Severity: a.Severity,
+			Text:     a.Text,
+		})
+	}
+
+	return transitions
+}
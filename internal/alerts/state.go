@@ -0,0 +1,178 @@
+package alerts
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/juacker/loghound/internal/clock"
+	"github.com/juacker/loghound/internal/message"
+)
+
+// number of transitions kept in the alerts history
+const historySize = 500
+
+// Transition represents a change of the severity of an alert
+type Transition struct {
+	Time     time.Time         `json:"time"`
+	Rule     string            `json:"rule"`
+	Labels   map[string]string `json:"labels,omitempty"`
+	Metric   string            `json:"metric"`
+	Value    float64           `json:"value"`
+	Severity message.Severity  `json:"severity"`
+	Text     string            `json:"text"`
+}
+
+// ActiveAlert represents an active alert with its notification state
+type ActiveAlert struct {
+	Transition
+	Acked    bool `json:"acked"`
+	Silenced bool `json:"silenced"`
+}
+
+// State keeps the current severity of the alerts by rule, the history of their transitions,
+// silences and acknowledgements. If it has a path, it is persisted there after every change
+type State struct {
+	sync.Mutex
+	path       string
+	clock      clock.Clock
+	Severities map[string]message.Severity `json:"severities"`
+	History    []Transition                `json:"history"`
+	Silences   []Silence                   `json:"silences"`
+	Acks       map[string]time.Time        `json:"acks"`
+}
+
+// LoadState loads the alerts state stored at path. With an empty path,
+// or if the file does not exist yet, an empty state is returned
+func LoadState(path string) (*State, error) {
+	s := &State{
+		path:       path,
+		clock:      clock.New(),
+		Severities: make(map[string]message.Severity),
+		History:    make([]Transition, 0),
+		Silences:   make([]Silence, 0),
+		Acks:       make(map[string]time.Time),
+	}
+
+	if path == "" {
+		return s, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("alerts: failed reading state file %s: %v", path, err)
+	}
+
+	err = json.Unmarshal(data, s)
+	if err != nil {
+		return nil, fmt.Errorf("alerts: invalid state file %s: %v", path, err)
+	}
+
+	// state files written by previous versions
+	if s.Acks == nil {
+		s.Acks = make(map[string]time.Time)
+	}
+
+	return s, nil
+}
+
+// SetClock sets the clock telling which silences are active and when alerts are acknowledged
+func (s *State) SetClock(c clock.Clock) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.clock = c
+}
+
+// Severity returns the current severity of the alert for rule
+func (s *State) Severity(rule string) message.Severity {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.Severities[rule]
+}
+
+// Record registers a transition and persists the state
+func (s *State) Record(t Transition) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if t.Severity == message.SeverityCanceled {
+		delete(s.Severities, t.Rule)
+		delete(s.Acks, t.Rule)
+	} else {
+		s.Severities[t.Rule] = t.Severity
+	}
+
+	s.History = append(s.History, t)
+	if len(s.History) > historySize {
+		s.History = s.History[len(s.History)-historySize:]
+	}
+
+	return s.save()
+}
+
+// Transitions returns the alerts history, oldest first
+func (s *State) Transitions() []Transition {
+	s.Lock()
+	defer s.Unlock()
+
+	history := make([]Transition, len(s.History))
+	copy(history, s.History)
+
+	return history
+}
+
+// Active returns the last transition of every active alert
+func (s *State) Active() []ActiveAlert {
+	s.Lock()
+	defer s.Unlock()
+
+	now := s.clock.Now()
+	active := make([]ActiveAlert, 0, len(s.Severities))
+	for rule := range s.Severities {
+		for i := len(s.History) - 1; i >= 0; i-- {
+			if s.History[i].Rule == rule {
+				_, acked := s.Acks[rule]
+				active = append(active, ActiveAlert{
+					Transition: s.History[i],
+					Acked:      acked,
+					Silenced:   s.silenced(s.History[i].Metric, now),
+				})
+				break
+			}
+		}
+	}
+
+	sort.Slice(active, func(a, b int) bool {
+		return active[a].Rule < active[b].Rule
+	})
+
+	return active
+}
+
+// save writes the state to its file, replacing the previous one atomically
+func (s *State) save() error {
+	if s.path == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := s.path + ".tmp"
+	err = ioutil.WriteFile(tmp, data, 0644)
+	if err != nil {
+		return fmt.Errorf("alerts: failed writing state file %s: %v", tmp, err)
+	}
+
+	return os.Rename(tmp, s.path)
+}
@@ -24,14 +24,15 @@ func (m *metricStore) push(datapoint datapoint) {
 }
 
 // stats return actual store stats, previously expiring out of interval datapoints
-func (m *metricStore) stats() int {
+func (m *metricStore) stats(now time.Time) int {
 	m.Lock()
 	defer m.Unlock()
 
-	limit := time.Now().Unix() - m.interval
+	// points are stamped at the end of their stats interval
+	limit := now.Unix() - m.interval
 
 	for len(m.points) > 0 {
-		if m.points[0].Timestamp >= limit {
+		if m.points[0].Timestamp > limit {
 			break
 		}
 
@@ -43,8 +44,8 @@ func (m *metricStore) stats() int {
 }
 
 // mean returns the mean value of elements in store
-func (m *metricStore) mean() float64 {
-	sum := m.stats()
+func (m *metricStore) mean(now time.Time) float64 {
+	sum := m.stats(now)
 
 	return float64(sum) / float64(m.interval)
 }
@@ -54,3 +55,48 @@ type datapoint struct {
 	Timestamp int64
 	Value     int
 }
+
+// snapshot represents the stats generated at a given time
+type snapshot struct {
+	Timestamp int64
+	Stats     map[string]int
+}
+
+// windowStore keeps all the stats generated within its interval, for rules
+// combining several metrics
+type windowStore struct {
+	sync.Mutex
+	snapshots []snapshot
+	interval  int64
+}
+
+// push adds new stats to the store
+func (w *windowStore) push(s snapshot) {
+	w.Lock()
+	defer w.Unlock()
+
+	w.snapshots = append(w.snapshots, s)
+}
+
+// totals returns the sum within the interval of every metric matching pattern,
+// previously expiring out of interval snapshots
+func (w *windowStore) totals(pattern string, now time.Time) map[string]float64 {
+	w.Lock()
+	defer w.Unlock()
+
+	limit := now.Unix() - w.interval
+	for len(w.snapshots) > 0 && w.snapshots[0].Timestamp <= limit {
+		w.snapshots = w.snapshots[1:]
+	}
+
+	totals := make(map[string]float64)
+	for _, s := range w.snapshots {
+		for metric, value := range s.Stats {
+			if matchMetric(pattern, metric) {
+				totals[metric] += float64(value)
+			}
+		}
+	}
+
+	return totals
+}
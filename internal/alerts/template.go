@@ -0,0 +1,135 @@
+package alerts
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// maximum number of instances created by a rule template
+const maxInstances = 100
+
+// placeholders of labels in rule templates, like path in path.{path}.requests
+var placeholder = regexp.MustCompile(`\{([a-z_]+)\}`)
+
+// isTemplate returns if the rule is a template, with label placeholders in its metric
+func isTemplate(r Rule) bool {
+	return placeholder.MatchString(r.Metric)
+}
+
+// expand replaces the label placeholders in s with their values
+func expand(s string, labels map[string]string) string {
+	return placeholder.ReplaceAllStringFunc(s, func(p string) string {
+		return labels[p[1:len(p)-1]]
+	})
+}
+
+// instanceName returns the name of a rule instance with labels, like
+// high-traffic{path=/admin}
+func instanceName(name string, labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		pairs = append(pairs, k+"="+labels[k])
+	}
+
+	return name + "{" + strings.Join(pairs, ",") + "}"
+}
+
+// ruleTemplate creates a rule instance for every set of labels found in the
+// metrics matching the template metric
+type ruleTemplate struct {
+	rule      Rule
+	pattern   *regexp.Regexp
+	instances map[string]bool
+}
+
+func newRuleTemplate(r Rule) (*ruleTemplate, error) {
+	// labels match a metric segment, wildcards work as in silences
+	var expr strings.Builder
+	expr.WriteString("^")
+
+	last := 0
+	for _, loc := range placeholder.FindAllStringSubmatchIndex(r.Metric, -1) {
+		expr.WriteString(globToRegexp(r.Metric[last:loc[0]]))
+		expr.WriteString("(?P<" + r.Metric[loc[2]:loc[3]] + ">[^.]+)")
+		last = loc[1]
+	}
+	expr.WriteString(globToRegexp(r.Metric[last:]))
+	expr.WriteString("$")
+
+	pattern, err := regexp.Compile(expr.String())
+	if err != nil {
+		return nil, fmt.Errorf("rule %s: invalid metric template: %v", r.Name, err)
+	}
+
+	return &ruleTemplate{
+		rule:      r,
+		pattern:   pattern,
+		instances: make(map[string]bool),
+	}, nil
+}
+
+// globToRegexp quotes s, keeping its wildcards
+func globToRegexp(s string) string {
+	s = regexp.QuoteMeta(s)
+	s = strings.ReplaceAll(s, `\*`, `[^.]*`)
+	s = strings.ReplaceAll(s, `\?`, `[^.]`)
+	return s
+}
+
+// match returns the labels of metric if it matches the template
+func (t *ruleTemplate) match(metric string) (map[string]string, bool) {
+	values := t.pattern.FindStringSubmatch(metric)
+	if values == nil {
+		return nil, false
+	}
+
+	labels := make(map[string]string)
+	for i, name := range t.pattern.SubexpNames() {
+		if name != "" {
+			labels[name] = values[i]
+		}
+	}
+
+	return labels, true
+}
+
+// discover returns the rules of the instances not created yet for metrics, with their labels
+func (t *ruleTemplate) discover(metrics []string) ([]Rule, []map[string]string) {
+	rules := make([]Rule, 0)
+	labels := make([]map[string]string, 0)
+
+	for _, metric := range metrics {
+		l, ok := t.match(metric)
+		if !ok {
+			continue
+		}
+
+		name := instanceName(t.rule.Name, l)
+		if t.instances[name] {
+			continue
+		}
+		if len(t.instances) >= maxInstances {
+			return rules, labels
+		}
+		t.instances[name] = true
+
+		r := t.rule
+		r.Name = name
+		r.Metric = expand(r.Metric, l)
+		r.Expr = expand(r.Expr, l)
+		r.Volume = expand(r.Volume, l)
+
+		rules = append(rules, r)
+		labels = append(labels, l)
+	}
+
+	return rules, labels
+}
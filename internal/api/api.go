@@ -0,0 +1,484 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/juacker/loghound/internal/alerts"
+	"github.com/juacker/loghound/internal/broker"
+	"github.com/juacker/loghound/internal/clock"
+	"github.com/juacker/loghound/internal/health"
+	"github.com/juacker/loghound/internal/message"
+	"github.com/juacker/loghound/internal/reload"
+	"github.com/juacker/loghound/internal/tsdb"
+	"github.com/juacker/loghound/pkg/clf"
+)
+
+type apiServer struct {
+	sync.Mutex
+	ctl    chan bool
+	wg     *sync.WaitGroup
+	broker broker.Link
+	db     *tsdb.DB
+	state  *alerts.State
+	server *http.Server
+	reload *reload.Reloader
+	clock  clock.Clock
+
+	// data
+	stats  *message.StatMessage
+	paths  *clf.PathTable
+	health *message.HealthMessage
+}
+
+func (a *apiServer) loop() {
+	log.Println("api: initializing http api on ", a.server.Addr)
+
+	go func() {
+		err := a.server.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			log.Println("api: http server failed: ", err)
+		}
+	}()
+
+LOOP:
+	for {
+		select {
+		case payload := <-a.broker.Receive():
+			log.Println("api: new message received")
+			err := a.processMessage(payload)
+			if err != nil {
+				log.Println("api: failed processing message: ", err)
+			}
+		case <-a.ctl:
+			log.Println("api: ctl signal received, exiting")
+			break LOOP
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err := a.server.Shutdown(ctx)
+	if err != nil {
+		log.Println("api: failed stopping http server: ", err)
+	}
+
+	a.wg.Done()
+}
+
+func (a *apiServer) processMessage(payload []byte) error {
+	var msg message.Message
+	err := json.Unmarshal(payload, &msg)
+	if err != nil {
+		return err
+	}
+
+	switch msg.Type {
+	case message.TypeStat:
+		var statMsg message.StatMessage
+		err = json.Unmarshal(payload, &statMsg)
+		if err != nil {
+			return err
+		}
+		return a.processStatMessage(&statMsg)
+	case message.TypeHealth:
+		var healthMsg message.HealthMessage
+		err = json.Unmarshal(payload, &healthMsg)
+		if err != nil {
+			return err
+		}
+		return a.processHealthMessage(&healthMsg)
+	}
+
+	return fmt.Errorf("invalid message")
+}
+
+func (a *apiServer) processHealthMessage(msg *message.HealthMessage) error {
+	a.Lock()
+	defer a.Unlock()
+
+	a.health = msg
+	return nil
+}
+
+func (a *apiServer) processStatMessage(msg *message.StatMessage) error {
+	a.Lock()
+	defer a.Unlock()
+
+	a.stats = msg
+
+	// status is aggregated by class, only the last stats are kept
+	a.paths.ResetStatus()
+	for metric, value := range msg.Stats {
+		a.paths.Add(metric, float64(value))
+	}
+
+	return nil
+}
+
+func (a *apiServer) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	var metrics []string
+
+	if a.db != nil {
+		metrics = a.db.Metrics()
+	} else {
+		a.Lock()
+		metrics = make([]string, 0)
+		if a.stats != nil {
+			for metric := range a.stats.Stats {
+				metrics = append(metrics, metric)
+			}
+		}
+		a.Unlock()
+		sort.Strings(metrics)
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"metrics": metrics,
+	})
+}
+
+func (a *apiServer) handleSeries(w http.ResponseWriter, r *http.Request) {
+	if a.db == nil {
+		writeError(w, http.StatusServiceUnavailable, fmt.Errorf("stats storage is disabled"))
+		return
+	}
+
+	query := r.URL.Query()
+
+	metric := query.Get("metric")
+	if metric == "" {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("metric parameter is required"))
+		return
+	}
+
+	now := a.clock.Now()
+	from, err := parseTime(query.Get("from"), now, now.Add(-time.Hour))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	to, err := parseTime(query.Get("to"), now, now)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	var step int64
+	if s := query.Get("step"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid step: %s", s))
+			return
+		}
+		step = int64(d / time.Second)
+	}
+
+	agg, err := tsdb.ParseAggregation(query.Get("agg"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	points, err := a.db.Query(metric, from, to, step, agg)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"metric":      metric,
+		"from":        from,
+		"to":          to,
+		"step":        step,
+		"aggregation": agg,
+		"points":      points,
+	})
+}
+
+func (a *apiServer) handlePaths(w http.ResponseWriter, r *http.Request) {
+	a.Lock()
+	defer a.Unlock()
+
+	var init, end int64
+	if a.stats != nil {
+		init, end = a.stats.Init, a.stats.End
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"init":  init,
+		"end":   end,
+		"paths": a.paths.Requests(),
+	})
+}
+
+func (a *apiServer) handleAlerts(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"active":  a.state.Active(),
+		"history": a.state.Transitions(),
+	})
+}
+
+// handlePrometheus serves the last health metrics in the prometheus text exposition format
+func (a *apiServer) handlePrometheus(w http.ResponseWriter, r *http.Request) {
+	a.Lock()
+	msg := a.health
+	a.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	if msg == nil {
+		return
+	}
+
+	var b strings.Builder
+	var family string
+	for _, m := range msg.Metrics {
+		if m.Name != family {
+			family = m.Name
+			fmt.Fprintf(&b, "# TYPE %s %s\n", m.Name, m.Type)
+		}
+
+		labels := health.LabelString(m.Labels)
+		if labels != "" {
+			labels = "{" + labels + "}"
+		}
+
+		if m.Type == message.MetricSummary {
+			fmt.Fprintf(&b, "%s_sum%s %s\n", m.Name, labels, formatFloat(m.Value))
+			fmt.Fprintf(&b, "%s_count%s %d\n", m.Name, labels, m.Count)
+		} else {
+			fmt.Fprintf(&b, "%s%s %s\n", m.Name, labels, formatFloat(m.Value))
+		}
+	}
+
+	_, err := w.Write([]byte(b.String()))
+	if err != nil {
+		log.Println("api: failed writing response: ", err)
+	}
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// silenceRequest is the body to create a silence, either with an end time or a duration from start
+type silenceRequest struct {
+	Matcher  string    `json:"matcher"`
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	Duration string    `json:"duration"`
+	Comment  string    `json:"comment"`
+}
+
+func (a *apiServer) handleSilences(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"silences": a.state.ActiveSilences(),
+		})
+	case http.MethodPost:
+		var req silenceRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid silence: %v", err))
+			return
+		}
+
+		if req.Start.IsZero() {
+			req.Start = a.clock.Now()
+		}
+		if req.End.IsZero() {
+			d, err := time.ParseDuration(req.Duration)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, fmt.Errorf("invalid silence duration: %s", req.Duration))
+				return
+			}
+			req.End = req.Start.Add(d)
+		}
+
+		silence, err := alerts.NewSilence(req.Matcher, req.Start, req.End, req.Comment)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		err = a.state.AddSilence(silence)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		writeJSON(w, http.StatusCreated, silence)
+	case http.MethodDelete:
+		err := a.state.RemoveSilence(r.URL.Query().Get("id"))
+		if err != nil {
+			writeError(w, http.StatusNotFound, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method not allowed"))
+	}
+}
+
+func (a *apiServer) handleAck(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method not allowed"))
+		return
+	}
+
+	var req struct {
+		Rule string `json:"rule"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid ack: %v", err))
+		return
+	}
+
+	err = a.state.Ack(req.Rule)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *apiServer) handleReload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method not allowed"))
+		return
+	}
+
+	if a.reload == nil {
+		writeError(w, http.StatusServiceUnavailable, fmt.Errorf("reload is disabled"))
+		return
+	}
+
+	changes, err := a.reload.Reload()
+	if err != nil {
+		log.Println("api: failed reloading configuration: ", err)
+		writeError(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	log.Println("api: configuration reloaded, ", changes)
+	writeJSON(w, http.StatusOK, changes)
+}
+
+// parseTime parses unix seconds or a duration relative to now, like -15m
+func parseTime(s string, now, def time.Time) (int64, error) {
+	if s == "" {
+		return def.Unix(), nil
+	}
+
+	if ts, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return ts, nil
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time: %s", s)
+	}
+
+	return now.Add(d).Unix(), nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		log.Println("api: failed writing response: ", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{
+		"error": err.Error(),
+	})
+}
+
+// get only allows GET requests to the handler
+func get(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method not allowed"))
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// CheckAddr returns an error if the api would listen on addr beyond the loopback
+// interface without public set. The endpoints changing silences, acks and the
+// configuration have no authentication
+func CheckAddr(addr string, public bool) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+
+	if public || host == "localhost" {
+		return nil
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+		return nil
+	}
+
+	return fmt.Errorf("api: %s is not a loopback address and the api has no authentication, set -http-public to serve it", addr)
+}
+
+// Run starts the http api listening on addr. Alerts are served from state,
+// silences and acknowledgements are added to it.
+// If a storage is given, metric series are served from it, and if a reloader
+// is given, the configuration can be reloaded. Relative times and silences
+// start on the time of clk
+func Run(wg *sync.WaitGroup, ctl chan bool, addr string, state *alerts.State, db *tsdb.DB, r *reload.Reloader, clk clock.Clock) {
+	conn, err := broker.NewConnection("api", broker.TopicStat, broker.TopicHealth)
+	if err != nil {
+		log.Fatal("api: failed opening broker connection ", err)
+	}
+
+	api := &apiServer{
+		ctl:    ctl,
+		wg:     wg,
+		broker: conn,
+		db:     db,
+		state:  state,
+		paths:  clf.NewPathTable(),
+		reload: r,
+		clock:  clk,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/metrics", get(api.handleMetrics))
+	mux.HandleFunc("/api/v1/series", get(api.handleSeries))
+	mux.HandleFunc("/api/v1/paths", get(api.handlePaths))
+	mux.HandleFunc("/api/v1/alerts", get(api.handleAlerts))
+	mux.HandleFunc("/api/v1/alerts/ack", api.handleAck)
+	mux.HandleFunc("/api/v1/silences", api.handleSilences)
+	mux.HandleFunc("/api/v1/reload", api.handleReload)
+	mux.HandleFunc("/metrics", get(api.handlePrometheus))
+
+	api.server = &http.Server{
+		Addr:    addr,
+		Handler: mux,
+	}
+
+	api.loop()
+}
@@ -0,0 +1,256 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/juacker/loghound/internal/alerts"
+	"github.com/juacker/loghound/internal/clock"
+	"github.com/juacker/loghound/internal/message"
+	"github.com/juacker/loghound/internal/reload"
+	"github.com/juacker/loghound/internal/tsdb"
+	"github.com/juacker/loghound/pkg/clf"
+	tassert "github.com/stretchr/testify/assert"
+)
+
+func TestInternalAPI(t *testing.T) {
+
+	assert := tassert.New(t)
+
+	state, err := alerts.LoadState("")
+	assert.Nil(err, "err nil")
+
+	// the time of a replayed log
+	c := clock.NewFake(time.Date(2018, 5, 9, 16, 0, 0, 0, time.UTC))
+	state.SetClock(c)
+
+	api := &apiServer{
+		paths: clf.NewPathTable(),
+		state: state,
+		clock: c,
+	}
+
+	stats := map[string]int{
+		"requests.total":                  3,
+		"path./users.requests":            3,
+		"path./users.status.200.requests": 2,
+		"path./users.status.500.requests": 1,
+		"path./users.method.GET.bytes":    10,
+	}
+
+	payload, err := json.Marshal(message.NewStatMessage(stats, 10, 12))
+	assert.Nil(err, "err nil")
+	assert.Nil(api.processMessage(payload), "err nil")
+
+	err = state.Record(alerts.Transition{
+		Time:     time.Now(),
+		Rule:     "high-traffic",
+		Metric:   "requests.total",
+		Value:    12,
+		Severity: message.SeverityMax,
+		Text:     "alert",
+	})
+	assert.Nil(err, "err nil")
+
+	t.Run("metrics - from last stats", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		get(api.handleMetrics)(rec, httptest.NewRequest(http.MethodGet, "/api/v1/metrics", nil))
+
+		var body struct {
+			Metrics []string `json:"metrics"`
+		}
+		assert.Equal(http.StatusOK, rec.Code, "status ok")
+		assert.Nil(json.Unmarshal(rec.Body.Bytes(), &body), "err nil")
+		assert.Equal(5, len(body.Metrics), "known metrics")
+		assert.Equal("path./users.method.GET.bytes", body.Metrics[0], "sorted metrics")
+	})
+
+	t.Run("prometheus - last health metrics", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		get(api.handlePrometheus)(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		assert.Equal(http.StatusOK, rec.Code, "status ok")
+		assert.Equal("", rec.Body.String(), "no metrics yet")
+
+		payload, err := json.Marshal(message.NewHealthMessage(12, []message.HealthMetric{
+			{Name: "loghound_alert_evaluation_seconds", Type: message.MetricSummary, Labels: map[string]string{"rule": "high-traffic"}, Value: 0.5, Count: 4},
+			{Name: "loghound_goroutines", Type: message.MetricGauge, Value: 12},
+			{Name: "loghound_lines_read_total", Type: message.MetricCounter, Labels: map[string]string{"file": `C:\logs\"access".log`}, Value: 1e6},
+			{Name: "loghound_lines_read_total", Type: message.MetricCounter, Labels: map[string]string{"file": "b.log"}, Value: 3},
+		}))
+		assert.Nil(err, "err nil")
+		assert.Nil(api.processMessage(payload), "err nil")
+
+		rec = httptest.NewRecorder()
+		get(api.handlePrometheus)(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		assert.Equal(`# TYPE loghound_alert_evaluation_seconds summary
+loghound_alert_evaluation_seconds_sum{rule="high-traffic"} 0.5
+loghound_alert_evaluation_seconds_count{rule="high-traffic"} 4
+# TYPE loghound_goroutines gauge
+loghound_goroutines 12
+# TYPE loghound_lines_read_total counter
+loghound_lines_read_total{file="C:\\logs\\\"access\".log"} 1e+06
+loghound_lines_read_total{file="b.log"} 3
+`, rec.Body.String())
+	})
+
+	t.Run("paths - requests table", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		get(api.handlePaths)(rec, httptest.NewRequest(http.MethodGet, "/api/v1/paths", nil))
+
+		var body struct {
+			Paths []clf.PathRequests `json:"paths"`
+		}
+		assert.Equal(http.StatusOK, rec.Code, "status ok")
+		assert.Nil(json.Unmarshal(rec.Body.Bytes(), &body), "err nil")
+		assert.Equal([]clf.PathRequests{{Path: "/users", Requests: 3, Status2xx: 2, Status5xx: 1}}, body.Paths, "expected table")
+	})
+
+	t.Run("alerts - active alert", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		get(api.handleAlerts)(rec, httptest.NewRequest(http.MethodGet, "/api/v1/alerts", nil))
+
+		var body struct {
+			Active  []alerts.Transition `json:"active"`
+			History []alerts.Transition `json:"history"`
+		}
+		assert.Equal(http.StatusOK, rec.Code, "status ok")
+		assert.Nil(json.Unmarshal(rec.Body.Bytes(), &body), "err nil")
+		assert.Equal(1, len(body.Active), "one active alert")
+		assert.Equal(1, len(body.History), "one alert in history")
+	})
+
+	t.Run("series - storage disabled", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		get(api.handleSeries)(rec, httptest.NewRequest(http.MethodGet, "/api/v1/series?metric=requests.total", nil))
+		assert.Equal(http.StatusServiceUnavailable, rec.Code, "service unavailable")
+	})
+
+	t.Run("series - ranges relative to the clock time", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "api")
+		assert.Nil(err, "err nil")
+		defer os.RemoveAll(dir)
+
+		opts := tsdb.DefaultOptions()
+		opts.Clock = c
+		db, err := tsdb.Open(dir, opts)
+		assert.Nil(err, "err nil")
+		defer db.Close()
+		assert.Nil(db.AppendStats(c.Now().Unix()-10, map[string]int{"requests.total": 3}), "err nil")
+
+		series := &apiServer{db: db, clock: c}
+		rec := httptest.NewRecorder()
+		get(series.handleSeries)(rec, httptest.NewRequest(http.MethodGet, "/api/v1/series?metric=requests.total&from=-1h", nil))
+		assert.Equal(http.StatusOK, rec.Code, "series served")
+		assert.Contains(rec.Body.String(), fmt.Sprintf(`{"timestamp":%d,"value":3}`, c.Now().Unix()-10), "point within the last hour of the clock")
+	})
+
+	t.Run("CheckAddr - loopback unless public", func(t *testing.T) {
+		assert.Nil(CheckAddr("localhost:8080", false), "localhost")
+		assert.Nil(CheckAddr("127.0.0.1:8080", false), "loopback")
+		assert.Nil(CheckAddr("[::1]:8080", false), "ipv6 loopback")
+		assert.NotNil(CheckAddr(":8080", false), "all interfaces")
+		assert.NotNil(CheckAddr("10.0.0.1:8080", false), "non loopback")
+		assert.Nil(CheckAddr(":8080", true), "public")
+	})
+
+	t.Run("client - metrics and series errors", func(t *testing.T) {
+		mux := http.NewServeMux()
+		mux.HandleFunc("/api/v1/metrics", get(api.handleMetrics))
+		mux.HandleFunc("/api/v1/series", get(api.handleSeries))
+		srv := httptest.NewServer(mux)
+		defer srv.Close()
+
+		addr := strings.TrimPrefix(srv.URL, "http://")
+
+		metrics, err := Metrics(addr)
+		assert.Nil(err, "err nil")
+		assert.Equal(5, len(metrics), "known metrics")
+
+		_, err = Series(addr, "requests.total", "-1h", "0s", time.Minute, "avg")
+		assert.Equal("api: 503 Service Unavailable: stats storage is disabled", err.Error(), "api error")
+	})
+
+	t.Run("read only", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		get(api.handleMetrics)(rec, httptest.NewRequest(http.MethodPost, "/api/v1/metrics", nil))
+		assert.Equal(http.StatusMethodNotAllowed, rec.Code, "method not allowed")
+	})
+
+	t.Run("silences - create and list", func(t *testing.T) {
+		body := `{"matcher": "requests.*", "duration": "1h", "comment": "load test"}`
+		rec := httptest.NewRecorder()
+		api.handleSilences(rec, httptest.NewRequest(http.MethodPost, "/api/v1/silences", strings.NewReader(body)))
+		assert.Equal(http.StatusCreated, rec.Code, "silence created")
+
+		assert.True(state.Silenced("requests.total", c.Now()), "alert silenced")
+		assert.False(state.Silenced("bytes.total", c.Now()), "alert not silenced")
+		assert.False(state.Silenced("requests.total", time.Now()), "silence from the clock time")
+
+		rec = httptest.NewRecorder()
+		api.handleSilences(rec, httptest.NewRequest(http.MethodGet, "/api/v1/silences", nil))
+
+		var silences struct {
+			Silences []alerts.Silence `json:"silences"`
+		}
+		assert.Nil(json.Unmarshal(rec.Body.Bytes(), &silences), "err nil")
+		assert.Equal(1, len(silences.Silences), "one silence")
+		assert.Equal("load test", silences.Silences[0].Comment, "silence comment")
+	})
+
+	t.Run("silences - invalid duration", func(t *testing.T) {
+		body := `{"matcher": "requests.*", "duration": "forever"}`
+		rec := httptest.NewRecorder()
+		api.handleSilences(rec, httptest.NewRequest(http.MethodPost, "/api/v1/silences", strings.NewReader(body)))
+		assert.Equal(http.StatusBadRequest, rec.Code, "bad request")
+	})
+
+	t.Run("ack - active alert", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		api.handleAck(rec, httptest.NewRequest(http.MethodPost, "/api/v1/alerts/ack", strings.NewReader(`{"rule": "high-traffic"}`)))
+		assert.Equal(http.StatusNoContent, rec.Code, "alert acknowledged")
+		assert.True(state.Acked("high-traffic"), "alert acknowledged")
+
+		rec = httptest.NewRecorder()
+		api.handleAck(rec, httptest.NewRequest(http.MethodPost, "/api/v1/alerts/ack", strings.NewReader(`{"rule": "low-traffic"}`)))
+		assert.Equal(http.StatusNotFound, rec.Code, "no active alert")
+	})
+
+	t.Run("reload - disabled", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		api.handleReload(rec, httptest.NewRequest(http.MethodPost, "/api/v1/reload", nil))
+		assert.Equal(http.StatusServiceUnavailable, rec.Code, "service unavailable")
+	})
+
+	t.Run("reload - changes and validation errors", func(t *testing.T) {
+		files := []string{"access.log"}
+		var loadErr error
+
+		r, err := reload.NewReloader(func() (*reload.Config, error) {
+			return &reload.Config{Files: files}, loadErr
+		}, &reload.Config{Files: []string{"access.log"}})
+		assert.Nil(err, "err nil")
+		api.reload = r
+
+		files = []string{"access.log", "other.log"}
+		rec := httptest.NewRecorder()
+		api.handleReload(rec, httptest.NewRequest(http.MethodPost, "/api/v1/reload", nil))
+
+		var changes reload.Changes
+		assert.Equal(http.StatusOK, rec.Code, "status ok")
+		assert.Nil(json.Unmarshal(rec.Body.Bytes(), &changes), "err nil")
+		assert.Equal([]string{"other.log"}, changes.Files.Added, "file added")
+
+		loadErr = fmt.Errorf("invalid rule")
+		rec = httptest.NewRecorder()
+		api.handleReload(rec, httptest.NewRequest(http.MethodPost, "/api/v1/reload", nil))
+		assert.Equal(http.StatusUnprocessableEntity, rec.Code, "invalid configuration")
+		assert.Contains(rec.Body.String(), "invalid rule", "validation error reported")
+	})
+}
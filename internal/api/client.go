@@ -0,0 +1,131 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/juacker/loghound/internal/alerts"
+	"github.com/juacker/loghound/internal/reload"
+	"github.com/juacker/loghound/internal/tsdb"
+)
+
+var client = &http.Client{Timeout: 10 * time.Second}
+
+// post sends body as json to the api running at addr, and decodes the response in v if not nil
+func post(addr, path string, body, v interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.Post("http://"+addr+path, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("api: request failed: %v", err)
+	}
+
+	return decode(resp, v)
+}
+
+// fetch requests path with query to the api running at addr, and decodes the response in v
+func fetch(addr, path string, query url.Values, v interface{}) error {
+	resp, err := client.Get("http://" + addr + path + "?" + query.Encode())
+	if err != nil {
+		return fmt.Errorf("api: request failed: %v", err)
+	}
+
+	return decode(resp, v)
+}
+
+// decode closes the body of resp after decoding it in v if not nil, or its error
+func decode(resp *http.Response, v interface{}) error {
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		var e struct {
+			Error string `json:"error"`
+		}
+		json.NewDecoder(resp.Body).Decode(&e) //errcheck: nolint
+		return fmt.Errorf("api: %s: %s", resp.Status, e.Error)
+	}
+
+	if v == nil {
+		return nil
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// CreateSilence creates a silence for matcher from now on, in the api running at addr
+func CreateSilence(addr, matcher string, duration time.Duration, comment string) (*alerts.Silence, error) {
+	var silence alerts.Silence
+
+	err := post(addr, "/api/v1/silences", silenceRequest{
+		Matcher:  matcher,
+		Duration: duration.String(),
+		Comment:  comment,
+	}, &silence)
+	if err != nil {
+		return nil, err
+	}
+
+	return &silence, nil
+}
+
+// Ack acknowledges the active alert for rule, in the api running at addr
+func Ack(addr, rule string) error {
+	return post(addr, "/api/v1/alerts/ack", map[string]string{"rule": rule}, nil)
+}
+
+// Reload reloads the configuration of the loghound running at addr, and returns the changes
+func Reload(addr string) (*reload.Changes, error) {
+	var changes reload.Changes
+
+	err := post(addr, "/api/v1/reload", nil, &changes)
+	if err != nil {
+		return nil, err
+	}
+
+	return &changes, nil
+}
+
+// Metrics returns the names of the metrics of the loghound running at addr
+func Metrics(addr string) ([]string, error) {
+	var resp struct {
+		Metrics []string `json:"metrics"`
+	}
+
+	err := fetch(addr, "/api/v1/metrics", nil, &resp)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Metrics, nil
+}
+
+// Series returns the points of metric stored by the loghound running at addr.
+// from and to are unix seconds or durations relative to now, like -1h
+func Series(addr, metric, from, to string, step time.Duration, agg tsdb.Aggregation) ([]tsdb.Point, error) {
+	query := url.Values{}
+	query.Set("metric", metric)
+	query.Set("from", from)
+	query.Set("to", to)
+	query.Set("agg", string(agg))
+	if step > 0 {
+		query.Set("step", step.String())
+	}
+
+	var resp struct {
+		Points []tsdb.Point `json:"points"`
+	}
+
+	err := fetch(addr, "/api/v1/series", query, &resp)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Points, nil
+}
@@ -3,17 +3,37 @@ package broker
 import (
 	"encoding/json"
 	"log"
+	"sort"
 	"sync"
+	"sync/atomic"
 )
 
+// size of the broker and subscribers queues
+const queueSize = 100
+
 type messageBroker struct {
 	sync.Mutex
 	ctl         chan bool
 	wg          *sync.WaitGroup
-	subscribers map[int][]chan []byte
+	subscribers map[int][]*subscription
 	listener    chan []byte
 }
 
+// subscriber is the queue of a connection
+type subscriber struct {
+	name string
+	ch   chan []byte
+}
+
+// subscription counts the messages of a topic not delivered right away to a subscriber
+type subscription struct {
+	// updated atomically, first to keep them aligned
+	dropped    uint64
+	blocked    uint64
+	topic      int
+	subscriber *subscriber
+}
+
 type message struct {
 	Topic   int    `json:"topic"`
 	Payload []byte `json:"payload"`
@@ -51,31 +71,93 @@ func (b *messageBroker) broadcast(payload []byte) error {
 		return err
 	}
 
+	// the lock is not held while waiting for slow subscribers, so stats
+	// can still be read
 	b.Lock()
-	defer b.Unlock()
+	subscriptions := b.subscribers[msg.Topic]
+	b.Unlock()
 
 	log.Println("Checking subscribers for topic ", msg.Topic)
-	for _, subscriber := range b.subscribers[msg.Topic] {
-		log.Println("sending message to subscriber ", subscriber, msg.Topic)
-		subscriber <- msg.Payload
+	for _, s := range subscriptions {
+		log.Println("sending message to subscriber ", s.subscriber.name, msg.Topic)
+
+		select {
+		case s.subscriber.ch <- msg.Payload:
+			continue
+		default:
+		}
+
+		// the subscriber queue is full
+		if lossy[msg.Topic] {
+			atomic.AddUint64(&s.dropped, 1)
+			continue
+		}
+
+		atomic.AddUint64(&s.blocked, 1)
+		s.subscriber.ch <- msg.Payload
 	}
 
 	return nil
 }
 
-func (b *messageBroker) subscribe(topic ...int) (chan []byte, error) {
+func (b *messageBroker) subscribe(name string, topic ...int) (chan []byte, error) {
 	b.Lock()
 	defer b.Unlock()
 
-	ch := make(chan []byte, 100)
+	s := &subscriber{
+		name: name,
+		ch:   make(chan []byte, queueSize),
+	}
+
 	for _, t := range topic {
-		if _, ok := b.subscribers[t]; !ok {
-			b.subscribers[t] = make([]chan []byte, 0)
+		// subscriptions are copied on write, broadcast reads them without lock
+		subscriptions := make([]*subscription, len(b.subscribers[t]), len(b.subscribers[t])+1)
+		copy(subscriptions, b.subscribers[t])
+		b.subscribers[t] = append(subscriptions, &subscription{topic: t, subscriber: s})
+	}
+
+	return s.ch, nil
+}
+
+// SubscriptionStats reports the queue of a subscriber of a topic
+type SubscriptionStats struct {
+	Subscriber string
+	Topic      int
+	// Depth is the number of messages waiting in the subscriber queue, of any topic
+	Depth int
+	// Dropped is the number of messages of lossy topics dropped with the queue full
+	Dropped uint64
+	// Blocked is the number of messages the broker waited to deliver with the queue full
+	Blocked uint64
+}
+
+// Stats returns the messages waiting to be broadcast by the broker, and the
+// stats of every subscription
+func Stats() (int, []SubscriptionStats) {
+	broker.Lock()
+	defer broker.Unlock()
+
+	stats := make([]SubscriptionStats, 0)
+	for _, subscriptions := range broker.subscribers {
+		for _, s := range subscriptions {
+			stats = append(stats, SubscriptionStats{
+				Subscriber: s.subscriber.name,
+				Topic:      s.topic,
+				Depth:      len(s.subscriber.ch),
+				Dropped:    atomic.LoadUint64(&s.dropped),
+				Blocked:    atomic.LoadUint64(&s.blocked),
+			})
 		}
-		b.subscribers[t] = append(b.subscribers[t], ch)
 	}
 
-	return ch, nil
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Subscriber != stats[j].Subscriber {
+			return stats[i].Subscriber < stats[j].Subscriber
+		}
+		return stats[i].Topic < stats[j].Topic
+	})
+
+	return len(broker.listener), stats
 }
 
 // Run starts message broker
@@ -85,10 +167,10 @@ func Run(wg *sync.WaitGroup, ctl chan bool) {
 	broker.listen()
 }
 
-// NewConnection returns a new broker connection, the connection
-// will receive messages from the subscribed topics only
-func NewConnection(topic ...int) (*Connection, error) {
-	readChannel, err := broker.subscribe(topic...)
+// NewConnection returns a new broker connection for the module name, the
+// connection will receive messages from the subscribed topics only
+func NewConnection(name string, topic ...int) (*Connection, error) {
+	readChannel, err := broker.subscribe(name, topic...)
 	if err != nil {
 		return nil, err
 	}
@@ -101,7 +183,7 @@ func NewConnection(topic ...int) (*Connection, error) {
 
 func init() {
 	broker = &messageBroker{
-		subscribers: make(map[int][]chan []byte),
-		listener:    make(chan []byte, 100),
+		subscribers: make(map[int][]*subscription),
+		listener:    make(chan []byte, queueSize),
 	}
 }
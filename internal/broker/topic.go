@@ -5,4 +5,39 @@ const (
 	TopicData = iota
 	TopicStat
 	TopicAlert
+	TopicBaseline
+	TopicFile
+	TopicSLO
+	TopicConfig
+	TopicHealth
+	TopicRejected
 )
+
+// topicNames are the names of the topics in the health metrics
+var topicNames = map[int]string{
+	TopicData:     "data",
+	TopicStat:     "stat",
+	TopicAlert:    "alert",
+	TopicBaseline: "baseline",
+	TopicFile:     "file",
+	TopicSLO:      "slo",
+	TopicConfig:   "config",
+	TopicHealth:   "health",
+	TopicRejected: "rejected",
+}
+
+// lossy topics drop the messages for subscribers with a full queue, instead
+// of waiting for them. Health snapshots are sent again a few seconds later,
+// and rejected lines are only samples
+var lossy = map[int]bool{
+	TopicHealth:   true,
+	TopicRejected: true,
+}
+
+// TopicName returns the name of topic
+func TopicName(topic int) string {
+	if name, ok := topicNames[topic]; ok {
+		return name
+	}
+	return "unknown"
+}
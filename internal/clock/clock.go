@@ -0,0 +1,196 @@
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock tells the time to the modules, so they can run on simulated time
+type Clock interface {
+	Now() time.Time
+	// NewTicker returns a ticker ticking every d of the clock time
+	NewTicker(d time.Duration) *Ticker
+}
+
+// Ticker delivers the ticks of a clock on C. Ticks only tell that the period
+// elapsed, modules read the time with the clock Now
+type Ticker struct {
+	C    <-chan time.Time
+	stop func()
+}
+
+// Stop turns off the ticker, no more ticks are sent
+func (t *Ticker) Stop() {
+	t.stop()
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) NewTicker(d time.Duration) *Ticker {
+	t := time.NewTicker(d)
+	return &Ticker{C: t.C, stop: t.Stop}
+}
+
+// New returns the system clock
+func New() Clock {
+	return realClock{}
+}
+
+// Fake is a clock that only moves when it is told to, so tests and simulations
+// control the time. Its tickers tick when the clock goes past their period
+type Fake struct {
+	sync.Mutex
+	cond    *sync.Cond
+	now     time.Time
+	tickers []*fakeTicker
+}
+
+type fakeTicker struct {
+	c      chan time.Time
+	period time.Duration
+	next   time.Time
+}
+
+// NewFake returns a fake clock set at now
+func NewFake(now time.Time) *Fake {
+	f := &Fake{now: now}
+	f.cond = sync.NewCond(&f.Mutex)
+
+	return f
+}
+
+// Now returns the clock time
+func (f *Fake) Now() time.Time {
+	f.Lock()
+	defer f.Unlock()
+
+	return f.now
+}
+
+// NewTicker returns a ticker ticking every d the clock is moved. As with
+// system tickers, ticks are dropped if the receiver falls behind
+func (f *Fake) NewTicker(d time.Duration) *Ticker {
+	if d <= 0 {
+		panic("clock: non-positive interval for NewTicker")
+	}
+
+	f.Lock()
+	defer f.Unlock()
+
+	t := &fakeTicker{
+		c:      make(chan time.Time, 1),
+		period: d,
+		next:   f.now.Add(d),
+	}
+	f.tickers = append(f.tickers, t)
+	f.cond.Broadcast()
+
+	return &Ticker{C: t.c, stop: func() { f.remove(t) }}
+}
+
+func (f *Fake) remove(t *fakeTicker) {
+	f.Lock()
+	defer f.Unlock()
+
+	for i, ticker := range f.tickers {
+		if ticker == t {
+			f.tickers = append(f.tickers[:i], f.tickers[i+1:]...)
+			f.cond.Broadcast()
+			return
+		}
+	}
+}
+
+// BlockUntil waits until n tickers are running, so the clock is not moved
+// before the goroutines under test create their tickers
+func (f *Fake) BlockUntil(n int) {
+	f.Lock()
+	defer f.Unlock()
+
+	for len(f.tickers) != n {
+		f.cond.Wait()
+	}
+}
+
+// Set sets the clock time, tickers whose period elapsed tick
+func (f *Fake) Set(now time.Time) {
+	f.Lock()
+	defer f.Unlock()
+
+	f.set(now)
+}
+
+// Advance moves the clock forward by d
+func (f *Fake) Advance(d time.Duration) {
+	f.Lock()
+	defer f.Unlock()
+
+	f.set(f.now.Add(d))
+}
+
+func (f *Fake) set(now time.Time) {
+	f.now = now
+	for _, t := range f.tickers {
+		if t.next.After(now) {
+			continue
+		}
+
+		select {
+		case t.c <- now:
+		default:
+		}
+
+		// the missed periods are skipped
+		for !t.next.After(now) {
+			t.next = t.next.Add(t.period)
+		}
+	}
+}
+
+// Virtual is a clock running speed times as fast as the system clock, from
+// a start time on. It drives the pipeline when replaying logs
+type Virtual struct {
+	start time.Time
+	real  time.Time
+	speed float64
+}
+
+// NewVirtual returns a virtual clock set at start, running at speed
+func NewVirtual(start time.Time, speed float64) *Virtual {
+	return &Virtual{
+		start: start,
+		real:  time.Now(),
+		speed: speed,
+	}
+}
+
+// Now returns the clock time
+func (v *Virtual) Now() time.Time {
+	return v.start.Add(time.Duration(float64(time.Since(v.real)) * v.speed))
+}
+
+// NewTicker returns a ticker ticking every d of the virtual time, at most
+// every millisecond of the system time
+func (v *Virtual) NewTicker(d time.Duration) *Ticker {
+	period := v.Real(d)
+	if period < time.Millisecond {
+		period = time.Millisecond
+	}
+
+	t := time.NewTicker(period)
+	return &Ticker{C: t.C, stop: t.Stop}
+}
+
+// Real returns the system time taking d of the virtual time
+func (v *Virtual) Real(d time.Duration) time.Duration {
+	return time.Duration(float64(d) / v.speed)
+}
+
+// Until returns the system time left until the clock reaches t
+func (v *Virtual) Until(t time.Time) time.Duration {
+	return v.Real(t.Sub(v.Now()))
+}
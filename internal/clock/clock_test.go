@@ -0,0 +1,85 @@
+package clock
+
+import (
+	"testing"
+	"time"
+
+	tassert "github.com/stretchr/testify/assert"
+)
+
+func TestInternalClock(t *testing.T) {
+
+	assert := tassert.New(t)
+
+	start := time.Date(2021, 1, 1, 10, 0, 0, 0, time.UTC)
+
+	t.Run("Fake - tickers tick when the clock is moved", func(t *testing.T) {
+		f := NewFake(start)
+
+		ticker := f.NewTicker(10 * time.Second)
+		f.BlockUntil(1)
+
+		f.Advance(9 * time.Second)
+		select {
+		case <-ticker.C:
+			assert.Fail("tick before the period")
+		default:
+		}
+
+		f.Advance(time.Second)
+		select {
+		case tick := <-ticker.C:
+			assert.Equal(start.Add(10*time.Second), tick, "tick at the clock time")
+		default:
+			assert.Fail("no tick")
+		}
+
+		// missed periods are skipped, a tick is kept for the receiver
+		f.Advance(35 * time.Second)
+		assert.Equal(1, len(ticker.C), "one tick")
+		<-ticker.C
+		f.Advance(4 * time.Second)
+		assert.Equal(0, len(ticker.C), "next period at 50s")
+		f.Advance(time.Second)
+		assert.Equal(1, len(ticker.C), "tick at 50s")
+
+		ticker.Stop()
+		f.BlockUntil(0)
+		assert.Equal(start.Add(50*time.Second), f.Now(), "clock time")
+	})
+
+	t.Run("Virtual - time at a speed factor", func(t *testing.T) {
+		v := NewVirtual(start, 100)
+
+		// a second of system time since the clock started
+		v.real = time.Now().Add(-time.Second)
+		assert.InDelta(100, v.Now().Sub(start).Seconds(), 5, "100 seconds of virtual time")
+
+		assert.Equal(100*time.Millisecond, v.Real(10*time.Second), "system time")
+		assert.InDelta(time.Second.Seconds(), v.Until(start.Add(200*time.Second)).Seconds(), 0.05, "system time until")
+		assert.True(v.Until(start) < 0, "past time")
+	})
+
+	t.Run("Virtual - tickers at a speed factor", func(t *testing.T) {
+		v := NewVirtual(start, 1000)
+
+		// a tick every 10ms of system time
+		ticker := v.NewTicker(10 * time.Second)
+		defer ticker.Stop()
+
+		begin := time.Now()
+		for i := 0; i < 3; i++ {
+			select {
+			case <-ticker.C:
+			case <-time.After(time.Second):
+				assert.Fail("no tick")
+				return
+			}
+		}
+
+		elapsed := time.Since(begin)
+		assert.True(elapsed >= 30*time.Millisecond, "ticks every 10s of virtual time")
+		assert.True(elapsed < time.Second, "ticks scaled by the speed")
+		assert.True(v.Now().Sub(start) >= 30*time.Second, "virtual time elapsed")
+	})
+}
@@ -0,0 +1,765 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/juacker/loghound/internal/alerts"
+	"github.com/juacker/loghound/internal/console"
+	"github.com/juacker/loghound/internal/notify"
+	"github.com/juacker/loghound/internal/slo"
+	"github.com/juacker/loghound/internal/stats"
+	"github.com/juacker/loghound/pkg/clf"
+	"gopkg.in/yaml.v3"
+)
+
+// Formats are the supported log formats of the inputs
+var Formats = clf.Formats
+
+// prefix of the environment variables overriding the configuration
+const envPrefix = "LOGHOUND_"
+
+// Input is a log file to monitor
+type Input struct {
+	Path   string `yaml:"path"`
+	Format string `yaml:"format"`
+}
+
+// Stats sets how the metrics are generated
+type Stats struct {
+	Interval time.Duration `yaml:"interval"`
+	// Paths group request paths, by default they are grouped by their first segment
+	Paths []stats.PathRule `yaml:"paths"`
+	// Metrics are the metric families generated, all if empty
+	Metrics []string `yaml:"metrics"`
+	// Params are the query parameters counted by value, none by default
+	Params []string `yaml:"params"`
+}
+
+// Alerts sets the alert rules and slos, and how alerts are kept
+type Alerts struct {
+	State  string        `yaml:"state"`
+	Repeat time.Duration `yaml:"repeat"`
+	// RulesFile has more rules and slos, relative to the configuration file
+	RulesFile string        `yaml:"rules_file"`
+	Rules     []alerts.Rule `yaml:"rules"`
+	SLOs      []slo.SLO     `yaml:"slos"`
+	// Threshold and Window of the high traffic rule created without rules nor slos
+	Threshold float64       `yaml:"threshold"`
+	Window    time.Duration `yaml:"window"`
+}
+
+// Journal sets the data journal, disabled without dir
+type Journal struct {
+	Dir string `yaml:"dir"`
+	// MaxSize is the retention size in MB
+	MaxSize int64         `yaml:"max_size"`
+	MaxAge  time.Duration `yaml:"max_age"`
+	// Replay is the period before startup to rebuild stats from
+	Replay time.Duration `yaml:"replay"`
+}
+
+// Rejects sets how the lines failing to parse are kept
+type Rejects struct {
+	// DeadLetter is the file to append them verbatim, disabled if empty
+	DeadLetter string `yaml:"dead_letter"`
+}
+
+// Syslog sets the syslog receiver, disabled without listen address
+type Syslog struct {
+	// Listen is the address to receive messages on, over udp and tcp
+	Listen string `yaml:"listen"`
+	// Format is the log format of the message bodies
+	Format string `yaml:"format"`
+}
+
+// Storage sets the stats history storage, disabled without dir
+type Storage struct {
+	Dir string `yaml:"dir"`
+}
+
+// HTTP sets the http api, disabled without addr
+type HTTP struct {
+	Addr string `yaml:"addr"`
+	// Public allows non loopback addresses, the api has no authentication
+	Public bool `yaml:"public"`
+}
+
+// Exporters sets how loghound data is served
+type Exporters struct {
+	HTTP HTTP `yaml:"http"`
+}
+
+// Notifiers sets where and how grouped alerts are notified
+type Notifiers struct {
+	Webhook       string        `yaml:"webhook"`
+	File          string        `yaml:"file"`
+	GroupBy       []string      `yaml:"group_by"`
+	GroupWait     time.Duration `yaml:"group_wait"`
+	GroupInterval time.Duration `yaml:"group_interval"`
+	Repeat        time.Duration `yaml:"repeat"`
+}
+
+// Layout sets the height of the dashboard rows, as percentages
+type Layout struct {
+	Plots  int `yaml:"plots"`
+	Tables int `yaml:"tables"`
+}
+
+// Dashboard sets the console dashboard
+type Dashboard struct {
+	Interval time.Duration `yaml:"interval"`
+	Refresh  time.Duration `yaml:"refresh"`
+	Layout   Layout        `yaml:"layout"`
+}
+
+// Config is the configuration of the whole pipeline
+type Config struct {
+	Inputs    []Input   `yaml:"inputs"`
+	Syslog    Syslog    `yaml:"syslog"`
+	Rejects   Rejects   `yaml:"rejects"`
+	Stats     Stats     `yaml:"stats"`
+	Alerts    Alerts    `yaml:"alerts"`
+	Journal   Journal   `yaml:"journal"`
+	Storage   Storage   `yaml:"storage"`
+	Exporters Exporters `yaml:"exporters"`
+	Notifiers Notifiers `yaml:"notifiers"`
+	Dashboard Dashboard `yaml:"dashboard"`
+
+	// node is the parsed file, to report errors with line numbers
+	node *yaml.Node
+	// overridden are the keys set by environment variables or flags
+	overridden map[string]bool
+}
+
+// Error is a configuration error, at Line of the file if known
+type Error struct {
+	Line    int
+	Field   string
+	Message string
+}
+
+func (e Error) Error() string {
+	switch {
+	case e.Line > 0 && e.Field != "":
+		return fmt.Sprintf("line %d: %s: %s", e.Line, e.Field, e.Message)
+	case e.Line > 0:
+		return fmt.Sprintf("line %d: %s", e.Line, e.Message)
+	case e.Field != "":
+		return fmt.Sprintf("%s: %s", e.Field, e.Message)
+	}
+	return e.Message
+}
+
+// Errors are the errors found in a configuration
+type Errors []Error
+
+func (e Errors) Error() string {
+	messages := make([]string, 0, len(e))
+	for _, err := range e {
+		messages = append(messages, err.Error())
+	}
+	return strings.Join(messages, "\n")
+}
+
+// Default returns the default configuration
+func Default() *Config {
+	n := notify.DefaultOptions()
+	d := console.DefaultOptions()
+
+	return &Config{
+		Inputs: []Input{{Path: "/tmp/access.log", Format: "clf"}},
+		Syslog: Syslog{Format: "clf"},
+		Stats: Stats{
+			Interval: 2 * time.Second,
+		},
+		Alerts: Alerts{
+			State:     "loghound-alerts.json",
+			Threshold: 10,
+			Window:    2 * time.Minute,
+		},
+		Journal: Journal{
+			MaxSize: 512,
+			MaxAge:  24 * time.Hour,
+		},
+		Notifiers: Notifiers{
+			GroupBy:       n.GroupBy,
+			GroupWait:     n.GroupWait,
+			GroupInterval: n.GroupInterval,
+			Repeat:        n.RepeatInterval,
+		},
+		Dashboard: Dashboard{
+			Interval: d.Interval,
+			Refresh:  d.Refresh,
+			Layout:   Layout{Plots: d.Layout.Plots, Tables: d.Layout.Tables},
+		},
+		overridden: make(map[string]bool),
+	}
+}
+
+// errors of the yaml decoder, like "line 3: cannot unmarshal ..."
+var yamlError = regexp.MustCompile(`^(?:yaml: )?line (\d+): (.*)$`)
+
+func decodeError(err error) Errors {
+	messages := []string{err.Error()}
+	if e, ok := err.(*yaml.TypeError); ok {
+		messages = e.Errors
+	}
+
+	errs := make(Errors, 0, len(messages))
+	for _, m := range messages {
+		match := yamlError.FindStringSubmatch(m)
+		if match == nil {
+			errs = append(errs, Error{Message: m})
+			continue
+		}
+		line, _ := strconv.Atoi(match[1])
+		errs = append(errs, Error{Line: line, Message: match[2]})
+	}
+	return errs
+}
+
+// Parse parses a yaml configuration over the defaults and validates it. Errors
+// are reported with their line numbers
+func Parse(data []byte) (*Config, error) {
+	return parse(data, "")
+}
+
+// Load reads the configuration file at path. The rules file is relative to it
+func Load(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("config: failed reading file %s: %v", path, err)
+	}
+
+	return parse(data, filepath.Dir(path))
+}
+
+// parse parses a yaml configuration, with the rules file relative to dir
+func parse(data []byte, dir string) (*Config, error) {
+	var node yaml.Node
+	err := yaml.Unmarshal(data, &node)
+	if err != nil {
+		return nil, decodeError(err)
+	}
+
+	c := Default()
+	c.node = &node
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	err = decoder.Decode(c)
+	if err != nil && err != io.EOF {
+		return nil, decodeError(err)
+	}
+
+	for i := range c.Inputs {
+		if c.Inputs[i].Format == "" {
+			c.Inputs[i].Format = "clf"
+		}
+	}
+
+	if c.Alerts.RulesFile != "" && !filepath.IsAbs(c.Alerts.RulesFile) {
+		c.Alerts.RulesFile = filepath.Join(dir, c.Alerts.RulesFile)
+	}
+
+	err = c.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// field returns the settable field of key, like stats.interval
+func (c *Config) field(key string) (reflect.Value, bool) {
+	v := reflect.ValueOf(c).Elem()
+
+	for _, name := range strings.Split(key, ".") {
+		if v.Kind() != reflect.Struct {
+			return reflect.Value{}, false
+		}
+
+		found := false
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).Tag.Get("yaml") == name {
+				v = v.Field(i)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return reflect.Value{}, false
+		}
+	}
+
+	switch v.Kind() {
+	case reflect.String, reflect.Int, reflect.Int64, reflect.Float64, reflect.Bool:
+		return v, true
+	case reflect.Slice:
+		return v, v.Type().Elem().Kind() == reflect.String
+	}
+	return reflect.Value{}, false
+}
+
+// Keys returns the keys that can be set with Set
+func Keys() []string {
+	keys := []string{"inputs"}
+
+	var walk func(t reflect.Type, prefix string)
+	walk = func(t reflect.Type, prefix string) {
+		for i := 0; i < t.NumField(); i++ {
+			tag := t.Field(i).Tag.Get("yaml")
+			if tag == "" {
+				continue
+			}
+
+			ft := t.Field(i).Type
+			switch {
+			case ft.Kind() == reflect.Struct:
+				walk(ft, prefix+tag+".")
+			case ft.Kind() == reflect.Slice && ft.Elem().Kind() != reflect.String:
+			default:
+				keys = append(keys, prefix+tag)
+			}
+		}
+	}
+	walk(reflect.TypeOf(Config{}), "")
+
+	sort.Strings(keys)
+	return keys
+}
+
+// Set overrides the value of key, like stats.interval, with value. Lists are comma
+// separated, and inputs are the paths of clf files
+func (c *Config) Set(key, value string) error {
+	c.overridden[key] = true
+
+	if key == "inputs" {
+		c.Inputs = make([]Input, 0)
+		for _, path := range split(value) {
+			c.Inputs = append(c.Inputs, Input{Path: path, Format: "clf"})
+		}
+		return nil
+	}
+
+	v, ok := c.field(key)
+	if !ok {
+		return fmt.Errorf("config: unknown key %s", key)
+	}
+
+	invalid := func(err error) error {
+		return fmt.Errorf("config: invalid value %q for %s: %v", value, key, err)
+	}
+
+	switch {
+	case v.Type() == reflect.TypeOf(time.Duration(0)):
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return invalid(err)
+		}
+		v.SetInt(int64(d))
+	case v.Kind() == reflect.String:
+		v.SetString(value)
+	case v.Kind() == reflect.Int || v.Kind() == reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return invalid(err)
+		}
+		v.SetInt(n)
+	case v.Kind() == reflect.Float64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return invalid(err)
+		}
+		v.SetFloat(f)
+	case v.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return invalid(err)
+		}
+		v.SetBool(b)
+	case v.Kind() == reflect.Slice:
+		v.Set(reflect.ValueOf(split(value)))
+	}
+
+	return nil
+}
+
+// split returns the non empty comma separated values of s
+func split(s string) []string {
+	values := make([]string, 0)
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// EnvName returns the environment variable overriding key, like LOGHOUND_STATS_INTERVAL
+func EnvName(key string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(key, ".", "_"))
+}
+
+// ApplyEnv overrides the configuration with the LOGHOUND_* environment variables found by lookup
+func (c *Config) ApplyEnv(lookup func(string) (string, bool)) error {
+	for _, key := range Keys() {
+		value, ok := lookup(EnvName(key))
+		if !ok {
+			continue
+		}
+
+		err := c.Set(key, value)
+		if err != nil {
+			return fmt.Errorf("%v (%s)", err, EnvName(key))
+		}
+	}
+	return nil
+}
+
+// validator collects the errors of a configuration with their lines
+type validator struct {
+	c    *Config
+	errs Errors
+}
+
+// line returns the line of the field in the file, 0 if unknown or overridden
+func (v *validator) line(field string) int {
+	path := strings.Split(field, ".")
+	for i := range path {
+		if v.c.overridden[strings.Join(path[:i+1], ".")] {
+			return 0
+		}
+	}
+
+	node := v.c.node
+	if node == nil {
+		return 0
+	}
+	if node.Kind == yaml.DocumentNode && len(node.Content) > 0 {
+		node = node.Content[0]
+	}
+
+	line := 0
+	for _, key := range path {
+		var next *yaml.Node
+		switch node.Kind {
+		case yaml.MappingNode:
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				if node.Content[i].Value == key {
+					next = node.Content[i+1]
+					line = node.Content[i].Line
+				}
+			}
+		case yaml.SequenceNode:
+			if i, err := strconv.Atoi(key); err == nil && i < len(node.Content) {
+				next = node.Content[i]
+				line = next.Line
+			}
+		}
+		if next == nil {
+			break
+		}
+		node = next
+	}
+
+	return line
+}
+
+func (v *validator) add(field string, format string, args ...interface{}) {
+	v.errs = append(v.errs, Error{
+		Line:    v.line(field),
+		Field:   field,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+func (v *validator) check(field string, err error) {
+	if err != nil {
+		v.add(field, "%v", err)
+	}
+}
+
+// format checks a log format, one of Formats or a custom regexp:<expression>
+func (v *validator) format(field, format string) {
+	if strings.HasPrefix(format, clf.FormatRegexp+":") {
+		_, err := clf.NewParser(format)
+		v.check(field, err)
+		return
+	}
+
+	if !contains(Formats, format) {
+		v.add(field, "unknown format %s, expected %s:<expression> or one of %s", format, clf.FormatRegexp, strings.Join(Formats, ", "))
+	}
+}
+
+// Validate checks the configuration, the errors have their line numbers in the
+// file unless the value was overridden
+func (c *Config) Validate() error {
+	v := &validator{c: c}
+
+	// syslog may be the only source of entries
+	if len(c.Inputs) == 0 && c.Syslog.Listen == "" {
+		v.add("inputs", "at least one input is required, or syslog.listen")
+	}
+	for i := range c.Inputs {
+		in := &c.Inputs[i]
+		field := fmt.Sprintf("inputs.%d", i)
+
+		if in.Path == "" {
+			v.add(field, "path is required")
+		}
+		v.format(field+".format", in.Format)
+	}
+
+	if c.Stats.Interval < time.Second {
+		v.add("stats.interval", "interval must be at least 1s")
+	}
+	for i, p := range c.Stats.Paths {
+		v.check(fmt.Sprintf("stats.paths.%d", i), (&stats.Counting{Paths: []stats.PathRule{p}}).Validate())
+	}
+	for i, p := range c.Stats.Params {
+		v.check(fmt.Sprintf("stats.params.%d", i), (&stats.Counting{Params: []string{p}}).Validate())
+	}
+	for i, m := range c.Stats.Metrics {
+		v.check(fmt.Sprintf("stats.metrics.%d", i), (&stats.Counting{Families: []string{m}}).Validate())
+	}
+
+	names := make(map[string]bool)
+	for i := range c.Alerts.Rules {
+		r := &c.Alerts.Rules[i]
+		field := fmt.Sprintf("alerts.rules.%d", i)
+
+		v.check(field, r.Check())
+		if names[r.Name] {
+			v.add(field, "duplicated rule name %s", r.Name)
+		}
+		names[r.Name] = true
+	}
+	sloNames := make(map[string]bool)
+	for i := range c.Alerts.SLOs {
+		s := &c.Alerts.SLOs[i]
+		field := fmt.Sprintf("alerts.slos.%d", i)
+
+		v.check(field, s.Check())
+		if sloNames[s.Name] {
+			v.add(field, "duplicated slo name %s", s.Name)
+		}
+		sloNames[s.Name] = true
+	}
+	if c.Alerts.RulesFile != "" {
+		_, _, err := c.Rules()
+		v.check("alerts.rules_file", err)
+	}
+	if c.Alerts.Repeat < 0 {
+		v.add("alerts.repeat", "repeat can't be negative")
+	}
+	if c.Alerts.Threshold <= 0 {
+		v.add("alerts.threshold", "threshold must be positive")
+	}
+	if c.Alerts.Window <= 0 {
+		v.add("alerts.window", "window must be positive")
+	}
+
+	if c.Journal.MaxSize <= 0 {
+		v.add("journal.max_size", "max size must be positive")
+	}
+	if c.Journal.MaxAge < 0 {
+		v.add("journal.max_age", "max age can't be negative")
+	}
+	if c.Journal.Replay < 0 {
+		v.add("journal.replay", "replay can't be negative")
+	}
+	if c.Journal.Replay > 0 && c.Journal.Dir == "" {
+		v.add("journal.replay", "replay needs a journal dir")
+	}
+
+	if c.Syslog.Listen != "" {
+		_, _, err := net.SplitHostPort(c.Syslog.Listen)
+		v.check("syslog.listen", err)
+	}
+	v.format("syslog.format", c.Syslog.Format)
+
+	if c.Exporters.HTTP.Addr != "" {
+		_, _, err := net.SplitHostPort(c.Exporters.HTTP.Addr)
+		v.check("exporters.http.addr", err)
+	}
+
+	if c.Notifiers.Webhook != "" {
+		u, err := url.Parse(c.Notifiers.Webhook)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			v.add("notifiers.webhook", "invalid url %s", c.Notifiers.Webhook)
+		}
+	}
+	if c.Notifiers.GroupWait < 0 {
+		v.add("notifiers.group_wait", "group wait can't be negative")
+	}
+	if c.Notifiers.GroupInterval < 0 {
+		v.add("notifiers.group_interval", "group interval can't be negative")
+	}
+	if c.Notifiers.Repeat < 0 {
+		v.add("notifiers.repeat", "repeat can't be negative")
+	}
+
+	if c.Dashboard.Interval < time.Minute {
+		v.add("dashboard.interval", "interval must be at least 1m")
+	}
+	if c.Dashboard.Refresh < 100*time.Millisecond {
+		v.add("dashboard.refresh", "refresh must be at least 100ms")
+	}
+	v.check("dashboard.layout", c.DashboardOptions().Layout.Validate())
+
+	if len(v.errs) > 0 {
+		sort.SliceStable(v.errs, func(i, j int) bool {
+			return v.errs[i].Line < v.errs[j].Line
+		})
+		return v.errs
+	}
+	return nil
+}
+
+func contains(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// fileRules returns the rules and slos of the rules file
+func (c *Config) fileRules() ([]alerts.Rule, []slo.SLO, error) {
+	rules, err := alerts.LoadRules(c.Alerts.RulesFile)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	slos, err := slo.Load(c.Alerts.RulesFile)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return rules, slos, nil
+}
+
+// Files returns the paths of the inputs
+func (c *Config) Files() []string {
+	files := make([]string, 0, len(c.Inputs))
+	for _, in := range c.Inputs {
+		files = append(files, in.Path)
+	}
+	return files
+}
+
+// FileFormats returns the log format of the inputs by path
+func (c *Config) FileFormats() map[string]string {
+	formats := make(map[string]string, len(c.Inputs))
+	for _, in := range c.Inputs {
+		formats[in.Path] = in.Format
+	}
+	return formats
+}
+
+// Rules returns the alert rules and slos of the configuration and the rules file.
+// Without rules file, rules nor slos, a high traffic rule is created with the
+// alerts threshold and window
+func (c *Config) Rules() ([]alerts.Rule, []slo.SLO, error) {
+	rules := append([]alerts.Rule{}, c.Alerts.Rules...)
+	slos := append([]slo.SLO{}, c.Alerts.SLOs...)
+
+	if c.Alerts.RulesFile != "" {
+		fileRules, fileSLOs, err := c.fileRules()
+		if err != nil {
+			return nil, nil, err
+		}
+		rules = append(rules, fileRules...)
+		slos = append(slos, fileSLOs...)
+	}
+
+	names := make(map[string]bool)
+	for _, r := range rules {
+		if names[r.Name] {
+			return nil, nil, fmt.Errorf("config: duplicated rule name %s", r.Name)
+		}
+		names[r.Name] = true
+	}
+	sloNames := make(map[string]bool)
+	for _, s := range slos {
+		if sloNames[s.Name] {
+			return nil, nil, fmt.Errorf("config: duplicated slo name %s", s.Name)
+		}
+		sloNames[s.Name] = true
+	}
+
+	if c.Alerts.RulesFile == "" && len(rules) == 0 && len(slos) == 0 {
+		rules = append(rules, alerts.Rule{
+			Name:      "high-traffic",
+			Type:      alerts.RuleThreshold,
+			Metric:    "requests.total",
+			Window:    c.Alerts.Window,
+			Threshold: c.Alerts.Threshold,
+		})
+	}
+
+	return rules, slos, nil
+}
+
+// Settings returns the sections of the configuration only applied at startup,
+// by name
+func (c *Config) Settings() map[string]interface{} {
+	return map[string]interface{}{
+		"syslog":  c.Syslog,
+		"rejects": c.Rejects,
+		"stats":   c.Stats,
+		"alerts": struct {
+			State  string
+			Repeat time.Duration
+		}{c.Alerts.State, c.Alerts.Repeat},
+		"journal":   c.Journal,
+		"storage":   c.Storage,
+		"exporters": c.Exporters,
+		"notifiers": c.Notifiers,
+		"dashboard": c.Dashboard,
+	}
+}
+
+// Counting returns how stats count the requests, with slos
+func (c *Config) Counting(slos []slo.SLO) stats.Counting {
+	return stats.Counting{
+		Paths:    c.Stats.Paths,
+		Families: c.Stats.Metrics,
+		Params:   c.Stats.Params,
+		SLOs:     slos,
+	}
+}
+
+// NotifyOptions returns how alerts are grouped before notifying them
+func (c *Config) NotifyOptions() notify.Options {
+	return notify.Options{
+		GroupBy:        c.Notifiers.GroupBy,
+		GroupWait:      c.Notifiers.GroupWait,
+		GroupInterval:  c.Notifiers.GroupInterval,
+		RepeatInterval: c.Notifiers.Repeat,
+	}
+}
+
+// DashboardOptions returns the console dashboard options
+func (c *Config) DashboardOptions() console.Options {
+	return console.Options{
+		Interval: c.Dashboard.Interval,
+		Refresh:  c.Dashboard.Refresh,
+		Layout:   clf.Layout{Plots: c.Dashboard.Layout.Plots, Tables: c.Dashboard.Layout.Tables},
+	}
+}
@@ -0,0 +1,193 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/juacker/loghound/pkg/clf"
+	tassert "github.com/stretchr/testify/assert"
+)
+
+func TestInternalConfig(t *testing.T) {
+
+	assert := tassert.New(t)
+
+	t.Run("Parse - defaults", func(t *testing.T) {
+		c, err := Parse([]byte(""))
+		assert.Nil(err, "err nil")
+		assert.Equal([]string{"/tmp/access.log"}, c.Files(), "default input")
+		assert.Equal(2*time.Second, c.Stats.Interval, "default stats interval")
+		assert.Equal(10*time.Minute, c.Dashboard.Interval, "default dashboard interval")
+		assert.Equal([]string{"alertname"}, c.Notifiers.GroupBy, "default group by")
+
+		rules, slos, err := c.Rules()
+		assert.Nil(err, "err nil")
+		assert.Equal(0, len(slos), "no slos")
+		assert.Equal(1, len(rules), "high traffic rule")
+		assert.Equal(10.0, rules[0].Threshold, "default threshold")
+		assert.Equal(2*time.Minute, rules[0].Window, "default window")
+	})
+
+	t.Run("Parse - whole pipeline", func(t *testing.T) {
+		c, err := Parse([]byte(`
+inputs:
+  - path: /var/log/nginx/access.log
+  - path: /var/log/api.log
+    format: clf
+syslog:
+  listen: localhost:5514
+  format: haproxy
+stats:
+  interval: 5s
+  paths:
+    - match: ^/api/v[0-9]+/(\w+)
+      name: /api-$1
+  metrics: [totals, paths]
+  params: [api_version]
+alerts:
+  rules:
+    - name: api-traffic
+      metric: path./api-users.requests
+      threshold: 5
+notifiers:
+  group_by: [alertname, path]
+dashboard:
+  interval: 30m
+  layout:
+    plots: 40
+    tables: 40
+`))
+		assert.Nil(err, "err nil")
+		assert.Equal([]string{"/var/log/nginx/access.log", "/var/log/api.log"}, c.Files(), "inputs")
+		assert.Equal("clf", c.Inputs[0].Format, "default format")
+		assert.Equal(Syslog{Listen: "localhost:5514", Format: "haproxy"}, c.Syslog, "syslog receiver")
+		assert.Equal(5*time.Second, c.Stats.Interval, "stats interval")
+		assert.Equal([]string{"totals", "paths"}, c.Counting(nil).Families, "metric families")
+		assert.Equal([]string{"api_version"}, c.Counting(nil).Params, "query parameters")
+		assert.Equal(30*time.Minute, c.DashboardOptions().Interval, "dashboard interval")
+		assert.Equal(40, c.DashboardOptions().Layout.Tables, "dashboard layout")
+		assert.Equal([]string{"alertname", "path"}, c.NotifyOptions().GroupBy, "group by")
+		assert.Equal(4*time.Hour, c.NotifyOptions().RepeatInterval, "default repeat")
+
+		rules, _, err := c.Rules()
+		assert.Nil(err, "err nil")
+		assert.Equal(1, len(rules), "rules from config only")
+		assert.Equal(2*time.Minute, rules[0].Window, "rule defaults")
+	})
+
+	t.Run("Parse - errors with line numbers", func(t *testing.T) {
+		_, err := Parse([]byte(`
+inputs:
+  - path: /tmp/access.log
+    format: xml
+stats:
+  interval: 100ms
+  metrics: [totals, colors]
+alerts:
+  rules:
+    - name: high-traffic
+      threshold: 5
+dashboard:
+  layout:
+    plots: 50
+    tables: 50
+`))
+		errs, ok := err.(Errors)
+		assert.True(ok, "config errors")
+		assert.Equal(5, len(errs), "all errors reported")
+		assert.Equal("line 4: inputs.0.format: unknown format xml, expected regexp:<expression> or one of clf, w3c, alb, elb, gcp, haproxy, envoy", errs[0].Error())
+		assert.Equal(6, errs[1].Line, "stats interval")
+		assert.Equal(7, errs[2].Line, "metric family")
+		assert.Equal(10, errs[3].Line, "rule without metric")
+		assert.Equal(13, errs[4].Line, "layout")
+
+		_, err = Parse([]byte("inputs: []\n"))
+		assert.Equal("line 1: inputs: at least one input is required, or syslog.listen", err.Error(), "no entries source")
+
+		c, err := Parse([]byte("inputs: []\nsyslog:\n  listen: localhost:5514\n"))
+		assert.Nil(err, "syslog only")
+		assert.Equal(0, len(c.Files()), "no inputs")
+
+		c, err = Parse([]byte("inputs:\n  - path: /tmp/app.log\n    format: 'regexp:^(?P<date>[^|]+)\\|(?P<status>\\d+)'\n"))
+		assert.Nil(err, "custom format")
+		_, err = clf.NewParser(c.FileFormats()["/tmp/app.log"])
+		assert.Nil(err, "parser of the custom format")
+
+		_, err = Parse([]byte("inputs:\n  - path: /tmp/app.log\n    format: 'regexp:^(?P<status>\\d+)'\n"))
+		assert.Equal("line 3: inputs.0.format: clf: missing group date in ^(?P<status>\\d+)", err.Error(), "custom format without date")
+
+		_, err = Parse([]byte("stats:\n  interval: often\n  color: red\n"))
+		errs, ok = err.(Errors)
+		assert.True(ok, "config errors")
+		assert.Equal(2, len(errs), "type and unknown field errors")
+		assert.Equal(2, errs[0].Line, "invalid duration")
+		assert.Equal(3, errs[1].Line, "unknown field")
+	})
+
+	t.Run("Load - rules file relative to the config", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "loghound-config")
+		assert.Nil(err, "err nil")
+		defer os.RemoveAll(dir)
+
+		rules := "rules:\n  - name: errors\n    metric: path./.status.500.requests\n    threshold: 1\n"
+		assert.Nil(ioutil.WriteFile(filepath.Join(dir, "rules.yml"), []byte(rules), 0644), "err nil")
+		config := "alerts:\n  rules_file: rules.yml\n  rules:\n    - name: errors\n      metric: requests.total\n"
+		assert.Nil(ioutil.WriteFile(filepath.Join(dir, "loghound.yml"), []byte(config), 0644), "err nil")
+
+		_, err = Load(filepath.Join(dir, "loghound.yml"))
+		assert.Equal("line 2: alerts.rules_file: config: duplicated rule name errors", err.Error())
+
+		config = "alerts:\n  rules_file: rules.yml\n"
+		assert.Nil(ioutil.WriteFile(filepath.Join(dir, "loghound.yml"), []byte(config), 0644), "err nil")
+
+		c, err := Load(filepath.Join(dir, "loghound.yml"))
+		assert.Nil(err, "err nil")
+		r, _, err := c.Rules()
+		assert.Nil(err, "err nil")
+		assert.Equal("errors", r[0].Name, "rules from the rules file")
+	})
+
+	t.Run("Settings - sections applied at startup", func(t *testing.T) {
+		c, err := Parse([]byte("stats:\n  params: [v]\n"))
+		assert.Nil(err, "err nil")
+		next, err := Parse([]byte("stats:\n  params: [v, page]\nalerts:\n  threshold: 20\n"))
+		assert.Nil(err, "err nil")
+
+		settings, nextSettings := c.Settings(), next.Settings()
+		assert.NotEqual(settings["stats"], nextSettings["stats"], "stats params changed")
+		assert.Equal(settings["alerts"], nextSettings["alerts"], "alert rules reloaded apart")
+		assert.Equal(settings["notifiers"], nextSettings["notifiers"], "notifiers unchanged")
+	})
+
+	t.Run("ApplyEnv and Set - overrides", func(t *testing.T) {
+		c, err := Parse([]byte("stats:\n  interval: 5s\n"))
+		assert.Nil(err, "err nil")
+
+		env := map[string]string{
+			"LOGHOUND_STATS_INTERVAL":      "10s",
+			"LOGHOUND_INPUTS":              "a.log, b.log",
+			"LOGHOUND_NOTIFIERS_GROUP_BY":  "path",
+			"LOGHOUND_EXPORTERS_HTTP_ADDR": "localhost:8080",
+		}
+		err = c.ApplyEnv(func(key string) (string, bool) {
+			v, ok := env[key]
+			return v, ok
+		})
+		assert.Nil(err, "err nil")
+		assert.Equal(10*time.Second, c.Stats.Interval, "env override")
+		assert.Equal([]string{"a.log", "b.log"}, c.Files(), "inputs override")
+		assert.Equal([]string{"path"}, c.Notifiers.GroupBy, "list override")
+		assert.Equal("localhost:8080", c.Exporters.HTTP.Addr, "nested override")
+
+		assert.Nil(c.Set("alerts.threshold", "20"), "flag override")
+		assert.Equal(20.0, c.Alerts.Threshold, "threshold")
+		assert.NotNil(c.Set("alerts.threshold", "high"), "invalid value")
+		assert.NotNil(c.Set("alerts.rules", "x"), "not settable")
+
+		assert.Nil(c.Set("stats.interval", "10ms"), "err nil")
+		assert.Equal("stats.interval: interval must be at least 1s", c.Validate().Error(), "overridden values have no line")
+	})
+}
@@ -4,17 +4,49 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"strconv"
+	"strings"
 	"time"
 
 	ui "github.com/gizak/termui/v3"
+	"github.com/juacker/loghound/internal/alerts"
 	"github.com/juacker/loghound/internal/broker"
+	"github.com/juacker/loghound/internal/clock"
+	"github.com/juacker/loghound/internal/health"
 	"github.com/juacker/loghound/internal/message"
+	"github.com/juacker/loghound/internal/tsdb"
 	"github.com/juacker/loghound/pkg/clf"
 )
 
+// Options sets the dashboard shown by the console
+type Options struct {
+	// Interval is the period of time shown in the dashboard
+	Interval time.Duration
+	// Refresh is the interval to render the dashboard
+	Refresh time.Duration
+	Layout  clf.Layout
+	// Clock tells the time of the stats and alerts shown, the dashboard is
+	// refreshed in the system time
+	Clock clock.Clock
+}
+
+// DefaultOptions returns the default dashboard options
+func DefaultOptions() Options {
+	return Options{
+		Interval: 10 * time.Minute,
+		Refresh:  time.Second,
+		Layout:   clf.DefaultLayout(),
+		Clock:    clock.New(),
+	}
+}
+
 type console struct {
 	broker    *broker.Connection
+	interval  int64
 	dashboard *clf.Dashboard
+	db        *tsdb.DB
+	state     *alerts.State
+	opts      Options
 }
 
 func (c *console) loop() {
@@ -26,11 +58,20 @@ func (c *console) loop() {
 	defer ui.Close()
 
 	width, height := ui.TerminalDimensions()
-	interval := int64(600)
-	c.dashboard = clf.NewDashboard(width, height, interval)
+	interval := int64(c.opts.Interval.Seconds())
+	c.dashboard = clf.NewDashboard(width, height, interval, c.opts.Layout)
+	c.dashboard.SetClock(c.opts.Clock.Now)
+
+	if c.db != nil {
+		c.loadHistory(interval)
+	}
+
+	for _, t := range c.state.Transitions() {
+		c.dashboard.History(t.Time, t.Text)
+	}
 
 	uiEvents := ui.PollEvents()
-	ticker := time.NewTicker(time.Second)
+	ticker := time.NewTicker(c.opts.Refresh)
 LOOP:
 	for {
 		select {
@@ -41,12 +82,26 @@ LOOP:
 				log.Println("console: failed processing message: ", err)
 			}
 		case <-ticker.C:
+			c.updateActiveAlerts()
 			c.dashboard.Render()
 		case e := <-uiEvents:
 			switch e.ID {
 			case "q", "<C-c>":
 				log.Println("console: 'q' or '<C-c>' key pressed, exiting")
 				break LOOP
+			case "l":
+				c.dashboard.ToggleHistory()
+				c.dashboard.Render()
+			case "h":
+				c.dashboard.ToggleHealth()
+				c.dashboard.Render()
+			case "b":
+				c.dashboard.ToggleRejected()
+				c.dashboard.Render()
+			case "a":
+				c.ackAlerts()
+			case "s":
+				c.silenceAlerts(time.Hour)
 			case "<Resize>":
 				payload := e.Payload.(ui.Resize)
 				c.dashboard.Resize(payload.Width, payload.Height)
@@ -77,6 +132,34 @@ func (c *console) processMessage(payload []byte) error {
 			return err
 		}
 		return c.processStatMessage(&statMsg)
+	case message.TypeBaseline:
+		var baselineMsg message.BaselineMessage
+		err := json.Unmarshal(payload, &baselineMsg)
+		if err != nil {
+			return err
+		}
+		return c.processBaselineMessage(&baselineMsg)
+	case message.TypeSLO:
+		var sloMsg message.SLOMessage
+		err := json.Unmarshal(payload, &sloMsg)
+		if err != nil {
+			return err
+		}
+		return c.processSLOMessage(&sloMsg)
+	case message.TypeHealth:
+		var healthMsg message.HealthMessage
+		err := json.Unmarshal(payload, &healthMsg)
+		if err != nil {
+			return err
+		}
+		return c.processHealthMessage(&healthMsg)
+	case message.TypeRejected:
+		var rejectedMsg message.RejectedMessage
+		err := json.Unmarshal(payload, &rejectedMsg)
+		if err != nil {
+			return err
+		}
+		return c.processRejectedMessage(&rejectedMsg)
 	default:
 		log.Println("console: invalid message received")
 		return nil
@@ -88,10 +171,62 @@ func (c *console) processAlertMessage(msg *message.AlertMessage) error {
 		return fmt.Errorf("invalid message")
 	}
 
-	c.dashboard.Message(time.Now(), msg.Text)
+	text := msg.Text
+	if msg.Silenced {
+		text = "[silenced] " + text
+	} else if msg.Acked {
+		text = "[acked] " + text
+	}
+
+	c.dashboard.Message(c.opts.Clock.Now(), text)
+	c.dashboard.History(c.opts.Clock.Now(), text)
 	return nil
 }
 
+// updateActiveAlerts shows the active alerts with their notification state
+func (c *console) updateActiveAlerts() {
+	active := c.state.Active()
+
+	rows := make([]string, 0, len(active))
+	for _, a := range active {
+		row := fmt.Sprintf("%s (%s) since %v", a.Rule, a.Metric, a.Time.Truncate(time.Second))
+		if a.Silenced {
+			row += " [silenced]"
+		}
+		if a.Acked {
+			row += " [acked]"
+		}
+		rows = append(rows, row)
+	}
+
+	c.dashboard.ActiveAlerts(rows)
+}
+
+// ackAlerts acknowledges all the active alerts
+func (c *console) ackAlerts() {
+	for _, a := range c.state.Active() {
+		err := c.state.Ack(a.Rule)
+		if err != nil {
+			log.Println("console: failed acknowledging alert ", a.Rule, err)
+		}
+	}
+}
+
+// silenceAlerts silences all the active alerts for duration
+func (c *console) silenceAlerts(duration time.Duration) {
+	now := c.opts.Clock.Now()
+
+	for _, a := range c.state.Active() {
+		silence, err := alerts.NewSilence(a.Metric, now, now.Add(duration), "silenced from console")
+		if err == nil {
+			err = c.state.AddSilence(silence)
+		}
+		if err != nil {
+			log.Println("console: failed silencing alert ", a.Metric, err)
+		}
+	}
+}
+
 func (c *console) processStatMessage(msg *message.StatMessage) error {
 	if !msg.IsValid() {
 		return fmt.Errorf("invalid message")
@@ -101,18 +236,104 @@ func (c *console) processStatMessage(msg *message.StatMessage) error {
 		c.dashboard.AddPoint(metric, msg.End, float64(value))
 	}
 
+	if msg.End > msg.Init {
+		c.interval = msg.End - msg.Init
+	}
+
 	return nil
 }
 
-// Run starts console
-func Run() {
-	conn, err := broker.NewConnection(broker.TopicStat, broker.TopicAlert)
+func (c *console) processBaselineMessage(msg *message.BaselineMessage) error {
+	if !msg.IsValid() {
+		return fmt.Errorf("invalid message")
+	}
+
+	// baselines are rates per second, plots show the values of every stats interval
+	if c.interval > 0 {
+		scale := float64(c.interval)
+		c.dashboard.AddBand(msg.Metric, msg.Timestamp, msg.Lower*scale, msg.Upper*scale)
+	}
+
+	return nil
+}
+
+func (c *console) processSLOMessage(msg *message.SLOMessage) error {
+	if !msg.IsValid() {
+		return fmt.Errorf("invalid message")
+	}
+
+	c.dashboard.SLO(msg.Name, msg.Objective, msg.Remaining, msg.Burn)
+	return nil
+}
+
+// processHealthMessage shows the health metrics, summaries as their mean
+func (c *console) processHealthMessage(msg *message.HealthMessage) error {
+	if !msg.IsValid() {
+		return fmt.Errorf("invalid message")
+	}
+
+	rows := make([][]string, 0, len(msg.Metrics))
+	for _, m := range msg.Metrics {
+		var value string
+		switch {
+		case m.Type == message.MetricSummary && m.Count > 0:
+			value = fmt.Sprintf("%.3fms avg of %d", m.Value/float64(m.Count)*1000, m.Count)
+		case strings.HasSuffix(m.Name, "_bytes"):
+			value = fmt.Sprintf("%.1fMB", m.Value/(1<<20))
+		default:
+			value = strconv.FormatFloat(m.Value, 'f', -1, 64)
+		}
+
+		rows = append(rows, []string{strings.TrimPrefix(m.Name, "loghound_"), health.LabelString(m.Labels), value})
+	}
+
+	c.dashboard.Health(rows)
+	return nil
+}
+
+func (c *console) processRejectedMessage(msg *message.RejectedMessage) error {
+	if !msg.IsValid() {
+		return fmt.Errorf("invalid message")
+	}
+
+	c.dashboard.Rejected(msg.Time, fmt.Sprintf("%s (%s): %s", msg.File, msg.Reason, msg.Line))
+	return nil
+}
+
+// loadHistory adds to the dashboard the total metrics stored during the last interval
+func (c *console) loadHistory(interval int64) {
+	now := c.opts.Clock.Now().Unix()
+
+	for _, metric := range []string{"requests.total", "bytes.total"} {
+		points, err := c.db.Query(metric, now-interval, now, 0, tsdb.AggSum)
+		if err != nil {
+			log.Println("console: failed loading history for metric ", metric, err)
+			continue
+		}
+
+		for _, p := range points {
+			c.dashboard.AddPoint(metric, p.Timestamp, p.Value)
+		}
+	}
+}
+
+// Run starts console, showing the dashboard set in opts. The alerts history is
+// loaded from state. If a storage is given, the dashboard history is loaded from it
+func Run(state *alerts.State, db *tsdb.DB, opts Options) {
+	conn, err := broker.NewConnection("console", broker.TopicStat, broker.TopicAlert, broker.TopicBaseline, broker.TopicSLO, broker.TopicHealth, broker.TopicRejected)
 	if err != nil {
 		log.Fatal("console: failed opening broker connection ", err)
 	}
 
+	if opts.Clock == nil {
+		opts.Clock = clock.New()
+	}
+
 	console := &console{
 		broker: conn,
+		db:     db,
+		state:  state,
+		opts:   opts,
 	}
 
 	console.loop()
@@ -2,26 +2,38 @@ package filemon
 
 import (
 	"bufio"
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"io"
 	"log"
 	"os"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/fsnotify/fsnotify"
 	"github.com/juacker/loghound/internal/broker"
+	"github.com/juacker/loghound/internal/health"
 	"github.com/juacker/loghound/internal/message"
+	"github.com/juacker/loghound/internal/rejects"
 	"github.com/juacker/loghound/pkg/clf"
 )
 
+// interval to report the status of the monitored files
+const statusInterval = 10 * time.Second
+
 type fileMonitor struct {
 	sync.Mutex
 	ctl     chan bool
 	wg      *sync.WaitGroup
 	broker  broker.Link
 	files   []string
+	formats map[string]string
 	fd      map[string]*os.File
+	parsers map[string]clf.Parser
 	watcher *fsnotify.Watcher
+	rejects *rejects.Sink
 }
 
 func (f *fileMonitor) loop() {
@@ -36,32 +48,26 @@ func (f *fileMonitor) loop() {
 	defer f.watcher.Close()
 
 	for _, filename := range f.files {
-		file, err := os.Open(filename)
-		if err != nil {
-			log.Fatal("filemon: failed openning file: ", filename)
-		}
-
-		defer file.Close()
-		f.fd[filename] = file
-
-		// let's position the file descriptor at the end of the file
-		// we want to process only new contents
-		position, err := file.Seek(0, 2)
-		if err != nil {
-			log.Fatal("filemon: failed positioning at the end of file: ", filename)
-		}
-		log.Println("positioned at the end of file: ", filename, position)
-
-		log.Println("filemon: adding file to monitoring list: ", filename)
-		err = watcher.Add(filename)
+		err := f.watch(filename)
 		if err != nil {
-			log.Fatal("filemon: could not add file ", filename, ", ", err)
+			log.Fatal(err)
 		}
 	}
 
+	ticker := time.NewTicker(statusInterval)
+	defer ticker.Stop()
+
 LOOP:
 	for {
 		select {
+		case payload := <-f.broker.Receive():
+			log.Println("filemon: new message received")
+			err := f.processMessage(payload)
+			if err != nil {
+				log.Println("filemon: failed processing message: ", err)
+			}
+		case <-ticker.C:
+			f.sendFilesStatus()
 		case event := <-watcher.Events:
 			log.Println("filemon: new event received: ", event, event.Name)
 			if event.Op&fsnotify.Write == fsnotify.Write {
@@ -76,11 +82,201 @@ LOOP:
 		}
 	}
 
+	for _, fd := range f.fd {
+		fd.Close()
+	}
+
 	f.wg.Done()
 }
 
+// watch starts monitoring the new contents of filename
+func (f *fileMonitor) watch(filename string) error {
+	parser, err := clf.NewParser(f.formats[filename])
+	if err != nil {
+		return fmt.Errorf("filemon: file %s: %v", filename, err)
+	}
+
+	file, err := os.Open(filename)
+	if err != nil {
+		return fmt.Errorf("filemon: failed openning file %s: %v", filename, err)
+	}
+
+	// let's position the file descriptor at the end of the file
+	// we want to process only new contents
+	position, err := file.Seek(0, 2)
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("filemon: failed positioning at the end of file %s", filename)
+	}
+	log.Println("positioned at the end of file: ", filename, position)
+
+	// the fields of the lines to come were set by the last directives
+	if f.formats[filename] == clf.FormatW3C {
+		directives, err := lastDirectives(file, position)
+		if err != nil {
+			file.Close()
+			return fmt.Errorf("filemon: failed reading directives of file %s: %v", filename, err)
+		}
+		for _, d := range directives {
+			parser.Parse(d)
+		}
+	}
+
+	log.Println("filemon: adding file to monitoring list: ", filename)
+	err = f.watcher.Add(filename)
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("filemon: could not add file %s, %v", filename, err)
+	}
+
+	f.fd[filename] = file
+	f.parsers[filename] = parser
+	return nil
+}
+
+// lastDirectives returns the last #Date and #Fields directives of a W3C file
+// before end, in file order. The file is read backwards from end until the
+// directives of the last header are found
+func lastDirectives(r io.ReaderAt, end int64) ([]string, error) {
+	const block = 64 << 10
+
+	found := make([]string, 0, 2)
+	var fields, date, done bool
+	// beginning of a line continuing in the block read before
+	var partial []byte
+
+	for end > 0 && !done {
+		start := end - block
+		if start < 0 {
+			start = 0
+		}
+
+		buf := make([]byte, end-start, end-start+int64(len(partial)))
+		_, err := r.ReadAt(buf, start)
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		buf = append(buf, partial...)
+
+		lines := bytes.Split(buf, []byte("\n"))
+		first := 0
+		if start > 0 {
+			// the first line may start in the previous block
+			first = 1
+			partial = lines[0]
+		}
+
+		for i := len(lines) - 1; i >= first && !done; i-- {
+			line := string(bytes.TrimRight(lines[i], "\r"))
+
+			switch {
+			case !fields && strings.HasPrefix(line, "#Fields:"):
+				fields = true
+				found = append(found, line)
+			case !date && strings.HasPrefix(line, "#Date:"):
+				date = true
+				found = append(found, line)
+			case fields && line != "" && !strings.HasPrefix(line, "#"):
+				// past the header of the last #Fields
+				done = true
+			}
+
+			done = done || fields && date
+		}
+
+		// a line too long is not a directive
+		done = done || len(partial) > block
+		end = start
+	}
+
+	// found backwards
+	for i, j := 0, len(found)-1; i < j; i, j = i+1, j-1 {
+		found[i], found[j] = found[j], found[i]
+	}
+	return found, nil
+}
+
+// unwatch stops monitoring filename
+func (f *fileMonitor) unwatch(filename string) {
+	log.Println("filemon: removing file from monitoring list: ", filename)
+
+	err := f.watcher.Remove(filename)
+	if err != nil {
+		log.Println("filemon: could not remove file ", filename, ", ", err)
+	}
+
+	if fd := f.fd[filename]; fd != nil {
+		fd.Close()
+		delete(f.fd, filename)
+	}
+	delete(f.parsers, filename)
+}
+
+func (f *fileMonitor) processMessage(payload []byte) error {
+	var msg message.ConfigMessage
+	err := json.Unmarshal(payload, &msg)
+	if err != nil {
+		return err
+	}
+
+	// check message is the expected
+	if !msg.IsValid() {
+		return fmt.Errorf("invalid message")
+	}
+
+	f.reload(msg.Files, msg.Formats)
+	return nil
+}
+
+// reload reconciles the monitored files with files: new files are read from their
+// end, removed ones are closed, and the rest keep their position, parsed from now
+// on with their format in formats. Files failing to open are still reported in
+// the files status
+func (f *fileMonitor) reload(files []string, formats map[string]string) {
+	changed := make(map[string]bool)
+	for _, filename := range files {
+		if formats[filename] != f.formats[filename] {
+			changed[filename] = true
+		}
+	}
+	f.formats = formats
+
+	wanted := make(map[string]bool, len(files))
+	for _, filename := range files {
+		wanted[filename] = true
+	}
+
+	for filename := range f.fd {
+		if !wanted[filename] {
+			f.unwatch(filename)
+		}
+	}
+
+	for _, filename := range files {
+		if f.fd[filename] != nil {
+			if changed[filename] {
+				parser, err := clf.NewParser(formats[filename])
+				if err != nil {
+					log.Println("filemon: file ", filename, ", ", err)
+					continue
+				}
+				log.Println("filemon: parsing file ", filename, " as ", formats[filename])
+				f.parsers[filename] = parser
+			}
+			continue
+		}
+
+		err := f.watch(filename)
+		if err != nil {
+			log.Println(err)
+		}
+	}
+
+	f.files = files
+}
+
 func (f *fileMonitor) processFileContents(filename string) error {
-	fd := f.fd[filename]
+	fd, parser := f.fd[filename], f.parsers[filename]
 	if fd == nil {
 		return fmt.Errorf("filemon: file descriptor not found for %s", filename)
 	}
@@ -117,13 +313,22 @@ func (f *fileMonitor) processFileContents(filename string) error {
 		}
 
 		if len(line) > 0 {
-			logEntry, err := clf.Parse(line)
+			health.Add(health.LinesRead, 1, "file", filename)
+
+			logEntry, err := parser.Parse(line)
+			if err == clf.ErrDirective {
+				continue
+			}
 			if err != nil {
 				log.Println("filemon: failed parsing line for file ", filename, err)
+				f.rejects.Reject(filename, line, err)
 				continue
 			}
 
-			err = f.broker.Send(broker.TopicData, message.NewCLFMessage(logEntry))
+			msg := message.NewCLFMessage(logEntry)
+			msg.File = filename
+
+			err = f.broker.Send(broker.TopicData, msg)
 			if err != nil {
 				log.Println("filemon: failed sending message to broker")
 			}
@@ -138,19 +343,74 @@ func (f *fileMonitor) processFileContents(filename string) error {
 	return nil
 }
 
-// Run starts file monitor
-func Run(wg *sync.WaitGroup, ctl chan bool, filename string) {
-	conn, err := broker.NewConnection()
+// sendFilesStatus reports if the monitored files exist and can be read, with their size
+func (f *fileMonitor) sendFilesStatus() {
+	for _, filename := range f.files {
+		msg := fileStatus(filename)
+		if msg.Status != message.FileOK {
+			log.Printf("filemon: file %s is %s: %s", filename, msg.Status, msg.Error)
+		}
+
+		err := f.broker.Send(broker.TopicFile, msg)
+		if err != nil {
+			log.Println("filemon: failed sending file status to broker")
+		}
+	}
+}
+
+// fileStatus checks the status of filename
+func fileStatus(filename string) *message.FileMessage {
+	info, err := os.Stat(filename)
+	if os.IsNotExist(err) {
+		msg := message.NewFileMessage(filename, message.FileMissing, 0, time.Time{})
+		msg.Error = err.Error()
+		return msg
+	}
+	if err != nil {
+		msg := message.NewFileMessage(filename, message.FileUnreadable, 0, time.Time{})
+		msg.Error = err.Error()
+		return msg
+	}
+
+	msg := message.NewFileMessage(filename, message.FileOK, info.Size(), info.ModTime())
+
+	fd, err := os.Open(filename)
+	if err != nil {
+		msg.Status = message.FileUnreadable
+		msg.Error = err.Error()
+		return msg
+	}
+	fd.Close()
+
+	return msg
+}
+
+// Run starts file monitor, parsing files with their log format in formats, clf
+// if missing. The monitored files are replaced by the ones of reloaded
+// configurations. Lines failing to parse are handled by sink. Without files,
+// as when entries are only received by syslog, it is not started
+func Run(wg *sync.WaitGroup, ctl chan bool, files []string, formats map[string]string, sink *rejects.Sink) {
+	if len(files) == 0 {
+		log.Println("filemon: no files to monitor, not started")
+		<-ctl
+		wg.Done()
+		return
+	}
+
+	conn, err := broker.NewConnection("filemon", broker.TopicConfig)
 	if err != nil {
 		log.Fatal("filemon: failed opening broker connection ", err)
 	}
 
 	filemon := &fileMonitor{
-		ctl:    ctl,
-		wg:     wg,
-		files:  []string{filename}, // to support multiple files in the future
-		fd:     make(map[string]*os.File, 0),
-		broker: conn,
+		ctl:     ctl,
+		wg:      wg,
+		files:   files,
+		formats: formats,
+		fd:      make(map[string]*os.File, 0),
+		parsers: make(map[string]clf.Parser),
+		broker:  conn,
+		rejects: sink,
 	}
 
 	filemon.loop()
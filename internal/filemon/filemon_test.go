@@ -0,0 +1,46 @@
+package filemon
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	tassert "github.com/stretchr/testify/assert"
+)
+
+func TestInternalFilemon(t *testing.T) {
+
+	assert := tassert.New(t)
+
+	t.Run("lastDirectives - last header of a W3C file", func(t *testing.T) {
+		header := "#Software: Microsoft Internet Information Services 10.0\r\n#Version: 1.0\r\n#Date: %s\r\n#Fields: %s\r\n"
+		line := "2021-01-01 00:00:00 GET /index.html - 200 10\r\n"
+
+		var b strings.Builder
+		fmt.Fprintf(&b, header, "2021-01-01 00:00:00", "date time cs-method cs-uri-stem cs-uri-query sc-status time-taken")
+		for i := 0; i < 5000; i++ {
+			b.WriteString(line)
+		}
+		fmt.Fprintf(&b, header, "2021-01-02 00:00:00", "date time cs-method cs-uri-stem sc-status")
+		for i := 0; i < 5000; i++ {
+			b.WriteString(line)
+		}
+		s := b.String()
+
+		directives, err := lastDirectives(strings.NewReader(s), int64(len(s)))
+		assert.Nil(err, "err nil")
+		assert.Equal([]string{
+			"#Date: 2021-01-02 00:00:00",
+			"#Fields: date time cs-method cs-uri-stem sc-status",
+		}, directives, "directives of the last header")
+
+		end := int64(strings.Index(s, "#Software: Microsoft Internet Information Services 10.0\r\n#Version: 1.0\r\n#Date: 2021-01-02"))
+		directives, err = lastDirectives(strings.NewReader(s), end)
+		assert.Nil(err, "err nil")
+		assert.Equal("#Fields: date time cs-method cs-uri-stem cs-uri-query sc-status time-taken", directives[1], "directives before end")
+
+		directives, err = lastDirectives(strings.NewReader(line), int64(len(line)))
+		assert.Nil(err, "err nil")
+		assert.Equal(0, len(directives), "no directives")
+	})
+}
@@ -0,0 +1,212 @@
+package health
+
+import (
+	"log"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/juacker/loghound/internal/broker"
+	"github.com/juacker/loghound/internal/message"
+	"github.com/juacker/loghound/pkg/clf"
+)
+
+// interval to publish the health metrics
+const interval = 5 * time.Second
+
+// Metrics reported by the modules and the health module
+const (
+	LinesRead       = "loghound_lines_read_total"
+	ParseFailures   = "loghound_parse_failures_total"
+	CacheMetrics    = "loghound_stats_cache_metrics"
+	AlertEvaluation = "loghound_alert_evaluation_seconds"
+	BrokerQueue     = "loghound_broker_queue_depth"
+	BrokerDropped   = "loghound_broker_dropped_total"
+	BrokerBlocked   = "loghound_broker_blocked_total"
+	MemoryHeap      = "loghound_memory_heap_bytes"
+	MemorySys       = "loghound_memory_sys_bytes"
+	Goroutines      = "loghound_goroutines"
+)
+
+// registry keeps the metrics reported by the modules, by name and labels
+var registry = struct {
+	sync.Mutex
+	metrics map[string]*message.HealthMetric
+}{
+	metrics: make(map[string]*message.HealthMetric),
+}
+
+// metric returns the metric of the registry with name and labels, labels
+// given as key and value pairs, created with type t if it does not exist.
+// The registry must be locked
+func metric(t message.MetricType, name string, labels []string) *message.HealthMetric {
+	key := name + "{" + strings.Join(labels, ",") + "}"
+
+	m, ok := registry.metrics[key]
+	if !ok {
+		m = &message.HealthMetric{Name: name, Type: t}
+		if len(labels) > 1 {
+			m.Labels = make(map[string]string, len(labels)/2)
+			for i := 0; i+1 < len(labels); i += 2 {
+				m.Labels[labels[i]] = labels[i+1]
+			}
+		}
+		registry.metrics[key] = m
+	}
+
+	return m
+}
+
+// Add increments the counter name with labels, given as key and value pairs
+func Add(name string, delta float64, labels ...string) {
+	registry.Lock()
+	defer registry.Unlock()
+
+	metric(message.MetricCounter, name, labels).Value += delta
+}
+
+// Set sets the value of the gauge name with labels, given as key and value pairs
+func Set(name string, value float64, labels ...string) {
+	registry.Lock()
+	defer registry.Unlock()
+
+	metric(message.MetricGauge, name, labels).Value = value
+}
+
+// Observe adds a duration to the summary name with labels, given as key and value pairs
+func Observe(name string, d time.Duration, labels ...string) {
+	registry.Lock()
+	defer registry.Unlock()
+
+	m := metric(message.MetricSummary, name, labels)
+	m.Value += d.Seconds()
+	m.Count++
+}
+
+// Snapshot returns the metrics reported by the modules, plus the broker queues
+// and the process memory and goroutines, sorted by name
+func Snapshot() []message.HealthMetric {
+	registry.Lock()
+	metrics := make([]message.HealthMetric, 0, len(registry.metrics))
+	for _, m := range registry.metrics {
+		metrics = append(metrics, *m)
+	}
+	registry.Unlock()
+
+	queue, subscriptions := broker.Stats()
+	metrics = append(metrics, message.HealthMetric{
+		Name:   BrokerQueue,
+		Type:   message.MetricGauge,
+		Labels: map[string]string{"subscriber": "broker"},
+		Value:  float64(queue),
+	})
+
+	// subscribers share the queue among their topics
+	depths := make(map[string]int)
+	for _, s := range subscriptions {
+		depths[s.Subscriber] = s.Depth
+
+		labels := map[string]string{"subscriber": s.Subscriber, "topic": broker.TopicName(s.Topic)}
+		metrics = append(metrics,
+			message.HealthMetric{Name: BrokerDropped, Type: message.MetricCounter, Labels: labels, Value: float64(s.Dropped)},
+			message.HealthMetric{Name: BrokerBlocked, Type: message.MetricCounter, Labels: labels, Value: float64(s.Blocked)},
+		)
+	}
+	for subscriber, depth := range depths {
+		metrics = append(metrics, message.HealthMetric{
+			Name:   BrokerQueue,
+			Type:   message.MetricGauge,
+			Labels: map[string]string{"subscriber": subscriber},
+			Value:  float64(depth),
+		})
+	}
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	metrics = append(metrics,
+		message.HealthMetric{Name: MemoryHeap, Type: message.MetricGauge, Value: float64(mem.HeapAlloc)},
+		message.HealthMetric{Name: MemorySys, Type: message.MetricGauge, Value: float64(mem.Sys)},
+		message.HealthMetric{Name: Goroutines, Type: message.MetricGauge, Value: float64(runtime.NumGoroutine())},
+	)
+
+	sort.SliceStable(metrics, func(i, j int) bool {
+		if metrics[i].Name != metrics[j].Name {
+			return metrics[i].Name < metrics[j].Name
+		}
+		return LabelString(metrics[i].Labels) < LabelString(metrics[j].Labels)
+	})
+
+	return metrics
+}
+
+// labelEscaper escapes label values as in the prometheus exposition format
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// LabelString returns the labels as key="value" pairs sorted by key and
+// separated by commas, as in the prometheus exposition format
+func LabelString(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		pairs = append(pairs, k+`="`+labelEscaper.Replace(labels[k])+`"`)
+	}
+
+	return strings.Join(pairs, ",")
+}
+
+// ParseFailure counts a line of file failing to parse with err
+func ParseFailure(file string, err error) {
+	Add(ParseFailures, 1, "file", file, "reason", clf.Reason(err))
+}
+
+type healthMonitor struct {
+	ctl    chan bool
+	wg     *sync.WaitGroup
+	broker broker.Link
+}
+
+func (h *healthMonitor) loop() {
+	log.Println("health: initializing health metrics")
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+LOOP:
+	for {
+		select {
+		case <-ticker.C:
+			err := h.broker.Send(broker.TopicHealth, message.NewHealthMessage(time.Now().Unix(), Snapshot()))
+			if err != nil {
+				log.Println("health: failed sending health metrics: ", err)
+			}
+		case <-h.ctl:
+			log.Println("health: ctl signal received, exiting")
+			break LOOP
+		}
+	}
+
+	h.wg.Done()
+}
+
+// Run starts publishing the health metrics of loghound every few seconds
+func Run(wg *sync.WaitGroup, ctl chan bool) {
+	conn, err := broker.NewConnection("health")
+	if err != nil {
+		log.Fatal("health: failed opening broker connection ", err)
+	}
+
+	h := &healthMonitor{
+		ctl:    ctl,
+		wg:     wg,
+		broker: conn,
+	}
+
+	h.loop()
+}
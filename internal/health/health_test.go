@@ -0,0 +1,98 @@
+package health
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/juacker/loghound/internal/broker"
+	"github.com/juacker/loghound/internal/message"
+	"github.com/juacker/loghound/pkg/clf"
+	tassert "github.com/stretchr/testify/assert"
+)
+
+// find returns the metric of the snapshot with name and labels
+func find(metrics []message.HealthMetric, name string, labels string) *message.HealthMetric {
+	for i := range metrics {
+		if metrics[i].Name == name && LabelString(metrics[i].Labels) == labels {
+			return &metrics[i]
+		}
+	}
+	return nil
+}
+
+func TestInternalHealth(t *testing.T) {
+
+	assert := tassert.New(t)
+
+	t.Run("registry - counters, gauges and summaries", func(t *testing.T) {
+		registry.metrics = make(map[string]*message.HealthMetric)
+
+		Add(LinesRead, 1, "file", "a.log")
+		Add(LinesRead, 2, "file", "a.log")
+		Add(LinesRead, 1, "file", "b.log")
+		Set(CacheMetrics, 10)
+		Set(CacheMetrics, 7)
+		Observe(AlertEvaluation, 10*time.Millisecond, "rule", "high-traffic")
+		Observe(AlertEvaluation, 30*time.Millisecond, "rule", "high-traffic")
+		ParseFailure("a.log", &clf.ParseError{Reason: "date", Value: "yesterday"})
+		ParseFailure("a.log", errors.New("other"))
+
+		metrics := Snapshot()
+
+		assert.Equal(3.0, find(metrics, LinesRead, `file="a.log"`).Value, "counter incremented")
+		assert.Equal(message.MetricCounter, find(metrics, LinesRead, `file="b.log"`).Type, "counter type")
+		assert.Equal(7.0, find(metrics, CacheMetrics, "").Value, "gauge set")
+
+		evaluation := find(metrics, AlertEvaluation, `rule="high-traffic"`)
+		assert.InDelta(0.04, evaluation.Value, 1e-9, "sum of observations")
+		assert.Equal(uint64(2), evaluation.Count, "observations")
+
+		assert.Equal(1.0, find(metrics, ParseFailures, `file="a.log",reason="date"`).Value, "parse error reason")
+		assert.Equal(1.0, find(metrics, ParseFailures, `file="a.log",reason="unknown"`).Value, "unknown reason")
+
+		assert.NotNil(find(metrics, Goroutines, ""), "process goroutines")
+		assert.True(find(metrics, MemoryHeap, "").Value > 0, "process memory")
+
+		for i := 1; i < len(metrics); i++ {
+			assert.True(metrics[i-1].Name <= metrics[i].Name, "sorted by name")
+		}
+	})
+
+	t.Run("Snapshot - broker queues and drops", func(t *testing.T) {
+		var wg sync.WaitGroup
+		ctl := make(chan bool)
+
+		wg.Add(1)
+		go broker.Run(&wg, ctl)
+
+		// the broker is shared by the test runs
+		name := fmt.Sprintf("slow-%d", time.Now().UnixNano())
+		conn, err := broker.NewConnection(name, broker.TopicHealth)
+		assert.Nil(err, "err nil")
+
+		// the subscriber never reads, health messages over its queue are dropped
+		for i := 0; i < 105; i++ {
+			assert.Nil(conn.Send(broker.TopicHealth, message.NewHealthMessage(int64(i), nil)), "err nil")
+		}
+
+		var dropped *message.HealthMetric
+		for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+			dropped = find(Snapshot(), BrokerDropped, `subscriber="`+name+`",topic="health"`)
+			if dropped != nil && dropped.Value == 5 {
+				break
+			}
+		}
+
+		metrics := Snapshot()
+		assert.Equal(5.0, dropped.Value, "messages dropped")
+		assert.Equal(100.0, find(metrics, BrokerQueue, `subscriber="`+name+`"`).Value, "queue full")
+		assert.Equal(0.0, find(metrics, BrokerBlocked, `subscriber="`+name+`",topic="health"`).Value, "broker never blocked")
+		assert.NotNil(find(metrics, BrokerQueue, `subscriber="broker"`), "broker queue")
+
+		ctl <- true
+		wg.Wait()
+	})
+}
@@ -0,0 +1,395 @@
+package journal
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// record header: offset (8) + timestamp (8) + payload length (4) + crc (4)
+const headerSize = 24
+
+const segmentSuffix = ".log"
+
+// ErrCorrupted is returned when a record fails its checksum
+var ErrCorrupted = errors.New("journal: corrupted record")
+
+// Options sets segment size and retention limits of a journal
+type Options struct {
+	SegmentSize int64         // bytes written to a segment before rolling to a new one
+	MaxSize     int64         // total bytes kept on disk, 0 means no limit
+	MaxAge      time.Duration // age of the records kept on disk, 0 means no limit
+}
+
+// DefaultOptions returns the default journal options
+func DefaultOptions() Options {
+	return Options{
+		SegmentSize: 16 << 20,
+		MaxSize:     512 << 20,
+		MaxAge:      24 * time.Hour,
+	}
+}
+
+// Record represents a journal record
+type Record struct {
+	Offset    uint64
+	Timestamp time.Time
+	Payload   []byte
+}
+
+type segment struct {
+	base    uint64
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+// Journal is an append-only log split in segments on disk
+type Journal struct {
+	sync.Mutex
+	dir      string
+	opts     Options
+	segments []*segment
+	active   *os.File
+	next     uint64
+}
+
+// Open opens the journal stored at dir, creating it if needed
+func Open(dir string, opts Options) (*Journal, error) {
+	if opts.SegmentSize <= 0 {
+		opts.SegmentSize = DefaultOptions().SegmentSize
+	}
+
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("journal: failed creating directory %s: %v", dir, err)
+	}
+
+	j := &Journal{
+		dir:      dir,
+		opts:     opts,
+		segments: make([]*segment, 0),
+	}
+
+	err = j.load()
+	if err != nil {
+		return nil, err
+	}
+
+	return j, nil
+}
+
+// load reads the segments list and recovers the next offset from the last segment
+func (j *Journal) load() error {
+	files, err := ioutil.ReadDir(j.dir)
+	if err != nil {
+		return fmt.Errorf("journal: failed reading directory %s: %v", j.dir, err)
+	}
+
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), segmentSuffix) {
+			continue
+		}
+
+		base, err := strconv.ParseUint(strings.TrimSuffix(f.Name(), segmentSuffix), 10, 64)
+		if err != nil {
+			log.Println("journal: ignoring unknown file ", f.Name())
+			continue
+		}
+
+		j.segments = append(j.segments, &segment{
+			base:    base,
+			path:    filepath.Join(j.dir, f.Name()),
+			size:    f.Size(),
+			modTime: f.ModTime(),
+		})
+	}
+
+	sort.Slice(j.segments, func(a, b int) bool {
+		return j.segments[a].base < j.segments[b].base
+	})
+
+	if len(j.segments) == 0 {
+		return j.roll(0)
+	}
+
+	// scan the last segment, a crash could have left a partial record at its end
+	last := j.segments[len(j.segments)-1]
+	next, valid, err := scanSegment(last.path, last.base)
+	if err != nil {
+		return err
+	}
+
+	if valid < last.size {
+		log.Printf("journal: truncating segment %s at %d, partial record found", last.path, valid)
+		err = os.Truncate(last.path, valid)
+		if err != nil {
+			return fmt.Errorf("journal: failed truncating segment %s: %v", last.path, err)
+		}
+		last.size = valid
+	}
+
+	j.next = next
+	j.active, err = os.OpenFile(last.path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("journal: failed opening segment %s: %v", last.path, err)
+	}
+
+	return nil
+}
+
+// roll closes the active segment and starts a new one at base offset
+func (j *Journal) roll(base uint64) error {
+	if j.active != nil {
+		j.active.Close()
+	}
+
+	path := filepath.Join(j.dir, fmt.Sprintf("%020d%s", base, segmentSuffix))
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("journal: failed creating segment %s: %v", path, err)
+	}
+
+	j.active = f
+	j.next = base
+	j.segments = append(j.segments, &segment{
+		base:    base,
+		path:    path,
+		modTime: time.Now(),
+	})
+
+	j.enforceRetention()
+	return nil
+}
+
+// enforceRetention removes the oldest segments exceeding size or age limits.
+// the active segment is never removed
+func (j *Journal) enforceRetention() {
+	var total int64
+	for _, s := range j.segments {
+		total += s.size
+	}
+
+	limit := time.Now().Add(-j.opts.MaxAge)
+
+	for len(j.segments) > 1 {
+		oldest := j.segments[0]
+
+		expired := j.opts.MaxAge > 0 && oldest.modTime.Before(limit)
+		oversized := j.opts.MaxSize > 0 && total > j.opts.MaxSize
+		if !expired && !oversized {
+			break
+		}
+
+		log.Println("journal: removing segment ", oldest.path)
+		err := os.Remove(oldest.path)
+		if err != nil && !os.IsNotExist(err) {
+			log.Println("journal: failed removing segment ", oldest.path, err)
+			break
+		}
+
+		total -= oldest.size
+		j.segments = j.segments[1:]
+	}
+}
+
+// Expire removes the segments exceeding the retention limits. Retention is
+// enforced when segments roll, Expire enforces it for journals rarely written
+func (j *Journal) Expire() {
+	j.Lock()
+	defer j.Unlock()
+
+	j.enforceRetention()
+}
+
+// Append adds a new record to the journal and returns its offset
+func (j *Journal) Append(payload []byte) (uint64, error) {
+	j.Lock()
+	defer j.Unlock()
+
+	active := j.segments[len(j.segments)-1]
+	if active.size >= j.opts.SegmentSize {
+		err := j.roll(j.next)
+		if err != nil {
+			return 0, err
+		}
+		active = j.segments[len(j.segments)-1]
+	}
+
+	now := time.Now()
+	offset := j.next
+
+	buf := make([]byte, headerSize+len(payload))
+	binary.LittleEndian.PutUint64(buf[0:8], offset)
+	binary.LittleEndian.PutUint64(buf[8:16], uint64(now.UnixNano()))
+	binary.LittleEndian.PutUint32(buf[16:20], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(buf[20:24], crc32.ChecksumIEEE(payload))
+	copy(buf[headerSize:], payload)
+
+	_, err := j.active.Write(buf)
+	if err != nil {
+		return 0, fmt.Errorf("journal: failed writing record: %v", err)
+	}
+
+	active.size += int64(len(buf))
+	active.modTime = now
+	j.next++
+
+	return offset, nil
+}
+
+// FirstOffset returns the offset of the oldest record kept in the journal
+func (j *Journal) FirstOffset() uint64 {
+	j.Lock()
+	defer j.Unlock()
+
+	return j.segments[0].base
+}
+
+// NextOffset returns the offset the next appended record will get
+func (j *Journal) NextOffset() uint64 {
+	j.Lock()
+	defer j.Unlock()
+
+	return j.next
+}
+
+// OffsetAt returns the offset of the first record written at or after t
+func (j *Journal) OffsetAt(t time.Time) (uint64, error) {
+	offset := j.NextOffset()
+
+	errFound := errors.New("found")
+	err := j.ReadFrom(j.FirstOffset(), func(r Record) error {
+		if !r.Timestamp.Before(t) {
+			offset = r.Offset
+			return errFound
+		}
+		return nil
+	})
+
+	if err != nil && err != errFound {
+		return 0, err
+	}
+
+	return offset, nil
+}
+
+// ReadFrom calls fn for every record starting at offset, in order.
+// reading stops at the first error returned by fn
+func (j *Journal) ReadFrom(offset uint64, fn func(Record) error) error {
+	j.Lock()
+	segments := make([]segment, 0, len(j.segments))
+	for i, s := range j.segments {
+		// skip segments fully before the requested offset
+		if i+1 < len(j.segments) && j.segments[i+1].base <= offset {
+			continue
+		}
+		segments = append(segments, *s)
+	}
+	next := j.next
+	j.Unlock()
+
+	for _, s := range segments {
+		err := readSegment(s.path, func(r Record) error {
+			if r.Offset < offset || r.Offset >= next {
+				return nil
+			}
+			return fn(r)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Close closes the journal
+func (j *Journal) Close() error {
+	j.Lock()
+	defer j.Unlock()
+
+	if j.active == nil {
+		return nil
+	}
+
+	err := j.active.Close()
+	j.active = nil
+	return err
+}
+
+// readSegment calls fn for every valid record stored in the segment at path
+func readSegment(path string, fn func(Record) error) error {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		// removed by retention while reading
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("journal: failed opening segment %s: %v", path, err)
+	}
+	defer f.Close()
+
+	reader := bufio.NewReader(f)
+	header := make([]byte, headerSize)
+
+	for {
+		_, err = io.ReadFull(reader, header)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		payload := make([]byte, binary.LittleEndian.Uint32(header[16:20]))
+		_, err = io.ReadFull(reader, payload)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if crc32.ChecksumIEEE(payload) != binary.LittleEndian.Uint32(header[20:24]) {
+			return ErrCorrupted
+		}
+
+		err = fn(Record{
+			Offset:    binary.LittleEndian.Uint64(header[0:8]),
+			Timestamp: time.Unix(0, int64(binary.LittleEndian.Uint64(header[8:16]))),
+			Payload:   payload,
+		})
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// scanSegment returns the next offset and the size of the valid records in the segment
+func scanSegment(path string, base uint64) (uint64, int64, error) {
+	next := base
+	var valid int64
+
+	err := readSegment(path, func(r Record) error {
+		next = r.Offset + 1
+		valid += int64(headerSize + len(r.Payload))
+		return nil
+	})
+
+	if err == ErrCorrupted {
+		// keep the records before the corrupted one
+		return next, valid, nil
+	}
+
+	return next, valid, err
+}
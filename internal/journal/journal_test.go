@@ -0,0 +1,141 @@
+package journal
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	tassert "github.com/stretchr/testify/assert"
+)
+
+func TestJournal(t *testing.T) {
+
+	assert := tassert.New(t)
+
+	dir, err := ioutil.TempDir("", "journal")
+	assert.Nil(err, "err nil")
+	defer os.RemoveAll(dir)
+
+	opts := Options{SegmentSize: 100}
+
+	t.Run("Append - rolls segments", func(t *testing.T) {
+		j, err := Open(dir, opts)
+		assert.Nil(err, "err nil")
+
+		for i := 0; i < 10; i++ {
+			offset, err := j.Append([]byte(fmt.Sprintf("message %d", i)))
+			assert.Nil(err, "err nil")
+			assert.Equal(uint64(i), offset, "expected offset")
+		}
+
+		assert.True(len(j.segments) > 1, "segments rolled")
+		assert.Nil(j.Close(), "err nil")
+	})
+
+	t.Run("ReadFrom - after reopening", func(t *testing.T) {
+		j, err := Open(dir, opts)
+		assert.Nil(err, "err nil")
+		defer j.Close()
+
+		assert.Equal(uint64(10), j.NextOffset(), "next offset recovered")
+
+		read := make([]string, 0)
+		err = j.ReadFrom(7, func(r Record) error {
+			read = append(read, string(r.Payload))
+			return nil
+		})
+		assert.Nil(err, "err nil")
+		assert.Equal([]string{"message 7", "message 8", "message 9"}, read, "expected records")
+	})
+
+	t.Run("Open - truncates partial record", func(t *testing.T) {
+		j, err := Open(dir, opts)
+		assert.Nil(err, "err nil")
+		last := j.segments[len(j.segments)-1].path
+		assert.Nil(j.Close(), "err nil")
+
+		f, err := os.OpenFile(last, os.O_WRONLY|os.O_APPEND, 0644)
+		assert.Nil(err, "err nil")
+		_, err = f.Write([]byte{1, 2, 3})
+		assert.Nil(err, "err nil")
+		f.Close()
+
+		j, err = Open(dir, opts)
+		assert.Nil(err, "err nil")
+		defer j.Close()
+
+		offset, err := j.Append([]byte("message 10"))
+		assert.Nil(err, "err nil")
+		assert.Equal(uint64(10), offset, "expected offset")
+
+		count := 0
+		err = j.ReadFrom(0, func(r Record) error {
+			count++
+			return nil
+		})
+		assert.Nil(err, "err nil")
+		assert.Equal(11, count, "all records readable")
+	})
+
+	t.Run("OffsetAt - first record after time", func(t *testing.T) {
+		j, err := Open(dir, opts)
+		assert.Nil(err, "err nil")
+		defer j.Close()
+
+		offset, err := j.OffsetAt(time.Now().Add(-time.Hour))
+		assert.Nil(err, "err nil")
+		assert.Equal(uint64(0), offset, "oldest offset")
+
+		offset, err = j.OffsetAt(time.Now().Add(time.Hour))
+		assert.Nil(err, "err nil")
+		assert.Equal(j.NextOffset(), offset, "no record after time")
+	})
+
+	t.Run("Retention - removes oldest segments", func(t *testing.T) {
+		j, err := Open(dir, Options{SegmentSize: 100, MaxSize: 200})
+		assert.Nil(err, "err nil")
+		defer j.Close()
+
+		for i := 0; i < 20; i++ {
+			_, err = j.Append([]byte("retention"))
+			assert.Nil(err, "err nil")
+		}
+
+		files, err := filepath.Glob(filepath.Join(dir, "*"+segmentSuffix))
+		assert.Nil(err, "err nil")
+		assert.Equal(len(j.segments), len(files), "segments on disk")
+		assert.True(j.FirstOffset() > 0, "oldest records removed")
+	})
+
+	t.Run("Expire - removes aged segments without writes", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "journal")
+		assert.Nil(err, "err nil")
+		defer os.RemoveAll(dir)
+
+		j, err := Open(dir, Options{SegmentSize: 100, MaxAge: time.Hour})
+		assert.Nil(err, "err nil")
+		defer j.Close()
+
+		for i := 0; i < 10; i++ {
+			_, err = j.Append([]byte("expire"))
+			assert.Nil(err, "err nil")
+		}
+		assert.True(len(j.segments) > 1, "segments rolled")
+
+		j.Expire()
+		assert.True(len(j.segments) > 1, "recent segments kept")
+
+		for _, s := range j.segments {
+			s.modTime = time.Now().Add(-2 * time.Hour)
+		}
+		j.Expire()
+
+		files, err := filepath.Glob(filepath.Join(dir, "*"+segmentSuffix))
+		assert.Nil(err, "err nil")
+		assert.Equal(1, len(j.segments), "active segment kept")
+		assert.Equal(1, len(files), "segments on disk")
+	})
+}
@@ -0,0 +1,66 @@
+package journal
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/juacker/loghound/internal/broker"
+)
+
+// interval between retention checks, segments also expire when they roll
+const expireInterval = time.Minute
+
+type journalWriter struct {
+	ctl     chan bool
+	wg      *sync.WaitGroup
+	broker  broker.Link
+	journal *Journal
+}
+
+func (w *journalWriter) loop() {
+	log.Println("journal: initializing journal writer")
+
+	ticker := time.NewTicker(expireInterval)
+	defer ticker.Stop()
+
+LOOP:
+	for {
+		select {
+		case payload := <-w.broker.Receive():
+			_, err := w.journal.Append(payload)
+			if err != nil {
+				log.Println("journal: failed appending message: ", err)
+			}
+		case <-ticker.C:
+			w.journal.Expire()
+		case <-w.ctl:
+			log.Println("journal: ctl signal received, exiting")
+			break LOOP
+		}
+	}
+
+	err := w.journal.Close()
+	if err != nil {
+		log.Println("journal: failed closing journal: ", err)
+	}
+
+	w.wg.Done()
+}
+
+// Run starts the journal writer, every data message is appended to the journal
+func Run(wg *sync.WaitGroup, ctl chan bool, j *Journal) {
+	conn, err := broker.NewConnection("journal", broker.TopicData)
+	if err != nil {
+		log.Fatal("journal: failed opening broker connection ", err)
+	}
+
+	writer := &journalWriter{
+		ctl:     ctl,
+		wg:      wg,
+		broker:  conn,
+		journal: j,
+	}
+
+	writer.loop()
+}
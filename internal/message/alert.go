@@ -3,9 +3,14 @@ package message
 // AlertMessage struct
 type AlertMessage struct {
 	Message
-	Metric   string   `json:"metric"`
-	Severity Severity `json:"severity"`
-	Text     string   `json:"text"`
+	Rule     string            `json:"rule"`
+	Labels   map[string]string `json:"labels,omitempty"`
+	Metric   string            `json:"metric"`
+	Value    float64           `json:"value"`
+	Severity Severity          `json:"severity"`
+	Text     string            `json:"text"`
+	Silenced bool              `json:"silenced"`
+	Acked    bool              `json:"acked"`
 }
 
 // IsValid check if message has the right type
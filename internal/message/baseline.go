@@ -0,0 +1,30 @@
+package message
+
+// BaselineMessage struct
+type BaselineMessage struct {
+	Message
+	Rule      string  `json:"rule"`
+	Metric    string  `json:"metric"`
+	Timestamp int64   `json:"timestamp"`
+	Expected  float64 `json:"expected"`
+	Lower     float64 `json:"lower"`
+	Upper     float64 `json:"upper"`
+}
+
+// IsValid check if message has the right type
+func (m *BaselineMessage) IsValid() bool {
+	return m.Message.Type == TypeBaseline
+}
+
+// NewBaselineMessage returns a new BaselineMessage
+func NewBaselineMessage(rule, metric string, timestamp int64, expected, lower, upper float64) *BaselineMessage {
+	return &BaselineMessage{
+		Message:   Message{TypeBaseline},
+		Rule:      rule,
+		Metric:    metric,
+		Timestamp: timestamp,
+		Expected:  expected,
+		Lower:     lower,
+		Upper:     upper,
+	}
+}
@@ -8,6 +8,12 @@ import (
 type CLFMessage struct {
 	Message
 	clf.Entry
+	// File is the log file the entry was read from, or its syslog source
+	File string `json:"file,omitempty"`
+	// Host and App are the hostname and app name of the syslog message
+	// the entry was received in
+	Host string `json:"host,omitempty"`
+	App  string `json:"app,omitempty"`
 }
 
 // IsValid check if message has the right type
@@ -18,7 +24,7 @@ func (m *CLFMessage) IsValid() bool {
 // NewCLFMessage returns a new CLFMessage
 func NewCLFMessage(m *clf.Entry) *CLFMessage {
 	return &CLFMessage{
-		Message{TypeCLF},
-		*m,
+		Message: Message{TypeCLF},
+		Entry:   *m,
 	}
 }
@@ -0,0 +1,30 @@
+package message
+
+import "encoding/json"
+
+// ConfigMessage carries a reloaded configuration to the running modules. Rules
+// and SLOs are json encoded, as their types belong to the modules using them
+type ConfigMessage struct {
+	Message
+	Files []string `json:"files"`
+	// Formats are the log formats of the files, clf if missing
+	Formats map[string]string `json:"formats,omitempty"`
+	Rules   json.RawMessage   `json:"rules"`
+	SLOs    json.RawMessage   `json:"slos"`
+}
+
+// IsValid check if message has the right type
+func (m *ConfigMessage) IsValid() bool {
+	return m.Message.Type == TypeConfig
+}
+
+// NewConfigMessage returns a new ConfigMessage
+func NewConfigMessage(files []string, formats map[string]string, rules, slos json.RawMessage) *ConfigMessage {
+	return &ConfigMessage{
+		Message: Message{TypeConfig},
+		Files:   files,
+		Formats: formats,
+		Rules:   rules,
+		SLOs:    slos,
+	}
+}
@@ -0,0 +1,39 @@
+package message
+
+import "time"
+
+// FileStatus is the state of a monitored file
+type FileStatus string
+
+// File status values
+const (
+	FileOK         FileStatus = "ok"
+	FileMissing    FileStatus = "missing"
+	FileUnreadable FileStatus = "unreadable"
+)
+
+// FileMessage struct
+type FileMessage struct {
+	Message
+	File     string     `json:"file"`
+	Status   FileStatus `json:"status"`
+	Size     int64      `json:"size"`
+	Modified time.Time  `json:"modified"`
+	Error    string     `json:"error,omitempty"`
+}
+
+// IsValid check if message has the right type
+func (m *FileMessage) IsValid() bool {
+	return m.Message.Type == TypeFile
+}
+
+// NewFileMessage returns a new FileMessage
+func NewFileMessage(file string, status FileStatus, size int64, modified time.Time) *FileMessage {
+	return &FileMessage{
+		Message:  Message{TypeFile},
+		File:     file,
+		Status:   status,
+		Size:     size,
+		Modified: modified,
+	}
+}
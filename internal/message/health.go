@@ -0,0 +1,42 @@
+package message
+
+// MetricType is the kind of a health metric
+type MetricType string
+
+// Health metric types, as in the prometheus exposition format
+const (
+	MetricCounter MetricType = "counter"
+	MetricGauge   MetricType = "gauge"
+	MetricSummary MetricType = "summary"
+)
+
+// HealthMetric is a metric of loghound itself. Summaries have the sum of the
+// observations as value, and their count
+type HealthMetric struct {
+	Name   string            `json:"name"`
+	Type   MetricType        `json:"type"`
+	Labels map[string]string `json:"labels,omitempty"`
+	Value  float64           `json:"value"`
+	Count  uint64            `json:"count,omitempty"`
+}
+
+// HealthMessage struct
+type HealthMessage struct {
+	Message
+	Timestamp int64          `json:"timestamp"`
+	Metrics   []HealthMetric `json:"metrics"`
+}
+
+// IsValid check if message has the right type
+func (m *HealthMessage) IsValid() bool {
+	return m.Message.Type == TypeHealth
+}
+
+// NewHealthMessage returns a new HealthMessage
+func NewHealthMessage(timestamp int64, metrics []HealthMetric) *HealthMessage {
+	return &HealthMessage{
+		Message:   Message{TypeHealth},
+		Timestamp: timestamp,
+		Metrics:   metrics,
+	}
+}
@@ -8,6 +8,12 @@ const (
 	TypeCLF Type = iota
 	TypeStat
 	TypeAlert
+	TypeBaseline
+	TypeFile
+	TypeSLO
+	TypeConfig
+	TypeHealth
+	TypeRejected
 )
 
 // Message to map the messages sent by the modules
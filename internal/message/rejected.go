@@ -0,0 +1,30 @@
+package message
+
+import "time"
+
+// RejectedMessage is a line of a monitored file that failed to parse
+type RejectedMessage struct {
+	Message
+	File   string    `json:"file"`
+	Line   string    `json:"line"`
+	Reason string    `json:"reason"`
+	Error  string    `json:"error"`
+	Time   time.Time `json:"time"`
+}
+
+// IsValid check if message has the right type
+func (m *RejectedMessage) IsValid() bool {
+	return m.Message.Type == TypeRejected
+}
+
+// NewRejectedMessage returns a new RejectedMessage
+func NewRejectedMessage(file, line, reason, err string, t time.Time) *RejectedMessage {
+	return &RejectedMessage{
+		Message: Message{TypeRejected},
+		File:    file,
+		Line:    line,
+		Reason:  reason,
+		Error:   err,
+		Time:    t,
+	}
+}
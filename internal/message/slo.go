@@ -0,0 +1,32 @@
+package message
+
+// SLOMessage struct
+type SLOMessage struct {
+	Message
+	Name      string  `json:"name"`
+	Objective float64 `json:"objective"`
+	Good      int     `json:"good"`
+	Total     int     `json:"total"`
+	// Remaining is the fraction of the error budget of the period not spent yet
+	Remaining float64 `json:"remaining"`
+	// Burn is the burn rate over the shortest long window
+	Burn float64 `json:"burn"`
+}
+
+// IsValid check if message has the right type
+func (m *SLOMessage) IsValid() bool {
+	return m.Message.Type == TypeSLO
+}
+
+// NewSLOMessage returns a new SLOMessage
+func NewSLOMessage(name string, objective float64, good, total int, remaining, burn float64) *SLOMessage {
+	return &SLOMessage{
+		Message:   Message{TypeSLO},
+		Name:      name,
+		Objective: objective,
+		Good:      good,
+		Total:     total,
+		Remaining: remaining,
+		Burn:      burn,
+	}
+}
@@ -6,6 +6,8 @@ type StatMessage struct {
 	Stats map[string]int `json:"stats"`
 	Init  int64          `json:"init"`
 	End   int64          `json:"end"`
+	// Rebuilt is set on the stats rebuilt from the journal at startup
+	Rebuilt bool `json:"rebuilt,omitempty"`
 }
 
 // IsValid check if message has the right type
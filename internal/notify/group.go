@@ -0,0 +1,253 @@
+package notify
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/juacker/loghound/internal/clock"
+	"github.com/juacker/loghound/internal/message"
+)
+
+// Options sets how alerts are grouped before notifying them
+type Options struct {
+	// GroupBy are the labels shared by the alerts of a group. Besides the alert
+	// labels, alertname is the rule name without labels, rule and metric are the
+	// alert ones. Empty groups all the alerts together
+	GroupBy []string
+	// GroupWait is the time to wait for more alerts since the first alert of a group
+	GroupWait time.Duration
+	// GroupInterval is the time to wait to notify new alerts of an already notified group
+	GroupInterval time.Duration
+	// RepeatInterval is the time to notify again a group without changes, 0 disables it
+	RepeatInterval time.Duration
+	// Clock tells the time alerts are received and groups are due
+	Clock clock.Clock
+}
+
+// DefaultOptions returns the default grouping options
+func DefaultOptions() Options {
+	return Options{
+		GroupBy:        []string{"alertname"},
+		GroupWait:      30 * time.Second,
+		GroupInterval:  5 * time.Minute,
+		RepeatInterval: 4 * time.Hour,
+		Clock:          clock.New(),
+	}
+}
+
+// Alert is an alert within a group notification
+type Alert struct {
+	Rule     string            `json:"rule"`
+	Labels   map[string]string `json:"labels,omitempty"`
+	Metric   string            `json:"metric"`
+	Value    float64           `json:"value"`
+	Firing   bool              `json:"firing"`
+	Acked    bool              `json:"acked"`
+	Text     string            `json:"text"`
+	StartsAt time.Time         `json:"starts_at"`
+	EndsAt   time.Time         `json:"ends_at"`
+}
+
+// Group is a notification of the alerts sharing the group labels
+type Group struct {
+	Key    string            `json:"key"`
+	Labels map[string]string `json:"labels"`
+	Alerts []Alert           `json:"alerts"`
+}
+
+// Firing returns the number of firing alerts of the group
+func (g *Group) Firing() int {
+	var n int
+	for _, a := range g.Alerts {
+		if a.Firing {
+			n++
+		}
+	}
+	return n
+}
+
+// group is the grouping state of the alerts sharing some labels
+type group struct {
+	labels   map[string]string
+	alerts   map[string]*Alert
+	changed  bool
+	next     time.Time
+	notified time.Time
+}
+
+// Grouper batches the alerts sharing the group labels, and decides when to notify them
+type Grouper struct {
+	opts   Options
+	groups map[string]*group
+}
+
+// NewGrouper returns a grouper
+func NewGrouper(opts Options) *Grouper {
+	return &Grouper{
+		opts:   opts,
+		groups: make(map[string]*group),
+	}
+}
+
+// labels returns the alert labels used to group it
+func labels(msg *message.AlertMessage) map[string]string {
+	l := make(map[string]string, len(msg.Labels)+3)
+	for k, v := range msg.Labels {
+		l[k] = v
+	}
+
+	l["alertname"] = msg.Rule
+	if i := strings.IndexByte(msg.Rule, '{'); i >= 0 {
+		l["alertname"] = msg.Rule[:i]
+	}
+	l["rule"] = msg.Rule
+	l["metric"] = msg.Metric
+
+	return l
+}
+
+// key returns the group of an alert with labels, and the group labels
+func (g *Grouper) key(l map[string]string) (string, map[string]string) {
+	by := make(map[string]string, len(g.opts.GroupBy))
+	pairs := make([]string, 0, len(g.opts.GroupBy))
+
+	for _, name := range g.opts.GroupBy {
+		by[name] = l[name]
+		pairs = append(pairs, name+"="+l[name])
+	}
+	sort.Strings(pairs)
+
+	return "{" + strings.Join(pairs, ",") + "}", by
+}
+
+// Add adds an alert message received at now to its group. Repeated messages of an
+// alert without changes are deduplicated, and silenced alerts are not notified
+func (g *Grouper) Add(msg *message.AlertMessage, now time.Time) {
+	firing := msg.Severity != message.SeverityCanceled
+	if firing && msg.Silenced {
+		return
+	}
+
+	l := labels(msg)
+	key, by := g.key(l)
+
+	gr, ok := g.groups[key]
+	if !ok {
+		// resolved alerts are only notified in groups notified before
+		if !firing {
+			return
+		}
+
+		gr = &group{
+			labels: by,
+			alerts: make(map[string]*Alert),
+			next:   now.Add(g.opts.GroupWait),
+		}
+		g.groups[key] = gr
+	}
+
+	a, ok := gr.alerts[msg.Rule]
+	if !ok {
+		if !firing {
+			return
+		}
+
+		a = &Alert{StartsAt: now}
+		gr.alerts[msg.Rule] = a
+	}
+
+	// alerts resolved before their group was notified are forgotten
+	if !firing && gr.notified.IsZero() {
+		delete(gr.alerts, msg.Rule)
+		if len(gr.alerts) == 0 {
+			delete(g.groups, key)
+		}
+		return
+	}
+
+	if a.Firing != firing || !ok {
+		gr.changed = true
+	}
+	if firing && !a.Firing {
+		a.StartsAt = now
+	}
+	if !firing && a.Firing {
+		a.EndsAt = now
+	}
+
+	a.Rule = msg.Rule
+	a.Labels = msg.Labels
+	a.Metric = msg.Metric
+	a.Value = msg.Value
+	a.Firing = firing
+	a.Acked = msg.Acked
+	a.Text = msg.Text
+}
+
+// Flush returns the groups to notify at now: changed groups once their group wait
+// or group interval are over, and groups with firing alerts not acknowledged every
+// repeat interval. Resolved alerts are dropped once notified
+func (g *Grouper) Flush(now time.Time) []Group {
+	keys := make([]string, 0, len(g.groups))
+	for key := range g.groups {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	groups := make([]Group, 0)
+	for _, key := range keys {
+		gr := g.groups[key]
+
+		due := gr.changed && !now.Before(gr.next)
+		if !gr.changed && g.opts.RepeatInterval > 0 && !gr.notified.IsZero() {
+			due = gr.pending() && !now.Before(gr.notified.Add(g.opts.RepeatInterval))
+		}
+		if !due {
+			continue
+		}
+
+		groups = append(groups, gr.notification(key))
+
+		gr.changed = false
+		gr.notified = now
+		gr.next = now.Add(g.opts.GroupInterval)
+
+		for rule, a := range gr.alerts {
+			if !a.Firing {
+				delete(gr.alerts, rule)
+			}
+		}
+		if len(gr.alerts) == 0 {
+			delete(g.groups, key)
+		}
+	}
+
+	return groups
+}
+
+// pending returns if the group has firing alerts not acknowledged
+func (gr *group) pending() bool {
+	for _, a := range gr.alerts {
+		if a.Firing && !a.Acked {
+			return true
+		}
+	}
+	return false
+}
+
+// notification returns the group notification, alerts sorted by rule
+func (gr *group) notification(key string) Group {
+	n := Group{
+		Key:    key,
+		Labels: gr.labels,
+		Alerts: make([]Alert, 0, len(gr.alerts)),
+	}
+
+	for _, a := range gr.alerts {
+		n.Alerts = append(n.Alerts, *a)
+	}
+	sort.Slice(n.Alerts, func(i, j int) bool { return n.Alerts[i].Rule < n.Alerts[j].Rule })
+
+	return n
+}
@@ -0,0 +1,81 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// Notifier sends group notifications
+type Notifier interface {
+	Notify(g Group) error
+}
+
+// Webhook posts group notifications as json to a url
+type Webhook struct {
+	URL    string
+	client *http.Client
+}
+
+// NewWebhook returns a webhook notifier for url
+func NewWebhook(url string) *Webhook {
+	return &Webhook{
+		URL:    url,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Notify posts the group notification
+func (w *Webhook) Notify(g Group) error {
+	data, err := json.Marshal(g)
+	if err != nil {
+		return err
+	}
+
+	resp, err := w.client.Post(w.URL, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("notify: webhook request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("notify: webhook %s answered %s", w.URL, resp.Status)
+	}
+
+	return nil
+}
+
+// File appends group notifications as json lines to a file
+type File struct {
+	sync.Mutex
+	path string
+}
+
+// NewFile returns a file notifier writing to path
+func NewFile(path string) *File {
+	return &File{path: path}
+}
+
+// Notify appends the group notification to the file
+func (f *File) Notify(g Group) error {
+	f.Lock()
+	defer f.Unlock()
+
+	data, err := json.Marshal(g)
+	if err != nil {
+		return err
+	}
+
+	fd, err := os.OpenFile(f.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("notify: failed opening file %s: %v", f.path, err)
+	}
+	defer fd.Close()
+
+	_, err = fd.Write(append(data, '\n'))
+	return err
+}
@@ -0,0 +1,140 @@
+package notify
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/juacker/loghound/internal/broker"
+	"github.com/juacker/loghound/internal/clock"
+	"github.com/juacker/loghound/internal/message"
+)
+
+// number of groups waiting to be sent, groups flushed while the queue is full
+// are dropped so slow notifiers don't block the alerts
+const queueSize = 100
+
+type notifyModule struct {
+	ctl       chan bool
+	wg        *sync.WaitGroup
+	broker    broker.Link
+	clock     clock.Clock
+	grouper   *Grouper
+	notifiers []Notifier
+	// groups to send, by the sender goroutine
+	queue chan Group
+	// closed on exit, groups still queued are dropped
+	done chan struct{}
+	sent chan struct{}
+}
+
+func (m *notifyModule) loop() {
+	log.Println("notify: initializing notifications")
+
+	ticker := m.clock.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	go m.send()
+
+LOOP:
+	for {
+		select {
+		case payload := <-m.broker.Receive():
+			log.Println("notify: new message received")
+			err := m.processMessage(payload)
+			if err != nil {
+				log.Println("notify: failed processing message: ", err)
+			}
+		case <-ticker.C:
+			m.flush(m.clock.Now())
+		case <-m.ctl:
+			log.Println("notify: ctl signal received, exiting")
+			break LOOP
+		}
+	}
+
+	// the group being sent is finished
+	close(m.done)
+	close(m.queue)
+	<-m.sent
+
+	m.wg.Done()
+}
+
+func (m *notifyModule) processMessage(payload []byte) error {
+	var msg message.AlertMessage
+	err := json.Unmarshal(payload, &msg)
+	if err != nil {
+		return err
+	}
+
+	// check message is the expected
+	if !msg.IsValid() {
+		return fmt.Errorf("invalid message")
+	}
+
+	m.grouper.Add(&msg, m.clock.Now())
+	return nil
+}
+
+// flush queues the groups due at now to be sent
+func (m *notifyModule) flush(now time.Time) {
+	for _, g := range m.grouper.Flush(now) {
+		select {
+		case m.queue <- g:
+		default:
+			log.Printf("notify: queue full, dropping group %s with %d alerts", g.Key, len(g.Alerts))
+		}
+	}
+}
+
+// send sends the queued groups to every notifier, until the module exits
+func (m *notifyModule) send() {
+	defer close(m.sent)
+
+	for g := range m.queue {
+		select {
+		case <-m.done:
+			log.Printf("notify: exiting, dropping group %s with %d alerts", g.Key, len(g.Alerts))
+			continue
+		default:
+		}
+
+		log.Printf("notify: sending group %s with %d alerts, %d firing", g.Key, len(g.Alerts), g.Firing())
+
+		for _, n := range m.notifiers {
+			err := n.Notify(g)
+			if err != nil {
+				log.Println("notify: failed sending notification: ", err)
+			}
+		}
+	}
+}
+
+// Run starts notifications. Alerts are grouped with opts before being sent to notifiers
+func Run(wg *sync.WaitGroup, ctl chan bool, opts Options, notifiers []Notifier) {
+	conn, err := broker.NewConnection("notify", broker.TopicAlert)
+	if err != nil {
+		log.Fatal("notify: failed opening broker connection ", err)
+	}
+
+	if opts.Clock == nil {
+		opts.Clock = clock.New()
+	}
+
+	m := &notifyModule{
+		ctl:       ctl,
+		wg:        wg,
+		broker:    conn,
+		clock:     opts.Clock,
+		grouper:   NewGrouper(opts),
+		notifiers: notifiers,
+		queue:     make(chan Group, queueSize),
+		done:      make(chan struct{}),
+		sent:      make(chan struct{}),
+	}
+
+	m.loop()
+}
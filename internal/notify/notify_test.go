@@ -0,0 +1,149 @@
+package notify
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/juacker/loghound/internal/message"
+	tassert "github.com/stretchr/testify/assert"
+)
+
+func alert(rule, path string, severity message.Severity) *message.AlertMessage {
+	msg := message.NewAlertMessage("path."+path+".requests", "alert on "+path, severity)
+	msg.Rule = rule + "{path=" + path + "}"
+	msg.Labels = map[string]string{"path": path}
+	return msg
+}
+
+// blockingNotifier blocks sending groups until released
+type blockingNotifier struct {
+	sent    chan Group
+	release chan struct{}
+}
+
+func (n *blockingNotifier) Notify(g Group) error {
+	n.sent <- g
+	<-n.release
+	return nil
+}
+
+func TestInternalNotify(t *testing.T) {
+
+	assert := tassert.New(t)
+
+	opts := Options{
+		GroupBy:        []string{"alertname"},
+		GroupWait:      30 * time.Second,
+		GroupInterval:  5 * time.Minute,
+		RepeatInterval: time.Hour,
+	}
+	start := time.Now()
+
+	t.Run("Grouper - batches alerts within group wait", func(t *testing.T) {
+		g := NewGrouper(opts)
+
+		g.Add(alert("path-traffic", "/admin", message.SeverityMax), start)
+		g.Add(alert("path-traffic", "/users", message.SeverityMax), start.Add(10*time.Second))
+		g.Add(alert("other", "/users", message.SeverityMax), start.Add(10*time.Second))
+
+		assert.Equal(0, len(g.Flush(start.Add(20*time.Second))), "within group wait")
+
+		groups := g.Flush(start.Add(40 * time.Second))
+		assert.Equal(2, len(groups), "one group per alertname")
+		assert.Equal("{alertname=path-traffic}", groups[1].Key)
+		assert.Equal(2, len(groups[1].Alerts), "two alerts grouped")
+		assert.Equal("path-traffic{path=/admin}", groups[1].Alerts[0].Rule)
+
+		// repeated messages without changes are deduplicated
+		g.Add(alert("path-traffic", "/admin", message.SeverityMax), start.Add(time.Minute))
+		assert.Equal(0, len(g.Flush(start.Add(10*time.Minute))), "nothing changed")
+
+		// changes wait for the group interval
+		g.Add(alert("path-traffic", "/admin", message.SeverityCanceled), start.Add(2*time.Minute))
+		assert.Equal(0, len(g.Flush(start.Add(3*time.Minute))), "within group interval")
+
+		groups = g.Flush(start.Add(6 * time.Minute))
+		assert.Equal(1, len(groups), "group changed")
+		assert.Equal(1, groups[0].Firing(), "one alert firing")
+		assert.False(groups[0].Alerts[0].Firing, "/admin resolved")
+
+		// resolved alerts are notified once
+		groups = g.Flush(start.Add(2 * time.Hour))
+		assert.Equal(2, len(groups), "groups repeated")
+		assert.Equal(1, len(groups[1].Alerts), "only /users")
+	})
+
+	t.Run("Grouper - silenced and early resolved alerts", func(t *testing.T) {
+		g := NewGrouper(opts)
+
+		silenced := alert("path-traffic", "/admin", message.SeverityMax)
+		silenced.Silenced = true
+		g.Add(silenced, start)
+
+		g.Add(alert("path-traffic", "/users", message.SeverityMax), start)
+		g.Add(alert("path-traffic", "/users", message.SeverityCanceled), start.Add(10*time.Second))
+
+		assert.Equal(0, len(g.Flush(start.Add(time.Minute))), "nothing to notify")
+		assert.Equal(0, len(g.groups), "no groups left")
+	})
+
+	t.Run("Webhook - posts groups", func(t *testing.T) {
+		var received Group
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			json.NewDecoder(r.Body).Decode(&received) //errcheck: nolint
+		}))
+		defer server.Close()
+
+		g := NewGrouper(opts)
+		g.Add(alert("path-traffic", "/admin", message.SeverityMax), start)
+		groups := g.Flush(start.Add(time.Minute))
+
+		assert.Nil(NewWebhook(server.URL).Notify(groups[0]), "err nil")
+		assert.Equal("{alertname=path-traffic}", received.Key)
+		assert.Equal(map[string]string{"path": "/admin"}, received.Alerts[0].Labels)
+	})
+
+	t.Run("flush - groups sent apart from the loop", func(t *testing.T) {
+		n := &blockingNotifier{sent: make(chan Group, 3), release: make(chan struct{})}
+		m := &notifyModule{
+			grouper:   NewGrouper(opts),
+			notifiers: []Notifier{n},
+			queue:     make(chan Group, 1),
+			done:      make(chan struct{}),
+			sent:      make(chan struct{}),
+		}
+		go m.send()
+
+		m.grouper.Add(alert("path-traffic", "/admin", message.SeverityMax), start)
+		m.flush(start.Add(time.Minute))
+
+		select {
+		case g := <-n.sent:
+			assert.Equal("{alertname=path-traffic}", g.Key, "group being sent")
+		case <-time.After(time.Second):
+			assert.Fail("group not sent")
+			return
+		}
+
+		// the notifier is blocked, flushing doesn't wait for it
+		m.grouper.Add(alert("errors", "/admin", message.SeverityMax), start.Add(time.Minute))
+		m.grouper.Add(alert("latency", "/admin", message.SeverityMax), start.Add(time.Minute))
+		m.flush(start.Add(2 * time.Minute))
+		assert.Equal(1, len(m.queue), "one group queued, the other dropped")
+
+		close(n.release)
+		select {
+		case <-n.sent:
+		case <-time.After(time.Second):
+			assert.Fail("queued group not sent")
+		}
+
+		close(m.done)
+		close(m.queue)
+		<-m.sent
+		assert.Equal(0, len(n.sent), "no more groups sent")
+	})
+}
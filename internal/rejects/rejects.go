@@ -0,0 +1,97 @@
+package rejects
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/juacker/loghound/internal/broker"
+	"github.com/juacker/loghound/internal/clock"
+	"github.com/juacker/loghound/internal/health"
+	"github.com/juacker/loghound/internal/message"
+	"github.com/juacker/loghound/pkg/clf"
+)
+
+// maximum number of rejected lines sent to the pipeline every second, the rest
+// are only counted and written to the dead-letter file
+const samplesPerSecond = 5
+
+// Sink handles the lines of the inputs failing to parse: they are counted by
+// file and reason in the health metrics, a sample of them is sent to the
+// pipeline, and all of them are appended verbatim to the dead-letter file
+type Sink struct {
+	sync.Mutex
+	broker     broker.Link
+	clock      clock.Clock
+	deadLetter *os.File
+	second     int64
+	sampled    int
+}
+
+// Open returns a sink appending the rejected lines to the dead-letter file at
+// path, the file is disabled if path is empty
+func Open(path string) (*Sink, error) {
+	conn, err := broker.NewConnection("rejects")
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Sink{broker: conn, clock: clock.New()}
+
+	if path != "" {
+		s.deadLetter, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("rejects: failed opening dead-letter file %s: %v", path, err)
+		}
+	}
+
+	return s, nil
+}
+
+// Reject handles a line of file failing to parse with err
+func (s *Sink) Reject(file, line string, err error) {
+	health.ParseFailure(file, err)
+
+	s.Lock()
+	defer s.Unlock()
+
+	if s.deadLetter != nil {
+		if !strings.HasSuffix(line, "\n") {
+			line += "\n"
+		}
+
+		_, werr := s.deadLetter.WriteString(line)
+		if werr != nil {
+			log.Println("rejects: failed writing dead-letter file: ", werr)
+		}
+	}
+
+	now := s.clock.Now()
+	if now.Unix() != s.second {
+		s.second = now.Unix()
+		s.sampled = 0
+	}
+	if s.sampled >= samplesPerSecond {
+		return
+	}
+	s.sampled++
+
+	msg := message.NewRejectedMessage(file, strings.TrimRight(line, "\r\n"), clf.Reason(err), err.Error(), now)
+	serr := s.broker.Send(broker.TopicRejected, msg)
+	if serr != nil {
+		log.Println("rejects: failed sending message to broker")
+	}
+}
+
+// Close closes the dead-letter file
+func (s *Sink) Close() error {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.deadLetter == nil {
+		return nil
+	}
+	return s.deadLetter.Close()
+}
@@ -0,0 +1,58 @@
+package rejects
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/juacker/loghound/internal/broker"
+	"github.com/juacker/loghound/internal/clock"
+	"github.com/juacker/loghound/internal/message"
+	"github.com/juacker/loghound/internal/testutils"
+	"github.com/juacker/loghound/pkg/clf"
+	tassert "github.com/stretchr/testify/assert"
+)
+
+func TestInternalRejects(t *testing.T) {
+
+	assert := tassert.New(t)
+
+	t.Run("Sink - dead-letter file and samples", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "rejects")
+		assert.Nil(err, "err nil")
+		defer os.RemoveAll(dir)
+
+		path := filepath.Join(dir, "dead-letter.log")
+		s, err := Open(path)
+		assert.Nil(err, "err nil")
+
+		l := &testutils.Recorder{}
+		c := clock.NewFake(time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC))
+		s.broker = l
+		s.clock = c
+
+		for _, line := range []string{"a\n", "b\n", "c\n", "d\n", "e\n", "f\n", "g"} {
+			_, err := clf.Parse(line)
+			s.Reject("access.log", line, err)
+		}
+
+		sent := l.Sent(broker.TopicRejected)
+		assert.Equal(samplesPerSecond, len(sent), "sampled lines")
+		rejected := sent[0].(*message.RejectedMessage)
+		assert.Equal("a", rejected.Line, "line without break")
+		assert.Equal(clf.ReasonFormat, rejected.Reason, "reason")
+		assert.Equal("access.log", rejected.File, "file")
+
+		c.Advance(time.Second)
+		_, err = clf.Parse("h")
+		s.Reject("access.log", "h", err)
+		assert.Equal(samplesPerSecond+1, len(l.Sent(broker.TopicRejected)), "sampled on the next second")
+
+		assert.Nil(s.Close(), "err nil")
+		data, err := ioutil.ReadFile(path)
+		assert.Nil(err, "err nil")
+		assert.Equal("a\nb\nc\nd\ne\nf\ng\nh\n", string(data), "all the lines verbatim")
+	})
+}
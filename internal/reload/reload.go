@@ -0,0 +1,245 @@
+package reload
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/juacker/loghound/internal/alerts"
+	"github.com/juacker/loghound/internal/broker"
+	"github.com/juacker/loghound/internal/message"
+	"github.com/juacker/loghound/internal/slo"
+)
+
+// Config is the configuration that can be reloaded while running
+type Config struct {
+	Files []string
+	// Formats are the log formats of the files, clf if missing
+	Formats map[string]string
+	Rules   []alerts.Rule
+	SLOs    []slo.SLO
+	// Settings are the sections only applied at startup, by name, their
+	// changes are reported as requiring a restart
+	Settings map[string]interface{}
+}
+
+// Loader loads and validates the configuration
+type Loader func() (*Config, error)
+
+// Diff lists the names added, changed and removed in a configuration section
+type Diff struct {
+	Added   []string `json:"added,omitempty"`
+	Changed []string `json:"changed,omitempty"`
+	Removed []string `json:"removed,omitempty"`
+}
+
+// Empty returns if there are no differences
+func (d Diff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Changed) == 0 && len(d.Removed) == 0
+}
+
+func (d Diff) String() string {
+	changes := make([]string, 0, len(d.Added)+len(d.Changed)+len(d.Removed))
+	for _, name := range d.Added {
+		changes = append(changes, "+"+name)
+	}
+	for _, name := range d.Changed {
+		changes = append(changes, "~"+name)
+	}
+	for _, name := range d.Removed {
+		changes = append(changes, "-"+name)
+	}
+	return strings.Join(changes, " ")
+}
+
+// Changes are the differences between two configurations
+type Changes struct {
+	Files Diff `json:"files"`
+	Rules Diff `json:"rules"`
+	SLOs  Diff `json:"slos"`
+	// Restart are the settings changed that are not applied until a restart
+	Restart Diff `json:"restart"`
+}
+
+// Empty returns if the configurations are the same
+func (c *Changes) Empty() bool {
+	return !c.applied() && c.Restart.Empty()
+}
+
+// applied returns if there are changes applied by the running modules
+func (c *Changes) applied() bool {
+	return !c.Files.Empty() || !c.Rules.Empty() || !c.SLOs.Empty()
+}
+
+func (c *Changes) String() string {
+	if c.Empty() {
+		return "no changes"
+	}
+
+	sections := make([]string, 0, 4)
+	for _, s := range []struct {
+		name string
+		diff Diff
+	}{{"files", c.Files}, {"rules", c.Rules}, {"slos", c.SLOs}, {"requires restart", c.Restart}} {
+		if !s.diff.Empty() {
+			sections = append(sections, s.name+": "+s.diff.String())
+		}
+	}
+	return strings.Join(sections, ", ")
+}
+
+// diff compares two sets of items by name
+func diff(old, new map[string]interface{}) Diff {
+	d := Diff{}
+
+	for name, item := range new {
+		previous, ok := old[name]
+		switch {
+		case !ok:
+			d.Added = append(d.Added, name)
+		case !reflect.DeepEqual(previous, item):
+			d.Changed = append(d.Changed, name)
+		}
+	}
+	for name := range old {
+		if _, ok := new[name]; !ok {
+			d.Removed = append(d.Removed, name)
+		}
+	}
+
+	sort.Strings(d.Added)
+	sort.Strings(d.Changed)
+	sort.Strings(d.Removed)
+	return d
+}
+
+// Compare returns the changes from old to new
+func Compare(old, new *Config) Changes {
+	files := func(c *Config) map[string]interface{} {
+		m := make(map[string]interface{}, len(c.Files))
+		for _, f := range c.Files {
+			m[f] = c.Formats[f]
+		}
+		return m
+	}
+	rules := func(c *Config) map[string]interface{} {
+		m := make(map[string]interface{}, len(c.Rules))
+		for _, r := range c.Rules {
+			m[r.Name] = r
+		}
+		return m
+	}
+	slos := func(c *Config) map[string]interface{} {
+		m := make(map[string]interface{}, len(c.SLOs))
+		for _, s := range c.SLOs {
+			m[s.Name] = s
+		}
+		return m
+	}
+
+	return Changes{
+		Files:   diff(files(old), files(new)),
+		Rules:   diff(rules(old), rules(new)),
+		SLOs:    diff(slos(old), slos(new)),
+		Restart: diff(old.Settings, new.Settings),
+	}
+}
+
+// Reloader loads the configuration again and sends it to the running modules
+type Reloader struct {
+	sync.Mutex
+	load    Loader
+	broker  broker.Link
+	current *Config
+}
+
+// NewReloader returns a reloader of the configuration loaded by load, current
+// being the configuration the modules were started with
+func NewReloader(load Loader, current *Config) (*Reloader, error) {
+	conn, err := broker.NewConnection("reload")
+	if err != nil {
+		return nil, err
+	}
+
+	return &Reloader{
+		load:    load,
+		broker:  conn,
+		current: current,
+	}, nil
+}
+
+// Reload loads the configuration, and sends it to the modules if it changed.
+// An invalid configuration is not applied, the running one is kept. Changed
+// settings only applied at startup are reported until a restart
+func (r *Reloader) Reload() (*Changes, error) {
+	r.Lock()
+	defer r.Unlock()
+
+	config, err := r.load()
+	if err != nil {
+		return nil, fmt.Errorf("reload: invalid configuration, keeping the running one: %v", err)
+	}
+
+	changes := Compare(r.current, config)
+	if !changes.Restart.Empty() {
+		log.Println("reload: settings changed, they require a restart: ", changes.Restart)
+	}
+	if !changes.applied() {
+		return &changes, nil
+	}
+
+	// the settings running are still the ones of startup
+	config.Settings = r.current.Settings
+
+	rules, err := json.Marshal(config.Rules)
+	if err != nil {
+		return nil, err
+	}
+	slos, err := json.Marshal(config.SLOs)
+	if err != nil {
+		return nil, err
+	}
+
+	err = r.broker.Send(broker.TopicConfig, message.NewConfigMessage(config.Files, config.Formats, rules, slos))
+	if err != nil {
+		return nil, err
+	}
+
+	r.current = config
+	return &changes, nil
+}
+
+// Run reloads the configuration with r on SIGHUP
+func Run(wg *sync.WaitGroup, ctl chan bool, r *Reloader) {
+	log.Println("reload: waiting for SIGHUP to reload the configuration")
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	defer signal.Stop(signals)
+
+LOOP:
+	for {
+		select {
+		case <-signals:
+			log.Println("reload: SIGHUP received, reloading configuration")
+			changes, err := r.Reload()
+			if err != nil {
+				log.Println(err)
+				continue
+			}
+			log.Println("reload: configuration reloaded, ", changes)
+		case <-ctl:
+			log.Println("reload: ctl signal received, exiting")
+			break LOOP
+		}
+	}
+
+	wg.Done()
+}
@@ -0,0 +1,125 @@
+package reload
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/juacker/loghound/internal/alerts"
+	"github.com/juacker/loghound/internal/broker"
+	"github.com/juacker/loghound/internal/message"
+	"github.com/juacker/loghound/internal/slo"
+	"github.com/juacker/loghound/internal/testutils"
+	tassert "github.com/stretchr/testify/assert"
+)
+
+func TestInternalReload(t *testing.T) {
+
+	assert := tassert.New(t)
+
+	running := &Config{
+		Files: []string{"a.log", "b.log"},
+		Rules: []alerts.Rule{
+			{Name: "high-traffic", Type: alerts.RuleThreshold, Metric: "requests.total", Window: time.Minute, Threshold: 10},
+			{Name: "big-responses", Type: alerts.RuleThreshold, Metric: "bytes.total", Window: time.Minute, Threshold: 1000},
+		},
+		SLOs: []slo.SLO{{Name: "api", Objective: 0.99, Period: time.Hour}},
+	}
+
+	t.Run("Compare - added, changed and removed by name", func(t *testing.T) {
+		next := &Config{
+			Files: []string{"b.log", "c.log"},
+			Rules: []alerts.Rule{
+				{Name: "high-traffic", Type: alerts.RuleThreshold, Metric: "requests.total", Window: time.Minute, Threshold: 20},
+				{Name: "big-responses", Type: alerts.RuleThreshold, Metric: "bytes.total", Window: time.Minute, Threshold: 1000},
+				{Name: "errors", Type: alerts.RuleThreshold, Metric: "path./.status.500.requests", Window: time.Minute, Threshold: 1},
+			},
+			SLOs: running.SLOs,
+		}
+
+		changes := Compare(running, next)
+		assert.Equal(Diff{Added: []string{"c.log"}, Removed: []string{"a.log"}}, changes.Files, "files diff")
+		assert.Equal(Diff{Added: []string{"errors"}, Changed: []string{"high-traffic"}}, changes.Rules, "rules diff")
+		assert.True(changes.SLOs.Empty(), "slos unchanged")
+		assert.Equal("files: +c.log -a.log, rules: +errors ~high-traffic", changes.String(), "changes summary")
+
+		changes = Compare(running, running)
+		assert.Equal("no changes", changes.String(), "no changes")
+
+		next = &Config{Files: running.Files, Formats: map[string]string{"b.log": "w3c"}}
+		changes = Compare(running, next)
+		assert.Equal(Diff{Changed: []string{"b.log"}}, changes.Files, "file format changed")
+	})
+
+	t.Run("Reload - invalid configuration keeps the running one", func(t *testing.T) {
+		l := &testutils.Recorder{}
+		next := running
+		var loadErr error
+
+		r := &Reloader{
+			load:    func() (*Config, error) { return next, loadErr },
+			broker:  l,
+			current: running,
+		}
+
+		changes, err := r.Reload()
+		assert.Nil(err, "err nil")
+		assert.True(changes.Empty(), "no changes")
+		assert.Equal(0, len(l.Sent(broker.TopicConfig)), "unchanged configuration not sent")
+
+		loadErr = fmt.Errorf("invalid rule")
+		next = &Config{Files: []string{"c.log"}, Formats: map[string]string{"c.log": "alb"}}
+		_, err = r.Reload()
+		assert.NotNil(err, "validation error")
+		assert.Equal(0, len(l.Sent(broker.TopicConfig)), "invalid configuration not sent")
+		assert.Equal(running, r.current, "running configuration kept")
+
+		loadErr = nil
+		changes, err = r.Reload()
+		assert.Nil(err, "err nil")
+		assert.Equal(2, len(changes.Rules.Removed), "rules removed")
+		assert.Equal(1, len(l.Sent(broker.TopicConfig)), "configuration sent")
+
+		msg, ok := l.Sent(broker.TopicConfig)[0].(*message.ConfigMessage)
+		assert.True(ok, "config message")
+		assert.Equal([]string{"c.log"}, msg.Files, "files sent")
+		assert.Equal("alb", msg.Formats["c.log"], "formats sent")
+		assert.Equal("null", string(msg.Rules), "no rules")
+		assert.Equal(next, r.current, "new configuration running")
+	})
+
+	t.Run("Reload - settings changes require a restart", func(t *testing.T) {
+		l := &testutils.Recorder{}
+		started := &Config{
+			Files:    running.Files,
+			Settings: map[string]interface{}{"stats": []string{"totals"}, "notifiers": "hook"},
+		}
+		next := &Config{
+			Files:    running.Files,
+			Settings: map[string]interface{}{"stats": []string{"totals", "paths"}, "notifiers": "hook"},
+		}
+
+		r := &Reloader{
+			load:    func() (*Config, error) { return next, nil },
+			broker:  l,
+			current: started,
+		}
+
+		changes, err := r.Reload()
+		assert.Nil(err, "err nil")
+		assert.False(changes.Empty(), "settings changed")
+		assert.Equal(Diff{Changed: []string{"stats"}}, changes.Restart, "settings diff")
+		assert.Equal("requires restart: ~stats", changes.String(), "changes summary")
+		assert.Equal(0, len(l.Sent(broker.TopicConfig)), "settings not sent")
+
+		next = &Config{
+			Files:    []string{"a.log"},
+			Settings: next.Settings,
+		}
+		changes, err = r.Reload()
+		assert.Nil(err, "err nil")
+		assert.Equal(1, len(l.Sent(broker.TopicConfig)), "files sent")
+		assert.Equal("files: -b.log, requires restart: ~stats", changes.String(), "settings still require a restart")
+		assert.Equal(started.Settings, r.current.Settings, "settings of startup kept")
+	})
+}
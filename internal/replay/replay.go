@@ -0,0 +1,157 @@
+package replay
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/juacker/loghound/internal/broker"
+	"github.com/juacker/loghound/internal/clock"
+	"github.com/juacker/loghound/internal/health"
+	"github.com/juacker/loghound/internal/message"
+	"github.com/juacker/loghound/internal/rejects"
+	"github.com/juacker/loghound/pkg/clf"
+)
+
+type replayer struct {
+	ctl     chan bool
+	wg      *sync.WaitGroup
+	broker  broker.Link
+	path    string
+	parser  clf.Parser
+	clock   *clock.Virtual
+	rejects *rejects.Sink
+}
+
+func (r *replayer) loop() {
+	log.Println("replay: replaying file ", r.path)
+
+	entries, stopped, err := r.replay()
+	if err != nil {
+		log.Println("replay: failed replaying file: ", err)
+	}
+	log.Printf("replay: %d entries replayed from %s", entries, r.path)
+
+	// the rest of the pipeline keeps running until exit
+	if !stopped {
+		<-r.ctl
+	}
+	log.Println("replay: ctl signal received, exiting")
+
+	r.wg.Done()
+}
+
+// replay sends the entries of the file to the pipeline when the clock reaches
+// their dates. It returns the entries sent, and if it was stopped by a ctl signal
+func (r *replayer) replay() (int, bool, error) {
+	file, err := os.Open(r.path)
+	if err != nil {
+		return 0, false, fmt.Errorf("replay: failed openning file %s: %v", r.path, err)
+	}
+	defer file.Close()
+
+	entries := 0
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if len(line) == 0 {
+			continue
+		}
+
+		health.Add(health.LinesRead, 1, "file", r.path)
+
+		logEntry, err := r.parser.Parse(line)
+		if err == clf.ErrDirective {
+			continue
+		}
+		if err != nil {
+			log.Println("replay: failed parsing line ", err)
+			r.rejects.Reject(r.path, line, err)
+			continue
+		}
+
+		// entries out of order are sent right away
+		wait := r.clock.Until(logEntry.Date)
+		if wait > 0 {
+			select {
+			case <-time.After(wait):
+			case <-r.ctl:
+				return entries, true, nil
+			}
+		}
+
+		msg := message.NewCLFMessage(logEntry)
+		msg.File = r.path
+
+		err = r.broker.Send(broker.TopicData, msg)
+		if err != nil {
+			log.Println("replay: failed sending message to broker")
+		}
+		entries++
+	}
+
+	return entries, false, scanner.Err()
+}
+
+// Start returns the date of the first entry of the log file at path in format,
+// where the clock of a replay starts
+func Start(path, format string) (time.Time, error) {
+	parser, err := clf.NewParser(format)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("replay: failed openning file %s: %v", path, err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if len(scanner.Text()) == 0 {
+			continue
+		}
+
+		logEntry, err := parser.Parse(scanner.Text())
+		if err == nil {
+			return logEntry.Date, nil
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return time.Time{}, fmt.Errorf("replay: failed reading file %s: %v", path, err)
+	}
+	return time.Time{}, fmt.Errorf("replay: no entries found in %s", path)
+}
+
+// Run starts replaying the log file at path in format, its entries are sent to
+// the pipeline when the clock reaches their dates, so they keep their spacing
+// scaled by the clock speed. Lines failing to parse are handled by sink
+func Run(wg *sync.WaitGroup, ctl chan bool, path, format string, c *clock.Virtual, sink *rejects.Sink) {
+	parser, err := clf.NewParser(format)
+	if err != nil {
+		log.Fatal("replay: ", err)
+	}
+
+	conn, err := broker.NewConnection("replay")
+	if err != nil {
+		log.Fatal("replay: failed opening broker connection ", err)
+	}
+
+	replayer := &replayer{
+		ctl:     ctl,
+		wg:      wg,
+		broker:  conn,
+		path:    path,
+		parser:  parser,
+		clock:   c,
+		rejects: sink,
+	}
+
+	replayer.loop()
+}
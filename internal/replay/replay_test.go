@@ -0,0 +1,85 @@
+package replay
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/juacker/loghound/internal/broker"
+	"github.com/juacker/loghound/internal/clock"
+	"github.com/juacker/loghound/internal/message"
+	"github.com/juacker/loghound/internal/testutils"
+	"github.com/juacker/loghound/pkg/clf"
+	tassert "github.com/stretchr/testify/assert"
+)
+
+func TestInternalReplay(t *testing.T) {
+
+	assert := tassert.New(t)
+
+	dir, err := ioutil.TempDir("", "replay")
+	assert.Nil(err, "err nil")
+	defer os.RemoveAll(dir)
+
+	// entries 10 seconds apart
+	path := filepath.Join(dir, "access.log")
+	lines := `127.0.0.1 - - [09/May/2018:16:00:00 +0000] "GET /a HTTP/1.0" 200 10
+
+127.0.0.1 - - [09/May/2018:16:00:10 +0000] "GET /b HTTP/1.0" 200 10
+127.0.0.1 - - [09/May/2018:16:00:20 +0000] "GET /c HTTP/1.0" 200 10
+`
+	assert.Nil(ioutil.WriteFile(path, []byte(lines), 0644), "err nil")
+
+	t.Run("Start - date of the first entry", func(t *testing.T) {
+		start, err := Start(path, clf.FormatCLF)
+		assert.Nil(err, "err nil")
+		assert.Equal(time.Date(2018, 5, 9, 16, 0, 0, 0, time.UTC), start.UTC())
+
+		_, err = Start(path, "xml")
+		assert.NotNil(err, "unknown format")
+	})
+
+	t.Run("replay - entries spaced by the clock speed", func(t *testing.T) {
+		start, err := Start(path, clf.FormatCLF)
+		assert.Nil(err, "err nil")
+
+		l := &testutils.Recorder{}
+		r := &replayer{
+			ctl:    make(chan bool),
+			broker: l,
+			path:   path,
+			parser: clf.ParserFunc(clf.Parse),
+			// 10 seconds of the log every 20ms
+			clock: clock.NewVirtual(start, 500),
+		}
+
+		begin := time.Now()
+		done := make(chan int)
+		go func() {
+			entries, _, err := r.replay()
+			assert.Nil(err, "err nil")
+			done <- entries
+		}()
+
+		sent := l.Wait(broker.TopicData, 1, time.Second)
+		assert.Equal(1, len(sent), "first entry right away")
+
+		select {
+		case entries := <-done:
+			assert.Equal(3, entries, "entries replayed")
+		case <-time.After(time.Second):
+			assert.Fail("replay not finished")
+			return
+		}
+
+		elapsed := time.Since(begin)
+		assert.True(elapsed >= 40*time.Millisecond, "20 seconds of the log take 40ms")
+		assert.True(elapsed < time.Second, "spacing scaled by the speed")
+
+		sent = l.Sent(broker.TopicData)
+		assert.Equal("/c", sent[2].(*message.CLFMessage).Request.Path, "entries in order")
+		assert.Equal(path, sent[2].(*message.CLFMessage).File, "file of the entries")
+	})
+}
@@ -0,0 +1,344 @@
+package ruletest
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/juacker/loghound/internal/alerts"
+	"github.com/juacker/loghound/internal/clock"
+	"github.com/juacker/loghound/internal/message"
+	"github.com/juacker/loghound/internal/slo"
+	"github.com/juacker/loghound/internal/stats"
+	"github.com/juacker/loghound/pkg/clf"
+	"gopkg.in/yaml.v3"
+)
+
+// Expectation sets when an alert is expected to fire and resolve, as offsets from the start
+type Expectation struct {
+	Rule     string          `yaml:"rule"`
+	Fires    []time.Duration `yaml:"fires"`
+	Resolves []time.Duration `yaml:"resolves"`
+}
+
+// Spec defines a test of alert rules: fixtures of metric series or a sample access log,
+// replayed on a simulated clock, and the expected alerts
+type Spec struct {
+	// Rules is the rules file, relative to the spec file
+	Rules string `yaml:"rules"`
+	// Start is the simulated time of the first interval, by default the date of the
+	// first log entry, or 2021-01-01 without log
+	Start time.Time `yaml:"start"`
+	// Interval is the stats interval, 10s by default
+	Interval time.Duration `yaml:"interval"`
+	// EvaluationInterval is the interval to check the alerts, 5s by default
+	EvaluationInterval time.Duration `yaml:"evaluation_interval"`
+	// Duration of the simulation, by default until the end of the fixtures
+	Duration time.Duration `yaml:"duration"`
+	// Series are the values of metrics for every interval, like "10x30 100x12 _ 5":
+	// a value, repeated N times with xN, and _ for no value
+	Series map[string]string `yaml:"series"`
+	// Log is an access log file, relative to the spec file, replayed by entry dates
+	Log    string        `yaml:"log"`
+	Expect []Expectation `yaml:"expect"`
+}
+
+// Result is the outcome of a rules test
+type Result struct {
+	Start       time.Time
+	Transitions []alerts.Transition
+	Failures    []string
+}
+
+// event is a message sent to the monitors at a given time
+type event struct {
+	time time.Time
+	msg  interface{}
+}
+
+// LoadSpec reads the test spec at path
+func LoadSpec(path string) (*Spec, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("ruletest: failed reading spec %s: %v", path, err)
+	}
+
+	var spec Spec
+	err = yaml.Unmarshal(data, &spec)
+	if err != nil {
+		return nil, fmt.Errorf("ruletest: invalid spec %s: %v", path, err)
+	}
+
+	if spec.Interval == 0 {
+		spec.Interval = 10 * time.Second
+	}
+	if spec.EvaluationInterval == 0 {
+		spec.EvaluationInterval = 5 * time.Second
+	}
+
+	// files are relative to the spec
+	dir := filepath.Dir(path)
+	if spec.Rules != "" && !filepath.IsAbs(spec.Rules) {
+		spec.Rules = filepath.Join(dir, spec.Rules)
+	}
+	if spec.Log != "" && !filepath.IsAbs(spec.Log) {
+		spec.Log = filepath.Join(dir, spec.Log)
+	}
+
+	return &spec, nil
+}
+
+// Run runs the test spec at path with the rules and slos defined in rulesPath,
+// or in the spec rules file if empty
+func Run(path, rulesPath string) (*Result, error) {
+	spec, err := LoadSpec(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if rulesPath == "" {
+		rulesPath = spec.Rules
+	}
+	if rulesPath == "" {
+		return nil, fmt.Errorf("ruletest: rules file is required")
+	}
+
+	rules, err := alerts.LoadRules(rulesPath)
+	if err != nil {
+		return nil, err
+	}
+	slos, err := slo.Load(rulesPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return spec.Run(rules, slos)
+}
+
+// Run replays the spec fixtures to the alert monitors of rules and slos, and checks
+// the alerts fire and resolve when expected
+func (s *Spec) Run(rules []alerts.Rule, slos []slo.SLO) (*Result, error) {
+	events, err := s.events(slos)
+	if err != nil {
+		return nil, err
+	}
+
+	end := s.Start.Add(s.Duration)
+	if s.Duration == 0 && len(events) > 0 {
+		end = events[len(events)-1].time
+	}
+
+	c := clock.NewFake(s.Start)
+	simulator := alerts.NewSimulator(rules, slos, c)
+
+	result := &Result{Start: s.Start}
+
+	next := 0
+	for t := s.Start.Add(s.EvaluationInterval); !t.After(end); t = t.Add(s.EvaluationInterval) {
+		for ; next < len(events) && !events[next].time.After(t); next++ {
+			c.Set(events[next].time)
+			err = simulator.Process(events[next].msg)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		c.Set(t)
+		result.Transitions = append(result.Transitions, simulator.Evaluate()...)
+	}
+
+	result.check(s.Expect)
+	return result, nil
+}
+
+// events returns the messages of the fixtures sorted by time. Log entries are
+// sent as they are, and counted in stats messages every interval
+func (s *Spec) events(slos []slo.SLO) ([]event, error) {
+	entries, err := s.readLog()
+	if err != nil {
+		return nil, err
+	}
+
+	if s.Start.IsZero() {
+		s.Start = time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+		if len(entries) > 0 {
+			s.Start = entries[0].Date.Truncate(s.Interval)
+		}
+	}
+
+	// stats of every interval, by interval number from 1
+	intervals := make(map[int]map[string]int)
+	last := 0
+
+	for metric, values := range s.Series {
+		series, err := parseSeries(values)
+		if err != nil {
+			return nil, fmt.Errorf("ruletest: invalid series of %s: %v", metric, err)
+		}
+
+		for i, v := range series {
+			if intervals[i+1] == nil {
+				intervals[i+1] = make(map[string]int)
+			}
+			if v != nil {
+				intervals[i+1][metric] = *v
+			}
+			if i+1 > last {
+				last = i + 1
+			}
+		}
+	}
+
+	events := make([]event, 0)
+	counter, err := stats.NewCounter(stats.Counting{SLOs: slos})
+	if err != nil {
+		return nil, err
+	}
+	interval := 1
+
+	// flush counts the entries of the intervals ended before t
+	flush := func(t time.Time) {
+		for ; !s.Start.Add(time.Duration(interval) * s.Interval).After(t); interval++ {
+			if intervals[interval] == nil {
+				intervals[interval] = make(map[string]int)
+			}
+			for k, v := range counter.Stats() {
+				intervals[interval][k] += v
+			}
+			if interval > last {
+				last = interval
+			}
+		}
+	}
+
+	for _, e := range entries {
+		if e.Date.Before(s.Start) {
+			continue
+		}
+
+		flush(e.Date)
+
+		msg := message.NewCLFMessage(e)
+		msg.File = s.Log
+		events = append(events, event{e.Date, msg})
+
+		err = counter.Add(msg)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(entries) > 0 {
+		flush(entries[len(entries)-1].Date.Add(s.Interval))
+	}
+
+	for i := 1; i <= last; i++ {
+		end := s.Start.Add(time.Duration(i) * s.Interval)
+		events = append(events, event{end, message.NewStatMessage(intervals[i], end.Add(-s.Interval).Unix(), end.Unix())})
+	}
+
+	sort.SliceStable(events, func(a, b int) bool { return events[a].time.Before(events[b].time) })
+	return events, nil
+}
+
+// readLog parses the entries of the spec log, lines failing to parse are skipped
+func (s *Spec) readLog() ([]*clf.Entry, error) {
+	entries := make([]*clf.Entry, 0)
+	if s.Log == "" {
+		return entries, nil
+	}
+
+	f, err := os.Open(s.Log)
+	if err != nil {
+		return nil, fmt.Errorf("ruletest: failed opening log %s: %v", s.Log, err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		entry, err := clf.Parse(scanner.Text())
+		if err != nil {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+
+	sort.SliceStable(entries, func(a, b int) bool { return entries[a].Date.Before(entries[b].Date) })
+	return entries, scanner.Err()
+}
+
+// parseSeries parses the values of a series, nil for no value
+func parseSeries(s string) ([]*int, error) {
+	values := make([]*int, 0)
+
+	for _, token := range strings.Fields(s) {
+		times := 1
+
+		if i := strings.IndexByte(token, 'x'); i >= 0 {
+			n, err := strconv.Atoi(token[i+1:])
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("invalid repetition %s", token)
+			}
+			times = n
+			token = token[:i]
+		}
+
+		var value *int
+		if token != "_" {
+			v, err := strconv.Atoi(token)
+			if err != nil {
+				return nil, fmt.Errorf("invalid value %s", token)
+			}
+			value = &v
+		}
+
+		for i := 0; i < times; i++ {
+			values = append(values, value)
+		}
+	}
+
+	return values, nil
+}
+
+// check compares the transitions with the expected ones
+func (r *Result) check(expect []Expectation) {
+	for _, e := range expect {
+		fires := make([]time.Duration, 0)
+		resolves := make([]time.Duration, 0)
+
+		for _, t := range r.Transitions {
+			if t.Rule != e.Rule {
+				continue
+			}
+			if t.Severity == message.SeverityCanceled {
+				resolves = append(resolves, t.Time.Sub(r.Start))
+			} else {
+				fires = append(fires, t.Time.Sub(r.Start))
+			}
+		}
+
+		if !equal(fires, e.Fires) {
+			r.Failures = append(r.Failures, fmt.Sprintf("rule %s: expected to fire at %v, fired at %v", e.Rule, e.Fires, fires))
+		}
+		if !equal(resolves, e.Resolves) {
+			r.Failures = append(r.Failures, fmt.Sprintf("rule %s: expected to resolve at %v, resolved at %v", e.Rule, e.Resolves, resolves))
+		}
+	}
+}
+
+func equal(a, b []time.Duration) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
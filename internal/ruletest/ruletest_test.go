@@ -0,0 +1,105 @@
+package ruletest
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	tassert "github.com/stretchr/testify/assert"
+)
+
+const rules = `
+rules:
+  - name: high-traffic
+    metric: requests.total
+    window: 1m
+    threshold: 10
+  - name: api-errors
+    type: expression
+    expr: '"path./api.status.5*.requests" / "path./api.requests"'
+    window: 1m
+    threshold: 0.2
+`
+
+const spec = `
+rules: rules.yaml
+interval: 10s
+series:
+  requests.total: "50x12 200x12 50x12"
+expect:
+  - rule: high-traffic
+    fires: [2m30s]
+    resolves: [4m40s]
+`
+
+const log = `127.0.0.1 - - [01/Jan/2021:00:00:05 +0000] "GET /api/users HTTP/1.0" 200 100
+127.0.0.1 - - [01/Jan/2021:00:00:15 +0000] "GET /api/users HTTP/1.0" 503 100
+127.0.0.1 - - [01/Jan/2021:00:00:25 +0000] "GET /api/users HTTP/1.0" 200 100
+127.0.0.1 - - [01/Jan/2021:00:01:35 +0000] "GET /api/users HTTP/1.0" 200 100
+`
+
+const logSpec = `
+rules: rules.yaml
+log: access.log
+duration: 3m
+expect:
+  - rule: api-errors
+    fires: [20s]
+    resolves: [1m20s]
+`
+
+func TestInternalRuletest(t *testing.T) {
+
+	assert := tassert.New(t)
+
+	dir, err := ioutil.TempDir("", "ruletest")
+	assert.Nil(err, "err nil")
+	defer os.RemoveAll(dir)
+
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		assert.Nil(ioutil.WriteFile(path, []byte(content), 0644), "err nil")
+		return path
+	}
+
+	write("rules.yaml", rules)
+	write("access.log", log)
+
+	t.Run("Run - series fixture", func(t *testing.T) {
+		result, err := Run(write("spec.yaml", spec), "")
+		assert.Nil(err, "err nil")
+		assert.Equal(0, len(result.Failures), "no failures: %v", result.Failures)
+		assert.Equal(2, len(result.Transitions), "fired and resolved")
+	})
+
+	t.Run("Run - access log fixture", func(t *testing.T) {
+		result, err := Run(write("log.yaml", logSpec), "")
+		assert.Nil(err, "err nil")
+		assert.Equal(0, len(result.Failures), "no failures: %v", result.Failures)
+	})
+
+	t.Run("Run - mismatch", func(t *testing.T) {
+		result, err := Run(write("wrong.yaml", `
+rules: rules.yaml
+series:
+  requests.total: "50x12"
+expect:
+  - rule: high-traffic
+    fires: [1m]
+`), "")
+		assert.Nil(err, "err nil")
+		assert.Equal([]string{"rule high-traffic: expected to fire at [1m0s], fired at []"}, result.Failures)
+	})
+
+	t.Run("parseSeries - repetitions and gaps", func(t *testing.T) {
+		values, err := parseSeries("1x2 _ 3")
+		assert.Nil(err, "err nil")
+		assert.Equal(4, len(values), "four values")
+		assert.Equal(1, *values[1])
+		assert.Nil(values[2], "no value")
+
+		_, err = parseSeries("1xa")
+		assert.Equal("invalid repetition 1xa", err.Error())
+	})
+}
@@ -0,0 +1,182 @@
+package slo
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Good defines the requests that count as good for an SLO
+type Good struct {
+	// StatusBelow is the first status code considered an error, 500 by default
+	StatusBelow int `yaml:"status_below" json:"status_below"`
+	// LatencyBelow, if set, is the first latency considered an error. Entries
+	// without latency are good
+	LatencyBelow time.Duration `yaml:"latency_below" json:"latency_below"`
+}
+
+// BurnWindow defines a multi window burn rate alert: it fires when the error
+// budget is burnt BurnRate times faster than allowed over both windows
+type BurnWindow struct {
+	Long     time.Duration `yaml:"long" json:"long"`
+	Short    time.Duration `yaml:"short" json:"short"`
+	BurnRate float64       `yaml:"burn_rate" json:"burn_rate"`
+}
+
+// SLO defines an availability objective over the requests to a path
+type SLO struct {
+	Name string `yaml:"name" json:"name"`
+	// Path is the path of the requests of the SLO, its subpaths included, like
+	// /api and /api/users for /api. All the requests if empty
+	Path string `yaml:"path" json:"path"`
+	// Objective is the fraction of good requests, like 0.999
+	Objective float64       `yaml:"objective" json:"objective"`
+	Period    time.Duration `yaml:"period" json:"period"`
+	Good      Good          `yaml:"good" json:"good"`
+	Windows   []BurnWindow  `yaml:"windows" json:"windows"`
+}
+
+// DefaultWindows are the burn rate alerts used when an SLO doesn't define them,
+// 2% and 5% of a 30 days budget spent in 1 and 6 hours
+func DefaultWindows() []BurnWindow {
+	return []BurnWindow{
+		{Long: time.Hour, Short: 5 * time.Minute, BurnRate: 14.4},
+		{Long: 6 * time.Hour, Short: 30 * time.Minute, BurnRate: 6},
+	}
+}
+
+// setDefaults fills the optional fields of the SLO
+func (s *SLO) setDefaults() {
+	if s.Period == 0 {
+		s.Period = 30 * 24 * time.Hour
+	}
+	if s.Good.StatusBelow == 0 {
+		s.Good.StatusBelow = 500
+	}
+	if len(s.Windows) == 0 {
+		s.Windows = DefaultWindows()
+	}
+}
+
+// Validate checks the SLO is complete and consistent
+func (s *SLO) Validate() error {
+	if s.Name == "" {
+		return fmt.Errorf("slo name is required")
+	}
+	if strings.ContainsAny(s.Name, ". ") {
+		return fmt.Errorf("slo %s: name can't contain dots or spaces", s.Name)
+	}
+	if s.Objective <= 0 || s.Objective >= 1 {
+		return fmt.Errorf("slo %s: objective must be between 0 and 1", s.Name)
+	}
+	if s.Period < time.Hour {
+		return fmt.Errorf("slo %s: period must be at least 1h", s.Name)
+	}
+
+	for _, w := range s.Windows {
+		if w.Short < time.Second || w.Long <= w.Short {
+			return fmt.Errorf("slo %s: burn windows need a short window of at least 1s, shorter than the long one", s.Name)
+		}
+		if w.Long > s.Period {
+			return fmt.Errorf("slo %s: burn windows can't be longer than the period", s.Name)
+		}
+		if w.BurnRate <= 0 {
+			return fmt.Errorf("slo %s: burn rate must be positive", s.Name)
+		}
+	}
+
+	return nil
+}
+
+// Matches returns if a request to path counts for the SLO, the path of the
+// SLO or a subpath, /api/users but not /apiary for /api
+func (s *SLO) Matches(path string) bool {
+	if s.Path == "" || path == s.Path {
+		return true
+	}
+
+	return strings.HasPrefix(path, strings.TrimSuffix(s.Path, "/")+"/")
+}
+
+// IsGood returns if a request with status and latency is good
+func (s *SLO) IsGood(status int, latency time.Duration) bool {
+	if status >= s.Good.StatusBelow {
+		return false
+	}
+
+	return s.Good.LatencyBelow == 0 || latency < s.Good.LatencyBelow
+}
+
+// GoodMetric returns the name of the stats metric counting good requests
+func (s *SLO) GoodMetric() string {
+	return "slo." + s.Name + ".good"
+}
+
+// TotalMetric returns the name of the stats metric counting all the requests
+func (s *SLO) TotalMetric() string {
+	return "slo." + s.Name + ".total"
+}
+
+// Budget returns the fraction of requests allowed to fail
+func (s *SLO) Budget() float64 {
+	return 1 - s.Objective
+}
+
+// LongestWindow returns the longest burn window of the SLO
+func (s *SLO) LongestWindow() time.Duration {
+	var longest time.Duration
+	for _, w := range s.Windows {
+		if w.Long > longest {
+			longest = w.Long
+		}
+	}
+	return longest
+}
+
+// Check fills the optional fields of the SLO with their defaults, and validates it
+func (s *SLO) Check() error {
+	s.setDefaults()
+	return s.Validate()
+}
+
+// Parse parses and validates the list of SLOs in yaml
+func Parse(data []byte) ([]SLO, error) {
+	var file struct {
+		SLOs []SLO `yaml:"slos"`
+	}
+
+	err := yaml.Unmarshal(data, &file)
+	if err != nil {
+		return nil, fmt.Errorf("slo: invalid slos: %v", err)
+	}
+
+	names := make(map[string]bool)
+	for i := range file.SLOs {
+		s := &file.SLOs[i]
+
+		err = s.Check()
+		if err != nil {
+			return nil, fmt.Errorf("slo: invalid slo: %v", err)
+		}
+
+		if names[s.Name] {
+			return nil, fmt.Errorf("slo: duplicated slo name %s", s.Name)
+		}
+		names[s.Name] = true
+	}
+
+	return file.SLOs, nil
+}
+
+// Load reads the SLOs defined in the file at path
+func Load(path string) ([]SLO, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("slo: failed reading file %s: %v", path, err)
+	}
+
+	return Parse(data)
+}
@@ -0,0 +1,70 @@
+package slo
+
+import (
+	"testing"
+	"time"
+
+	tassert "github.com/stretchr/testify/assert"
+)
+
+func TestInternalSLO(t *testing.T) {
+
+	assert := tassert.New(t)
+
+	t.Run("Parse - defaults and validation", func(t *testing.T) {
+		slos, err := Parse([]byte(`
+slos:
+  - name: api-availability
+    path: /api
+    objective: 0.999
+    good:
+      latency_below: 300ms
+`))
+		assert.Nil(err, "err nil")
+		assert.Equal(1, len(slos), "one slo")
+		assert.Equal(30*24*time.Hour, slos[0].Period, "default period")
+		assert.Equal(500, slos[0].Good.StatusBelow, "default status")
+		assert.Equal(DefaultWindows(), slos[0].Windows, "default windows")
+		assert.Equal(6*time.Hour, slos[0].LongestWindow(), "longest window")
+		assert.InDelta(0.001, slos[0].Budget(), 1e-9, "budget")
+
+		_, err = Parse([]byte(`
+slos:
+  - name: broken
+    objective: 99.9
+`))
+		assert.Equal("slo: invalid slo: slo broken: objective must be between 0 and 1", err.Error())
+
+		_, err = Parse([]byte(`
+slos:
+  - name: broken
+    objective: 0.99
+    windows:
+      - long: 5m
+        short: 1h
+        burn_rate: 2
+`))
+		assert.Equal("slo: invalid slo: slo broken: burn windows need a short window of at least 1s, shorter than the long one", err.Error())
+	})
+
+	t.Run("IsGood - status and latency", func(t *testing.T) {
+		s := SLO{Name: "api", Path: "/api", Objective: 0.99}
+		s.setDefaults()
+
+		assert.True(s.Matches("/api/users"), "path matches")
+		assert.True(s.Matches("/api"), "same path")
+		assert.False(s.Matches("/admin"), "path doesn't match")
+		assert.False(s.Matches("/apiary"), "path with the same prefix doesn't match")
+
+		root := SLO{Path: "/"}
+		assert.True(root.Matches("/api"), "root path matches all")
+		all := SLO{}
+		assert.True(all.Matches("/api"), "no path matches all")
+		assert.True(s.IsGood(404, 0), "client errors are good")
+		assert.False(s.IsGood(503, 0), "server errors are bad")
+
+		s.Good.LatencyBelow = 300 * time.Millisecond
+		assert.True(s.IsGood(200, 0), "no latency is good")
+		assert.False(s.IsGood(200, time.Second), "slow requests are bad")
+	})
+}
@@ -2,13 +2,16 @@ package stats
 
 import (
 	"sync"
-	"time"
+
+	"github.com/juacker/loghound/internal/clock"
+	"github.com/juacker/loghound/internal/message"
 )
 
 type cache struct {
 	sync.Mutex
 	metrics map[string]int
 	reset   int64
+	clock   clock.Clock
 }
 
 func (c *cache) Increment(metric string, value int) {
@@ -22,7 +25,7 @@ func (c *cache) Stats() (map[string]int, int64, int64) {
 	c.Lock()
 	defer c.Unlock()
 
-	now := time.Now().Unix()
+	now := c.clock.Now().Unix()
 	begin := c.reset
 	c.reset = now
 
@@ -35,3 +38,35 @@ func (c *cache) Stats() (map[string]int, int64, int64) {
 
 	return stats, begin, now
 }
+
+// Counter counts the metrics of log messages like the stats module does,
+// out of the pipeline
+type Counter struct {
+	cache    *cache
+	counting *counting
+}
+
+// NewCounter returns a counter of the requests as set in c
+func NewCounter(c Counting) (*Counter, error) {
+	counting, err := newCounting(c)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Counter{
+		cache:    &cache{metrics: make(map[string]int), clock: clock.New()},
+		counting: counting,
+	}, nil
+}
+
+// Add counts a log message
+func (c *Counter) Add(msg *message.CLFMessage) error {
+	return countCLFMessage(c.cache, msg, c.counting)
+}
+
+// Stats returns the metrics counted since the previous call. As in the stats
+// module, metrics seen before are returned with 0 values
+func (c *Counter) Stats() map[string]int {
+	stats, _, _ := c.cache.Stats()
+	return stats
+}
@@ -0,0 +1,160 @@
+package stats
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/juacker/loghound/internal/slo"
+)
+
+// Metric families counted by stats
+const (
+	// FamilyTotals are requests.total and bytes.total
+	FamilyTotals = "totals"
+	// FamilyPaths are path.<path>.requests and path.<path>.bytes
+	FamilyPaths = "paths"
+	// FamilyStatus are path.<path>.status.<status>.requests
+	FamilyStatus = "status"
+	// FamilyMethods are path.<path>.method.<method>.bytes
+	FamilyMethods = "methods"
+	// FamilyTermination are termination.<flag>.requests, for the requests a
+	// proxy logged as not ended normally, like termination.CD.requests
+	FamilyTermination = "termination"
+)
+
+// Families are all the metric families
+var Families = []string{FamilyTotals, FamilyPaths, FamilyStatus, FamilyMethods, FamilyTermination}
+
+// MaxParamValues is the number of values of a query parameter counted apart,
+// later values are counted as param.<name>.other.requests
+const MaxParamValues = 20
+
+// PathRule groups the request paths matching the regular expression Match
+// under Name, which can refer to its submatches, like /api/$1
+type PathRule struct {
+	Match string `yaml:"match" json:"match"`
+	Name  string `yaml:"name" json:"name"`
+}
+
+// Counting sets how stats count the requests
+type Counting struct {
+	// Paths are the rules to group request paths, the first matching rule is used.
+	// Paths not matching any rule are grouped by their first segment
+	Paths []PathRule
+	// Families are the metric families counted, all if empty
+	Families []string
+	// Params are the query parameters counted by value, as
+	// param.<name>.<value>.requests. Each value is a metric, so only
+	// parameters with a few values, like api_version, should be counted.
+	// Past MaxParamValues values, the rest are counted as other
+	Params []string
+	// SLOs get their good and total requests counted
+	SLOs []slo.SLO
+}
+
+// counting is a Counting ready to count requests
+type counting struct {
+	paths    []*regexp.Regexp
+	names    []string
+	families map[string]bool
+	params   []string
+	// values are the values of each parameter counted apart
+	values map[string]map[string]bool
+	slos   []slo.SLO
+}
+
+func newCounting(c Counting) (*counting, error) {
+	compiled := &counting{
+		paths:    make([]*regexp.Regexp, 0, len(c.Paths)),
+		names:    make([]string, 0, len(c.Paths)),
+		families: make(map[string]bool),
+		params:   c.Params,
+		values:   make(map[string]map[string]bool, len(c.Params)),
+		slos:     c.SLOs,
+	}
+
+	for _, p := range c.Paths {
+		re, err := regexp.Compile(p.Match)
+		if err != nil {
+			return nil, fmt.Errorf("stats: invalid path rule %s: %v", p.Match, err)
+		}
+		if p.Name == "" {
+			return nil, fmt.Errorf("stats: path rule %s without name", p.Match)
+		}
+		compiled.paths = append(compiled.paths, re)
+		compiled.names = append(compiled.names, p.Name)
+	}
+
+	for _, p := range c.Params {
+		if p == "" || strings.Contains(p, ".") {
+			return nil, fmt.Errorf("stats: invalid query parameter name %q", p)
+		}
+		compiled.values[p] = make(map[string]bool)
+	}
+
+	families := c.Families
+	if len(families) == 0 {
+		families = Families
+	}
+	for _, f := range families {
+		if !isFamily(f) {
+			return nil, fmt.Errorf("stats: unknown metric family %s, expected one of %s", f, strings.Join(Families, ", "))
+		}
+		compiled.families[f] = true
+	}
+
+	return compiled, nil
+}
+
+// Validate checks the path rules, metric families and query parameters
+func (c *Counting) Validate() error {
+	_, err := newCounting(*c)
+	return err
+}
+
+func isFamily(f string) bool {
+	for _, family := range Families {
+		if f == family {
+			return true
+		}
+	}
+	return false
+}
+
+// path returns the name of the path group of a request path
+func (c *counting) path(p string) (string, error) {
+	for i, re := range c.paths {
+		match := re.FindStringSubmatchIndex(p)
+		if match != nil {
+			return string(re.ExpandString(nil, c.names[i], p, match)), nil
+		}
+	}
+
+	// root path
+	paths := strings.Split(p, "/")
+	if len(paths) == 0 {
+		return "", fmt.Errorf("invalid path detected")
+	} else if len(paths) == 1 {
+		return "/", nil
+	}
+	return "/" + paths[1], nil
+}
+
+// param returns the metric segment of a value of the query parameter name: the
+// value with its dots replaced, - if empty, or other past MaxParamValues values
+func (c *counting) param(name, value string) string {
+	if value == "" {
+		return "-"
+	}
+	value = strings.Replace(value, ".", "_", -1)
+
+	values := c.values[name]
+	if !values[value] {
+		if len(values) >= MaxParamValues {
+			return "other"
+		}
+		values[value] = true
+	}
+	return value
+}
@@ -4,12 +4,18 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"sort"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/juacker/loghound/internal/broker"
+	"github.com/juacker/loghound/internal/clock"
+	"github.com/juacker/loghound/internal/health"
+	"github.com/juacker/loghound/internal/journal"
 	"github.com/juacker/loghound/internal/message"
+	"github.com/juacker/loghound/internal/slo"
+	"github.com/juacker/loghound/pkg/clf"
 )
 
 type statsMonitor struct {
@@ -18,12 +24,24 @@ type statsMonitor struct {
 	interval int64
 	broker   broker.Link
 	cache    *cache
+	journal  *journal.Journal
+	replay   time.Duration
+	started  time.Time
+	until    uint64
+	history  []*message.StatMessage
+	// sending is closed once the history is sent, live stats are held in
+	// pending meanwhile so they are sent after it, in time order
+	sending  chan struct{}
+	pending  []*message.StatMessage
+	counting *counting
+	clock    clock.Clock
 }
 
 func (s *statsMonitor) loop() {
 	log.Println("stats: initializing stats monitoring")
 
-	ticker := time.NewTicker(time.Duration(s.interval) * time.Second)
+	ticker := s.clock.NewTicker(time.Duration(s.interval) * time.Second)
+	defer ticker.Stop()
 
 LOOP:
 	for {
@@ -35,6 +53,14 @@ LOOP:
 				log.Println("stats: failed processing message: ", err)
 			}
 		case <-ticker.C:
+			// send history once the rest of modules are already subscribed. It's
+			// sent apart so data messages keep being drained meanwhile
+			if len(s.history) > 0 {
+				s.sending = make(chan struct{})
+				go s.sendHistory(s.history, s.sending)
+				s.history = nil
+			}
+
 			log.Println("stats: sending stats")
 			err := s.sendStats()
 			if err != nil {
@@ -50,32 +76,68 @@ LOOP:
 }
 
 func (s *statsMonitor) processMessage(payload []byte) error {
-	var msg message.CLFMessage
+	var msg message.Message
 	err := json.Unmarshal(payload, &msg)
 	if err != nil {
 		return err
 	}
 
-	// check message is the expected
-	if !msg.IsValid() {
-		return fmt.Errorf("invalid message")
+	switch msg.Type {
+	case message.TypeCLF:
+		var clfMsg message.CLFMessage
+		err = json.Unmarshal(payload, &clfMsg)
+		if err != nil {
+			return err
+		}
+
+		return s.processCLFMessage(&clfMsg)
+	case message.TypeConfig:
+		var configMsg message.ConfigMessage
+		err = json.Unmarshal(payload, &configMsg)
+		if err != nil {
+			return err
+		}
+
+		return s.processConfigMessage(&configMsg)
 	}
 
-	return s.processCLFMessage(&msg)
+	return fmt.Errorf("invalid message")
 }
 
 func (s *statsMonitor) processCLFMessage(msg *message.CLFMessage) error {
+	return countCLFMessage(s.cache, msg, s.counting)
+}
+
+// processConfigMessage counts the requests of the reloaded slos from now on
+func (s *statsMonitor) processConfigMessage(msg *message.ConfigMessage) error {
+	var slos []slo.SLO
+	err := json.Unmarshal(msg.SLOs, &slos)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("stats: slos reloaded, counting requests for %d slos", len(slos))
+
+	// counting is shared with the rebuild of the history, replaced as a whole
+	counting := *s.counting
+	counting.slos = slos
+	s.counting = &counting
+	return nil
+}
+
+func countCLFMessage(c *cache, msg *message.CLFMessage, counting *counting) error {
+
+	// journal records written before requests were split keep the query string in the path
+	request := msg.Request
+	if request.Target == "" {
+		request = clf.NewRequest(request.Method, request.Path)
+	}
 
 	// Process message fields
-	// root path
-	paths := strings.Split(msg.Request.Path, "/")
-	var rootPath string
-	if len(paths) == 0 {
-		return fmt.Errorf("invalid path detected")
-	} else if len(paths) == 1 {
-		rootPath = "/"
-	} else {
-		rootPath = "/" + paths[1]
+	// path group, the root path by default
+	rootPath, err := counting.path(request.Path)
+	if err != nil {
+		return err
 	}
 
 	// status
@@ -83,23 +145,58 @@ func (s *statsMonitor) processCLFMessage(msg *message.CLFMessage) error {
 
 	// create some metrics
 
-	// metric: requests.total
-	s.cache.Increment("requests.total", 1)
+	if counting.families[FamilyTotals] {
+		// metric: requests.total
+		c.Increment("requests.total", 1)
+
+		// metric: bytes.total
+		c.Increment("bytes.total", msg.Bytes)
+	}
 
-	// metric: bytes.total
-	s.cache.Increment("bytes.total", msg.Bytes)
+	if counting.families[FamilyPaths] {
+		// metric: path.<path>.requests
+		c.Increment("path."+rootPath+".requests", 1)
+
+		// metric: path.<path>.bytes
+		c.Increment("path."+rootPath+".bytes", msg.Bytes)
+	}
+
+	if counting.families[FamilyStatus] {
+		// metric: path.<path>.status.<status>.requests
+		c.Increment("path."+rootPath+".status."+status+".requests", 1)
+	}
+
+	if counting.families[FamilyMethods] {
+		// metric: path.<path>.method.<method>.bytes
+		c.Increment("path."+rootPath+".method."+request.Method+".bytes", msg.Bytes)
+	}
 
-	// metric: path.<path>.requests
-	s.cache.Increment("path."+rootPath+".requests", 1)
+	if counting.families[FamilyTermination] && msg.Termination != "" {
+		// metric: termination.<flag>.requests, for each of the flags like UF,URX
+		for _, flag := range strings.Split(msg.Termination, ",") {
+			c.Increment("termination."+flag+".requests", 1)
+		}
+	}
 
-	// metric: path.<path>.bytes
-	s.cache.Increment("path."+rootPath+".bytes", msg.Bytes)
+	// metric: param.<name>.<value>.requests
+	for _, name := range counting.params {
+		for _, v := range request.Query[name] {
+			c.Increment("param."+name+"."+counting.param(name, v)+".requests", 1)
+		}
+	}
 
-	// metric: path.<path>.status.<status>.requests
-	s.cache.Increment("path."+rootPath+".status."+status+".requests", 1)
+	// metrics: slo.<name>.total and slo.<name>.good
+	slos := counting.slos
+	for i := range slos {
+		if !slos[i].Matches(request.Path) {
+			continue
+		}
 
-	// metric: path.<path>.method.<method>.bytes
-	s.cache.Increment("path."+rootPath+".method."+msg.Request.Method+".bytes", msg.Bytes)
+		c.Increment(slos[i].TotalMetric(), 1)
+		if slos[i].IsGood(msg.Status, msg.Latency) {
+			c.Increment(slos[i].GoodMetric(), 1)
+		}
+	}
 
 	return nil
 }
@@ -107,16 +204,111 @@ func (s *statsMonitor) processCLFMessage(msg *message.CLFMessage) error {
 func (s *statsMonitor) sendStats() error {
 
 	stats, begin, end := s.cache.Stats()
-	return s.broker.Send(broker.TopicStat, message.NewStatMessage(stats, begin, end))
+	health.Set(health.CacheMetrics, float64(len(stats)))
+
+	s.pending = append(s.pending, message.NewStatMessage(stats, begin, end))
+	if s.sending != nil {
+		select {
+		case <-s.sending:
+			s.sending = nil
+		default:
+			log.Println("stats: history still being sent, holding stats")
+			return nil
+		}
+	}
+
+	pending := s.pending
+	s.pending = nil
+	for _, msg := range pending {
+		err := s.broker.Send(broker.TopicStat, msg)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
-// Run starts stats
-func Run(wg *sync.WaitGroup, ctl chan bool, interval int64) {
-	conn, err := broker.NewConnection(broker.TopicData)
+// rebuild replays the journal records written during the replay period before
+// startup, and returns the stats they generate, one message per interval
+func (s *statsMonitor) rebuild() ([]*message.StatMessage, error) {
+	from, err := s.journal.OffsetAt(s.started.Add(-s.replay))
+	if err != nil {
+		return nil, err
+	}
+
+	log.Println("stats: rebuilding stats from journal offset ", from)
+
+	buckets := make(map[int64]*cache)
+	err = s.journal.ReadFrom(from, func(r journal.Record) error {
+		if r.Offset >= s.until {
+			return nil
+		}
+
+		var msg message.CLFMessage
+		err := json.Unmarshal(r.Payload, &msg)
+		if err != nil || !msg.IsValid() {
+			log.Println("stats: skipping invalid journal record ", r.Offset)
+			return nil
+		}
+
+		// buckets are aligned backwards from startup time
+		bucket := (s.started.Unix() - r.Timestamp.Unix() - 1) / s.interval
+		if _, ok := buckets[bucket]; !ok {
+			buckets[bucket] = &cache{metrics: make(map[string]int)}
+		}
+
+		return countCLFMessage(buckets[bucket], &msg, s.counting)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]int64, 0, len(buckets))
+	for k := range buckets {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(a, b int) bool { return keys[a] > keys[b] })
+
+	history := make([]*message.StatMessage, 0, len(keys))
+	for _, k := range keys {
+		end := s.started.Unix() - k*s.interval
+		msg := message.NewStatMessage(buckets[k].metrics, end-s.interval, end)
+		msg.Rebuilt = true
+		history = append(history, msg)
+	}
+
+	return history, nil
+}
+
+// sendHistory sends the stats rebuilt from the journal, oldest first, and
+// closes done when finished
+func (s *statsMonitor) sendHistory(history []*message.StatMessage, done chan struct{}) {
+	defer close(done)
+
+	for _, msg := range history {
+		err := s.broker.Send(broker.TopicStat, msg)
+		if err != nil {
+			log.Println("stats: failed sending rebuilt stats: ", err)
+			return
+		}
+	}
+}
+
+// Run starts stats. If a journal is given, stats for the replay period
+// before startup are rebuilt from it. Requests are counted as set in counting,
+// and stats are sent every interval of the clock time
+func Run(wg *sync.WaitGroup, ctl chan bool, interval int64, j *journal.Journal, replay time.Duration, c Counting, clk clock.Clock) {
+	conn, err := broker.NewConnection("stats", broker.TopicData, broker.TopicConfig)
 	if err != nil {
 		log.Fatal("stats: failed opening broker connection ", err)
 	}
 
+	counting, err := newCounting(c)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	stats := &statsMonitor{
 		ctl:      ctl,
 		wg:       wg,
@@ -124,8 +316,24 @@ func Run(wg *sync.WaitGroup, ctl chan bool, interval int64) {
 		interval: interval,
 		cache: &cache{
 			metrics: make(map[string]int),
-			reset:   time.Now().Unix(),
+			reset:   clk.Now().Unix(),
+			clock:   clk,
 		},
+		journal:  j,
+		replay:   replay,
+		started:  clk.Now(),
+		counting: counting,
+		clock:    clk,
+	}
+
+	// the journal is read before any message is received, so it holds only
+	// the records written before startup
+	if j != nil && replay > 0 {
+		stats.until = j.NextOffset()
+		stats.history, err = stats.rebuild()
+		if err != nil {
+			log.Println("stats: failed rebuilding stats from journal: ", err)
+		}
 	}
 
 	stats.loop()
@@ -0,0 +1,167 @@
+package stats
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/juacker/loghound/internal/broker"
+	"github.com/juacker/loghound/internal/clock"
+	"github.com/juacker/loghound/internal/message"
+	"github.com/juacker/loghound/internal/testutils"
+	"github.com/juacker/loghound/pkg/clf"
+	tassert "github.com/stretchr/testify/assert"
+)
+
+// entry returns a message of a request to target
+func entry(target string, status int) *message.CLFMessage {
+	return message.NewCLFMessage(&clf.Entry{
+		Request: clf.NewRequest("GET", target),
+		Status:  status,
+		Bytes:   10,
+	})
+}
+
+func TestInternalStats(t *testing.T) {
+
+	assert := tassert.New(t)
+
+	t.Run("cache - stats of the clock intervals", func(t *testing.T) {
+		start := time.Date(2021, 1, 1, 10, 0, 0, 0, time.UTC)
+		c := clock.NewFake(start)
+		cache := &cache{metrics: make(map[string]int), reset: start.Unix(), clock: c}
+
+		cache.Increment("requests.total", 2)
+		c.Advance(10 * time.Second)
+
+		stats, begin, end := cache.Stats()
+		assert.Equal(map[string]int{"requests.total": 2}, stats, "stats of the interval")
+		assert.Equal(start.Unix(), begin, "interval begin")
+		assert.Equal(start.Unix()+10, end, "interval end at the clock time")
+
+		c.Advance(5 * time.Second)
+		stats, begin, end = cache.Stats()
+		assert.Equal(map[string]int{"requests.total": 0}, stats, "metrics seen before with 0 values")
+		assert.Equal(start.Unix()+10, begin, "next interval from the previous end")
+		assert.Equal(start.Unix()+15, end, "interval end at the clock time")
+	})
+
+	t.Run("countCLFMessage - params by value", func(t *testing.T) {
+		c, err := NewCounter(Counting{Families: []string{FamilyTotals}, Params: []string{"api_version", "v"}})
+		assert.Nil(err, "err nil")
+
+		assert.Nil(c.Add(entry("/users?api_version=2&page=3", 200)), "err nil")
+		assert.Nil(c.Add(entry("/users?api_version=2&api_version=3", 200)), "err nil")
+		assert.Nil(c.Add(entry("/users?api_version=", 200)), "err nil")
+		assert.Nil(c.Add(entry("/users?v=1.2.0", 200)), "err nil")
+		assert.Nil(c.Add(entry("/users", 200)), "err nil")
+
+		assert.Equal(map[string]int{
+			"requests.total":               5,
+			"bytes.total":                  50,
+			"param.api_version.2.requests": 2,
+			"param.api_version.3.requests": 1,
+			"param.api_version.-.requests": 1,
+			"param.v.1_2_0.requests":       1,
+		}, c.Stats(), "params counted, dots replaced")
+	})
+
+	t.Run("countCLFMessage - params values limited", func(t *testing.T) {
+		c, err := NewCounter(Counting{Families: []string{FamilyTotals}, Params: []string{"id"}})
+		assert.Nil(err, "err nil")
+
+		for i := 0; i < MaxParamValues+5; i++ {
+			assert.Nil(c.Add(entry(fmt.Sprintf("/users?id=%d", i), 200)), "err nil")
+		}
+		assert.Nil(c.Add(entry("/users?id=0", 200)), "err nil")
+
+		stats := c.Stats()
+		assert.Equal(MaxParamValues+3, len(stats), "values, other and totals")
+		assert.Equal(2, stats["param.id.0.requests"], "values seen keep counting")
+		assert.Equal(5, stats["param.id.other.requests"], "values past the limit")
+	})
+
+	t.Run("countCLFMessage - requests without response", func(t *testing.T) {
+		c, err := NewCounter(Counting{Families: []string{FamilyTotals, FamilyStatus}})
+		assert.Nil(err, "err nil")
+
+		gcp, err := clf.ParseGCP(`{"timestamp":"2021-01-01T00:00:00Z","httpRequest":{"requestMethod":"GET","requestUrl":"https://example.com/users","remoteIp":"1.2.3.4"}}`)
+		assert.Nil(err, "err nil")
+		assert.Nil(c.Add(message.NewCLFMessage(gcp)), "err nil")
+
+		stats := c.Stats()
+		assert.Equal(1, stats["requests.total"], "request counted")
+		assert.Equal(1, stats["path./users.status.0.requests"], "counted with status 0")
+	})
+
+	t.Run("countCLFMessage - termination flags", func(t *testing.T) {
+		c, err := NewCounter(Counting{Families: []string{FamilyTermination}})
+		assert.Nil(err, "err nil")
+
+		haproxy, err := clf.ParseHAProxy(`haproxy[14389]: 10.0.1.2:33317 [06/Feb/2009:12:14:14.655] http-in static/srv1 10/0/30/69/109 200 2750 - - CD-- 1/1/1/1/0 0/0 {1wt.eu} {} "GET /index.html HTTP/1.1"`)
+		assert.Nil(err, "err nil")
+		assert.Nil(c.Add(message.NewCLFMessage(haproxy)), "err nil")
+
+		envoy, err := clf.ParseEnvoy(`[2016-04-15T20:17:00.310Z] "POST /api/v1/locations HTTP/2" 503 UF,URX 154 0 226 - "10.0.35.28" "nsq2http" "cc21d9b0" "locations" "-"`)
+		assert.Nil(err, "err nil")
+		assert.Nil(c.Add(message.NewCLFMessage(envoy)), "err nil")
+
+		// ended normally
+		assert.Nil(c.Add(entry("/", 200)), "err nil")
+
+		assert.Equal(map[string]int{
+			"termination.CD.requests":  1,
+			"termination.UF.requests":  1,
+			"termination.URX.requests": 1,
+		}, c.Stats(), "flags split")
+
+		c, err = NewCounter(Counting{Families: []string{FamilyTotals}})
+		assert.Nil(err, "err nil")
+		assert.Nil(c.Add(message.NewCLFMessage(envoy)), "err nil")
+		_, ok := c.Stats()["termination.UF.requests"]
+		assert.False(ok, "family not counted")
+	})
+
+	t.Run("loop - history sent before the live stats", func(t *testing.T) {
+		start := time.Date(2021, 1, 1, 10, 0, 0, 0, time.UTC)
+		c := clock.NewFake(start)
+		l := &testutils.Recorder{}
+
+		history := make([]*message.StatMessage, 0)
+		for i := int64(5); i > 0; i-- {
+			msg := message.NewStatMessage(map[string]int{"requests.total": 1}, start.Unix()-i*10, start.Unix()-(i-1)*10)
+			msg.Rebuilt = true
+			history = append(history, msg)
+		}
+
+		var wg sync.WaitGroup
+		wg.Add(1)
+		ctl := make(chan bool)
+		s := &statsMonitor{
+			ctl:      ctl,
+			wg:       &wg,
+			interval: 10,
+			broker:   l,
+			cache:    &cache{metrics: make(map[string]int), reset: start.Unix(), clock: c},
+			history:  history,
+			clock:    c,
+		}
+		go s.loop()
+
+		c.BlockUntil(1)
+		c.Advance(10 * time.Second)
+		sent := l.Wait(broker.TopicStat, len(history), time.Second)
+		c.Advance(10 * time.Second)
+		sent = l.Wait(broker.TopicStat, len(history)+2, time.Second)
+
+		ctl <- true
+		wg.Wait()
+
+		assert.Equal(len(history)+2, len(sent), "history and live stats sent")
+		for i := 1; i < len(sent); i++ {
+			assert.True(sent[i-1].(*message.StatMessage).End < sent[i].(*message.StatMessage).End, "stats in time order")
+		}
+		assert.False(sent[len(sent)-1].(*message.StatMessage).Rebuilt, "live stats")
+	})
+}
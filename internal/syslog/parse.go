@@ -0,0 +1,230 @@
+package syslog
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Message is a syslog message, RFC 5424 or RFC 3164. Hostname and AppName are
+// empty if the sender didn't set them
+type Message struct {
+	Facility  int
+	Severity  int
+	Timestamp time.Time
+	Hostname  string
+	AppName   string
+	// Body is the message, the access log line
+	Body string
+}
+
+// layout of the RFC 3164 timestamps, without year
+const bsdLayout = "Jan _2 15:04:05"
+
+// Parse parses a syslog message, RFC 5424 if a version follows the priority,
+// like <165>1 2003-10-11T22:14:15.003Z host app - - - body, and RFC 3164
+// otherwise, like <34>Oct 11 22:14:15 host app[123]: body. The hostname of
+// RFC 3164 messages is optional, as many senders omit it, and it is only taken
+// when a tag follows it
+func Parse(s string) (*Message, error) {
+	s = strings.TrimRight(s, "\r\n\x00")
+
+	// <PRI>, 0 to 191
+	end := strings.IndexByte(s, '>')
+	if !strings.HasPrefix(s, "<") || end < 2 || end > 4 {
+		return nil, fmt.Errorf("syslog: missing priority: %q", s)
+	}
+	pri, err := strconv.Atoi(s[1:end])
+	if err != nil || pri < 0 || pri > 191 {
+		return nil, fmt.Errorf("syslog: invalid priority: %q", s[:end+1])
+	}
+
+	m := &Message{Facility: pri / 8, Severity: pri % 8}
+	s = s[end+1:]
+
+	if len(s) > 1 && s[0] >= '1' && s[0] <= '9' && s[1] == ' ' {
+		return m, m.parse5424(s[2:])
+	}
+	return m, m.parse3164(s)
+}
+
+// parse5424 parses the rest of a RFC 5424 message after the version:
+// TIMESTAMP HOSTNAME APP-NAME PROCID MSGID STRUCTURED-DATA [MSG]
+func (m *Message) parse5424(s string) error {
+	header := make([]string, 5)
+	for i := range header {
+		end := strings.IndexByte(s, ' ')
+		if end < 0 {
+			return fmt.Errorf("syslog: incomplete header: %q", s)
+		}
+		header[i], s = s[:end], s[end+1:]
+	}
+
+	if header[0] != "-" {
+		t, err := time.Parse(time.RFC3339Nano, header[0])
+		if err != nil {
+			return fmt.Errorf("syslog: invalid timestamp: %q", header[0])
+		}
+		m.Timestamp = t
+	}
+	if header[1] != "-" {
+		m.Hostname = header[1]
+	}
+	if header[2] != "-" {
+		m.AppName = header[2]
+	}
+
+	// structured data, - or [id param="value"]... with \] escaped in values
+	switch {
+	case strings.HasPrefix(s, "-"):
+		s = s[1:]
+	case strings.HasPrefix(s, "["):
+		end := structuredDataEnd(s)
+		if end < 0 {
+			return fmt.Errorf("syslog: unterminated structured data: %q", s)
+		}
+		s = s[end:]
+	default:
+		return fmt.Errorf("syslog: invalid structured data: %q", s)
+	}
+
+	// the message may start with a byte order mark
+	m.Body = strings.TrimPrefix(strings.TrimPrefix(s, " "), "\ufeff")
+	return nil
+}
+
+// structuredDataEnd returns the end of the structured data elements at the
+// start of s, -1 if they are not terminated
+func structuredDataEnd(s string) int {
+	quoted := false
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			if quoted {
+				i++
+			}
+		case '"':
+			quoted = !quoted
+		case ']':
+			if !quoted && (i+1 == len(s) || s[i+1] != '[') {
+				return i + 1
+			}
+		}
+	}
+	return -1
+}
+
+// parse3164 parses the rest of a RFC 3164 message after the priority:
+// TIMESTAMP [HOSTNAME] TAG: MSG
+func (m *Message) parse3164(s string) error {
+	if len(s) < len(bsdLayout)+1 || s[len(bsdLayout)] != ' ' {
+		return fmt.Errorf("syslog: invalid timestamp: %q", s)
+	}
+	t, err := time.ParseInLocation(bsdLayout, s[:len(bsdLayout)], time.Local)
+	if err != nil {
+		return fmt.Errorf("syslog: invalid timestamp: %q", s[:len(bsdLayout)])
+	}
+
+	// the year is not sent, it's the current one unless that's in the future
+	now := time.Now()
+	m.Timestamp = time.Date(now.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, time.Local)
+	if m.Timestamp.After(now.Add(24 * time.Hour)) {
+		m.Timestamp = m.Timestamp.AddDate(-1, 0, 0)
+	}
+	s = s[len(bsdLayout)+1:]
+
+	// the first word is the hostname only if a tag follows it, otherwise it
+	// may be the start of the body, like the client of a common log format line
+	first, rest := splitWord(s)
+	if !isTag(first) {
+		if next, _ := splitWord(rest); isTag(next) {
+			m.Hostname, s = first, rest
+		}
+	}
+
+	tag, body := splitWord(s)
+	if !isTag(tag) {
+		// no tag, all of it is the message
+		m.Body = s
+		return nil
+	}
+
+	m.AppName = strings.TrimSuffix(tag, ":")
+	if i := strings.IndexByte(m.AppName, '['); i >= 0 {
+		m.AppName = m.AppName[:i]
+	}
+	m.Body = body
+	return nil
+}
+
+// splitWord returns the first word of s and the rest after the space
+func splitWord(s string) (string, string) {
+	end := strings.IndexByte(s, ' ')
+	if end < 0 {
+		return s, ""
+	}
+	return s[:end], s[end+1:]
+}
+
+// isTag returns if word is a RFC 3164 tag, like app: or app[123]:
+func isTag(word string) bool {
+	if len(word) < 2 || !strings.HasSuffix(word, ":") {
+		return false
+	}
+
+	word = word[:len(word)-1]
+	if i := strings.IndexByte(word, '['); i >= 0 {
+		return i > 0 && strings.HasSuffix(word, "]")
+	}
+	return true
+}
+
+// splitFrames splits a tcp stream in syslog messages, octet counted like
+// 27 <34>Oct 11 22:14:15 app: x, or ended by a newline, as RFC 6587 frames
+// them. Each message may be framed either way
+func splitFrames(data []byte, atEOF bool) (int, []byte, error) {
+	// blank lines between messages
+	start := 0
+	for start < len(data) && (data[start] == '\n' || data[start] == '\r') {
+		start++
+	}
+	if start == len(data) {
+		return start, nil, nil
+	}
+	data = data[start:]
+
+	if data[0] >= '1' && data[0] <= '9' {
+		space := bytes.IndexByte(data, ' ')
+		if space < 0 {
+			if len(data) > 10 {
+				return 0, nil, fmt.Errorf("syslog: invalid frame length: %q", data[:10])
+			}
+			if atEOF {
+				return 0, nil, fmt.Errorf("syslog: truncated frame: %q", data)
+			}
+			return start, nil, nil
+		}
+
+		n, err := strconv.Atoi(string(data[:space]))
+		if err != nil {
+			return 0, nil, fmt.Errorf("syslog: invalid frame length: %q", data[:space])
+		}
+		if len(data) < space+1+n {
+			if atEOF {
+				return 0, nil, fmt.Errorf("syslog: truncated frame of %d octets", n)
+			}
+			return start, nil, nil
+		}
+		return start + space + 1 + n, data[space+1 : space+1+n], nil
+	}
+
+	if end := bytes.IndexByte(data, '\n'); end >= 0 {
+		return start + end + 1, bytes.TrimRight(data[:end], "\r"), nil
+	}
+	if atEOF {
+		return start + len(data), data, nil
+	}
+	return start, nil, nil
+}
@@ -0,0 +1,281 @@
+package syslog
+
+import (
+	"bufio"
+	"log"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/juacker/loghound/internal/broker"
+	"github.com/juacker/loghound/internal/health"
+	"github.com/juacker/loghound/internal/message"
+	"github.com/juacker/loghound/internal/rejects"
+	"github.com/juacker/loghound/pkg/clf"
+)
+
+// maximum size of the messages received, larger tcp frames end the connection
+const maxMessageSize = 64 << 10
+
+// maximum number of sources, by hostname and app name, tracked apart. Senders
+// choose their hostnames, so the messages of the sources beyond the limit share
+// otherSource and its parser and health metrics
+const maxSources = 256
+
+// source of the messages of the sources beyond maxSources
+const otherSource = "syslog://other"
+
+// frame is a syslog message received from addr
+type frame struct {
+	data string
+	addr net.Addr
+}
+
+type receiver struct {
+	sync.Mutex
+	ctl    chan bool
+	wg     *sync.WaitGroup
+	broker broker.Link
+	format string
+	// parsers of the access log lines by source, as they may keep state
+	parsers map[string]clf.Parser
+	// full is set once the sources reach maxSources
+	full    bool
+	rejects *rejects.Sink
+
+	frames chan frame
+	udp    net.PacketConn
+	tcp    net.Listener
+	conns  map[net.Conn]bool
+	done   chan struct{}
+}
+
+func newReceiver(ctl chan bool, wg *sync.WaitGroup, link broker.Link, format string, sink *rejects.Sink) *receiver {
+	return &receiver{
+		ctl:     ctl,
+		wg:      wg,
+		broker:  link,
+		format:  format,
+		parsers: make(map[string]clf.Parser),
+		rejects: sink,
+		frames:  make(chan frame),
+		conns:   make(map[net.Conn]bool),
+		done:    make(chan struct{}),
+	}
+}
+
+// listen starts receiving messages on addr, over udp and tcp
+func (r *receiver) listen(addr string) error {
+	udp, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return err
+	}
+
+	// the same port for tcp, the one picked for udp if addr has port 0
+	tcp, err := net.Listen("tcp", udp.LocalAddr().String())
+	if err != nil {
+		udp.Close()
+		return err
+	}
+
+	r.udp, r.tcp = udp, tcp
+	log.Println("syslog: listening on udp and tcp ", udp.LocalAddr())
+
+	go r.readUDP()
+	go r.acceptTCP()
+	return nil
+}
+
+func (r *receiver) loop() {
+LOOP:
+	for {
+		select {
+		case f := <-r.frames:
+			r.process(f)
+		case <-r.ctl:
+			log.Println("syslog: ctl signal received, exiting")
+			break LOOP
+		}
+	}
+
+	close(r.done)
+	r.udp.Close()
+	r.tcp.Close()
+
+	r.Lock()
+	for conn := range r.conns {
+		conn.Close()
+	}
+	r.Unlock()
+
+	r.wg.Done()
+}
+
+// readUDP receives a message per datagram
+func (r *receiver) readUDP() {
+	buf := make([]byte, maxMessageSize)
+	for {
+		n, addr, err := r.udp.ReadFrom(buf)
+		if err != nil {
+			select {
+			case <-r.done:
+			default:
+				log.Println("syslog: failed reading udp: ", err)
+			}
+			return
+		}
+
+		if !r.send(frame{data: string(buf[:n]), addr: addr}) {
+			return
+		}
+	}
+}
+
+func (r *receiver) acceptTCP() {
+	for {
+		conn, err := r.tcp.Accept()
+		if err != nil {
+			select {
+			case <-r.done:
+			default:
+				log.Println("syslog: failed accepting tcp connection: ", err)
+			}
+			return
+		}
+
+		r.Lock()
+		r.conns[conn] = true
+		r.Unlock()
+
+		go r.readTCP(conn)
+	}
+}
+
+// readTCP receives the messages of a connection, octet counted or ended by newlines
+func (r *receiver) readTCP(conn net.Conn) {
+	defer func() {
+		r.Lock()
+		delete(r.conns, conn)
+		r.Unlock()
+		conn.Close()
+	}()
+
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 4096), maxMessageSize)
+	scanner.Split(splitFrames)
+
+	for scanner.Scan() {
+		if !r.send(frame{data: scanner.Text(), addr: conn.RemoteAddr()}) {
+			return
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		select {
+		case <-r.done:
+		default:
+			log.Println("syslog: closing connection from ", conn.RemoteAddr(), ": ", err)
+		}
+	}
+}
+
+// send hands a frame to the loop, it returns false once the loop exited
+func (r *receiver) send(f frame) bool {
+	select {
+	case r.frames <- f:
+		return true
+	case <-r.done:
+		return false
+	}
+}
+
+// process parses the access log line in the body of a message, and sends its
+// entry tagged with the hostname and app name of the message
+func (r *receiver) process(f frame) {
+	msg, err := Parse(f.data)
+	if err != nil {
+		log.Println("syslog: failed parsing message from ", f.addr, ": ", err)
+		return
+	}
+
+	// without hostname, the sender address
+	if msg.Hostname == "" {
+		msg.Hostname = host(f.addr)
+	}
+
+	source := r.source(msg.Hostname, msg.AppName)
+	health.Add(health.LinesRead, 1, "file", source)
+
+	parser, ok := r.parsers[source]
+	if !ok {
+		parser, err = clf.NewParser(r.format)
+		if err != nil {
+			log.Println("syslog: ", err)
+			return
+		}
+		r.parsers[source] = parser
+	}
+
+	entry, err := parser.Parse(msg.Body)
+	if err == clf.ErrDirective {
+		return
+	}
+	if err != nil {
+		log.Println("syslog: failed parsing line from ", source, err)
+		r.rejects.Reject(source, msg.Body, err)
+		return
+	}
+
+	m := message.NewCLFMessage(entry)
+	m.File = source
+	m.Host = msg.Hostname
+	m.App = msg.AppName
+
+	err = r.broker.Send(broker.TopicData, m)
+	if err != nil {
+		log.Println("syslog: failed sending message to broker")
+	}
+}
+
+// source returns the source of the messages of hostname and app, like
+// syslog://host/app, or otherSource once there are maxSources
+func (r *receiver) source(hostname, app string) string {
+	source := "syslog://" + hostname + "/" + app
+	if _, ok := r.parsers[source]; ok || len(r.parsers) < maxSources-1 {
+		return source
+	}
+
+	if !r.full {
+		log.Println("syslog: reached ", maxSources, " sources, new ones are tracked as ", otherSource)
+		r.full = true
+	}
+	return otherSource
+}
+
+// host returns the host of a sender address
+func host(addr net.Addr) string {
+	h, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return strings.Trim(addr.String(), "[]")
+	}
+	return h
+}
+
+// Run starts receiving syslog messages on addr over udp and tcp, parsing their
+// bodies as access log lines in format. Entries are sent to the pipeline with
+// the hostname and app name of the messages, and their source as file, like
+// syslog://host/app. Lines failing to parse are handled by sink
+func Run(wg *sync.WaitGroup, ctl chan bool, addr, format string, sink *rejects.Sink) {
+	conn, err := broker.NewConnection("syslog")
+	if err != nil {
+		log.Fatal("syslog: failed opening broker connection ", err)
+	}
+
+	r := newReceiver(ctl, wg, conn, format, sink)
+	err = r.listen(addr)
+	if err != nil {
+		log.Fatal("syslog: failed listening on ", addr, ": ", err)
+	}
+
+	r.loop()
+}
@@ -0,0 +1,158 @@
+package syslog
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/juacker/loghound/internal/broker"
+	"github.com/juacker/loghound/internal/message"
+	"github.com/juacker/loghound/internal/testutils"
+	tassert "github.com/stretchr/testify/assert"
+)
+
+const line = `127.0.0.1 - - [09/May/2018:16:00:39 +0000] "GET /report?v=2 HTTP/1.0" 200 123`
+
+func TestInternalSyslog(t *testing.T) {
+
+	assert := tassert.New(t)
+
+	t.Run("Parse - RFC 5424", func(t *testing.T) {
+		m, err := Parse(`<165>1 2003-10-11T22:14:15.003Z web1.example.com nginx 8710 - [meta x="a\]b"][origin ip="10.0.0.1"] ` + "\ufeff" + line + "\n")
+		assert.Nil(err, "err nil")
+		assert.Equal(20, m.Facility)
+		assert.Equal(5, m.Severity)
+		assert.Equal(time.Date(2003, 10, 11, 22, 14, 15, 3000000, time.UTC), m.Timestamp.UTC())
+		assert.Equal("web1.example.com", m.Hostname)
+		assert.Equal("nginx", m.AppName)
+		assert.Equal(line, m.Body, "structured data and byte order mark skipped")
+
+		m, err = Parse("<14>1 - - - - - -")
+		assert.Nil(err, "err nil, nil values")
+		assert.Equal("", m.Hostname)
+		assert.Equal("", m.Body)
+
+		_, err = Parse(`<14>1 2003-10-11T22:14:15Z host app - - [meta x="1"`)
+		assert.NotNil(err, "unterminated structured data")
+	})
+
+	t.Run("Parse - RFC 3164, hostname optional", func(t *testing.T) {
+		m, err := Parse("<134>Oct  9 22:14:15 lb1 haproxy[1234]: " + line)
+		assert.Nil(err, "err nil")
+		assert.Equal(16, m.Facility)
+		assert.Equal(time.October, m.Timestamp.Month())
+		assert.Equal(9, m.Timestamp.Day())
+		assert.Equal("lb1", m.Hostname)
+		assert.Equal("haproxy", m.AppName)
+		assert.Equal(line, m.Body)
+
+		m, err = Parse("<134>Oct 11 22:14:15 nginx: " + line)
+		assert.Nil(err, "err nil")
+		assert.Equal("", m.Hostname, "no hostname")
+		assert.Equal("nginx", m.AppName)
+		assert.Equal(line, m.Body)
+
+		m, err = Parse("<134>Oct 11 22:14:15 " + line)
+		assert.Nil(err, "err nil")
+		assert.Equal("", m.Hostname, "client of the body is not the hostname")
+		assert.Equal("", m.AppName, "no tag")
+		assert.Equal(line, m.Body, "raw common log format body")
+
+		m, err = Parse("<134>Oct 11 22:14:15 web1 nginx[12]: " + line)
+		assert.Nil(err, "err nil")
+		assert.Equal("web1", m.Hostname)
+		assert.Equal("nginx", m.AppName, "tag with pid")
+		assert.Equal(line, m.Body)
+
+		_, err = Parse(line)
+		assert.NotNil(err, "no priority")
+		_, err = Parse("<134>yesterday nginx: " + line)
+		assert.NotNil(err, "invalid timestamp")
+	})
+
+	t.Run("splitFrames - octet counting and newlines", func(t *testing.T) {
+		first := "<14>1 - host app - - - first"
+		stream := fmt.Sprintf("%d %s<14>1 - host app - - - second\r\n\n%d %s", len(first), first, len(first), first)
+
+		scanner := bufio.NewScanner(strings.NewReader(stream))
+		scanner.Split(splitFrames)
+		frames := []string{}
+		for scanner.Scan() {
+			frames = append(frames, scanner.Text())
+		}
+		assert.Nil(scanner.Err(), "err nil")
+		assert.Equal([]string{first, "<14>1 - host app - - - second", first}, frames)
+
+		scanner = bufio.NewScanner(strings.NewReader("100 <14>1 - host app - - - short"))
+		scanner.Split(splitFrames)
+		assert.False(scanner.Scan(), "truncated frame")
+		assert.NotNil(scanner.Err(), "truncated frame error")
+	})
+
+	t.Run("receiver - sources limited", func(t *testing.T) {
+		l := &testutils.Recorder{}
+		r := newReceiver(nil, nil, l, "clf", nil)
+		addr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 514}
+
+		for i := 0; i < maxSources+10; i++ {
+			r.process(frame{data: fmt.Sprintf("<134>Oct 11 22:14:15 host%d nginx: %s", i, line), addr: addr})
+		}
+		sent := l.Sent(broker.TopicData)
+		assert.Equal(maxSources, len(r.parsers), "sources limited")
+		assert.Equal(maxSources+10, len(sent), "entries sent")
+		assert.Equal("syslog://host0/nginx", sent[0].(*message.CLFMessage).File)
+		assert.Equal(otherSource, sent[maxSources+9].(*message.CLFMessage).File, "sources beyond the limit")
+
+		r.process(frame{data: "<134>Oct 11 22:14:15 host1 nginx: " + line, addr: addr})
+		sent = l.Sent(broker.TopicData)
+		assert.Equal("syslog://host1/nginx", sent[maxSources+10].(*message.CLFMessage).File, "known source")
+	})
+
+	t.Run("receiver - entries over udp and tcp tagged by source", func(t *testing.T) {
+		l := &testutils.Recorder{}
+		ctl := make(chan bool)
+		var wg sync.WaitGroup
+		wg.Add(1)
+
+		r := newReceiver(ctl, &wg, l, "clf", nil)
+		assert.Nil(r.listen("127.0.0.1:0"), "err nil")
+		go r.loop()
+
+		udp, err := net.Dial("udp", r.udp.LocalAddr().String())
+		assert.Nil(err, "err nil")
+		defer udp.Close()
+		_, err = udp.Write([]byte("<134>Oct 11 22:14:15 nginx: " + line))
+		assert.Nil(err, "err nil")
+
+		sent := l.Wait(broker.TopicData, 1, time.Second)
+		assert.Equal(1, len(sent), "udp entry")
+
+		tcp, err := net.Dial("tcp", r.tcp.Addr().String())
+		assert.Nil(err, "err nil")
+		defer tcp.Close()
+		msg := "<165>1 2003-10-11T22:14:15Z web1 envoy - - - " + line
+		_, err = fmt.Fprintf(tcp, "%d %s", len(msg), msg)
+		assert.Nil(err, "err nil")
+
+		sent = l.Wait(broker.TopicData, 2, time.Second)
+		assert.Equal(2, len(sent), "tcp entry")
+
+		ctl <- true
+		wg.Wait()
+
+		if len(sent) == 2 {
+			udpEntry, tcpEntry := sent[0].(*message.CLFMessage), sent[1].(*message.CLFMessage)
+			assert.Equal("127.0.0.1", udpEntry.Host, "sender address without hostname")
+			assert.Equal("nginx", udpEntry.App)
+			assert.Equal("syslog://127.0.0.1/nginx", udpEntry.File)
+			assert.Equal("/report", udpEntry.Request.Path)
+			assert.Equal("web1", tcpEntry.Host)
+			assert.Equal("envoy", tcpEntry.App)
+			assert.Equal(123, tcpEntry.Bytes)
+		}
+	})
+}
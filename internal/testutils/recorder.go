@@ -0,0 +1,85 @@
+package testutils
+
+import (
+	"sync"
+	"time"
+)
+
+// Recorder satisfies broker.Link interface, it keeps the messages sent by
+// topic. It is safe for concurrent use, so modules can send from their loops
+// while tests wait for their messages
+type Recorder struct {
+	sync.Mutex
+	sent   map[int][]interface{}
+	notify chan struct{}
+}
+
+// Send keeps msg as sent on topic
+func (r *Recorder) Send(topic int, msg interface{}) error {
+	r.Lock()
+	defer r.Unlock()
+
+	r.init()
+	r.sent[topic] = append(r.sent[topic], msg)
+
+	select {
+	case r.notify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Receive returns a channel without messages
+func (r *Recorder) Receive() <-chan []byte {
+	return nil
+}
+
+// Sent returns the messages sent on topic
+func (r *Recorder) Sent(topic int) []interface{} {
+	r.Lock()
+	defer r.Unlock()
+
+	return append([]interface{}(nil), r.sent[topic]...)
+}
+
+// Wait waits until n messages were sent on topic, or timeout, and returns the
+// messages sent on topic
+func (r *Recorder) Wait(topic, n int, timeout time.Duration) []interface{} {
+	r.Lock()
+	r.init()
+	notify := r.notify
+	r.Unlock()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		sent := r.Sent(topic)
+		if len(sent) >= n {
+			return sent
+		}
+
+		select {
+		case <-notify:
+		case <-timer.C:
+			return r.Sent(topic)
+		}
+	}
+}
+
+// Reset forgets the messages sent
+func (r *Recorder) Reset() {
+	r.Lock()
+	defer r.Unlock()
+
+	r.sent = nil
+}
+
+func (r *Recorder) init() {
+	if r.sent == nil {
+		r.sent = make(map[int][]interface{})
+	}
+	if r.notify == nil {
+		r.notify = make(chan struct{}, 1)
+	}
+}
@@ -0,0 +1,154 @@
+package traffic
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Formats are the names of the built-in formats, nginx:<log_format> sets a
+// custom nginx log format
+var Formats = []string{"clf", "combined", "json"}
+
+// Formatter returns the log line of an entry, without line break
+type Formatter func(e *Entry) string
+
+const (
+	clfTime = "02/Jan/2006:15:04:05 -0700"
+	// nginx log_format of the combined format
+	combinedFormat = `$remote_addr - $remote_user [$time_local] "$request" $status $body_bytes_sent "$http_referer" "$http_user_agent"`
+	// nginx log_format of the common log format
+	clfFormat = `$remote_addr - $remote_user [$time_local] "$request" $status $body_bytes_sent`
+)
+
+// nginx variables supported in custom formats
+var variables = map[string]func(e *Entry) string{
+	"remote_addr":     func(e *Entry) string { return e.RemoteHost },
+	"remote_user":     func(e *Entry) string { return e.AuthUser },
+	"time_local":      func(e *Entry) string { return e.Date.Format(clfTime) },
+	"time_iso8601":    func(e *Entry) string { return e.Date.Format(time.RFC3339) },
+	"msec":            func(e *Entry) string { return fmt.Sprintf("%.3f", float64(e.Date.UnixNano())/1e9) },
+	"request":         func(e *Entry) string { return e.Request.Method + " " + e.Request.Target + " HTTP/1.1" },
+	"request_method":  func(e *Entry) string { return e.Request.Method },
+	"request_uri":     func(e *Entry) string { return e.Request.Target },
+	"uri":             func(e *Entry) string { return e.Request.Path },
+	"server_protocol": func(e *Entry) string { return "HTTP/1.1" },
+	"status":          func(e *Entry) string { return strconv.Itoa(e.Status) },
+	"body_bytes_sent": func(e *Entry) string { return strconv.Itoa(e.Bytes) },
+	"bytes_sent":      func(e *Entry) string { return strconv.Itoa(e.Bytes + 200) },
+	"http_referer":    func(e *Entry) string { return e.Referer },
+	"http_user_agent": func(e *Entry) string { return e.UserAgent },
+	"request_time":    func(e *Entry) string { return fmt.Sprintf("%.3f", e.Latency.Seconds()) },
+}
+
+// NewFormatter returns the formatter of format, one of Formats or nginx:<log_format>
+func NewFormatter(format string) (Formatter, error) {
+	switch format {
+	case "clf":
+		return nginxFormatter(clfFormat)
+	case "combined":
+		return nginxFormatter(combinedFormat)
+	case "json":
+		return formatJSON, nil
+	}
+
+	if strings.HasPrefix(format, "nginx:") {
+		return nginxFormatter(strings.TrimPrefix(format, "nginx:"))
+	}
+
+	return nil, fmt.Errorf("traffic: unknown format %s, expected nginx:<log_format> or one of %s", format, strings.Join(Formats, ", "))
+}
+
+// nginxFormatter returns a formatter of the nginx log_format, with $variable or ${variable}
+func nginxFormatter(format string) (Formatter, error) {
+	// the format is split in literals and variables, variables at odd positions
+	parts := make([]string, 0)
+	literal := strings.Builder{}
+
+	for i := 0; i < len(format); i++ {
+		if format[i] != '$' {
+			literal.WriteByte(format[i])
+			continue
+		}
+
+		var name string
+		if strings.HasPrefix(format[i+1:], "{") {
+			end := strings.IndexByte(format[i:], '}')
+			if end < 0 {
+				return nil, fmt.Errorf("traffic: unclosed variable in format at %d", i)
+			}
+			name = format[i+2 : i+end]
+			i += end
+		} else {
+			end := i + 1
+			for end < len(format) && isVariableChar(format[end]) {
+				end++
+			}
+			name = format[i+1 : end]
+			i = end - 1
+
+			// a $ without name is kept
+			if name == "" {
+				literal.WriteByte('$')
+				continue
+			}
+		}
+
+		if _, ok := variables[name]; !ok {
+			return nil, fmt.Errorf("traffic: unsupported variable $%s in format", name)
+		}
+		parts = append(parts, literal.String(), name)
+		literal.Reset()
+	}
+	parts = append(parts, literal.String())
+
+	return func(e *Entry) string {
+		line := strings.Builder{}
+		for i, part := range parts {
+			if i%2 == 0 {
+				line.WriteString(part)
+			} else {
+				line.WriteString(variables[part](e))
+			}
+		}
+		return line.String()
+	}, nil
+}
+
+func isVariableChar(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9')
+}
+
+// jsonLine is an entry in the json lines format
+type jsonLine struct {
+	Time      string  `json:"time"`
+	Host      string  `json:"remote_addr"`
+	User      string  `json:"remote_user"`
+	Method    string  `json:"method"`
+	Path      string  `json:"path"`
+	Protocol  string  `json:"protocol"`
+	Status    int     `json:"status"`
+	Bytes     int     `json:"bytes"`
+	Duration  float64 `json:"request_time"`
+	Referer   string  `json:"referer"`
+	UserAgent string  `json:"user_agent"`
+}
+
+func formatJSON(e *Entry) string {
+	data, _ := json.Marshal(jsonLine{
+		Time:      e.Date.Format(time.RFC3339Nano),
+		Host:      e.RemoteHost,
+		User:      e.AuthUser,
+		Method:    e.Request.Method,
+		Path:      e.Request.Target,
+		Protocol:  "HTTP/1.1",
+		Status:    e.Status,
+		Bytes:     e.Bytes,
+		Duration:  e.Latency.Seconds(),
+		Referer:   e.Referer,
+		UserAgent: e.UserAgent,
+	})
+	return string(data)
+}
@@ -0,0 +1,231 @@
+package traffic
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Event types
+const (
+	// EventSpike multiplies the rate by Factor
+	EventSpike = "spike"
+	// EventOutage makes requests fail with Status
+	EventOutage = "outage"
+)
+
+// Event is a scripted change of the traffic, from Start since the beginning of the
+// generation and for Duration
+type Event struct {
+	Type     string        `yaml:"type"`
+	Start    time.Duration `yaml:"start"`
+	Duration time.Duration `yaml:"duration"`
+	// Factor multiplies the rate in spikes, 5 by default
+	Factor float64 `yaml:"factor"`
+	// Status of the failed requests in outages, 503 by default
+	Status int `yaml:"status"`
+	// Path prefix of the requests failing in outages, all if empty
+	Path string `yaml:"path"`
+	// Ratio of the requests failing in outages, 1 by default
+	Ratio float64 `yaml:"ratio"`
+}
+
+// active returns if the event is happening at offset since the beginning
+func (e *Event) active(offset time.Duration) bool {
+	return offset >= e.Start && offset < e.Start+e.Duration
+}
+
+// Profile sets the shape of the generated traffic
+type Profile struct {
+	// Rate is the mean of requests per second, 10 by default
+	Rate float64 `yaml:"rate"`
+	// Diurnal is the amplitude of the daily curve, from 0 (flat) to 1 (no traffic at night)
+	Diurnal float64 `yaml:"diurnal"`
+	// Peak is the hour of the day with most traffic, 14 by default
+	Peak *int `yaml:"peak"`
+	// Paths requested, ranked by popularity. A catalog of site paths by default
+	Paths []string `yaml:"paths"`
+	// PathSkew is the Zipf exponent of the path popularity, greater than 1, 1.2 by default
+	PathSkew float64 `yaml:"path_skew"`
+	// Clients is the number of different clients, 500 by default
+	Clients int `yaml:"clients"`
+	// ClientSkew is the Zipf exponent of the client activity, greater than 1, 1.1 by default
+	ClientSkew float64 `yaml:"client_skew"`
+	// Latency is the median time to serve a request, 50ms by default
+	Latency time.Duration `yaml:"latency"`
+	Events  []Event       `yaml:"events"`
+}
+
+// catalog are the default paths, the most popular first
+var catalog = []string{
+	"/",
+	"/home",
+	"/api/v1/users",
+	"/static/app.js",
+	"/static/style.css",
+	"/news",
+	"/api/v1/orders",
+	"/users/login",
+	"/news/latest",
+	"/api/v1/products",
+	"/customers",
+	"/static/logo.png",
+	"/users/profile",
+	"/api/v1/search",
+	"/customers/orders",
+	"/help",
+	"/admin",
+	"/admin/users",
+	"/admin/reports",
+	"/ips/blocked",
+	"/log/errors",
+	"/example/one",
+	"/example/two",
+	"/example/three",
+}
+
+// Profiles are the built-in profiles by name
+var Profiles = map[string]Profile{
+	// steady traffic under the default high traffic threshold
+	"steady": {Rate: 5},
+	// traffic following the daily curve, peaking at 14h
+	"daily": {Rate: 10, Diurnal: 0.8},
+	// steady traffic with a spike firing the default high traffic alert, and
+	// an outage of the api
+	"incident": {
+		Rate: 5,
+		Events: []Event{
+			{Type: EventSpike, Start: 2 * time.Minute, Duration: 5 * time.Minute, Factor: 5},
+			{Type: EventOutage, Start: 10 * time.Minute, Duration: 3 * time.Minute, Path: "/api"},
+		},
+	},
+}
+
+// ProfileNames returns the names of the built-in profiles, sorted
+func ProfileNames() []string {
+	names := make([]string, 0, len(Profiles))
+	for name := range Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LoadProfile returns the built-in profile called name, or reads the yaml
+// profile file at name
+func LoadProfile(name string) (*Profile, error) {
+	if p, ok := Profiles[name]; ok {
+		p.setDefaults()
+		return &p, p.Validate()
+	}
+
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, fmt.Errorf("traffic: unknown profile %s, expected a file or one of %s", name, strings.Join(ProfileNames(), ", "))
+	}
+
+	return ParseProfile(data)
+}
+
+// ParseProfile parses a yaml profile
+func ParseProfile(data []byte) (*Profile, error) {
+	var p Profile
+	err := yaml.Unmarshal(data, &p)
+	if err != nil {
+		return nil, fmt.Errorf("traffic: invalid profile: %v", err)
+	}
+
+	p.setDefaults()
+	return &p, p.Validate()
+}
+
+func (p *Profile) setDefaults() {
+	if p.Rate == 0 {
+		p.Rate = 10
+	}
+	if p.Peak == nil {
+		peak := 14
+		p.Peak = &peak
+	}
+	if len(p.Paths) == 0 {
+		p.Paths = catalog
+	}
+	if p.PathSkew == 0 {
+		p.PathSkew = 1.2
+	}
+	if p.Clients == 0 {
+		p.Clients = 500
+	}
+	if p.ClientSkew == 0 {
+		p.ClientSkew = 1.1
+	}
+	if p.Latency == 0 {
+		p.Latency = 50 * time.Millisecond
+	}
+
+	// events of the built-in profiles are shared
+	p.Events = append([]Event(nil), p.Events...)
+	for i := range p.Events {
+		e := &p.Events[i]
+		if e.Type == EventSpike && e.Factor == 0 {
+			e.Factor = 5
+		}
+		if e.Type == EventOutage && e.Status == 0 {
+			e.Status = 503
+		}
+		if e.Type == EventOutage && e.Ratio == 0 {
+			e.Ratio = 1
+		}
+	}
+}
+
+// Validate checks the profile values
+func (p *Profile) Validate() error {
+	if p.Rate <= 0 {
+		return fmt.Errorf("traffic: rate must be positive")
+	}
+	if p.Diurnal < 0 || p.Diurnal > 1 {
+		return fmt.Errorf("traffic: diurnal must be between 0 and 1")
+	}
+	if p.Peak != nil && (*p.Peak < 0 || *p.Peak > 23) {
+		return fmt.Errorf("traffic: peak must be an hour between 0 and 23")
+	}
+	if p.PathSkew <= 1 || p.ClientSkew <= 1 {
+		return fmt.Errorf("traffic: path and client skews must be greater than 1")
+	}
+	if p.Clients <= 0 {
+		return fmt.Errorf("traffic: clients must be positive")
+	}
+	for _, path := range p.Paths {
+		if !strings.HasPrefix(path, "/") {
+			return fmt.Errorf("traffic: path %s must start with /", path)
+		}
+	}
+
+	for _, e := range p.Events {
+		switch e.Type {
+		case EventSpike:
+			if e.Factor <= 0 {
+				return fmt.Errorf("traffic: spike factor must be positive")
+			}
+		case EventOutage:
+			if e.Status < 100 || e.Status > 599 {
+				return fmt.Errorf("traffic: invalid outage status %d", e.Status)
+			}
+			if e.Ratio <= 0 || e.Ratio > 1 {
+				return fmt.Errorf("traffic: outage ratio must be between 0 and 1")
+			}
+		default:
+			return fmt.Errorf("traffic: unknown event type %s, expected %s or %s", e.Type, EventSpike, EventOutage)
+		}
+		if e.Duration <= 0 {
+			return fmt.Errorf("traffic: %s event without duration", e.Type)
+		}
+	}
+
+	return nil
+}
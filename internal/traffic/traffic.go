@@ -0,0 +1,215 @@
+package traffic
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/juacker/loghound/pkg/clf"
+)
+
+// Entry is a generated request, with the fields of the combined and json formats
+type Entry struct {
+	clf.Entry
+	Referer   string `json:"referer"`
+	UserAgent string `json:"user_agent"`
+}
+
+type client struct {
+	host  string
+	user  string
+	agent string
+}
+
+// a request method with its statuses, weights are relative
+type method struct {
+	name     string
+	weight   float64
+	statuses []int
+	weights  []float64
+}
+
+var methods = []method{
+	{"GET", 80, []int{200, 304, 301, 404, 500}, []float64{88, 5, 2, 4, 1}},
+	{"POST", 12, []int{201, 200, 400, 422, 500}, []float64{80, 10, 6, 3, 1}},
+	{"PUT", 5, []int{200, 400, 404, 500}, []float64{90, 5, 4, 1}},
+	{"DELETE", 3, []int{204, 404, 500}, []float64{85, 12, 3}},
+}
+
+var users = []string{"alice", "bob", "carol", "carlos", "charly", "dan", "erin", "faythe"}
+
+var agents = []string{
+	"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/118.0 Safari/537.36",
+	"Mozilla/5.0 (Macintosh; Intel Mac OS X 13_5) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/16.6 Safari/605.1.15",
+	"Mozilla/5.0 (X11; Linux x86_64; rv:109.0) Gecko/20100101 Firefox/118.0",
+	"Mozilla/5.0 (iPhone; CPU iPhone OS 16_6 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Mobile/15E148",
+	"curl/8.1.2",
+	"Googlebot/2.1 (+http://www.google.com/bot.html)",
+}
+
+// Generator generates requests following a profile, as a Poisson process with the
+// rate of the profile at every moment. Generators with the same profile, seed and
+// start generate the same requests
+type Generator struct {
+	profile Profile
+	rand    *rand.Rand
+	paths   *rand.Zipf
+	ranks   *rand.Zipf
+	clients []client
+	start   time.Time
+	now     time.Time
+}
+
+// NewGenerator returns a generator of requests from start on
+func NewGenerator(p Profile, seed int64, start time.Time) (*Generator, error) {
+	p.setDefaults()
+	err := p.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	r := rand.New(rand.NewSource(seed))
+
+	clients := make([]client, p.Clients)
+	for i := range clients {
+		clients[i].host = fmt.Sprintf("%d.%d.%d.%d", 1+r.Intn(223), r.Intn(256), r.Intn(256), 1+r.Intn(254))
+		clients[i].user = "-"
+		if r.Intn(5) == 0 {
+			clients[i].user = users[r.Intn(len(users))]
+		}
+		clients[i].agent = agents[r.Intn(len(agents))]
+	}
+
+	return &Generator{
+		profile: p,
+		rand:    r,
+		paths:   rand.NewZipf(r, p.PathSkew, 1, uint64(len(p.Paths)-1)),
+		ranks:   rand.NewZipf(r, p.ClientSkew, 1, uint64(p.Clients-1)),
+		clients: clients,
+		start:   start,
+		now:     start,
+	}, nil
+}
+
+// Rate returns the requests per second expected at t
+func (g *Generator) Rate(t time.Time) float64 {
+	rate := g.profile.Rate
+
+	if g.profile.Diurnal > 0 {
+		hour := float64(t.Hour()) + float64(t.Minute())/60 + float64(t.Second())/3600
+		rate *= 1 + g.profile.Diurnal*math.Cos(2*math.Pi*(hour-float64(*g.profile.Peak))/24)
+	}
+
+	offset := t.Sub(g.start)
+	for _, e := range g.profile.Events {
+		if e.Type == EventSpike && e.active(offset) {
+			rate *= e.Factor
+		}
+	}
+
+	return rate
+}
+
+// Next returns the next request, dated after the previous one
+func (g *Generator) Next() *Entry {
+	for {
+		// the rate changes over time, waits longer than a second are sampled
+		// again a second later, which keeps the arrivals a Poisson process
+		rate := g.Rate(g.now)
+		if rate > 0 {
+			wait := g.rand.ExpFloat64() / rate
+			if wait <= 1 {
+				g.now = g.now.Add(time.Duration(wait * float64(time.Second)))
+				break
+			}
+		}
+		g.now = g.now.Add(time.Second)
+	}
+
+	return g.entry(g.now)
+}
+
+func (g *Generator) entry(t time.Time) *Entry {
+	path := g.profile.Paths[g.paths.Uint64()]
+	c := g.clients[g.ranks.Uint64()]
+
+	m := methods[0]
+	if !strings.HasPrefix(path, "/static/") {
+		m = methods[g.pick(methodWeights)]
+	}
+
+	status := m.statuses[g.pick(m.weights)]
+	latency := time.Duration(float64(g.profile.Latency) * math.Exp(0.5*g.rand.NormFloat64()))
+
+	offset := t.Sub(g.start)
+	for _, e := range g.profile.Events {
+		if e.Type == EventOutage && e.active(offset) && strings.HasPrefix(path, e.Path) && g.rand.Float64() < e.Ratio {
+			status = e.Status
+			latency *= 10
+		}
+	}
+
+	referer := "-"
+	if g.rand.Intn(5) < 3 {
+		referer = "https://example.com" + g.profile.Paths[g.paths.Uint64()]
+	}
+
+	return &Entry{
+		Entry: clf.Entry{
+			RemoteHost:    c.host,
+			RemoteLogname: "-",
+			AuthUser:      c.user,
+			Date:          t,
+			Request:       clf.NewRequest(m.name, path),
+			Status:        status,
+			Bytes:         g.size(path, status),
+			Latency:       latency,
+		},
+		Referer:   referer,
+		UserAgent: c.agent,
+	}
+}
+
+var methodWeights = func() []float64 {
+	weights := make([]float64, len(methods))
+	for i, m := range methods {
+		weights[i] = m.weight
+	}
+	return weights
+}()
+
+// pick returns a random index of weights, by their relative values
+func (g *Generator) pick(weights []float64) int {
+	total := 0.0
+	for _, w := range weights {
+		total += w
+	}
+
+	n := g.rand.Float64() * total
+	for i, w := range weights {
+		if n < w {
+			return i
+		}
+		n -= w
+	}
+	return len(weights) - 1
+}
+
+// size returns the bytes of a response, around a size of its path
+func (g *Generator) size(path string, status int) int {
+	switch {
+	case status == 204 || status == 304:
+		return 0
+	case status >= 300:
+		return 100 + g.rand.Intn(500)
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(path)) //errcheck: nolint
+	base := 500 + int(h.Sum32()%20000)
+
+	return base*4/5 + g.rand.Intn(base*2/5+1)
+}
@@ -0,0 +1,156 @@
+package traffic
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/juacker/loghound/pkg/clf"
+	tassert "github.com/stretchr/testify/assert"
+)
+
+func TestInternalTraffic(t *testing.T) {
+
+	assert := tassert.New(t)
+
+	start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	t.Run("Generator - same seed same traffic", func(t *testing.T) {
+		a, err := NewGenerator(Profile{Rate: 10}, 42, start)
+		assert.Nil(err, "err nil")
+		b, err := NewGenerator(Profile{Rate: 10}, 42, start)
+		assert.Nil(err, "err nil")
+		c, err := NewGenerator(Profile{Rate: 10}, 43, start)
+		assert.Nil(err, "err nil")
+
+		same, different := true, false
+		for i := 0; i < 100; i++ {
+			ea, eb, ec := a.Next(), b.Next(), c.Next()
+			same = same && ea.Request.Method == eb.Request.Method && ea.Request.Target == eb.Request.Target && ea.Date == eb.Date && ea.RemoteHost == eb.RemoteHost
+			different = different || ea.Date != ec.Date
+		}
+		assert.True(same, "same traffic")
+		assert.True(different, "other seed, other traffic")
+	})
+
+	t.Run("Generator - rate, methods and paths", func(t *testing.T) {
+		g, err := NewGenerator(Profile{Rate: 10, Paths: []string{"/a", "/b", "/c"}}, 1, start)
+		assert.Nil(err, "err nil")
+
+		methods := make(map[string]int)
+		paths := make(map[string]int)
+		entries := 0
+		for e := g.Next(); e.Date.Before(start.Add(time.Hour)); e = g.Next() {
+			methods[e.Request.Method]++
+			paths[e.Request.Path]++
+			entries++
+		}
+
+		assert.InDelta(36000, entries, 1000, "requests at the target rate")
+		assert.Equal(4, len(methods), "all methods")
+		assert.Equal(0, methods["DELTE"], "no typos")
+		assert.True(paths["/a"] > paths["/b"] && paths["/b"] > paths["/c"] && paths["/c"] > 0, "zipf distributed paths")
+	})
+
+	t.Run("Generator - diurnal curve", func(t *testing.T) {
+		peak := 12
+		g, err := NewGenerator(Profile{Rate: 10, Diurnal: 0.5, Peak: &peak}, 1, start)
+		assert.Nil(err, "err nil")
+
+		assert.InDelta(15, g.Rate(start.Add(12*time.Hour)), 0.01, "peak rate")
+		assert.InDelta(5, g.Rate(start), 0.01, "night rate")
+
+		entries := 0
+		for e := g.Next(); e.Date.Before(start.Add(24 * time.Hour)); e = g.Next() {
+			entries++
+		}
+		assert.InDelta(864000, entries, 10000, "daily mean at the rate")
+	})
+
+	t.Run("Generator - spikes and outages", func(t *testing.T) {
+		g, err := NewGenerator(Profile{
+			Rate: 10,
+			Events: []Event{
+				{Type: EventSpike, Start: time.Minute, Duration: time.Minute, Factor: 4},
+				{Type: EventOutage, Start: 3 * time.Minute, Duration: time.Minute, Path: "/api"},
+			},
+		}, 1, start)
+		assert.Nil(err, "err nil")
+
+		assert.Equal(40.0, g.Rate(start.Add(90*time.Second)), "spike rate")
+		assert.Equal(10.0, g.Rate(start.Add(2*time.Minute)), "rate after the spike")
+
+		minutes := make([]int, 5)
+		failed, api := 0, 0
+		for e := g.Next(); e.Date.Before(start.Add(5 * time.Minute)); e = g.Next() {
+			minute := int(e.Date.Sub(start) / time.Minute)
+			minutes[minute]++
+
+			if minute == 3 && strings.HasPrefix(e.Request.Path, "/api") {
+				api++
+				if e.Status == 503 {
+					failed++
+				}
+			}
+		}
+
+		assert.True(minutes[1] > 3*minutes[0], "requests of the spike")
+		assert.True(api > 0, "api requests in the outage")
+		assert.Equal(api, failed, "api requests failing")
+	})
+
+	t.Run("Profile - built-in and errors", func(t *testing.T) {
+		p, err := LoadProfile("incident")
+		assert.Nil(err, "err nil")
+		assert.Equal(503, p.Events[1].Status, "outage defaults")
+		assert.Equal(0, Profiles["incident"].Events[1].Status, "built-in profile unchanged")
+
+		p, err = ParseProfile([]byte("rate: 20\nevents:\n  - type: spike\n    start: 1m\n    duration: 30s\n"))
+		assert.Nil(err, "err nil")
+		assert.Equal(5.0, p.Events[0].Factor, "spike defaults")
+
+		_, err = ParseProfile([]byte("events:\n  - type: flood\n    duration: 1m\n"))
+		assert.Equal("traffic: unknown event type flood, expected spike or outage", err.Error())
+
+		_, err = ParseProfile([]byte("path_skew: 0.5\n"))
+		assert.NotNil(err, "zipf exponent")
+
+		_, err = LoadProfile("nonexistent")
+		assert.NotNil(err, "unknown profile")
+	})
+
+	t.Run("Formatter - formats", func(t *testing.T) {
+		g, err := NewGenerator(Profile{}, 1, start)
+		assert.Nil(err, "err nil")
+		e := g.Next()
+
+		for _, format := range []string{"clf", "combined"} {
+			f, err := NewFormatter(format)
+			assert.Nil(err, "err nil")
+
+			parsed, err := clf.Parse(f(e))
+			assert.Nil(err, "err nil")
+			assert.Equal(e.Request.Path, parsed.Request.Path, "parsed path")
+			assert.Equal(e.Status, parsed.Status, "parsed status")
+			assert.Equal(e.Date.Unix(), parsed.Date.Unix(), "parsed date")
+		}
+
+		f, err := NewFormatter("json")
+		assert.Nil(err, "err nil")
+		var line map[string]interface{}
+		assert.Nil(json.Unmarshal([]byte(f(e)), &line), "err nil")
+		assert.Equal(e.Request.Path, line["path"], "json path")
+
+		f, err = NewFormatter(`nginx:$remote_addr "$request" ${status}/$request_time $`)
+		assert.Nil(err, "err nil")
+		expected := fmt.Sprintf(`%s "%s %s HTTP/1.1" %d/%.3f $`, e.RemoteHost, e.Request.Method, e.Request.Path, e.Status, e.Latency.Seconds())
+		assert.Equal(expected, f(e), "custom format")
+
+		_, err = NewFormatter("nginx:$upstream_addr")
+		assert.Equal("traffic: unsupported variable $upstream_addr in format", err.Error())
+		_, err = NewFormatter("xml")
+		assert.NotNil(err, "unknown format")
+	})
+}
@@ -0,0 +1,182 @@
+package tsdb
+
+import (
+	"compress/gzip"
+	"encoding/gob"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const blockSuffix = ".gz"
+
+// bucket aggregates the points of a rollup step
+type bucket struct {
+	Timestamp int64
+	Count     int
+	Sum       float64
+	Min       float64
+	Max       float64
+	Last      float64
+}
+
+func (b *bucket) add(p Point) {
+	if b.Count == 0 || p.Value < b.Min {
+		b.Min = p.Value
+	}
+	if b.Count == 0 || p.Value > b.Max {
+		b.Max = p.Value
+	}
+
+	b.Count++
+	b.Sum += p.Value
+	b.Last = p.Value
+}
+
+func (b *bucket) merge(o bucket) {
+	if b.Count == 0 || o.Min < b.Min {
+		b.Min = o.Min
+	}
+	if b.Count == 0 || o.Max > b.Max {
+		b.Max = o.Max
+	}
+
+	b.Count += o.Count
+	b.Sum += o.Sum
+	b.Last = o.Last
+}
+
+func (b *bucket) value(agg Aggregation) float64 {
+	if b.Count == 0 {
+		return math.NaN()
+	}
+
+	switch agg {
+	case AggSum:
+		return b.Sum
+	case AggMin:
+		return b.Min
+	case AggMax:
+		return b.Max
+	case AggCount:
+		return float64(b.Count)
+	case AggLast:
+		return b.Last
+	default:
+		return b.Sum / float64(b.Count)
+	}
+}
+
+// blockData is the content of a block file, raw points or rollup buckets by metric
+type blockData struct {
+	Points  map[string][]Point
+	Buckets map[string][]bucket
+}
+
+// blockFile references a compressed block on disk covering [mint, maxt]
+type blockFile struct {
+	path string
+	mint int64
+	maxt int64
+}
+
+func (b blockFile) overlaps(from, to int64) bool {
+	return b.mint <= to && b.maxt >= from
+}
+
+// listBlocks returns the blocks stored in dir sorted by time
+func listBlocks(dir string) ([]blockFile, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	blocks := make([]blockFile, 0, len(files))
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), blockSuffix) {
+			continue
+		}
+
+		bounds := strings.SplitN(strings.TrimSuffix(f.Name(), blockSuffix), "-", 2)
+		if len(bounds) != 2 {
+			continue
+		}
+
+		mint, err := strconv.ParseInt(bounds[0], 10, 64)
+		if err != nil {
+			continue
+		}
+		maxt, err := strconv.ParseInt(bounds[1], 10, 64)
+		if err != nil {
+			continue
+		}
+
+		blocks = append(blocks, blockFile{
+			path: filepath.Join(dir, f.Name()),
+			mint: mint,
+			maxt: maxt,
+		})
+	}
+
+	sort.Slice(blocks, func(a, b int) bool {
+		return blocks[a].mint < blocks[b].mint
+	})
+
+	return blocks, nil
+}
+
+// writeBlock stores data compressed in dir, the file name holds the time bounds
+func writeBlock(dir string, mint, maxt int64, data *blockData) error {
+	path := filepath.Join(dir, fmt.Sprintf("%d-%d%s", mint, maxt, blockSuffix))
+	tmp := path + ".tmp"
+
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	zw := gzip.NewWriter(f)
+	err = gob.NewEncoder(zw).Encode(data)
+	if err == nil {
+		err = zw.Close()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	f.Close()
+
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// readBlock decodes a block file
+func readBlock(path string) (*blockData, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	var data blockData
+	err = gob.NewDecoder(zr).Decode(&data)
+	if err != nil {
+		return nil, err
+	}
+
+	return &data, nil
+}
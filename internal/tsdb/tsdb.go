@@ -0,0 +1,513 @@
+package tsdb
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/juacker/loghound/internal/clock"
+)
+
+// Point represents a metric value at a given time
+type Point struct {
+	Timestamp int64   `json:"timestamp"`
+	Value     float64 `json:"value"`
+}
+
+// Aggregation defines how the points within a step are combined
+type Aggregation string
+
+// Supported aggregations
+const (
+	AggAvg   Aggregation = "avg"
+	AggSum   Aggregation = "sum"
+	AggMin   Aggregation = "min"
+	AggMax   Aggregation = "max"
+	AggCount Aggregation = "count"
+	AggLast  Aggregation = "last"
+)
+
+// ParseAggregation returns the aggregation named s
+func ParseAggregation(s string) (Aggregation, error) {
+	switch agg := Aggregation(s); agg {
+	case AggAvg, AggSum, AggMin, AggMax, AggCount, AggLast:
+		return agg, nil
+	case "":
+		return AggAvg, nil
+	default:
+		return "", fmt.Errorf("tsdb: unknown aggregation %s", s)
+	}
+}
+
+// Options sets blocks and retention of the storage
+type Options struct {
+	BlockDuration   int64         // seconds covered by a block before it is written to disk
+	RollupStep      int64         // seconds aggregated in a rollup bucket
+	RawRetention    time.Duration // retention of raw points
+	RollupRetention time.Duration // retention of rollup buckets
+	CacheBlocks     int           // decoded blocks kept in memory for queries
+	Clock           clock.Clock   // tells the time of retention and queries, the system clock if nil
+}
+
+// DefaultOptions returns the default storage options:
+// raw points for 24h and 1 minute rollups for 30 days
+func DefaultOptions() Options {
+	return Options{
+		BlockDuration:   3600,
+		RollupStep:      60,
+		RawRetention:    24 * time.Hour,
+		RollupRetention: 30 * 24 * time.Hour,
+		CacheBlocks:     32,
+	}
+}
+
+type walEntry struct {
+	Timestamp int64          `json:"t"`
+	Stats     map[string]int `json:"s"`
+}
+
+// DB is an embedded time series storage. Points are kept in memory in the head
+// block, backed by a write ahead log, until the block is complete. Complete blocks
+// are written compressed to disk as raw points and as rollups, and the metric
+// names are indexed apart so they are known without reading the blocks.
+type DB struct {
+	sync.Mutex
+	dir       string
+	opts      Options
+	head      map[string][]Point
+	headStart int64
+	// names are the metric names with the time of their last point
+	names map[string]int64
+	wal   *os.File
+	// cache has the last blocks read by path, cached in read order
+	cache  map[string]*blockData
+	cached []string
+	clock  clock.Clock
+}
+
+// Open opens the storage at dir, creating it if needed
+func Open(dir string, opts Options) (*DB, error) {
+	for _, d := range []string{dir, filepath.Join(dir, "raw"), filepath.Join(dir, "rollup")} {
+		err := os.MkdirAll(d, 0755)
+		if err != nil {
+			return nil, fmt.Errorf("tsdb: failed creating directory %s: %v", d, err)
+		}
+	}
+
+	db := &DB{
+		dir:   dir,
+		opts:  opts,
+		head:  make(map[string][]Point),
+		names: make(map[string]int64),
+		cache: make(map[string]*blockData),
+		clock: opts.Clock,
+	}
+	if db.clock == nil {
+		db.clock = clock.New()
+	}
+
+	err := db.loadNames()
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.replayWAL()
+	if err != nil {
+		return nil, err
+	}
+
+	db.wal, err = os.OpenFile(db.walPath(), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("tsdb: failed opening wal: %v", err)
+	}
+
+	return db, nil
+}
+
+func (db *DB) walPath() string {
+	return filepath.Join(db.dir, "wal")
+}
+
+func (db *DB) namesPath() string {
+	return filepath.Join(db.dir, "names.json")
+}
+
+// loadNames reads the metric names index, it is rebuilt from the raw blocks on
+// disk if missing
+func (db *DB) loadNames() error {
+	data, err := ioutil.ReadFile(db.namesPath())
+	if err == nil {
+		err = json.Unmarshal(data, &db.names)
+		if err == nil {
+			return nil
+		}
+		log.Println("tsdb: invalid names index, rebuilding it: ", err)
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("tsdb: failed reading names index: %v", err)
+	}
+
+	blocks, err := listBlocks(filepath.Join(db.dir, "raw"))
+	if err != nil {
+		return fmt.Errorf("tsdb: failed listing blocks: %v", err)
+	}
+
+	for _, b := range blocks {
+		data, err := readBlock(b.path)
+		if err != nil {
+			log.Println("tsdb: failed reading block ", b.path, err)
+			continue
+		}
+
+		for name := range data.Points {
+			if b.maxt > db.names[name] {
+				db.names[name] = b.maxt
+			}
+		}
+	}
+
+	return db.writeNames()
+}
+
+// writeNames replaces the metric names index
+func (db *DB) writeNames() error {
+	data, err := json.Marshal(db.names)
+	if err != nil {
+		return err
+	}
+
+	tmp := db.namesPath() + ".tmp"
+	err = ioutil.WriteFile(tmp, data, 0644)
+	if err != nil {
+		return fmt.Errorf("tsdb: failed writing names index: %v", err)
+	}
+
+	return os.Rename(tmp, db.namesPath())
+}
+
+// replayWAL loads the points of the head block not yet written to disk
+func (db *DB) replayWAL() error {
+	f, err := os.Open(db.walPath())
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("tsdb: failed opening wal: %v", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	for scanner.Scan() {
+		var entry walEntry
+		err := json.Unmarshal(scanner.Bytes(), &entry)
+		if err != nil {
+			// partial entry written before a crash
+			log.Println("tsdb: skipping invalid wal entry")
+			continue
+		}
+
+		for metric, value := range entry.Stats {
+			db.append(metric, Point{entry.Timestamp, float64(value)})
+		}
+	}
+
+	return scanner.Err()
+}
+
+// AppendStats stores the stats generated at timestamp
+func (db *DB) AppendStats(timestamp int64, stats map[string]int) error {
+	db.Lock()
+	defer db.Unlock()
+
+	if db.headStart > 0 && timestamp >= db.headStart+db.opts.BlockDuration {
+		err := db.cut()
+		if err != nil {
+			return err
+		}
+	}
+
+	data, err := json.Marshal(walEntry{timestamp, stats})
+	if err != nil {
+		return err
+	}
+
+	_, err = db.wal.Write(append(data, '\n'))
+	if err != nil {
+		return fmt.Errorf("tsdb: failed writing wal: %v", err)
+	}
+
+	for metric, value := range stats {
+		db.append(metric, Point{timestamp, float64(value)})
+	}
+
+	return nil
+}
+
+func (db *DB) append(metric string, p Point) {
+	if db.headStart == 0 {
+		db.headStart = p.Timestamp - p.Timestamp%db.opts.BlockDuration
+	}
+
+	db.head[metric] = append(db.head[metric], p)
+	if p.Timestamp > db.names[metric] {
+		db.names[metric] = p.Timestamp
+	}
+}
+
+// cut writes the head block to disk, as raw points and rollups, and starts a new one
+func (db *DB) cut() error {
+	if len(db.head) > 0 {
+		mint, maxt := int64(-1), int64(-1)
+		for _, points := range db.head {
+			for _, p := range points {
+				if mint < 0 || p.Timestamp < mint {
+					mint = p.Timestamp
+				}
+				if p.Timestamp > maxt {
+					maxt = p.Timestamp
+				}
+			}
+		}
+
+		err := writeBlock(filepath.Join(db.dir, "raw"), mint, maxt, &blockData{Points: db.head})
+		if err != nil {
+			return fmt.Errorf("tsdb: failed writing raw block: %v", err)
+		}
+
+		err = writeBlock(filepath.Join(db.dir, "rollup"), mint, maxt, &blockData{Buckets: db.rollup(db.head)})
+		if err != nil {
+			return fmt.Errorf("tsdb: failed writing rollup block: %v", err)
+		}
+	}
+
+	err := db.wal.Truncate(0)
+	if err != nil {
+		return fmt.Errorf("tsdb: failed truncating wal: %v", err)
+	}
+
+	db.head = make(map[string][]Point)
+	db.headStart = 0
+
+	db.compact()
+	return db.writeNames()
+}
+
+// rollup aggregates the points in buckets of RollupStep seconds
+func (db *DB) rollup(points map[string][]Point) map[string][]bucket {
+	rollups := make(map[string][]bucket, len(points))
+
+	for metric, ps := range points {
+		buckets := make(map[int64]*bucket)
+		for _, p := range ps {
+			ts := p.Timestamp - p.Timestamp%db.opts.RollupStep
+			if _, ok := buckets[ts]; !ok {
+				buckets[ts] = &bucket{Timestamp: ts}
+			}
+			buckets[ts].add(p)
+		}
+
+		for _, b := range buckets {
+			rollups[metric] = append(rollups[metric], *b)
+		}
+
+		sort.Slice(rollups[metric], func(a, b int) bool {
+			return rollups[metric][a].Timestamp < rollups[metric][b].Timestamp
+		})
+	}
+
+	return rollups
+}
+
+// compact removes the blocks out of retention, and the names of the metrics
+// without points within raw retention
+func (db *DB) compact() {
+	now := db.clock.Now()
+
+	retentions := map[string]time.Duration{
+		"raw":    db.opts.RawRetention,
+		"rollup": db.opts.RollupRetention,
+	}
+
+	for dir, retention := range retentions {
+		blocks, err := listBlocks(filepath.Join(db.dir, dir))
+		if err != nil {
+			log.Println("tsdb: failed listing blocks: ", err)
+			continue
+		}
+
+		limit := now.Add(-retention).Unix()
+		for _, b := range blocks {
+			if b.maxt >= limit {
+				continue
+			}
+
+			log.Println("tsdb: removing block out of retention ", b.path)
+			err = os.Remove(b.path)
+			if err != nil {
+				log.Println("tsdb: failed removing block ", b.path, err)
+			}
+			db.uncache(b.path)
+		}
+	}
+
+	limit := now.Add(-db.opts.RawRetention).Unix()
+	for name, last := range db.names {
+		if last < limit {
+			delete(db.names, name)
+		}
+	}
+}
+
+// block returns the decoded block at path, the last CacheBlocks blocks read
+// are kept in memory
+func (db *DB) block(path string) (*blockData, error) {
+	if data, ok := db.cache[path]; ok {
+		return data, nil
+	}
+
+	data, err := readBlock(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if db.opts.CacheBlocks > 0 {
+		if len(db.cached) >= db.opts.CacheBlocks {
+			delete(db.cache, db.cached[0])
+			db.cached = db.cached[1:]
+		}
+		db.cache[path] = data
+		db.cached = append(db.cached, path)
+	}
+
+	return data, nil
+}
+
+// uncache forgets the block at path
+func (db *DB) uncache(path string) {
+	if _, ok := db.cache[path]; !ok {
+		return
+	}
+
+	delete(db.cache, path)
+	for i, cached := range db.cached {
+		if cached == path {
+			db.cached = append(db.cached[:i], db.cached[i+1:]...)
+			break
+		}
+	}
+}
+
+// Query returns the points of metric between from and to (unix seconds, both included),
+// aggregated by agg in steps of step seconds. With step 0 points are not aggregated.
+// Ranges starting beyond raw retention are served from rollups.
+func (db *DB) Query(metric string, from, to, step int64, agg Aggregation) ([]Point, error) {
+	db.Lock()
+	defer db.Unlock()
+
+	align := func(ts int64) int64 {
+		if step <= 0 {
+			return ts
+		}
+		return ts - ts%step
+	}
+
+	buckets := make(map[int64]*bucket)
+	at := func(ts int64) *bucket {
+		ts = align(ts)
+		if _, ok := buckets[ts]; !ok {
+			buckets[ts] = &bucket{Timestamp: ts}
+		}
+		return buckets[ts]
+	}
+
+	raw := from >= db.clock.Now().Add(-db.opts.RawRetention).Unix()
+
+	dir := "rollup"
+	if raw {
+		dir = "raw"
+	} else if step > 0 && step < db.opts.RollupStep {
+		step = db.opts.RollupStep
+	}
+
+	blocks, err := listBlocks(filepath.Join(db.dir, dir))
+	if err != nil {
+		return nil, fmt.Errorf("tsdb: failed listing blocks: %v", err)
+	}
+
+	for _, b := range blocks {
+		if !b.overlaps(from, to) {
+			continue
+		}
+
+		data, err := db.block(b.path)
+		if err != nil {
+			return nil, fmt.Errorf("tsdb: failed reading block %s: %v", b.path, err)
+		}
+
+		for _, p := range data.Points[metric] {
+			if p.Timestamp >= from && p.Timestamp <= to {
+				at(p.Timestamp).add(p)
+			}
+		}
+		for _, r := range data.Buckets[metric] {
+			if r.Timestamp >= from && r.Timestamp <= to {
+				at(r.Timestamp).merge(r)
+			}
+		}
+	}
+
+	// points still in the head block
+	if raw {
+		for _, p := range db.head[metric] {
+			if p.Timestamp >= from && p.Timestamp <= to {
+				at(p.Timestamp).add(p)
+			}
+		}
+	} else {
+		for _, r := range db.rollup(map[string][]Point{metric: db.head[metric]})[metric] {
+			if r.Timestamp >= from && r.Timestamp <= to {
+				at(r.Timestamp).merge(r)
+			}
+		}
+	}
+
+	points := make([]Point, 0, len(buckets))
+	for ts, b := range buckets {
+		points = append(points, Point{ts, b.value(agg)})
+	}
+
+	sort.Slice(points, func(a, b int) bool {
+		return points[a].Timestamp < points[b].Timestamp
+	})
+
+	return points, nil
+}
+
+// Metrics returns the names of the metrics stored within raw retention
+func (db *DB) Metrics() []string {
+	db.Lock()
+	defer db.Unlock()
+
+	names := make([]string, 0, len(db.names))
+	for name := range db.names {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// Close closes the storage, the head block is kept in the wal
+func (db *DB) Close() error {
+	db.Lock()
+	defer db.Unlock()
+
+	return db.wal.Close()
+}
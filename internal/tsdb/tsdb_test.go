@@ -0,0 +1,193 @@
+package tsdb
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/juacker/loghound/internal/clock"
+	"github.com/juacker/loghound/internal/message"
+	tassert "github.com/stretchr/testify/assert"
+)
+
+func TestTSDB(t *testing.T) {
+
+	assert := tassert.New(t)
+
+	dir, err := ioutil.TempDir("", "tsdb")
+	assert.Nil(err, "err nil")
+	defer os.RemoveAll(dir)
+
+	opts := DefaultOptions()
+	opts.BlockDuration = 60
+	opts.RollupStep = 10
+
+	now := time.Now().Unix()
+	start := now - now%60 - 120
+
+	t.Run("AppendStats - cuts blocks", func(t *testing.T) {
+		db, err := Open(dir, opts)
+		assert.Nil(err, "err nil")
+
+		for i := int64(0); i < 90; i += 2 {
+			err = db.AppendStats(start+i, map[string]int{"requests.total": int(i)})
+			assert.Nil(err, "err nil")
+		}
+
+		raw, err := listBlocks(filepath.Join(dir, "raw"))
+		assert.Nil(err, "err nil")
+		assert.Equal(1, len(raw), "raw block written")
+
+		rollup, err := listBlocks(filepath.Join(dir, "rollup"))
+		assert.Nil(err, "err nil")
+		assert.Equal(1, len(rollup), "rollup block written")
+
+		assert.Nil(db.Close(), "err nil")
+	})
+
+	t.Run("Query - raw points from blocks and wal", func(t *testing.T) {
+		db, err := Open(dir, opts)
+		assert.Nil(err, "err nil")
+		defer db.Close()
+
+		assert.Equal([]string{"requests.total"}, db.Metrics(), "known metrics")
+
+		points, err := db.Query("requests.total", start, start+89, 0, AggAvg)
+		assert.Nil(err, "err nil")
+		assert.Equal(45, len(points), "all points")
+		assert.Equal(Point{start + 88, 88}, points[44], "last point replayed from wal")
+	})
+
+	t.Run("Query - aggregated by step", func(t *testing.T) {
+		db, err := Open(dir, opts)
+		assert.Nil(err, "err nil")
+		defer db.Close()
+
+		points, err := db.Query("requests.total", start, start+59, 30, AggSum)
+		assert.Nil(err, "err nil")
+		assert.Equal(2, len(points), "two steps")
+		// 0 + 2 + ... + 28
+		assert.Equal(float64(210), points[0].Value, "sum of first step")
+
+		points, err = db.Query("requests.total", start, start+59, 60, AggMax)
+		assert.Nil(err, "err nil")
+		assert.Equal([]Point{{start, 58}}, points, "max of the step")
+	})
+
+	t.Run("Query - rollups beyond raw retention", func(t *testing.T) {
+		rollupOpts := opts
+		rollupOpts.RawRetention = 0
+
+		db, err := Open(dir, rollupOpts)
+		assert.Nil(err, "err nil")
+		defer db.Close()
+
+		points, err := db.Query("requests.total", start-1, start+59, 0, AggCount)
+		assert.Nil(err, "err nil")
+		assert.Equal(6, len(points), "one point per rollup step")
+		assert.Equal(float64(5), points[0].Value, "points in rollup step")
+	})
+
+	t.Run("processMessage - rebuilt stats not stored again", func(t *testing.T) {
+		db, err := Open(dir, opts)
+		assert.Nil(err, "err nil")
+		defer db.Close()
+
+		w := &statsWriter{db: db}
+
+		rebuilt := message.NewStatMessage(map[string]int{"requests.total": 100}, start+88, start+90)
+		rebuilt.Rebuilt = true
+		payload, err := json.Marshal(rebuilt)
+		assert.Nil(err, "err nil")
+		assert.Nil(w.processMessage(payload), "err nil")
+
+		points, err := db.Query("requests.total", start+90, start+90, 0, AggSum)
+		assert.Nil(err, "err nil")
+		assert.Equal(0, len(points), "rebuilt stats skipped")
+
+		payload, err = json.Marshal(message.NewStatMessage(map[string]int{"requests.total": 100}, start+88, start+90))
+		assert.Nil(err, "err nil")
+		assert.Nil(w.processMessage(payload), "err nil")
+
+		points, err = db.Query("requests.total", start+90, start+90, 0, AggSum)
+		assert.Nil(err, "err nil")
+		assert.Equal([]Point{{start + 90, 100}}, points, "live stats stored")
+	})
+
+	t.Run("Open - names from the index, blocks cached", func(t *testing.T) {
+		db, err := Open(dir, opts)
+		assert.Nil(err, "err nil")
+		defer db.Close()
+
+		_, err = os.Stat(filepath.Join(dir, "names.json"))
+		assert.Nil(err, "names index written")
+
+		_, err = db.Query("requests.total", start, start+59, 0, AggSum)
+		assert.Nil(err, "err nil")
+
+		// blocks read are kept decoded for the next queries
+		raw, err := listBlocks(filepath.Join(dir, "raw"))
+		assert.Nil(err, "err nil")
+		cached, ok := db.cache[raw[0].path]
+		assert.True(ok, "block cached")
+		data, err := db.block(raw[0].path)
+		assert.Nil(err, "err nil")
+		assert.True(cached == data, "block served from the cache")
+
+		// names are known without reading the blocks
+		reopened, err := Open(dir, opts)
+		assert.Nil(err, "err nil")
+		defer reopened.Close()
+		assert.Equal([]string{"requests.total"}, reopened.Metrics(), "names from the index")
+	})
+
+	t.Run("compact - retention on the clock time", func(t *testing.T) {
+		dir := filepath.Join(dir, "clock")
+
+		// points replayed from a log of 2018
+		begin := time.Date(2018, 5, 9, 16, 0, 0, 0, time.UTC)
+		c := clock.NewFake(begin)
+
+		clockOpts := opts
+		clockOpts.RawRetention = time.Hour
+		clockOpts.Clock = c
+
+		db, err := Open(dir, clockOpts)
+		assert.Nil(err, "err nil")
+		defer db.Close()
+
+		for i := int64(0); i < 90; i += 2 {
+			assert.Nil(db.AppendStats(begin.Unix()+i, map[string]int{"old.metric": 1}), "err nil")
+		}
+		c.Set(begin.Add(90 * time.Second))
+
+		raw, err := listBlocks(filepath.Join(dir, "raw"))
+		assert.Nil(err, "err nil")
+		assert.Equal(1, len(raw), "block within retention of the clock")
+
+		points, err := db.Query("old.metric", begin.Unix(), begin.Unix()+59, 0, AggCount)
+		assert.Nil(err, "err nil")
+		assert.Equal(30, len(points), "raw points within retention of the clock")
+
+		// an hour later the block and its names are out of raw retention
+		c.Advance(2 * time.Hour)
+		assert.Nil(db.AppendStats(c.Now().Unix(), map[string]int{"new.metric": 1}), "err nil")
+		assert.Nil(db.AppendStats(c.Now().Unix()+60, map[string]int{"new.metric": 1}), "err nil")
+
+		raw, err = listBlocks(filepath.Join(dir, "raw"))
+		assert.Nil(err, "err nil")
+		for _, b := range raw {
+			assert.True(b.mint > begin.Unix()+60, "old block removed")
+		}
+		assert.Equal([]string{"new.metric"}, db.Metrics(), "names out of retention removed")
+	})
+
+	t.Run("bucket - empty value", func(t *testing.T) {
+		b := bucket{}
+		assert.True(math.IsNaN(b.value(AggAvg)), "no value for empty bucket")
+	})
+}
@@ -0,0 +1,80 @@
+package tsdb
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/juacker/loghound/internal/broker"
+	"github.com/juacker/loghound/internal/message"
+)
+
+type statsWriter struct {
+	ctl    chan bool
+	wg     *sync.WaitGroup
+	broker broker.Link
+	db     *DB
+}
+
+func (w *statsWriter) loop() {
+	log.Println("tsdb: initializing stats storage")
+
+LOOP:
+	for {
+		select {
+		case payload := <-w.broker.Receive():
+			err := w.processMessage(payload)
+			if err != nil {
+				log.Println("tsdb: failed processing message: ", err)
+			}
+		case <-w.ctl:
+			log.Println("tsdb: ctl signal received, exiting")
+			break LOOP
+		}
+	}
+
+	err := w.db.Close()
+	if err != nil {
+		log.Println("tsdb: failed closing storage: ", err)
+	}
+
+	w.wg.Done()
+}
+
+func (w *statsWriter) processMessage(payload []byte) error {
+	var msg message.StatMessage
+	err := json.Unmarshal(payload, &msg)
+	if err != nil {
+		return err
+	}
+
+	// check message is the expected
+	if !msg.IsValid() {
+		return fmt.Errorf("invalid message")
+	}
+
+	// stats rebuilt from the journal are already stored since before the restart
+	if msg.Rebuilt {
+		return nil
+	}
+
+	return w.db.AppendStats(msg.End, msg.Stats)
+}
+
+// Run starts the stats storage, every stat message but the rebuilt ones is persisted in db
+func Run(wg *sync.WaitGroup, ctl chan bool, db *DB) {
+	conn, err := broker.NewConnection("tsdb", broker.TopicStat)
+	if err != nil {
+		log.Fatal("tsdb: failed opening broker connection ", err)
+	}
+
+	writer := &statsWriter{
+		ctl:    ctl,
+		wg:     wg,
+		broker: conn,
+		db:     db,
+	}
+
+	writer.loop()
+}
@@ -0,0 +1,375 @@
+package clf
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	tassert "github.com/stretchr/testify/assert"
+)
+
+// equalParse checks Parse and ParseRegexp return the same entry or error for line
+func equalParse(line string) error {
+	fast, fastErr := Parse(line)
+	slow, slowErr := ParseRegexp(line)
+
+	if fastErr != nil || slowErr != nil {
+		if fmt.Sprint(fastErr) != fmt.Sprint(slowErr) || Reason(fastErr) != Reason(slowErr) {
+			return fmt.Errorf("errors differ for %q: %v != %v", line, fastErr, slowErr)
+		}
+		return nil
+	}
+
+	fastZone, fastOffset := fast.Date.Zone()
+	slowZone, slowOffset := slow.Date.Zone()
+	if !fast.Date.Equal(slow.Date) || fastZone != slowZone || fastOffset != slowOffset || fast.Date.Location() != slow.Date.Location() && fast.Date.Location().String() != slow.Date.Location().String() {
+		return fmt.Errorf("dates differ for %q: %v != %v", line, fast.Date, slow.Date)
+	}
+
+	fast.Date, slow.Date = time.Time{}, time.Time{}
+	if !reflect.DeepEqual(fast.Request, slow.Request) {
+		return fmt.Errorf("requests differ for %q: %+v != %+v", line, *fast.Request, *slow.Request)
+	}
+	fast.Request, slow.Request = nil, nil
+	if *fast != *slow {
+		return fmt.Errorf("entries differ for %q: %+v != %+v", line, *fast, *slow)
+	}
+
+	return nil
+}
+
+// lines are valid and invalid lines, also the seed corpus of the fuzz test
+var lines = []string{
+	`127.0.0.1 - james [09/May/2018:16:00:39 +0000] "GET /report HTTP/1.0" 200 123`,
+	`127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "POST /api/user?id=1 HTTP/1.1" 201 2326 "http://example.com/" "Mozilla/5.0"`,
+	`::1 - - [29/Feb/2020:23:59:59 +0530] "DELETE  HTTP/2" 204 -`,
+	`10.0.0.1 - - [15/Jul/2021:10:00:00 -0400] "HEAD /health HTTP/1.1" 200 0`,
+	`10.0.0.1 - - [30/Feb/2020:00:00:00 +0000] "GET / HTTP/1.1" 200 1`,
+	`10.0.0.1 - - [1/Jan/2020:00:00:00 +0000] "GET / HTTP/1.1" 200 1`,
+	`10.0.0.1 - - [01/jan/2020:00:00:00 +0000] "GET / HTTP/1.1" 200 1`,
+	`10.0.0.1 - - [01/Jan/2020:24:00:00 +0000] "GET / HTTP/1.1" 200 1`,
+	`10.0.0.1 - - [01/Jan/2020:00:00:00 +2400] "GET / HTTP/1.1" 200 1`,
+	`10.0.0.1 - - [01/Jan/2020:00:00:00 +0000] "get / HTTP/1.1" 200 1`,
+	`10.0.0.1 - - [01/Jan/2020:00:00:00 +0000] "GET / HTTP/" 200 1`,
+	`10.0.0.1 - - [01/Jan/2020:00:00:00 +0000] "GET /a b HTTP/1.1" 200 1`,
+	`10.0.0.1 - - [01/Jan/2020:00:00:00 +0000] "GET / HTTP/1.1" 20 1`,
+	`10.0.0.1 - - [01/Jan/2020:00:00:00 +0000] "GET / HTTP/1.1" 200 99999999999999999999`,
+	`10.0.0.1 - - [01/Jan/2020:00:00:00 +0000] "GET / HTTP/1.1" 200 x`,
+	"10.0.0.1\t- - [01/Jan/2020:00:00:00 +0000] \"GET / HTTP/1.1\" 200 1",
+	`10.0.0.1 - - [] "GET / HTTP/1.1" 200 1`,
+	`10.0.0.1 - - [01/Jan/2020:00:00:00 +0000 "GET / HTTP/1.1" 200 1`,
+	"",
+	"garbage",
+}
+
+func TestPkgCLF(t *testing.T) {
+
+	assert := tassert.New(t)
+
+	t.Run("Parse - combined format", func(t *testing.T) {
+		entry, err := Parse(lines[1])
+		assert.Nil(err, "err nil")
+		assert.Equal("127.0.0.1", entry.RemoteHost)
+		assert.Equal("frank", entry.AuthUser)
+		assert.Equal("POST", entry.Request.Method)
+		assert.Equal("/api/user", entry.Request.Path)
+		assert.Equal(201, entry.Status)
+		assert.Equal(2326, entry.Bytes)
+		assert.Equal(time.Date(2000, 10, 10, 20, 55, 36, 0, time.UTC).Unix(), entry.Date.Unix())
+		_, offset := entry.Date.Zone()
+		assert.Equal(-7*3600, offset, "zone offset")
+	})
+
+	t.Run("Parse - errors", func(t *testing.T) {
+		_, err := Parse("garbage")
+		assert.Equal(`line not in common log format: "garbage"`, err.Error())
+		_, err = Parse(`10.0.0.1 - - [30/Feb/2020:00:00:00 +0000] "GET / HTTP/1.1" 200 1`)
+		assert.Equal(ReasonDate, Reason(err), "day out of range")
+		_, err = Parse(`10.0.0.1 - - [01/Jan/2020:00:00:00 +0000] "GET / HTTP/1.1" 200 x`)
+		assert.Equal(ReasonFormat, Reason(err), "invalid bytes")
+	})
+
+	t.Run("Parse - bytes not sent", func(t *testing.T) {
+		for _, parse := range []func(string) (*Entry, error){Parse, ParseRegexp} {
+			entry, err := parse(`::1 - - [29/Feb/2020:23:59:59 +0530] "DELETE  HTTP/2" 204 -`)
+			assert.Nil(err, "err nil")
+			assert.Equal(204, entry.Status)
+			assert.Equal(0, entry.Bytes, "- as 0 bytes")
+		}
+	})
+
+	t.Run("Parse - bad lines rejected without panic", func(t *testing.T) {
+		lines := map[string]string{
+			"":      ReasonFormat,
+			"hello": ReasonFormat,
+			`1.2.3.4 - - [yesterday] "GET / HTTP/1.1" 200 10`:                                    ReasonDate,
+			`1.2.3.4 - - [10/Oct/2021:13:55:36 +0000] "GET / HTTP/1.1" 200 99999999999999999999`: ReasonBytes,
+		}
+
+		for line, reason := range lines {
+			entry, err := Parse(line)
+			assert.Nil(entry, "no entry")
+			assert.Equal(reason, Reason(err), line)
+		}
+	})
+
+	t.Run("NewRequest - path and query parameters", func(t *testing.T) {
+		r := NewRequest("GET", "/search%20all?q=a%26b&api_version=2&tag=x&tag=y")
+		assert.Equal("/search%20all?q=a%26b&api_version=2&tag=x&tag=y", r.Target, "target as logged")
+		assert.Equal("/search all", r.Path, "decoded path")
+		assert.Equal("q=a%26b&api_version=2&tag=x&tag=y", r.RawQuery)
+		assert.Equal("a&b", r.Query.Get("q"))
+		assert.Equal("2", r.Query.Get("api_version"))
+		assert.Equal([]string{"x", "y"}, r.Query["tag"])
+
+		r = NewRequest("GET", "/")
+		assert.Equal("/", r.Path)
+		assert.Equal("", r.RawQuery)
+		assert.Nil(r.Query, "no query string")
+
+		r = NewRequest("GET", "/bad%zz?a=%zz&b=1")
+		assert.Equal("/bad%zz", r.Path, "undecodable path kept")
+		assert.Equal("1", r.Query.Get("b"), "valid parameters kept")
+		_, ok := r.Query["a"]
+		assert.False(ok, "undecodable parameter skipped")
+	})
+
+	t.Run("Parse - same as the regexp parser", func(t *testing.T) {
+		for _, line := range lines {
+			assert.Nil(equalParse(line))
+		}
+	})
+
+	t.Run("W3CParser - fields directives mid-file", func(t *testing.T) {
+		p, err := NewParser(FormatW3C)
+		assert.Nil(err, "err nil")
+
+		_, err = p.Parse("#Software: Microsoft Internet Information Services 10.0\r\n")
+		assert.Equal(ErrDirective, err, "comment")
+
+		entry, err := p.Parse("2021-03-04 10:20:30 10.0.0.5 GET /default.aspx q=a+b 443 - 192.168.1.9 Mozilla/5.0 - 200 0 0 125\r\n")
+		assert.Nil(err, "err nil, IIS default fields")
+		assert.Equal(time.Date(2021, 3, 4, 10, 20, 30, 0, time.UTC), entry.Date)
+		assert.Equal("192.168.1.9", entry.RemoteHost)
+		assert.Equal("/default.aspx", entry.Request.Path)
+		assert.Equal("a b", entry.Request.Query.Get("q"))
+		assert.Equal(200, entry.Status)
+		assert.Equal(125*time.Millisecond, entry.Latency, "time-taken in ms")
+
+		_, err = p.Parse("#Date: 2021-03-05 00:00:00")
+		assert.Equal(ErrDirective, err, "date directive")
+		_, err = p.Parse("#Fields: time c-ip cs-method cs-uri sc-status sc-bytes time-taken")
+		assert.Equal(ErrDirective, err, "fields directive")
+
+		entry, err = p.Parse("01:02:03 10.1.1.1 POST /api?v=2 503 1024 0.250")
+		assert.Nil(err, "err nil, new fields")
+		assert.Equal(time.Date(2021, 3, 5, 1, 2, 3, 0, time.UTC), entry.Date, "date of the directive")
+		assert.Equal("POST", entry.Request.Method)
+		assert.Equal("/api?v=2", entry.Request.Target)
+		assert.Equal(503, entry.Status)
+		assert.Equal(1024, entry.Bytes)
+		assert.Equal(250*time.Millisecond, entry.Latency, "time-taken in seconds")
+
+		_, err = p.Parse("01:02:03 10.1.1.1 POST /api 503 1024")
+		assert.Equal(ReasonFormat, Reason(err), "missing field")
+		assert.Equal(`line not in W3C extended log format: "01:02:03 10.1.1.1 POST /api 503 1024"`, err.Error())
+		_, err = p.Parse("01:02:03 10.1.1.1 POST /api 5x3 1024 1")
+		assert.Equal(ReasonStatus, Reason(err), "invalid status")
+	})
+
+	t.Run("ParseALB and ParseELB - load balancer fields", func(t *testing.T) {
+		entry, err := ParseALB(`https 2018-07-02T22:23:00.186641Z app/my-lb/50dc6c495c0c9188 192.168.131.39:2817 10.0.0.1:80 0.001 0.048 0.001 502 504 34 366 "GET https://www.example.com:443/orders/1?expand=items HTTP/1.1" "curl/7.46.0" ECDHE-RSA-AES128-GCM-SHA256 TLSv1.2 arn:aws:elasticloadbalancing:us-east-2:123456789012:targetgroup/my-targets/73e2d6bc24d8a067 "Root=1-58337281-1d84f3d73c47ec4e58577259" "www.example.com" "-" 1 2018-07-02T22:22:48.364000Z "forward" "-" "-" "10.0.0.1:80" "504" "-" "-"`)
+		assert.Nil(err, "err nil")
+		assert.Equal(time.Date(2018, 7, 2, 22, 23, 0, 186641000, time.UTC), entry.Date)
+		assert.Equal("192.168.131.39", entry.RemoteHost)
+		assert.Equal("/orders/1", entry.Request.Path)
+		assert.Equal("items", entry.Request.Query.Get("expand"))
+		assert.Equal(502, entry.Status)
+		assert.Equal(366, entry.Bytes, "sent bytes")
+		assert.Equal(50*time.Millisecond, entry.Latency, "processing times")
+		assert.Equal("10.0.0.1:80", entry.Backend)
+		assert.Equal(504, entry.BackendStatus)
+		assert.Equal(48*time.Millisecond, entry.BackendLatency)
+
+		entry, err = ParseELB(`2015-05-13T23:39:43.945958Z my-lb 192.168.131.39:2817 - -1 -1 -1 503 - 0 0 "GET http://www.example.com:80/ HTTP/1.1" "curl/7.38.0" - -`)
+		assert.Nil(err, "err nil")
+		assert.Equal("/", entry.Request.Path)
+		assert.Equal(503, entry.Status)
+		assert.Equal("", entry.Backend, "not sent to a backend")
+		assert.Equal(0, entry.BackendStatus)
+		assert.Equal(time.Duration(0), entry.Latency)
+
+		_, err = ParseELB(`2015-05-13T23:39:43.945958Z my-lb 192.168.131.39:2817 10.0.0.1:80 0.000073 0.001048 0.000057 - - 57 502 "- - - " "-" - -`)
+		assert.Equal(ReasonFormat, Reason(err), "tcp listener")
+		_, err = ParseALB(lines[0])
+		assert.Equal(ReasonFormat, Reason(err), "common log format")
+	})
+
+	t.Run("ParseGCP - json entries", func(t *testing.T) {
+		entry, err := ParseGCP(`{"timestamp":"2021-01-01T00:00:00.123Z","httpRequest":{"requestMethod":"GET","requestUrl":"https://example.com/search?q=x%20y","status":200,"responseSize":"512","remoteIp":"1.2.3.4","serverIp":"10.0.0.7","latency":"0.012s"},"resource":{"type":"http_load_balancer","labels":{"backend_service_name":"web"}}}`)
+		assert.Nil(err, "err nil")
+		assert.Equal(time.Date(2021, 1, 1, 0, 0, 0, 123000000, time.UTC), entry.Date)
+		assert.Equal("1.2.3.4", entry.RemoteHost)
+		assert.Equal("/search", entry.Request.Path)
+		assert.Equal("x y", entry.Request.Query.Get("q"))
+		assert.Equal(200, entry.Status)
+		assert.Equal(512, entry.Bytes)
+		assert.Equal(12*time.Millisecond, entry.Latency)
+		assert.Equal("web", entry.Backend)
+
+		entry, err = ParseGCP(`{"timestamp":"2021-01-01T00:00:00Z","httpRequest":{"requestMethod":"GET","requestUrl":"https://example.com/","remoteIp":"1.2.3.4","latency":"2.5s"},"jsonPayload":{"statusDetails":"client_disconnected_before_any_response"}}`)
+		assert.Nil(err, "request closed by the client kept")
+		assert.Equal(0, entry.Status, "no response")
+		assert.Equal(2500*time.Millisecond, entry.Latency)
+		_, err = ParseGCP(`{"timestamp":"2021-01-01T00:00:00Z","httpRequest":{"requestMethod":"GET","requestUrl":"https://example.com/","status":42}}`)
+		assert.Equal(ReasonStatus, Reason(err), "invalid status")
+		_, err = ParseGCP(`{"timestamp":"2021-01-01T00:00:00Z"}`)
+		assert.Equal(ReasonFormat, Reason(err), "not a request")
+	})
+
+	t.Run("ParseHAProxy - timers and termination state", func(t *testing.T) {
+		entry, err := ParseHAProxy(`Feb  6 12:14:14 localhost haproxy[14389]: 10.0.1.2:33317 [06/Feb/2009:12:14:14.655] http-in static/srv1 10/0/30/69/109 200 2750 - - ---- 1/1/1/1/0 0/0 {1wt.eu|Mozilla/5.0 (X11; "quoted")} {} "GET /index.html?lang=en HTTP/1.1"`)
+		assert.Nil(err, "err nil")
+		assert.Equal(time.Date(2009, 2, 6, 12, 14, 14, 655000000, time.Local), entry.Date, "local time")
+		assert.Equal("10.0.1.2", entry.RemoteHost)
+		assert.Equal("/index.html", entry.Request.Path)
+		assert.Equal("en", entry.Request.Query.Get("lang"))
+		assert.Equal(200, entry.Status)
+		assert.Equal(2750, entry.Bytes)
+		assert.Equal(109*time.Millisecond, entry.Latency, "Tt")
+		assert.Equal(99*time.Millisecond, entry.BackendLatency, "Tw, Tc and Tr")
+		assert.Equal("static/srv1", entry.Backend)
+		assert.Equal("", entry.Termination, "normal termination")
+
+		entry, err = ParseHAProxy(`10.0.1.2:33318 [06/Feb/2009:12:14:15.001] http-in api/srv2 5/0/1/-1/+2003 -1 +0 - - CD-- 2/2/1/1/0 0/0 "POST /api/orders HTTP/1.1"`)
+		assert.Nil(err, "err nil, without syslog header")
+		assert.Equal(0, entry.Status, "aborted before the response")
+		assert.Equal(2003*time.Millisecond, entry.Latency, "logasap total")
+		assert.Equal(time.Millisecond, entry.BackendLatency, "connected, no response")
+
+		assert.Equal("CD", entry.Termination, "client aborted during data transfer")
+		entry, err = ParseHAProxy(`10.0.1.2:33319 [06/Feb/2009:12:14:16.001] http-in api/srv2 2/4000/-1/-1/4002 503 212 - - sQ-- 2/2/1/1/0 0/3 "GET /api/orders HTTP/1.1"`)
+		assert.Nil(err, "err nil")
+		assert.Equal(4000*time.Millisecond, entry.BackendLatency, "queued until timeout")
+		assert.Equal(4002*time.Millisecond, entry.Latency, "Tq in the total")
+		assert.Equal("sQ", entry.Termination, "server timeout in queue")
+
+		_, err = ParseHAProxy(`10.0.1.2:33318 [06/Feb/2009:12:14:15.001] http-in api/srv2 5/0/1 200 10 - - ---- 2/2/1/1/0 0/0 "GET / HTTP/1.1"`)
+		assert.Equal(ReasonLatency, Reason(err), "missing timers")
+		_, err = ParseHAProxy(`10.0.1.2:33318 [06/Feb/2009:12:14:15.001] http-in api/srv2 5/x/1/2/8 200 10 - - ---- 2/2/1/1/0 0/0 "GET / HTTP/1.1"`)
+		assert.Equal(ReasonLatency, Reason(err), "invalid queue timer")
+		_, err = ParseHAProxy(lines[0])
+		assert.Equal(ReasonFormat, Reason(err), "common log format")
+	})
+
+	t.Run("ParseEnvoy - response flags", func(t *testing.T) {
+		entry, err := ParseEnvoy(`[2016-04-15T20:17:00.310Z] "POST /api/v1/locations HTTP/2" 204 - 154 0 226 100 "10.0.35.28, 10.0.0.1" "nsq2http" "cc21d9b0-cf5c-432b-8c7e-98aeb7988cd2" "locations" "tcp://10.0.2.1:80"`)
+		assert.Nil(err, "err nil")
+		assert.Equal(time.Date(2016, 4, 15, 20, 17, 0, 310000000, time.UTC), entry.Date)
+		assert.Equal("10.0.35.28", entry.RemoteHost, "first forwarded address")
+		assert.Equal("/api/v1/locations", entry.Request.Path)
+		assert.Equal(204, entry.Status)
+		assert.Equal(226*time.Millisecond, entry.Latency)
+		assert.Equal(100*time.Millisecond, entry.BackendLatency)
+		assert.Equal("tcp://10.0.2.1:80", entry.Backend)
+		assert.Equal("", entry.Termination, "no flags")
+
+		entry, err = ParseEnvoy(`[2016-04-15T20:17:01.000Z] "GET /slow HTTP/1.1" 503 UF,URX 0 91 3001 - "-" "curl/7.58.0" "d2b1" "api" "-"`)
+		assert.Nil(err, "err nil")
+		assert.Equal("UF,URX", entry.Termination)
+		assert.Equal("", entry.Backend, "no upstream")
+		assert.Equal("-", entry.RemoteHost)
+
+		_, err = ParseEnvoy(`[2016-04-15T20:17:01.000Z] "- - -" 0 UC 0 0 12 - "-" "-" "-" "-" "10.0.2.1:5432"`)
+		assert.Equal(ReasonFormat, Reason(err), "tcp proxy")
+	})
+
+	t.Run("NewParser - formats", func(t *testing.T) {
+		for _, format := range Formats {
+			_, err := NewParser(format)
+			assert.Nil(err, format)
+		}
+		_, err := NewParser("xml")
+		assert.NotNil(err, "unknown format")
+
+		p, err := NewParser(`regexp:^(?P<date>[^|]+)\|(?P<status>\d+)\|(?P<path>\S+)`)
+		assert.Nil(err, "custom format")
+		entry, err := p.Parse("10/Oct/2000:13:55:36 -0700|404|/missing")
+		assert.Nil(err, "err nil")
+		assert.Equal(404, entry.Status, "status of the custom format")
+		assert.Equal("/missing", entry.Request.Path, "path of the custom format")
+
+		_, err = NewParser(`regexp:^(?P<status>\d+)`)
+		assert.NotNil(err, "custom format without date")
+		_, err = NewParser(`regexp:(`)
+		assert.NotNil(err, "invalid expression")
+	})
+
+	t.Run("RegexpParser - custom format", func(t *testing.T) {
+		p, err := NewRegexpParser(`^(?P<date>[^|]+)\|(?P<status>\d+)\|(?P<path>\S+)`)
+		assert.Nil(err, "err nil")
+
+		entry, err := p.Parse("10/Oct/2000:13:55:36 -0700|404|/missing")
+		assert.Nil(err, "err nil")
+		assert.Equal(404, entry.Status)
+		assert.Equal("/missing", entry.Request.Path)
+		assert.Equal(0, entry.Bytes, "bytes not in the format")
+
+		_, err = NewRegexpParser(`^(?P<path>\S+)`)
+		assert.NotNil(err, "date and status required")
+	})
+
+	t.Run("Dashboard - panels show the interval until the clock time", func(t *testing.T) {
+		now := time.Date(2021, 1, 1, 10, 0, 0, 0, time.UTC)
+
+		d := NewDashboard(100, 50, 60, DefaultLayout())
+		d.SetClock(func() time.Time { return now })
+
+		d.AddPoint("requests.total", now.Unix()-90, 1)
+		d.AddPoint("requests.total", now.Unix()-30, 2)
+		d.AddPoint("requests.total", now.Unix(), 3)
+		d.AddBand("requests.total", now.Unix()-90, 0, 1)
+		d.AddBand("requests.total", now.Unix()-20, 1, 4)
+		assert.Equal([][]float64{{2, 3}, {0, 1}, {1, 4}}, d.plotData("requests.total"), "points of the last minute and their bands")
+
+		d.AddPoint("bytes.total", now.Unix()-90, 100)
+		assert.Equal([][]float64{{0, 0}}, d.plotData("bytes.total"), "no points in the interval")
+
+		d.Message(now.Add(-90*time.Second), "old alert")
+		d.Message(now.Add(-10*time.Second), "new alert")
+		d.ActiveAlerts([]string{"high-traffic"})
+		d.updateMessagesPanel()
+		assert.Equal([]string{
+			"ACTIVE high-traffic",
+			fmt.Sprintf("[%v] new alert", now.Add(-10*time.Second)),
+		}, d.messagesPanel.Rows, "messages of the last minute")
+		assert.Equal(1, len(d.messages), "old messages dropped")
+
+		// the clock moves on
+		now = now.Add(time.Minute)
+		assert.Equal([][]float64{{0, 0}}, d.plotData("requests.total"), "points out of the interval")
+		d.updateMessagesPanel()
+		assert.Equal([]string{"ACTIVE high-traffic"}, d.messagesPanel.Rows, "messages out of the interval")
+	})
+}
+
+func BenchmarkParse(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_, err := Parse(lines[1])
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkParseRegexp(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_, err := ParseRegexp(lines[1])
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
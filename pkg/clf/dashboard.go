@@ -3,7 +3,6 @@ package clf
 import (
 	"fmt"
 	"sort"
-	"strings"
 	"sync"
 	"time"
 
@@ -16,11 +15,48 @@ type point struct {
 	Value     float64
 }
 
+// band represents the normal values of a metric at a given time
+type band struct {
+	Timestamp int64
+	Lower     float64
+	Upper     float64
+}
+
+// sloStatus represents the error budget of an SLO
+type sloStatus struct {
+	Objective float64
+	Remaining float64
+	Burn      float64
+}
+
 type message struct {
 	Time time.Time
 	Text string
 }
 
+// Layout sets the height of the dashboard rows, as percentages of the terminal
+// height: metric plots on top, paths tables in the middle and alerts below
+type Layout struct {
+	Plots  int
+	Tables int
+}
+
+// DefaultLayout returns the default dashboard layout
+func DefaultLayout() Layout {
+	return Layout{
+		Plots:  33,
+		Tables: 50,
+	}
+}
+
+// Validate checks the rows leave room for the alerts
+func (l Layout) Validate() error {
+	if l.Plots <= 0 || l.Tables <= 0 || l.Plots+l.Tables >= 100 {
+		return fmt.Errorf("invalid layout, plots (%d%%) and tables (%d%%) must be positive and leave room for alerts", l.Plots, l.Tables)
+	}
+	return nil
+}
+
 // Dashboard defines a dashboard to show common log format metrics
 type Dashboard struct {
 	sync.Mutex
@@ -29,6 +65,8 @@ type Dashboard struct {
 	width    int
 	height   int
 	interval int64
+	layout   Layout
+	now      func() time.Time
 
 	// panels
 	totalRequestsPanel *widgets.Plot
@@ -36,15 +74,47 @@ type Dashboard struct {
 	pathRequestsPanel  *widgets.Table
 	pathBytesPanel     *widgets.Table
 	messagesPanel      *widgets.List
+	sloPanel           *widgets.Table
+	healthPanel        *widgets.Table
+	rejectedPanel      *widgets.List
+
+	// state
+	showHistory bool
+	middle      int
 
 	//data
-	messages     []message
-	metrics      map[string][]point
-	sortedPaths  []string
-	pathRequests map[string]float64
-	pathBytes    map[string]float64
-	pathStatus   map[string]float64
-	pathMethods  map[string]float64
+	active   []string
+	messages []message
+	history  []message
+	metrics  map[string][]point
+	bands    map[string][]band
+	paths    *PathTable
+	slos     map[string]sloStatus
+	health   [][]string
+	rejected []message
+}
+
+// views of the middle row
+const (
+	viewPaths = iota
+	viewHealth
+	viewRejected
+)
+
+// number of messages kept in the alerts history
+const historySize = 500
+
+// number of rejected lines kept
+const rejectedSize = 100
+
+// plotsBottom returns the bottom of the plots row
+func (d *Dashboard) plotsBottom() int {
+	return d.height * d.layout.Plots / 100
+}
+
+// tablesBottom returns the bottom of the tables row
+func (d *Dashboard) tablesBottom() int {
+	return d.plotsBottom() + d.height*d.layout.Tables/100
 }
 
 // Resize resizes the dashboard
@@ -70,10 +140,25 @@ func (d *Dashboard) Render() {
 	ui.Render(
 		d.totalRequestsPanel,
 		d.totalBytesPanel,
-		d.pathRequestsPanel,
-		d.pathBytesPanel,
 		d.messagesPanel,
 	)
+
+	// health and rejected lines panels take the place of the paths tables
+	switch d.middle {
+	case viewHealth:
+		d.updateHealthPanel()
+		ui.Render(d.healthPanel)
+	case viewRejected:
+		d.updateRejectedPanel()
+		ui.Render(d.rejectedPanel)
+	default:
+		ui.Render(d.pathRequestsPanel, d.pathBytesPanel)
+	}
+
+	if len(d.slos) > 0 {
+		d.updateSLOPanel()
+		ui.Render(d.sloPanel)
+	}
 }
 
 // Message adds a new message to the messages panel
@@ -84,6 +169,90 @@ func (d *Dashboard) Message(t time.Time, text string) {
 	d.messages = append(d.messages, message{t, text})
 }
 
+// ActiveAlerts sets the active alerts shown on top of the messages panel
+func (d *Dashboard) ActiveAlerts(alerts []string) {
+	d.Lock()
+	defer d.Unlock()
+
+	d.active = alerts
+}
+
+// History adds a new message to the alerts history, shown instead of
+// the messages panel when toggled
+func (d *Dashboard) History(t time.Time, text string) {
+	d.Lock()
+	defer d.Unlock()
+
+	d.history = append(d.history, message{t, text})
+	if len(d.history) > historySize {
+		d.history = d.history[len(d.history)-historySize:]
+	}
+}
+
+// ToggleHistory switches the messages panel between recent alerts and alerts history
+func (d *Dashboard) ToggleHistory() {
+	d.Lock()
+	defer d.Unlock()
+
+	d.showHistory = !d.showHistory
+}
+
+// ToggleHealth switches the paths tables with the loghound health panel
+func (d *Dashboard) ToggleHealth() {
+	d.toggleMiddle(viewHealth)
+}
+
+// ToggleRejected switches the paths tables with the lines rejected by the parser
+func (d *Dashboard) ToggleRejected() {
+	d.toggleMiddle(viewRejected)
+}
+
+func (d *Dashboard) toggleMiddle(view int) {
+	d.Lock()
+	defer d.Unlock()
+
+	if d.middle == view {
+		d.middle = viewPaths
+	} else {
+		d.middle = view
+	}
+}
+
+// Rejected adds a line rejected by the parser, with the time it was read
+func (d *Dashboard) Rejected(t time.Time, text string) {
+	d.Lock()
+	defer d.Unlock()
+
+	d.rejected = append(d.rejected, message{t, text})
+	if len(d.rejected) > rejectedSize {
+		d.rejected = d.rejected[len(d.rejected)-rejectedSize:]
+	}
+}
+
+// Health sets the rows of the loghound health panel, as metric, labels and value
+func (d *Dashboard) Health(rows [][]string) {
+	d.Lock()
+	defer d.Unlock()
+
+	d.health = rows
+}
+
+// SLO sets the error budget remaining and the burn rate of an SLO, shown next to the messages panel
+func (d *Dashboard) SLO(name string, objective, remaining, burn float64) {
+	d.Lock()
+	defer d.Unlock()
+
+	d.slos[name] = sloStatus{objective, remaining, burn}
+}
+
+// AddBand adds the normal values band of a metric at timestamp, drawn with the metric plot
+func (d *Dashboard) AddBand(metric string, timestamp int64, lower, upper float64) {
+	d.Lock()
+	defer d.Unlock()
+
+	d.bands[metric] = append(d.bands[metric], band{timestamp, lower, upper})
+}
+
 // AddPoint adds a new point of the correspoinding metric
 func (d *Dashboard) AddPoint(metric string, timestamp int64, value float64) {
 	d.Lock()
@@ -94,87 +263,77 @@ func (d *Dashboard) AddPoint(metric string, timestamp int64, value float64) {
 		d.metrics[metric] = append(d.metrics[metric], point{timestamp, value})
 	} else {
 		// used for middle panels
-
-		metricWords := strings.Split(metric, ".")
-		if len(metricWords) == 3 {
-			rootPath := metricWords[1]
-
-			switch t := metricWords[2]; t {
-			case "requests":
-				if _, ok := d.pathRequests[rootPath]; !ok {
-					d.sortedPaths = append(d.sortedPaths, rootPath)
-					sort.Strings(d.sortedPaths)
-				}
-
-				d.pathRequests[rootPath] = value
-			case "bytes":
-				d.pathBytes[rootPath] = value
-			}
-		} else if len(metricWords) == 5 {
-			rootPath := metricWords[1]
-			subtype := metricWords[3]
-			switch t := metricWords[4]; t {
-			case "requests":
-				pathStatus := strings.Join([]string{rootPath, string(subtype[0]) + "xx"}, ".")
-				d.pathStatus[pathStatus] += value
-			case "bytes":
-				d.pathMethods[strings.Join([]string{rootPath, subtype}, ".")] = value
-			}
-		}
+		d.paths.Add(metric, value)
 	}
 }
 
-func (d *Dashboard) updateTotalRequestsPanel() {
-	limit := time.Now().Unix() - d.interval
+// plotData returns the metric points within the dashboard interval, plus the lower and
+// upper lines of its band if there is one
+func (d *Dashboard) plotData(metric string) [][]float64 {
+	limit := d.now().Unix() - d.interval
 
 	points := make([][]float64, 1)
 	points[0] = make([]float64, 0)
+	timestamps := make([]int64, 0)
 
-	for _, v := range d.metrics["requests.total"] {
+	for _, v := range d.metrics[metric] {
 		if v.Timestamp >= limit {
 			points[0] = append(points[0], v.Value)
+			timestamps = append(timestamps, v.Timestamp)
 		}
 	}
 
+	// drop bands out of the interval
+	bands := d.bands[metric]
+	for len(bands) > 1 && bands[1].Timestamp <= limit {
+		bands = bands[1:]
+	}
+	d.bands[metric] = bands
+
+	if len(bands) > 0 && len(points[0]) >= 2 {
+		lower := make([]float64, len(timestamps))
+		upper := make([]float64, len(timestamps))
+
+		// every point gets the last band known at its time
+		b := 0
+		for i, ts := range timestamps {
+			for b+1 < len(bands) && bands[b+1].Timestamp <= ts {
+				b++
+			}
+			lower[i], upper[i] = bands[b].Lower, bands[b].Upper
+		}
+
+		points = append(points, lower, upper)
+	}
+
 	if len(points[0]) < 2 {
 		points[0] = []float64{0, 0}
 	} else if len(points[0]) >= d.width/2 {
-		points[0] = points[0][len(points[0])-d.width/2 : len(points[0])]
+		for i := range points {
+			points[i] = points[i][len(points[i])-d.width/2 : len(points[i])]
+		}
 	}
 
+	return points
+}
+
+func (d *Dashboard) updateTotalRequestsPanel() {
 	// requests.total panel at top left
 	d.totalRequestsPanel.Title = "Total Requests"
-	d.totalRequestsPanel.Data = points
-	d.totalRequestsPanel.SetRect(0, 0, d.width/2, d.height/3)
+	d.totalRequestsPanel.Data = d.plotData("requests.total")
+	d.totalRequestsPanel.SetRect(0, 0, d.width/2, d.plotsBottom())
 	d.totalRequestsPanel.ShowAxes = true
 	d.totalRequestsPanel.AxesColor = ui.ColorRed
-	d.totalRequestsPanel.LineColors = []ui.Color{ui.ColorYellow}
+	d.totalRequestsPanel.LineColors = []ui.Color{ui.ColorYellow, ui.ColorGreen, ui.ColorGreen}
 }
 
 func (d *Dashboard) updateTotalBytesPanel() {
-	limit := time.Now().Unix() - d.interval
-
-	points := make([][]float64, 1)
-	points[0] = make([]float64, 0)
-
-	for _, v := range d.metrics["bytes.total"] {
-		if v.Timestamp > limit {
-			points[0] = append(points[0], v.Value)
-		}
-	}
-
-	if len(points[0]) < 2 {
-		points[0] = []float64{0, 0}
-	} else if len(points[0]) >= d.width/2 {
-		points[0] = points[0][len(points[0])-d.width/2 : len(points[0])]
-	}
-
 	// bytes.total panel at top right
 	d.totalBytesPanel.Title = "Total Bytes"
-	d.totalBytesPanel.Data = points
-	d.totalBytesPanel.SetRect(d.width/2, 0, d.width, d.height/3)
+	d.totalBytesPanel.Data = d.plotData("bytes.total")
+	d.totalBytesPanel.SetRect(d.width/2, 0, d.width, d.plotsBottom())
 	d.totalBytesPanel.AxesColor = ui.ColorWhite
-	d.totalBytesPanel.LineColors = []ui.Color{ui.ColorBlue}
+	d.totalBytesPanel.LineColors = []ui.Color{ui.ColorBlue, ui.ColorGreen, ui.ColorGreen}
 }
 
 func (d *Dashboard) updatePathRequestsPanel() {
@@ -182,26 +341,26 @@ func (d *Dashboard) updatePathRequestsPanel() {
 	rows := make([][]string, 1)
 	rows[0] = []string{"Path", "Requests", "2xx", "3xx", "4xx", "5xx"}
 
-	for _, p := range d.sortedPaths {
+	for _, p := range d.paths.Requests() {
 		row := make([]string, 6)
-		row[0] = p
-		row[1] = fmt.Sprintf("%f", d.pathRequests[p])
-		row[2] = fmt.Sprintf("%f", d.pathStatus[p+".2xx"])
-		row[3] = fmt.Sprintf("%f", d.pathStatus[p+".3xx"])
-		row[4] = fmt.Sprintf("%f", d.pathStatus[p+".4xx"])
-		row[5] = fmt.Sprintf("%f", d.pathStatus[p+".5xx"])
+		row[0] = p.Path
+		row[1] = fmt.Sprintf("%f", p.Requests)
+		row[2] = fmt.Sprintf("%f", p.Status2xx)
+		row[3] = fmt.Sprintf("%f", p.Status3xx)
+		row[4] = fmt.Sprintf("%f", p.Status4xx)
+		row[5] = fmt.Sprintf("%f", p.Status5xx)
 
 		rows = append(rows, row)
 	}
 
 	// reset after rendering as we aggregate points here
-	d.pathStatus = make(map[string]float64)
+	d.paths.ResetStatus()
 
 	d.pathRequestsPanel.Rows = rows
 	d.pathRequestsPanel.TextStyle = ui.NewStyle(ui.ColorWhite)
 	d.pathRequestsPanel.RowSeparator = false
 	d.pathRequestsPanel.BorderStyle = ui.NewStyle(ui.ColorWhite)
-	d.pathRequestsPanel.SetRect(0, d.height/3, d.width/2, d.height/3+d.height/2)
+	d.pathRequestsPanel.SetRect(0, d.plotsBottom(), d.width/2, d.tablesBottom())
 	d.pathRequestsPanel.FillRow = true
 	d.pathRequestsPanel.RowStyles[0] = ui.NewStyle(ui.ColorWhite, ui.ColorBlack, ui.ModifierBold)
 }
@@ -210,14 +369,14 @@ func (d *Dashboard) updatePathBytesPanel() {
 	rows := make([][]string, 1)
 	rows[0] = []string{"Path", "Bytes", "GET", "POST", "PUT", "DELETE"}
 
-	for _, p := range d.sortedPaths {
+	for _, p := range d.paths.Bytes() {
 		row := make([]string, 6)
-		row[0] = p
-		row[1] = fmt.Sprintf("%f", d.pathBytes[p])
-		row[2] = fmt.Sprintf("%f", d.pathMethods[p+".GET"])
-		row[3] = fmt.Sprintf("%f", d.pathMethods[p+".POST"])
-		row[4] = fmt.Sprintf("%f", d.pathMethods[p+".PUT"])
-		row[5] = fmt.Sprintf("%f", d.pathMethods[p+".DELETE"])
+		row[0] = p.Path
+		row[1] = fmt.Sprintf("%f", p.Bytes)
+		row[2] = fmt.Sprintf("%f", p.GET)
+		row[3] = fmt.Sprintf("%f", p.POST)
+		row[4] = fmt.Sprintf("%f", p.PUT)
+		row[5] = fmt.Sprintf("%f", p.DELETE)
 
 		rows = append(rows, row)
 	}
@@ -227,15 +386,23 @@ func (d *Dashboard) updatePathBytesPanel() {
 	d.pathBytesPanel.TextStyle = ui.NewStyle(ui.ColorWhite)
 	d.pathBytesPanel.RowSeparator = false
 	d.pathBytesPanel.BorderStyle = ui.NewStyle(ui.ColorWhite)
-	d.pathBytesPanel.SetRect(d.width/2, d.height/3, d.width, d.height/3+d.height/2)
+	d.pathBytesPanel.SetRect(d.width/2, d.plotsBottom(), d.width, d.tablesBottom())
 	d.pathBytesPanel.FillRow = true
 	d.pathBytesPanel.RowStyles[0] = ui.NewStyle(ui.ColorWhite, ui.ColorBlack, ui.ModifierBold)
 }
 
 func (d *Dashboard) updateMessagesPanel() {
+	if d.showHistory {
+		d.updateHistoryPanel()
+		return
+	}
+
 	messages := make([]string, 0)
+	for _, a := range d.active {
+		messages = append(messages, "ACTIVE "+a)
+	}
 
-	limit := time.Now().Add(-time.Duration(d.interval) * time.Second)
+	limit := d.now().Add(-time.Duration(d.interval) * time.Second)
 
 	var min int
 	for i := len(d.messages); i > 0; i-- {
@@ -251,32 +418,142 @@ func (d *Dashboard) updateMessagesPanel() {
 
 	d.messages = d.messages[min:]
 
-	d.messagesPanel.Title = "Alerts"
-	d.messagesPanel.SetRect(0, d.height/3+d.height/2, d.width, d.height)
+	d.messagesPanel.Title = "Alerts (a: ack, s: silence 1h, l: history, h: health, b: bad lines)"
+	d.messagesPanel.SetRect(0, d.tablesBottom(), d.messagesWidth(), d.height)
+	d.messagesPanel.Rows = messages
+	d.messagesPanel.WrapText = false
+
+}
+
+func (d *Dashboard) updateHistoryPanel() {
+	messages := make([]string, 0, len(d.history))
+
+	for i := len(d.history); i > 0; i-- {
+		messages = append(
+			messages,
+			fmt.Sprintf("[%v] %s", d.history[i-1].Time.Truncate(time.Second), d.history[i-1].Text),
+		)
+	}
+
+	d.messagesPanel.Title = "Alerts history (l: back to recent alerts)"
+	d.messagesPanel.SetRect(0, d.tablesBottom(), d.messagesWidth(), d.height)
 	d.messagesPanel.Rows = messages
 	d.messagesPanel.WrapText = false
+}
+
+// messagesWidth returns the width of the messages panel, that leaves room for the SLO panel
+func (d *Dashboard) messagesWidth() int {
+	if len(d.slos) > 0 {
+		return d.width * 2 / 3
+	}
+	return d.width
+}
+
+func (d *Dashboard) updateSLOPanel() {
+	names := make([]string, 0, len(d.slos))
+	for name := range d.slos {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	rows := make([][]string, 1, len(names)+1)
+	rows[0] = []string{"SLO", "Objective", "Budget", "Burn"}
+
+	for _, name := range names {
+		s := d.slos[name]
+		rows = append(rows, []string{
+			name,
+			fmt.Sprintf("%.3f%%", s.Objective*100),
+			fmt.Sprintf("%.1f%%", s.Remaining*100),
+			fmt.Sprintf("%.2fx", s.Burn),
+		})
+	}
+
+	// SLO panel at bottom right
+	d.sloPanel.Title = "Error budget"
+	d.sloPanel.Rows = rows
+	d.sloPanel.TextStyle = ui.NewStyle(ui.ColorWhite)
+	d.sloPanel.RowSeparator = false
+	d.sloPanel.SetRect(d.messagesWidth(), d.tablesBottom(), d.width, d.height)
+	d.sloPanel.FillRow = true
+	d.sloPanel.RowStyles[0] = ui.NewStyle(ui.ColorWhite, ui.ColorBlack, ui.ModifierBold)
+
+	for i, name := range names {
+		if d.slos[name].Remaining <= 0 {
+			d.sloPanel.RowStyles[i+1] = ui.NewStyle(ui.ColorRed)
+		} else {
+			delete(d.sloPanel.RowStyles, i+1)
+		}
+	}
+}
+
+func (d *Dashboard) updateHealthPanel() {
+	rows := make([][]string, 1, len(d.health)+1)
+	rows[0] = []string{"Metric", "Labels", "Value"}
+	rows = append(rows, d.health...)
+
+	// health panel at the middle row
+	d.healthPanel.Title = "loghound health (h: back to paths)"
+	d.healthPanel.Rows = rows
+	d.healthPanel.TextStyle = ui.NewStyle(ui.ColorWhite)
+	d.healthPanel.RowSeparator = false
+	d.healthPanel.ColumnWidths = []int{d.width / 4, d.width / 2, d.width - d.width/4 - d.width/2 - 2}
+	d.healthPanel.SetRect(0, d.plotsBottom(), d.width, d.tablesBottom())
+	d.healthPanel.FillRow = true
+	d.healthPanel.RowStyles[0] = ui.NewStyle(ui.ColorWhite, ui.ColorBlack, ui.ModifierBold)
+}
+
+func (d *Dashboard) updateRejectedPanel() {
+	rows := make([]string, 0, len(d.rejected))
+
+	for i := len(d.rejected); i > 0; i-- {
+		rows = append(
+			rows,
+			fmt.Sprintf("[%v] %s", d.rejected[i-1].Time.Truncate(time.Second), d.rejected[i-1].Text),
+		)
+	}
+
+	// rejected lines panel at the middle row
+	d.rejectedPanel.Title = "Rejected lines, newest first (b: back to paths)"
+	d.rejectedPanel.SetRect(0, d.plotsBottom(), d.width, d.tablesBottom())
+	d.rejectedPanel.Rows = rows
+	d.rejectedPanel.WrapText = false
+}
+
+// SetClock sets the function telling the time of the dashboard, time.Now by default.
+// Only the points and messages of the last interval until that time are shown
+func (d *Dashboard) SetClock(now func() time.Time) {
+	d.Lock()
+	defer d.Unlock()
 
+	d.now = now
 }
 
-// NewDashboard creates a new dashboard
-func NewDashboard(width, height int, interval int64) *Dashboard {
+// NewDashboard creates a new dashboard, showing the last interval seconds with layout
+func NewDashboard(width, height int, interval int64, layout Layout) *Dashboard {
 
 	d := Dashboard{
 		width:              width,
 		height:             height,
 		interval:           interval,
+		layout:             layout,
+		now:                time.Now,
 		totalRequestsPanel: widgets.NewPlot(),
 		totalBytesPanel:    widgets.NewPlot(),
 		pathRequestsPanel:  widgets.NewTable(),
 		pathBytesPanel:     widgets.NewTable(),
 		messagesPanel:      widgets.NewList(),
+		sloPanel:           widgets.NewTable(),
+		healthPanel:        widgets.NewTable(),
+		rejectedPanel:      widgets.NewList(),
+		active:             make([]string, 0),
 		messages:           make([]message, 0),
+		history:            make([]message, 0),
+		rejected:           make([]message, 0),
 		metrics:            make(map[string][]point, 0),
-		sortedPaths:        make([]string, 0),
-		pathRequests:       make(map[string]float64),
-		pathBytes:          make(map[string]float64),
-		pathStatus:         make(map[string]float64),
-		pathMethods:        make(map[string]float64),
+		bands:              make(map[string][]band),
+		paths:              NewPathTable(),
+		slos:               make(map[string]sloStatus),
 	}
 
 	return &d
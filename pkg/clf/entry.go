@@ -2,8 +2,10 @@ package clf
 
 import (
 	"fmt"
+	"net/url"
 	"regexp"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -16,45 +18,187 @@ type Entry struct {
 	Request       *Request  `json:"request"`
 	Status        int       `json:"status"`
 	Bytes         int       `json:"bytes"`
+	// Latency is the time taken to serve the request, 0 if the format doesn't log it
+	Latency time.Duration `json:"latency,omitempty"`
+	// Backend is the target a load balancer sent the request to, empty if the
+	// format doesn't log it. BackendStatus and BackendLatency are its status
+	// and the time it took to respond, 0 if not logged or not sent
+	Backend        string        `json:"backend,omitempty"`
+	BackendStatus  int           `json:"backend_status,omitempty"`
+	BackendLatency time.Duration `json:"backend_latency,omitempty"`
+	// Termination are the flags a proxy logs when a request didn't end normally,
+	// like CD for HAProxy or UC for Envoy, empty if it did or not logged
+	Termination string `json:"termination,omitempty"`
 }
 
 // Request represents the request field in a Clf entry
 type Request struct {
 	Method string `json:"method"`
-	Path   string `json:"path"`
+	// Target is the request target as logged, path and query string
+	Target string `json:"target,omitempty"`
+	// Path is the percent-decoded path of the target, without the query string
+	Path string `json:"path"`
+	// RawQuery is the query string of the target, without the '?'
+	RawQuery string `json:"raw_query,omitempty"`
+	// Query are the decoded query parameters, nil without query string
+	Query url.Values `json:"query,omitempty"`
 }
 
-var clfParser = regexp.MustCompile(`^(?P<remotehost>\S+) (?P<remotelogname>\S+) (?P<authuser>\S+) \[(?P<date>[^\]]+)\] "(?P<method>[A-Z]+) (?P<path>[^ "]+)? HTTP/[0-9.]+" (?P<status>[0-9]{3}) (?P<bytes>[0-9]+|-)`)
+// NewRequest returns the request of method to target, splitting the target in
+// path and query parameters. Paths and parameters that fail to decode are kept
+// as logged
+func NewRequest(method, target string) *Request {
+	r := &Request{Method: method, Target: target, Path: target}
 
-// Parse a Clf entry
-func Parse(s string) (*Entry, error) {
-	match := clfParser.FindStringSubmatch(s)
+	if i := strings.IndexByte(target, '?'); i >= 0 {
+		r.Path, r.RawQuery = target[:i], target[i+1:]
+	}
+
+	if path, err := url.PathUnescape(r.Path); err == nil {
+		r.Path = path
+	}
+
+	if r.RawQuery != "" {
+		// malformed pairs are skipped, the rest are kept
+		r.Query, _ = url.ParseQuery(r.RawQuery)
+	}
+
+	return r
+}
+
+// Reasons of the parse errors
+const (
+	ReasonFormat  = "format"
+	ReasonDate    = "date"
+	ReasonStatus  = "status"
+	ReasonBytes   = "bytes"
+	ReasonLatency = "latency"
+	ReasonUnknown = "unknown"
+)
+
+// ParseError is the error of a line failing to parse, Reason tells the field
+// that could not be parsed, or format if the line is not in the log format.
+// Format is the log format, common log format if empty
+type ParseError struct {
+	Reason string
+	Value  string
+	Format string
+}
+
+func (e *ParseError) Error() string {
+	name, ok := formatNames[e.Format]
+	if !ok {
+		name = formatNames[FormatCLF]
+	}
+
+	if e.Reason == ReasonFormat {
+		return fmt.Sprintf("line not in %s: %q", name, e.Value)
+	}
+	return fmt.Sprintf("fail parsing %s in %s: %s", e.Reason, name, e.Value)
+}
+
+// Reason returns the reason of a parse error, unknown for other errors
+func Reason(err error) string {
+	if e, ok := err.(*ParseError); ok {
+		return e.Reason
+	}
+	return ReasonUnknown
+}
+
+// clfPattern is the common log format as a regular expression, combined format lines match it too
+const clfPattern = `^(?P<remotehost>\S+) (?P<remotelogname>\S+) (?P<authuser>\S+) \[(?P<date>[^\]]+)\] "(?P<method>[A-Z]+) (?P<path>[^ "]+)? HTTP/[0-9.]+" (?P<status>[0-9]{3}) (?P<bytes>[0-9]+|-)`
+
+// layout of the dates in common log format
+const dateLayout = "02/Jan/2006:15:04:05 -0700"
 
-	date, err := time.Parse("02/Jan/2006:15:04:05 -0700", match[4])
+var clfRegexp = MustRegexpParser(clfPattern)
+
+// RegexpParser parses the entries of custom formats with a regular expression.
+// The named groups remotehost, remotelogname, authuser, date, method, path, status
+// and bytes set the entry fields, date and status are required
+type RegexpParser struct {
+	re     *regexp.Regexp
+	groups map[string]int
+}
+
+// NewRegexpParser returns a parser of the entries matching expr
+func NewRegexpParser(expr string) (*RegexpParser, error) {
+	re, err := regexp.Compile(expr)
 	if err != nil {
-		return nil, fmt.Errorf("fail parsing date in common log format: %s", match[4])
+		return nil, err
 	}
 
-	status, err := strconv.Atoi(match[7])
+	p := &RegexpParser{re: re, groups: make(map[string]int)}
+	for i, name := range re.SubexpNames() {
+		if name != "" {
+			p.groups[name] = i
+		}
+	}
+
+	for _, name := range []string{"date", "status"} {
+		if _, ok := p.groups[name]; !ok {
+			return nil, fmt.Errorf("clf: missing group %s in %s", name, expr)
+		}
+	}
+
+	return p, nil
+}
+
+// MustRegexpParser is like NewRegexpParser but panics if expr is not valid
+func MustRegexpParser(expr string) *RegexpParser {
+	p, err := NewRegexpParser(expr)
 	if err != nil {
-		return nil, fmt.Errorf("fail parsing status in common log format: %s", match[7])
+		panic(err)
 	}
+	return p
+}
 
-	bytes, err := strconv.Atoi(match[8])
+// Parse parses an entry
+func (p *RegexpParser) Parse(s string) (*Entry, error) {
+	match := p.re.FindStringSubmatch(s)
+	if match == nil {
+		return nil, &ParseError{Reason: ReasonFormat, Value: s}
+	}
+
+	group := func(name string) string {
+		if i, ok := p.groups[name]; ok {
+			return match[i]
+		}
+		return ""
+	}
+
+	date, err := time.Parse(dateLayout, group("date"))
+	if err != nil {
+		return nil, &ParseError{Reason: ReasonDate, Value: group("date")}
+	}
+
+	status, err := strconv.Atoi(group("status"))
 	if err != nil {
-		return nil, fmt.Errorf("fail parsing bytes in common log format: %s", match[8])
+		return nil, &ParseError{Reason: ReasonStatus, Value: group("status")}
+	}
+
+	// - when no bytes were sent
+	var bytes int
+	if _, ok := p.groups["bytes"]; ok && group("bytes") != "-" {
+		bytes, err = strconv.Atoi(group("bytes"))
+		if err != nil {
+			return nil, &ParseError{Reason: ReasonBytes, Value: group("bytes")}
+		}
 	}
 
 	return &Entry{
-		RemoteHost:    match[1],
-		RemoteLogname: match[2],
-		AuthUser:      match[3],
+		RemoteHost:    group("remotehost"),
+		RemoteLogname: group("remotelogname"),
+		AuthUser:      group("authuser"),
 		Date:          date,
-		Request: &Request{
-			Method: match[5],
-			Path:   match[6],
-		},
-		Status: status,
-		Bytes:  bytes,
+		Request:       NewRequest(group("method"), group("path")),
+		Status:        status,
+		Bytes:         bytes,
 	}, nil
 }
+
+// ParseRegexp parses a common log format entry with the regular expression
+// parser, slower than Parse
+func ParseRegexp(s string) (*Entry, error) {
+	return clfRegexp.Parse(s)
+}
@@ -0,0 +1,20 @@
+//go:build go1.18
+// +build go1.18
+
+package clf
+
+import "testing"
+
+// FuzzParse checks the scanner parses like the regular expression parser,
+// run with go test -fuzz FuzzParse ./pkg/clf
+func FuzzParse(f *testing.F) {
+	for _, line := range lines {
+		f.Add(line)
+	}
+
+	f.Fuzz(func(t *testing.T, line string) {
+		if err := equalParse(line); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
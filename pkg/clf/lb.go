@@ -0,0 +1,235 @@
+package clf
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ParseALB parses an AWS application load balancer access log entry, like
+// http 2018-07-02T22:23:00.186641Z app/lb/50dc 192.168.131.39:2817 10.0.0.1:80 0.000 0.001 0.000 200 200 34 366 "GET http://www.example.com:80/ HTTP/1.1" "curl/7.46.0" ...
+// The latency is the sum of the processing times, the backend is the target
+func ParseALB(s string) (*Entry, error) {
+	return parseLB(FormatALB, s, 1)
+}
+
+// ParseELB parses an AWS classic load balancer access log entry, like
+// 2015-05-13T23:39:43.945958Z lb 192.168.131.39:2817 10.0.0.1:80 0.000073 0.001048 0.000057 200 200 0 29 "GET http://www.example.com:80/ HTTP/1.1" "curl/7.38.0" - -
+// The latency is the sum of the processing times, the backend is the target
+func ParseELB(s string) (*Entry, error) {
+	return parseLB(FormatELB, s, 0)
+}
+
+// fields of the load balancer lines, after the type of the ALB lines
+const (
+	lbTime = iota
+	lbName
+	lbClient
+	lbTarget
+	lbRequestTime
+	lbTargetTime
+	lbResponseTime
+	lbStatus
+	lbTargetStatus
+	lbReceivedBytes
+	lbSentBytes
+	lbRequest
+	lbFields
+)
+
+// parseLB parses the load balancer lines of format, their fields starting at offset
+func parseLB(format, s string, offset int) (*Entry, error) {
+	f := tokens(s)
+	if len(f) < offset+lbFields {
+		return nil, &ParseError{Reason: ReasonFormat, Value: s, Format: format}
+	}
+	f = f[offset:]
+
+	// "GET http://www.example.com:80/path?query HTTP/1.1", "- - - " if not http
+	request := strings.Fields(f[lbRequest])
+	if len(request) < 2 || request[0] == "-" {
+		return nil, &ParseError{Reason: ReasonFormat, Value: s, Format: format}
+	}
+
+	date, err := time.Parse(time.RFC3339Nano, f[lbTime])
+	if err != nil {
+		return nil, &ParseError{Reason: ReasonDate, Value: f[lbTime], Format: format}
+	}
+
+	status, ok := atoi(f[lbStatus])
+	if !ok {
+		return nil, &ParseError{Reason: ReasonStatus, Value: f[lbStatus], Format: format}
+	}
+
+	// - if the request was not sent to a target
+	var targetStatus int
+	if f[lbTargetStatus] != "-" {
+		targetStatus, ok = atoi(f[lbTargetStatus])
+		if !ok {
+			return nil, &ParseError{Reason: ReasonStatus, Value: f[lbTargetStatus], Format: format}
+		}
+	}
+
+	bytes, ok := atoi(f[lbSentBytes])
+	if !ok {
+		return nil, &ParseError{Reason: ReasonBytes, Value: f[lbSentBytes], Format: format}
+	}
+
+	// processing times are -1 if the request was not sent or the target didn't respond
+	var latency, targetLatency time.Duration
+	for _, i := range []int{lbRequestTime, lbTargetTime, lbResponseTime} {
+		seconds, err := strconv.ParseFloat(f[i], 64)
+		if err != nil {
+			return nil, &ParseError{Reason: ReasonLatency, Value: f[i], Format: format}
+		}
+		if seconds < 0 {
+			continue
+		}
+
+		d := time.Duration(seconds * float64(time.Second))
+		latency += d
+		if i == lbTargetTime {
+			targetLatency = d
+		}
+	}
+
+	backend := f[lbTarget]
+	if backend == "-" {
+		backend = ""
+	}
+
+	return &Entry{
+		RemoteHost:     host(f[lbClient]),
+		RemoteLogname:  "-",
+		AuthUser:       "-",
+		Date:           date,
+		Request:        NewRequest(request[0], target(request[1])),
+		Status:         status,
+		Bytes:          bytes,
+		Latency:        latency,
+		Backend:        backend,
+		BackendStatus:  targetStatus,
+		BackendLatency: targetLatency,
+	}, nil
+}
+
+// tokens splits s in its space separated fields, double quoted fields keep
+// their spaces and lose their quotes
+func tokens(s string) []string {
+	var f []string
+
+	i := 0
+	for {
+		for i < len(s) && isSpace(s[i]) {
+			i++
+		}
+		if i == len(s) {
+			return f
+		}
+
+		start := i
+		if s[i] == '"' {
+			// quotes inside are escaped with a backslash
+			i++
+			for i < len(s) && s[i] != '"' {
+				if s[i] == '\\' {
+					i++
+				}
+				i++
+			}
+			if i > len(s) {
+				i = len(s)
+			}
+			f = append(f, s[start+1:i])
+			i++
+			if i > len(s) {
+				return f
+			}
+			continue
+		}
+
+		for i < len(s) && !isSpace(s[i]) {
+			i++
+		}
+		f = append(f, s[start:i])
+	}
+}
+
+// gcpEntry is the part of a GCP load balancer log entry parsed, as exported
+// by Cloud Logging
+type gcpEntry struct {
+	Timestamp   string `json:"timestamp"`
+	HTTPRequest *struct {
+		RequestMethod string      `json:"requestMethod"`
+		RequestURL    string      `json:"requestUrl"`
+		Status        int         `json:"status"`
+		ResponseSize  json.Number `json:"responseSize"`
+		RemoteIP      string      `json:"remoteIp"`
+		ServerIP      string      `json:"serverIp"`
+		Latency       string      `json:"latency"`
+	} `json:"httpRequest"`
+	Resource struct {
+		Labels struct {
+			BackendServiceName string `json:"backend_service_name"`
+		} `json:"labels"`
+	} `json:"resource"`
+}
+
+// ParseGCP parses a GCP load balancer log entry, a json object per line as
+// exported by Cloud Logging, like
+// {"timestamp":"2021-01-01T00:00:00.123Z","httpRequest":{"requestMethod":"GET","requestUrl":"https://example.com/","status":200,"responseSize":"512","remoteIp":"1.2.3.4","latency":"0.012s"},"resource":{"labels":{"backend_service_name":"web"}}}
+// The backend is the backend service, or the server ip without it. The status
+// is 0 if the request got no response
+func ParseGCP(s string) (*Entry, error) {
+	var e gcpEntry
+	err := json.Unmarshal([]byte(s), &e)
+	if err != nil || e.HTTPRequest == nil || e.HTTPRequest.RequestMethod == "" {
+		return nil, &ParseError{Reason: ReasonFormat, Value: s, Format: FormatGCP}
+	}
+	r := e.HTTPRequest
+
+	date, err := time.Parse(time.RFC3339Nano, e.Timestamp)
+	if err != nil {
+		return nil, &ParseError{Reason: ReasonDate, Value: e.Timestamp, Format: FormatGCP}
+	}
+
+	// requests without response, like the ones closed by clients, have no
+	// status. They are kept with status 0, as the HAProxy aborted requests
+	if r.Status != 0 && (r.Status < 100 || r.Status > 999) {
+		return nil, &ParseError{Reason: ReasonStatus, Value: strconv.Itoa(r.Status), Format: FormatGCP}
+	}
+
+	var bytes int
+	if r.ResponseSize != "" {
+		bytes, err = strconv.Atoi(r.ResponseSize.String())
+		if err != nil {
+			return nil, &ParseError{Reason: ReasonBytes, Value: r.ResponseSize.String(), Format: FormatGCP}
+		}
+	}
+
+	var latency time.Duration
+	if r.Latency != "" {
+		latency, err = time.ParseDuration(r.Latency)
+		if err != nil {
+			return nil, &ParseError{Reason: ReasonLatency, Value: r.Latency, Format: FormatGCP}
+		}
+	}
+
+	backend := e.Resource.Labels.BackendServiceName
+	if backend == "" {
+		backend = r.ServerIP
+	}
+
+	return &Entry{
+		RemoteHost:    r.RemoteIP,
+		RemoteLogname: "-",
+		AuthUser:      "-",
+		Date:          date,
+		Request:       NewRequest(r.RequestMethod, target(r.RequestURL)),
+		Status:        r.Status,
+		Bytes:         bytes,
+		Latency:       latency,
+		Backend:       backend,
+	}, nil
+}
@@ -0,0 +1,253 @@
+package clf
+
+import (
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Parse parses a common log format entry, combined format lines included. It
+// scans the line without regular expressions nor allocations besides the entry
+// and its request, and returns the same entries and errors as ParseRegexp
+func Parse(s string) (*Entry, error) {
+	var f fields
+	if !f.scan(s) {
+		return nil, &ParseError{Reason: ReasonFormat, Value: s}
+	}
+
+	date, ok := parseDate(f.date)
+	if !ok {
+		var err error
+		date, err = time.Parse(dateLayout, f.date)
+		if err != nil {
+			return nil, &ParseError{Reason: ReasonDate, Value: f.date}
+		}
+	}
+
+	// three digits always
+	status := int(f.status[0]-'0')*100 + int(f.status[1]-'0')*10 + int(f.status[2]-'0')
+
+	// - when no bytes were sent
+	var bytes int
+	if f.bytes != "-" {
+		bytes, ok = atoi(f.bytes)
+		if !ok {
+			return nil, &ParseError{Reason: ReasonBytes, Value: f.bytes}
+		}
+	}
+
+	return &Entry{
+		RemoteHost:    f.host,
+		RemoteLogname: f.logname,
+		AuthUser:      f.user,
+		Date:          date,
+		Request:       NewRequest(f.method, f.path),
+		Status:        status,
+		Bytes:         bytes,
+	}, nil
+}
+
+// fields are the fields of a common log format line, substrings of the line
+type fields struct {
+	host, logname, user string
+	date                string
+	method, path        string
+	status, bytes       string
+}
+
+// scan splits s in its fields, it returns false if s does not match the
+// common log format pattern of the regular expression parser
+func (f *fields) scan(s string) bool {
+	var i int
+	var ok bool
+
+	// host, logname and user are non space sequences followed by a space
+	for _, field := range []*string{&f.host, &f.logname, &f.user} {
+		*field, i, ok = word(s, i)
+		if !ok || !at(s, i, ' ') {
+			return false
+		}
+		i++
+	}
+
+	// [date]
+	if !at(s, i, '[') {
+		return false
+	}
+	i++
+	start := i
+	for i < len(s) && s[i] != ']' {
+		i++
+	}
+	if i == start || i == len(s) {
+		return false
+	}
+	f.date = s[start:i]
+	i++
+
+	// "METHOD path HTTP/version"
+	if !at(s, i, ' ') || !at(s, i+1, '"') {
+		return false
+	}
+	i += 2
+	start = i
+	for i < len(s) && s[i] >= 'A' && s[i] <= 'Z' {
+		i++
+	}
+	if i == start || !at(s, i, ' ') {
+		return false
+	}
+	f.method = s[start:i]
+	i++
+
+	// the path is optional
+	start = i
+	for i < len(s) && s[i] != ' ' && s[i] != '"' {
+		i++
+	}
+	f.path = s[start:i]
+	if len(s)-i < len(" HTTP/") || s[i:i+len(" HTTP/")] != " HTTP/" {
+		return false
+	}
+	i += len(" HTTP/")
+	start = i
+	for i < len(s) && (isDigit(s[i]) || s[i] == '.') {
+		i++
+	}
+	if i == start || !at(s, i, '"') || !at(s, i+1, ' ') {
+		return false
+	}
+	i += 2
+
+	// status and bytes, the rest of the line is ignored
+	if len(s)-i < 4 || !isDigit(s[i]) || !isDigit(s[i+1]) || !isDigit(s[i+2]) || s[i+3] != ' ' {
+		return false
+	}
+	f.status = s[i : i+3]
+	i += 4
+
+	start = i
+	for i < len(s) && isDigit(s[i]) {
+		i++
+	}
+	switch {
+	case i > start:
+		f.bytes = s[start:i]
+	case at(s, i, '-'):
+		f.bytes = "-"
+	default:
+		return false
+	}
+
+	return true
+}
+
+// word returns the sequence of non space characters of s at i, as \S+ matches them
+func word(s string, i int) (string, int, bool) {
+	start := i
+	for i < len(s) && !isSpace(s[i]) {
+		i++
+	}
+	return s[start:i], i, i > start
+}
+
+func at(s string, i int, c byte) bool {
+	return i < len(s) && s[i] == c
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\f' || c == '\r'
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// atoi converts the digits of s like strconv.Atoi does
+func atoi(s string) (int, bool) {
+	// larger numbers may overflow
+	if len(s) > 18 {
+		n, err := strconv.Atoi(s)
+		return n, err == nil
+	}
+
+	n := 0
+	for i := 0; i < len(s); i++ {
+		if !isDigit(s[i]) {
+			return 0, false
+		}
+		n = n*10 + int(s[i]-'0')
+	}
+	return n, len(s) > 0
+}
+
+var months = map[string]time.Month{
+	"Jan": time.January, "Feb": time.February, "Mar": time.March, "Apr": time.April,
+	"May": time.May, "Jun": time.June, "Jul": time.July, "Aug": time.August,
+	"Sep": time.September, "Oct": time.October, "Nov": time.November, "Dec": time.December,
+}
+
+// parseDate parses the usual dates of dateLayout, like 10/Oct/2000:13:55:36 -0700,
+// as time.Parse does. It returns false for the rest, left to time.Parse
+func parseDate(s string) (time.Time, bool) {
+	if len(s) != len(dateLayout) || s[2] != '/' || s[6] != '/' || s[11] != ':' ||
+		s[14] != ':' || s[17] != ':' || s[20] != ' ' || (s[21] != '+' && s[21] != '-') {
+		return time.Time{}, false
+	}
+
+	day, ok1 := digits(s, 0, 2)
+	month, ok2 := months[s[3:6]]
+	year, ok3 := digits(s, 7, 4)
+	hour, ok4 := digits(s, 12, 2)
+	min, ok5 := digits(s, 15, 2)
+	sec, ok6 := digits(s, 18, 2)
+	zoneHour, ok7 := digits(s, 22, 2)
+	zoneMin, ok8 := digits(s, 24, 2)
+	if !(ok1 && ok2 && ok3 && ok4 && ok5 && ok6 && ok7 && ok8) {
+		return time.Time{}, false
+	}
+
+	if day < 1 || day > daysIn(month, year) || hour > 23 || min > 59 || sec > 59 || zoneHour > 23 || zoneMin > 59 {
+		return time.Time{}, false
+	}
+
+	offset := (zoneHour*60 + zoneMin) * 60
+	if s[21] == '-' {
+		offset = -offset
+	}
+
+	// as time.Parse, the local zone is used if it has the offset at that time
+	t := time.Date(year, month, day, hour, min, sec, 0, time.UTC).Add(-time.Duration(offset) * time.Second)
+	if _, localOffset := t.In(time.Local).Zone(); localOffset == offset {
+		return t.In(time.Local), true
+	}
+	return t.In(zone(offset)), true
+}
+
+// zones caches the fixed zones of the offsets seen
+var zones sync.Map
+
+func zone(offset int) *time.Location {
+	if loc, ok := zones.Load(offset); ok {
+		return loc.(*time.Location)
+	}
+
+	loc, _ := zones.LoadOrStore(offset, time.FixedZone("", offset))
+	return loc.(*time.Location)
+}
+
+// digits returns the number of n digits of s at i
+func digits(s string, i, n int) (int, bool) {
+	v := 0
+	for _, c := range []byte(s[i : i+n]) {
+		if !isDigit(c) {
+			return 0, false
+		}
+		v = v*10 + int(c-'0')
+	}
+	return v, true
+}
+
+func daysIn(m time.Month, year int) int {
+	return time.Date(year, m+1, 0, 0, 0, 0, 0, time.UTC).Day()
+}
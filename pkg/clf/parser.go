@@ -0,0 +1,112 @@
+package clf
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Log formats with a parser
+const (
+	FormatCLF     = "clf"
+	FormatW3C     = "w3c"
+	FormatALB     = "alb"
+	FormatELB     = "elb"
+	FormatGCP     = "gcp"
+	FormatHAProxy = "haproxy"
+	FormatEnvoy   = "envoy"
+)
+
+// FormatRegexp prefixes the regular expression of a custom format, like
+// regexp:^(?P<remotehost>\S+) \[(?P<date>[^\]]+)\] (?P<status>\d+)$, see RegexpParser
+const FormatRegexp = "regexp"
+
+// Formats are the log formats with a parser
+var Formats = []string{FormatCLF, FormatW3C, FormatALB, FormatELB, FormatGCP, FormatHAProxy, FormatEnvoy}
+
+// formatNames name the formats in the parse errors
+var formatNames = map[string]string{
+	FormatCLF:     "common log format",
+	FormatW3C:     "W3C extended log format",
+	FormatALB:     "ALB log format",
+	FormatELB:     "ELB log format",
+	FormatGCP:     "GCP load balancer log format",
+	FormatHAProxy: "HAProxy HTTP log format",
+	FormatEnvoy:   "Envoy access log format",
+}
+
+// ErrDirective is returned for the lines without entry that set how the next
+// lines are parsed or just comment them, like the W3C directives
+var ErrDirective = errors.New("clf: directive line without entry")
+
+// Parser parses the lines of a log format into entries
+type Parser interface {
+	Parse(s string) (*Entry, error)
+}
+
+// ParserFunc is a stateless Parser
+type ParserFunc func(s string) (*Entry, error)
+
+// Parse parses s with f
+func (f ParserFunc) Parse(s string) (*Entry, error) {
+	return f(s)
+}
+
+// NewParser returns a parser of the lines of a file in format, one of Formats or
+// regexp:<expression>. Parsers may keep state between lines, like the fields of
+// W3C files, so a file needs its own
+func NewParser(format string) (Parser, error) {
+	switch format {
+	case FormatCLF, "":
+		return ParserFunc(Parse), nil
+	case FormatW3C:
+		return NewW3CParser(), nil
+	case FormatALB:
+		return ParserFunc(ParseALB), nil
+	case FormatELB:
+		return ParserFunc(ParseELB), nil
+	case FormatGCP:
+		return ParserFunc(ParseGCP), nil
+	case FormatHAProxy:
+		return ParserFunc(ParseHAProxy), nil
+	case FormatEnvoy:
+		return ParserFunc(ParseEnvoy), nil
+	}
+
+	if strings.HasPrefix(format, FormatRegexp+":") {
+		p, err := NewRegexpParser(strings.TrimPrefix(format, FormatRegexp+":"))
+		if err != nil {
+			return nil, err
+		}
+		return p, nil
+	}
+
+	return nil, fmt.Errorf("clf: unknown format %s, expected %s:<expression> or one of %s", format, FormatRegexp, strings.Join(Formats, ", "))
+}
+
+// target returns the request target of a url logged by a load balancer, like
+// /path?query of http://example.com:80/path?query
+func target(u string) string {
+	i := strings.Index(u, "://")
+	if i < 0 {
+		return u
+	}
+
+	u = u[i+len("://"):]
+	if i = strings.IndexAny(u, "/?"); i < 0 {
+		return "/"
+	}
+	if u[i] == '?' {
+		return "/" + u[i:]
+	}
+	return u[i:]
+}
+
+// host returns the host of an address logged as host:port
+func host(addr string) string {
+	if h, _, err := net.SplitHostPort(addr); err == nil {
+		return h
+	}
+	return addr
+}
@@ -0,0 +1,212 @@
+package clf
+
+import (
+	"strings"
+	"time"
+)
+
+// layout of the HAProxy accept dates, in the local time of the proxy
+const haproxyDateLayout = "02/Jan/2006:15:04:05.000"
+
+// fields of the HAProxy HTTP log lines, from the client address
+const (
+	haClient = iota
+	haDate
+	haFrontend
+	haBackend
+	haTimers
+	haStatus
+	haBytes
+	haRequestCookie
+	haResponseCookie
+	haTermination
+	haFields
+)
+
+// ParseHAProxy parses an HAProxy HTTP log entry, with or without syslog header, like
+// haproxy[14389]: 10.0.1.2:33317 [06/Feb/2009:12:14:14.655] http-in static/srv1 10/0/30/69/109 200 2750 - - ---- 1/1/1/1/0 0/0 {1wt.eu} {} "GET /index.html HTTP/1.1"
+// The latency is the total time Tt, or Ta in newer versions, and the backend
+// latency the time spent past the frontend: in queue Tw, connecting to the
+// server Tc and waiting for its response Tr. The time to receive the request
+// Tq only counts in the latency. The termination is the first two
+// characters of the termination state, the cause and the state of the session
+// when it ended, empty if it ended normally. The status is 0 if the client
+// aborted before the response. Dates are in the local time
+func ParseHAProxy(s string) (*Entry, error) {
+	// captured headers may have spaces and quotes, the request is the last field
+	end := strings.LastIndexByte(s, '"')
+	if end < 0 {
+		return nil, &ParseError{Reason: ReasonFormat, Value: s, Format: FormatHAProxy}
+	}
+	start := strings.LastIndexByte(s[:end], '"')
+	if start < 0 {
+		return nil, &ParseError{Reason: ReasonFormat, Value: s, Format: FormatHAProxy}
+	}
+	request := strings.Fields(s[start+1 : end])
+
+	// the client address is before the first field in brackets, after the syslog header
+	f := tokens(s[:start])
+	for i := 1; i < len(f); i++ {
+		if strings.HasPrefix(f[i], "[") && strings.HasSuffix(f[i], "]") {
+			f = f[i-1:]
+			break
+		}
+	}
+	if len(f) < haFields || !strings.HasPrefix(f[haDate], "[") || len(request) < 2 {
+		return nil, &ParseError{Reason: ReasonFormat, Value: s, Format: FormatHAProxy}
+	}
+
+	date, err := time.ParseInLocation(haproxyDateLayout, strings.Trim(f[haDate], "[]"), time.Local)
+	if err != nil {
+		return nil, &ParseError{Reason: ReasonDate, Value: f[haDate], Format: FormatHAProxy}
+	}
+
+	// Tq/Tw/Tc/Tr/Tt, -1 if the step was not reached, Tt prefixed with + with logasap
+	timers := strings.Split(f[haTimers], "/")
+	if len(timers) != 5 {
+		return nil, &ParseError{Reason: ReasonLatency, Value: f[haTimers], Format: FormatHAProxy}
+	}
+	var backend time.Duration
+	for _, timer := range timers[1:4] {
+		t, ok := milliseconds(timer)
+		if !ok {
+			return nil, &ParseError{Reason: ReasonLatency, Value: f[haTimers], Format: FormatHAProxy}
+		}
+		backend += t
+	}
+	tt, ok := milliseconds(strings.TrimPrefix(timers[4], "+"))
+	if !ok {
+		return nil, &ParseError{Reason: ReasonLatency, Value: f[haTimers], Format: FormatHAProxy}
+	}
+
+	var status int
+	if f[haStatus] != "-1" {
+		status, ok = atoi(f[haStatus])
+		if !ok {
+			return nil, &ParseError{Reason: ReasonStatus, Value: f[haStatus], Format: FormatHAProxy}
+		}
+	}
+
+	bytes, ok := atoi(strings.TrimPrefix(f[haBytes], "+"))
+	if !ok {
+		return nil, &ParseError{Reason: ReasonBytes, Value: f[haBytes], Format: FormatHAProxy}
+	}
+
+	var termination string
+	if state := f[haTermination]; len(state) >= 2 && state[:2] != "--" {
+		termination = state[:2]
+	}
+
+	return &Entry{
+		RemoteHost:     host(f[haClient]),
+		RemoteLogname:  "-",
+		AuthUser:       "-",
+		Date:           date,
+		Request:        NewRequest(request[0], request[1]),
+		Status:         status,
+		Bytes:          bytes,
+		Latency:        tt,
+		Backend:        f[haBackend],
+		BackendLatency: backend,
+		Termination:    termination,
+	}, nil
+}
+
+// fields of the Envoy default access log lines
+const (
+	envoyDate = iota
+	envoyRequest
+	envoyStatus
+	envoyFlags
+	envoyReceivedBytes
+	envoySentBytes
+	envoyDuration
+	envoyUpstreamTime
+	envoyForwardedFor
+	envoyUserAgent
+	envoyRequestID
+	envoyAuthority
+	envoyUpstreamHost
+)
+
+// ParseEnvoy parses an Envoy access log entry in the default format, like
+// [2016-04-15T20:17:00.310Z] "POST /api/v1/locations HTTP/2" 204 - 154 0 226 100 "10.0.35.28" "nsq2http" "cc21d9b0-cf5c-432b-8c7e-98aeb7988cd2" "locations" "tcp://10.0.2.1:80"
+// The latency is the duration, the backend latency the upstream service time
+// and the termination the response flags, like UC or UF,URX, empty without
+// flags. The status is 0 if there was no response. The remote host is the
+// first address of X-Forwarded-For, as the default format doesn't log the peer
+func ParseEnvoy(s string) (*Entry, error) {
+	f := tokens(s)
+	if len(f) <= envoyUpstreamTime || !strings.HasPrefix(f[envoyDate], "[") {
+		return nil, &ParseError{Reason: ReasonFormat, Value: s, Format: FormatEnvoy}
+	}
+
+	// "- - -" for tcp proxies
+	request := strings.Fields(f[envoyRequest])
+	if len(request) < 2 || request[0] == "-" {
+		return nil, &ParseError{Reason: ReasonFormat, Value: s, Format: FormatEnvoy}
+	}
+
+	date, err := time.Parse(time.RFC3339Nano, strings.Trim(f[envoyDate], "[]"))
+	if err != nil {
+		return nil, &ParseError{Reason: ReasonDate, Value: f[envoyDate], Format: FormatEnvoy}
+	}
+
+	status, ok := atoi(f[envoyStatus])
+	if !ok {
+		return nil, &ParseError{Reason: ReasonStatus, Value: f[envoyStatus], Format: FormatEnvoy}
+	}
+
+	bytes, ok := atoi(f[envoySentBytes])
+	if !ok {
+		return nil, &ParseError{Reason: ReasonBytes, Value: f[envoySentBytes], Format: FormatEnvoy}
+	}
+
+	latency, ok := milliseconds(f[envoyDuration])
+	if !ok {
+		return nil, &ParseError{Reason: ReasonLatency, Value: f[envoyDuration], Format: FormatEnvoy}
+	}
+
+	// - if the request was not sent upstream
+	var upstreamLatency time.Duration
+	if f[envoyUpstreamTime] != "-" {
+		upstreamLatency, ok = milliseconds(f[envoyUpstreamTime])
+		if !ok {
+			return nil, &ParseError{Reason: ReasonLatency, Value: f[envoyUpstreamTime], Format: FormatEnvoy}
+		}
+	}
+
+	e := &Entry{
+		RemoteHost:     "-",
+		RemoteLogname:  "-",
+		AuthUser:       "-",
+		Date:           date,
+		Request:        NewRequest(request[0], request[1]),
+		Status:         status,
+		Bytes:          bytes,
+		Latency:        latency,
+		BackendLatency: upstreamLatency,
+	}
+
+	if f[envoyFlags] != "-" {
+		e.Termination = f[envoyFlags]
+	}
+	if len(f) > envoyForwardedFor && f[envoyForwardedFor] != "-" {
+		e.RemoteHost = strings.TrimSpace(strings.Split(f[envoyForwardedFor], ",")[0])
+	}
+	if len(f) > envoyUpstreamHost && f[envoyUpstreamHost] != "-" {
+		e.Backend = f[envoyUpstreamHost]
+	}
+
+	return e, nil
+}
+
+// milliseconds parses a timer logged in milliseconds, -1 if not measured
+func milliseconds(v string) (time.Duration, bool) {
+	if v == "-1" {
+		return 0, true
+	}
+
+	ms, ok := atoi(v)
+	return time.Duration(ms) * time.Millisecond, ok
+}
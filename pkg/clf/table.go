@@ -0,0 +1,122 @@
+package clf
+
+import (
+	"sort"
+	"strings"
+)
+
+// PathRequests represents a row of the requests per path table
+type PathRequests struct {
+	Path      string  `json:"path"`
+	Requests  float64 `json:"requests"`
+	Status2xx float64 `json:"2xx"`
+	Status3xx float64 `json:"3xx"`
+	Status4xx float64 `json:"4xx"`
+	Status5xx float64 `json:"5xx"`
+}
+
+// PathBytes represents a row of the bytes per path table
+type PathBytes struct {
+	Path   string  `json:"path"`
+	Bytes  float64 `json:"bytes"`
+	GET    float64 `json:"GET"`
+	POST   float64 `json:"POST"`
+	PUT    float64 `json:"PUT"`
+	DELETE float64 `json:"DELETE"`
+}
+
+// PathTable aggregates the per path metrics generated by stats
+type PathTable struct {
+	sortedPaths  []string
+	pathRequests map[string]float64
+	pathBytes    map[string]float64
+	pathStatus   map[string]float64
+	pathMethods  map[string]float64
+}
+
+// NewPathTable creates a new path table
+func NewPathTable() *PathTable {
+	return &PathTable{
+		sortedPaths:  make([]string, 0),
+		pathRequests: make(map[string]float64),
+		pathBytes:    make(map[string]float64),
+		pathStatus:   make(map[string]float64),
+		pathMethods:  make(map[string]float64),
+	}
+}
+
+// Add sets the value of a per path metric, other metrics are ignored.
+// status values are aggregated by status class until ResetStatus is called
+func (t *PathTable) Add(metric string, value float64) {
+	metricWords := strings.Split(metric, ".")
+	if len(metricWords) < 3 || metricWords[0] != "path" {
+		return
+	}
+
+	if len(metricWords) == 3 {
+		rootPath := metricWords[1]
+
+		switch metricWords[2] {
+		case "requests":
+			if _, ok := t.pathRequests[rootPath]; !ok {
+				t.sortedPaths = append(t.sortedPaths, rootPath)
+				sort.Strings(t.sortedPaths)
+			}
+
+			t.pathRequests[rootPath] = value
+		case "bytes":
+			t.pathBytes[rootPath] = value
+		}
+	} else if len(metricWords) == 5 {
+		rootPath := metricWords[1]
+		subtype := metricWords[3]
+		switch metricWords[4] {
+		case "requests":
+			pathStatus := strings.Join([]string{rootPath, string(subtype[0]) + "xx"}, ".")
+			t.pathStatus[pathStatus] += value
+		case "bytes":
+			t.pathMethods[strings.Join([]string{rootPath, subtype}, ".")] = value
+		}
+	}
+}
+
+// ResetStatus resets the aggregated status values
+func (t *PathTable) ResetStatus() {
+	t.pathStatus = make(map[string]float64)
+}
+
+// Requests returns the requests per path table
+func (t *PathTable) Requests() []PathRequests {
+	rows := make([]PathRequests, 0, len(t.sortedPaths))
+
+	for _, p := range t.sortedPaths {
+		rows = append(rows, PathRequests{
+			Path:      p,
+			Requests:  t.pathRequests[p],
+			Status2xx: t.pathStatus[p+".2xx"],
+			Status3xx: t.pathStatus[p+".3xx"],
+			Status4xx: t.pathStatus[p+".4xx"],
+			Status5xx: t.pathStatus[p+".5xx"],
+		})
+	}
+
+	return rows
+}
+
+// Bytes returns the bytes per path table
+func (t *PathTable) Bytes() []PathBytes {
+	rows := make([]PathBytes, 0, len(t.sortedPaths))
+
+	for _, p := range t.sortedPaths {
+		rows = append(rows, PathBytes{
+			Path:   p,
+			Bytes:  t.pathBytes[p],
+			GET:    t.pathMethods[p+".GET"],
+			POST:   t.pathMethods[p+".POST"],
+			PUT:    t.pathMethods[p+".PUT"],
+			DELETE: t.pathMethods[p+".DELETE"],
+		})
+	}
+
+	return rows
+}
@@ -0,0 +1,149 @@
+package clf
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// w3cDefaultFields are the fields logged by IIS by default, used until a
+// #Fields directive is found
+var w3cDefaultFields = []string{
+	"date", "time", "s-ip", "cs-method", "cs-uri-stem", "cs-uri-query", "s-port", "cs-username",
+	"c-ip", "cs(User-Agent)", "cs(Referer)", "sc-status", "sc-substatus", "sc-win32-status", "time-taken",
+}
+
+// W3CParser parses the W3C extended log format files written by IIS. The
+// #Fields directives set the fields of the next lines, so a file can change
+// them in the middle, as IIS does when its settings change. It is not safe
+// for concurrent use, a file needs its own parser
+type W3CParser struct {
+	fields []string
+	// date of the #Date directive, for the files without date field
+	date string
+}
+
+// NewW3CParser returns a parser of a W3C extended log format file, with the
+// IIS default fields until a #Fields directive is found
+func NewW3CParser() *W3CParser {
+	return &W3CParser{fields: w3cDefaultFields}
+}
+
+// Parse parses an entry, or the directive lines starting with # returning
+// ErrDirective. Dates are UTC, and time-taken is milliseconds as IIS logs it,
+// or seconds if it has decimals as the W3C specification says
+func (p *W3CParser) Parse(s string) (*Entry, error) {
+	s = strings.TrimRight(s, "\r\n")
+
+	if strings.HasPrefix(s, "#") {
+		p.directive(s)
+		return nil, ErrDirective
+	}
+
+	values := strings.Fields(s)
+	if len(values) != len(p.fields) {
+		return nil, &ParseError{Reason: ReasonFormat, Value: s, Format: FormatW3C}
+	}
+
+	e := &Entry{RemoteLogname: "-", AuthUser: "-"}
+	var method, stem, query, uri, date, clock string
+	var status bool
+
+	for i, name := range p.fields {
+		v := values[i]
+		if v == "-" {
+			continue
+		}
+
+		switch name {
+		case "date":
+			date = v
+		case "time":
+			clock = v
+		case "c-ip":
+			e.RemoteHost = v
+		case "cs-username":
+			e.AuthUser = v
+		case "cs-method":
+			method = v
+		case "cs-uri-stem":
+			stem = v
+		case "cs-uri-query":
+			query = v
+		case "cs-uri":
+			uri = v
+		case "sc-status":
+			n, ok := atoi(v)
+			if !ok {
+				return nil, &ParseError{Reason: ReasonStatus, Value: v, Format: FormatW3C}
+			}
+			e.Status, status = n, true
+		case "sc-bytes":
+			n, ok := atoi(v)
+			if !ok {
+				return nil, &ParseError{Reason: ReasonBytes, Value: v, Format: FormatW3C}
+			}
+			e.Bytes = n
+		case "time-taken":
+			d, ok := w3cDuration(v)
+			if !ok {
+				return nil, &ParseError{Reason: ReasonLatency, Value: v, Format: FormatW3C}
+			}
+			e.Latency = d
+		}
+	}
+
+	if date == "" {
+		date = p.date
+	}
+	t, err := time.Parse("2006-01-02 15:04:05", date+" "+clock)
+	if err != nil {
+		return nil, &ParseError{Reason: ReasonDate, Value: strings.TrimSpace(date + " " + clock), Format: FormatW3C}
+	}
+	e.Date = t
+
+	if !status {
+		return nil, &ParseError{Reason: ReasonStatus, Value: "-", Format: FormatW3C}
+	}
+
+	if uri == "" {
+		uri = stem
+		if query != "" {
+			uri += "?" + query
+		}
+	}
+	e.Request = NewRequest(method, uri)
+
+	return e, nil
+}
+
+// directive applies the #Fields and #Date directives, the rest are comments
+func (p *W3CParser) directive(s string) {
+	i := strings.IndexByte(s, ':')
+	if i < 0 {
+		return
+	}
+
+	switch s[1:i] {
+	case "Fields":
+		p.fields = strings.Fields(s[i+1:])
+	case "Date":
+		if date := strings.Fields(s[i+1:]); len(date) > 0 {
+			p.date = date[0]
+		}
+	}
+}
+
+// w3cDuration parses the time-taken field
+func w3cDuration(v string) (time.Duration, bool) {
+	if strings.IndexByte(v, '.') < 0 {
+		ms, ok := atoi(v)
+		return time.Duration(ms) * time.Millisecond, ok
+	}
+
+	seconds, err := strconv.ParseFloat(v, 64)
+	if err != nil || seconds < 0 {
+		return 0, false
+	}
+	return time.Duration(seconds * float64(time.Second)), true
+}